@@ -12,6 +12,7 @@ import (
 
 	"lusty/config"
 	"lusty/internal/database"
+	"lusty/internal/jobs"
 	"lusty/internal/router"
 	"lusty/pkg/cloudinary"
 )
@@ -32,7 +33,9 @@ func main() {
 		log.Fatalf("cloudinary: %v", err)
 	}
 
-	engine := router.Setup(cfg, db, cloud)
+	scheduler := jobs.NewScheduler()
+	engine := router.Setup(cfg, db, cloud, scheduler)
+	scheduler.Start()
 	srv := &http.Server{
 		Addr:         ":" + cfg.Server.Port,
 		Handler:      engine,
@@ -54,5 +57,6 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatal("server shutdown:", err)
 	}
+	scheduler.Stop()
 	fmt.Println("server stopped")
 }
@@ -2,6 +2,8 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -16,6 +18,53 @@ type Config struct {
 	LiberecMpesa LiberecMpesaConfig
 	Swapuzi      SwapuziConfig
 	Firebase     FirebaseConfig
+	Jobs         JobsConfig
+	Webhooks     WebhooksConfig
+	WebRTC       WebRTCConfig
+	Redis        RedisConfig
+}
+
+// RedisConfig is the Redis used as the WebSocket backplane when several instances run side by side.
+type RedisConfig struct {
+	URL string // REDIS_URL, e.g. redis://:password@localhost:6379/0; empty keeps WebSocket hubs in-process
+}
+
+// WebRTCConfig is the ICE server configuration handed to video call clients.
+type WebRTCConfig struct {
+	STUNURLs          []string      // WEBRTC_STUN_URLS, comma-separated
+	TURNURLs          []string      // WEBRTC_TURN_URLS, comma-separated, e.g. turn:turn.example.com:3478,turns:turn.example.com:5349
+	TURNSecret        string        // WEBRTC_TURN_SECRET: coturn static-auth-secret (use-auth-secret); empty disables TURN
+	TURNCredentialTTL time.Duration // WEBRTC_TURN_TTL: longest lifetime of issued TURN credentials (capped at the session end); they cannot be revoked
+}
+
+// WebhooksConfig holds the authentication settings for each provider callback endpoint.
+type WebhooksConfig struct {
+	Mpesa      WebhookAuthConfig // /webhooks/mpesa (TheLiberec STK)
+	Crypto     WebhookAuthConfig // /webhooks/crypto (Swapuzi)
+	Withdrawal WebhookAuthConfig // /webhooks/withdrawal (TheLiberec B2C)
+}
+
+// WebhookAuthConfig controls how one provider's callbacks are authenticated.
+type WebhookAuthConfig struct {
+	Secret       string        // HMAC-SHA256 key shared with the provider; empty skips the signature check
+	AllowedIPs   []string      // source IPs or CIDRs; empty allows any source
+	ReplayWindow time.Duration // max age of the signed timestamp; identical deliveries inside it are dropped
+	VerifyBack   bool          // confirm the reported status with the provider API before changing state
+}
+
+// JobsConfig controls the background jobs run inside the server process.
+type JobsConfig struct {
+	SweepInterval time.Duration // how often expired requests and abandoned payments are swept
+
+	ReconcileInterval time.Duration // how often payments and withdrawals are checked against the providers
+	ReconcileMinAge   time.Duration // PENDING records younger than this are left for their webhook
+	ReconcileLookback time.Duration // settled records updated within this window are re-checked
+
+	AutoCompleteGrace time.Duration // AUTO_COMPLETE_GRACE: time the client has to confirm or dispute after the companion marks delivered
+
+	BookingReminderLead time.Duration // BOOKING_REMINDER_LEAD: how long before an accepted booking's slot both sides are reminded
+
+	ChatPushDelay time.Duration // CHAT_PUSH_DELAY: how long a chat message may go without a delivered receipt before it is pushed
 }
 
 type FirebaseConfig struct {
@@ -27,6 +76,9 @@ type ServerConfig struct {
 	Env          string
 	ReadTimeout  time.Duration
 	WriteTimeout time.Duration
+	// TrustedProxies are the addresses (IPs or CIDRs) whose X-Forwarded-For is believed when working out a
+	// client's IP. Empty means no proxy is trusted and the connection's remote address is used.
+	TrustedProxies []string
 }
 
 type DatabaseConfig struct {
@@ -63,8 +115,18 @@ type LocationConfig struct {
 }
 
 type PaymentConfig struct {
-	WebhookSecret string
 	PaymentExpiry time.Duration
+
+	MobileMoneyProvider string             // PAYMENT_MOBILE_MONEY_PROVIDER: "liberec" (default) or "fake"
+	CryptoProvider      string             // PAYMENT_CRYPTO_PROVIDER: "swapuzi" (default) or "fake"
+	Fake                FakeProviderConfig // used when either provider is "fake"
+}
+
+// FakeProviderConfig for the in-process fake provider (pkg/payment.FakeProvider), for local runs and tests.
+type FakeProviderConfig struct {
+	CallbackBaseURL string        // PAYMENT_FAKE_CALLBACK_BASE_URL; default http://localhost:<port>
+	Delay           time.Duration // PAYMENT_FAKE_DELAY; default 5s
+	FailRate        float64       // PAYMENT_FAKE_FAIL_RATE, 0..1
 }
 
 // LiberecMpesaConfig for M-Pesa STK via TheLiberec Card API
@@ -86,10 +148,11 @@ type SwapuziConfig struct {
 func Load() *Config {
 	return &Config{
 		Server: ServerConfig{
-			Port:         "8099",
-			Env:          "development",
-			ReadTimeout:  10 * time.Second,
-			WriteTimeout: 10 * time.Second,
+			Port:           "8099",
+			Env:            envOr("ENV", "development"),
+			ReadTimeout:    10 * time.Second,
+			WriteTimeout:   10 * time.Second,
+			TrustedProxies: envList("TRUSTED_PROXIES", ""),
 		},
 		Database: DatabaseConfig{
 			DSN:             "joelwasike:@Webuye2021@tcp(localhost:3306)/metchi?charset=utf8mb4&parseTime=True&loc=Local",
@@ -120,8 +183,42 @@ func Load() *Config {
 			MinAge:               18,
 		},
 		Payment: PaymentConfig{
-			WebhookSecret: "",
-			PaymentExpiry: 30 * time.Minute,
+			PaymentExpiry:       30 * time.Minute,
+			MobileMoneyProvider: envOr("PAYMENT_MOBILE_MONEY_PROVIDER", "liberec"),
+			CryptoProvider:      envOr("PAYMENT_CRYPTO_PROVIDER", "swapuzi"),
+			Fake: func() FakeProviderConfig {
+				c := FakeProviderConfig{
+					CallbackBaseURL: envOr("PAYMENT_FAKE_CALLBACK_BASE_URL", "http://localhost:8099"),
+					Delay:           envDuration("PAYMENT_FAKE_DELAY", 5*time.Second),
+				}
+				if f, err := strconv.ParseFloat(os.Getenv("PAYMENT_FAKE_FAIL_RATE"), 64); err == nil && f >= 0 && f <= 1 {
+					c.FailRate = f
+				}
+				return c
+			}(),
+		},
+		Webhooks: WebhooksConfig{
+			Mpesa:      webhookAuthFromEnv("MPESA"),
+			Crypto:     webhookAuthFromEnv("CRYPTO"),
+			Withdrawal: webhookAuthFromEnv("WITHDRAWAL"),
+		},
+		Jobs: JobsConfig{
+			SweepInterval:       time.Minute,
+			ReconcileInterval:   15 * time.Minute,
+			ReconcileMinAge:     15 * time.Minute,
+			ReconcileLookback:   72 * time.Hour,
+			AutoCompleteGrace:   envDuration("AUTO_COMPLETE_GRACE", 24*time.Hour),
+			BookingReminderLead: envDuration("BOOKING_REMINDER_LEAD", time.Hour),
+			ChatPushDelay:       envDuration("CHAT_PUSH_DELAY", 30*time.Second),
+		},
+		WebRTC: WebRTCConfig{
+			STUNURLs:          envList("WEBRTC_STUN_URLS", "stun:stun.l.google.com:19302"),
+			TURNURLs:          envList("WEBRTC_TURN_URLS", ""),
+			TURNSecret:        os.Getenv("WEBRTC_TURN_SECRET"),
+			TURNCredentialTTL: envDuration("WEBRTC_TURN_TTL", 10*time.Minute),
+		},
+		Redis: RedisConfig{
+			URL: os.Getenv("REDIS_URL"),
 		},
 		Firebase: FirebaseConfig{
 			ServiceAccountPath: os.Getenv("FIREBASE_SERVICE_ACCOUNT_PATH"), // e.g. /path/to/serviceAccountKey.json
@@ -152,3 +249,46 @@ func Load() *Config {
 		}(),
 	}
 }
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// envList splits a comma-separated env var, falling back to def when it is unset.
+func envList(key, def string) []string {
+	var list []string
+	for _, v := range strings.Split(envOr(key, def), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// envDuration parses a duration env var such as "90s" or "24h", falling back to def when it is unset, invalid
+// or not positive.
+func envDuration(key string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil && d > 0 {
+		return d
+	}
+	return def
+}
+
+// webhookAuthFromEnv reads <PREFIX>_WEBHOOK_SECRET, <PREFIX>_WEBHOOK_ALLOWED_IPS (comma-separated),
+// <PREFIX>_WEBHOOK_REPLAY_WINDOW (duration, default 5m) and <PREFIX>_WEBHOOK_VERIFY_BACK (default true).
+func webhookAuthFromEnv(prefix string) WebhookAuthConfig {
+	c := WebhookAuthConfig{
+		Secret:       os.Getenv(prefix + "_WEBHOOK_SECRET"),
+		ReplayWindow: envDuration(prefix+"_WEBHOOK_REPLAY_WINDOW", 5*time.Minute),
+		VerifyBack:   os.Getenv(prefix+"_WEBHOOK_VERIFY_BACK") != "false",
+	}
+	for _, ip := range strings.Split(os.Getenv(prefix+"_WEBHOOK_ALLOWED_IPS"), ",") {
+		if ip = strings.TrimSpace(ip); ip != "" {
+			c.AllowedIPs = append(c.AllowedIPs, ip)
+		}
+	}
+	return c
+}
@@ -39,22 +39,37 @@ func AutoMigrate(db *gorm.DB) error {
 		&models.User{},
 		&models.Wallet{},
 		&models.WalletTransaction{},
+		&models.LedgerAccount{},
+		&models.LedgerEntry{},
+		&models.LedgerPosting{},
+		&models.EscrowHold{},
 		&models.UserLocation{},
 		&models.UserPresence{},
 		&models.CompanionProfile{},
 		&models.CompanionMedia{},
 		&models.CompanionPricing{},
 		&models.CompanionBoost{},
+		&models.AvailabilityRule{},
+		&models.AvailabilityException{},
 		&models.Favorite{},
 		&models.Payment{},
 		&models.InteractionRequest{},
+		&models.InteractionTransition{},
 		&models.ChatSession{},
 		&models.ChatMessage{},
+		&models.SessionExtension{},
+		&models.VideoCall{},
 		&models.Notification{},
 		&models.Block{},
 		&models.Report{},
 		&models.AuditLog{},
+		&models.WebhookEvent{},
+		&models.ReconciliationDiscrepancy{},
 		&models.Withdrawal{},
+		&models.Refund{},
+		&models.Dispute{},
+		&models.DisputeEvidence{},
+		&models.Review{},
 		&models.ReferralCode{},
 		&models.Referral{},
 		&models.SystemSetting{},
@@ -0,0 +1,35 @@
+// Package databasetest opens a throwaway, fully migrated database for tests.
+package databasetest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"lusty/internal/database"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// New returns a migrated SQLite database in a file under t.TempDir. Transactions take the write lock when they
+// begin and wait for each other, so concurrent tests see the same serialization the row locks give on MySQL
+// (SQLite ignores FOR UPDATE).
+func New(t testing.TB) *gorm.DB {
+	t.Helper()
+	dsn := "file:" + filepath.Join(t.TempDir(), "test.db") +
+		"?_pragma=busy_timeout(30000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("open test database: %v", err)
+	}
+	if err := database.AutoMigrate(db); err != nil {
+		t.Fatalf("migrate test database: %v", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("test database handle: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
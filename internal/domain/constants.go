@@ -25,6 +25,89 @@ const (
 	RequestStatusAccepted   = "ACCEPTED"
 	RequestStatusRejected   = "REJECTED"
 	RequestStatusExpired    = "EXPIRED"
+	RequestStatusCompleted  = "COMPLETED" // client confirmed service done; escrow released
+	RequestStatusCancelled  = "CANCELLED"
+	RequestStatusDisputed   = "DISPUTED"
+)
+
+// Payment statuses
+const (
+	PaymentStatusPending   = "PENDING"
+	PaymentStatusCompleted = "COMPLETED"
+	PaymentStatusFailed    = "FAILED"
+	PaymentStatusCancelled = "CANCELLED" // abandoned or timed out before the provider confirmed
+	PaymentStatusRefunded  = "REFUNDED"
+	PaymentStatusExpired   = "EXPIRED"
+)
+
+// Webhook inbox event statuses
+const (
+	WebhookEventPending    = "PENDING"
+	WebhookEventProcessing = "PROCESSING"
+	WebhookEventProcessed  = "PROCESSED"
+	WebhookEventFailed     = "FAILED" // will be retried at next_attempt_at
+	WebhookEventDead       = "DEAD"   // out of retries; admin replay only
+)
+
+// Refund statuses, destinations and funding
+const (
+	RefundStatusPending    = "PENDING"    // funds reserved, not yet sent to the provider
+	RefundStatusProcessing = "PROCESSING" // provider accepted; waiting for its callback
+	RefundStatusCompleted  = "COMPLETED"
+	RefundStatusFailed     = "FAILED" // funds returned to where they were reserved from
+
+	RefundDestinationWallet = "WALLET" // client's spendable balance
+	RefundDestinationSource = "SOURCE" // the M-Pesa number or crypto address that paid
+
+	RefundFundedByWallet   = "CLIENT_WALLET"     // money already refunded to the client's wallet is sent on to the source
+	RefundFundedByPlatform = "PLATFORM"          // admin refund out of platform revenue
+	RefundFundedByProvider = "PROVIDER_CLEARING" // a charge that was never booked (payment cancelled here but paid at the provider)
+)
+
+// Reconciliation discrepancy statuses and the kinds of record reconciled
+const (
+	DiscrepancyStatusOpen         = "OPEN"          // needs an admin
+	DiscrepancyStatusResolved     = "RESOLVED"      // closed by an admin, or consistent on a later run
+	DiscrepancyStatusAutoResolved = "AUTO_RESOLVED" // fixed by the reconciler itself
+
+	ReconcileKindPayment    = "payment"
+	ReconcileKindWithdrawal = "withdrawal"
+)
+
+// Dispute statuses, reasons and resolutions
+const (
+	DisputeStatusOpen     = "OPEN" // escrow frozen until an admin resolves it
+	DisputeStatusResolved = "RESOLVED"
+
+	DisputeReasonNoShow       = "NO_SHOW"       // companion never showed up
+	DisputeReasonNotConfirmed = "NOT_CONFIRMED" // client will not confirm service done
+	DisputeReasonNotAsAgreed  = "NOT_AS_AGREED"
+	DisputeReasonOther        = "OTHER"
+
+	DisputeResolutionRefund  = "REFUND"  // full payment back to the client's wallet
+	DisputeResolutionRelease = "RELEASE" // companion paid as if the client had confirmed
+	DisputeResolutionSplit   = "SPLIT"   // divided between client and companion; platform keeps the rest
+)
+
+// Session extension statuses
+const (
+	ExtensionStatusPending  = "PENDING"  // waiting for its payment
+	ExtensionStatusApplied  = "APPLIED"  // paid, held in escrow with the interaction, session extended
+	ExtensionStatusRefunded = "REFUNDED" // paid after the session closed; refunded to the client's wallet
+	ExtensionStatusFailed   = "FAILED"   // payment failed
+)
+
+// Chat message kinds
+const (
+	ChatMessageKindText = "text"
+	ChatMessageKindCall = "call" // video call record posted by the server
+)
+
+// Review statuses. Only PUBLISHED reviews are shown and count towards ratings.
+const (
+	ReviewStatusPublished = "PUBLISHED"
+	ReviewStatusFlagged   = "FLAGGED" // held back by the moderator until an admin publishes or hides it
+	ReviewStatusHidden    = "HIDDEN"  // removed by an admin
 )
 
 const (
@@ -49,8 +132,60 @@ const (
 	WalletTxTypeReferralBonus      = "REFERRAL_BONUS"
 	WalletTxTypeRefund             = "REFUND"
 	WalletTxTypePlatformFee        = "PLATFORM_FEE"
+	WalletTxTypePayment            = "PAYMENT"
+	WalletTxTypeOpeningBalance     = "OPENING_BALANCE"
+	WalletTxTypeEscrowHold         = "ESCROW_HOLD"
+	WalletTxTypeEscrowTopUp        = "ESCROW_TOP_UP" // session extension added to an interaction's hold
+	WalletTxTypeEscrowSplit        = "ESCROW_SPLIT"
+	WalletTxTypeVideoPreauth       = "VIDEO_PREAUTH"   // a block of video call time reserved from the client's wallet
+	WalletTxTypeVideoCall          = "VIDEO_CALL"      // a video call settled: billed time paid out, the rest returned
+	WalletTxTypeRefundPayout       = "REFUND_PAYOUT"   // refund sent back to the payment source
+	WalletTxTypeRefundReversal     = "REFUND_REVERSAL" // a refund payout the provider failed, returned to its funding account
+)
+
+// Video call statuses. A call rings until the callee accepts, declines or it times out (missed), or the
+// caller cancels; once accepted it is ACTIVE, metered and billed per started VideoBlockSeconds until ENDED.
+const (
+	VideoCallStatusRinging   = "RINGING"
+	VideoCallStatusActive    = "ACTIVE"
+	VideoCallStatusEnded     = "ENDED"
+	VideoCallStatusMissed    = "MISSED"
+	VideoCallStatusDeclined  = "DECLINED"
+	VideoCallStatusCancelled = "CANCELLED"
+
+	VideoBlockSeconds = 300 // VIDEO_PER_5MIN pricing unit
+)
+
+// Escrow hold statuses
+const (
+	EscrowStatusHeld     = "HELD"
+	EscrowStatusReleased = "RELEASED"
+	EscrowStatusRefunded = "REFUNDED"
+	EscrowStatusSplit    = "SPLIT"
+)
+
+// Ledger account types. Per-user accounts are mirrored onto the user's Wallet row and may never go negative.
+// Platform accounts have no owner and may run negative (they mirror money held outside the app).
+const (
+	LedgerAccountClientBalance         = "CLIENT_BALANCE"         // wallets.balance_cents
+	LedgerAccountCompanionPending      = "COMPANION_PENDING"      // wallets.pending_cents
+	LedgerAccountCompanionWithdrawable = "COMPANION_WITHDRAWABLE" // wallets.withdrawable_cents
+	LedgerAccountPlatformRevenue       = "PLATFORM_REVENUE"
+	LedgerAccountReferralPayable       = "REFERRAL_PAYABLE"
+	LedgerAccountProviderClearing      = "PROVIDER_CLEARING" // funds received from / paid out through M-Pesa, crypto and wallet payments
+	LedgerAccountEscrow                = "ESCROW"            // client payments held for interactions (see EscrowHold)
+	LedgerAccountOpeningBalance        = "OPENING_BALANCE"   // balances that existed before the ledger
 )
 
+// IsUserLedgerAccount reports whether the account type is owned by a single user.
+func IsUserLedgerAccount(accountType string) bool {
+	switch accountType {
+	case LedgerAccountClientBalance, LedgerAccountCompanionPending, LedgerAccountCompanionWithdrawable:
+		return true
+	}
+	return false
+}
+
 // Platform fee: markup added to companion's base price (our profit)
 const (
 	PlatformFeeSmallCents = 20000  // KES 200 for base price <= KES 2000
@@ -97,4 +232,13 @@ const (
 	SettingReferralBonusReferred = "referral_bonus_referred_cents" // KES cents credited to new companion
 	SettingReferralCommissionRate = "referral_commission_rate"
 	SettingReferralMaxTx          = "referral_max_transactions"
+
+	SettingCancellationPolicy         = "cancellation_policy"                  // JSON refund tiers by minutes since accept, see interaction.CancellationPolicy
+	SettingBookingCancellationPolicy  = "booking_cancellation_policy"          // JSON refund tiers by minutes left before a booked slot
+	SettingCancellationCompanionShare = "cancellation_companion_share_percent" // share of a cancellation fee paid to the companion
+
+	SettingReviewEditWindowMinutes = "review_edit_window_minutes" // how long a review can be edited after it is left
+	SettingReviewBlockedTerms      = "review_blocked_terms"       // comma-separated; a review containing one is FLAGGED for an admin
+
+	SettingMaxConcurrentChats = "max_concurrent_chats" // highest max_concurrent_chats a companion may choose
 )
@@ -1,29 +1,47 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"lusty/internal/domain"
+	"lusty/internal/middleware"
 	"lusty/internal/repository"
 	"lusty/internal/service"
+	"lusty/internal/webhook"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 type AdminHandler struct {
 	adminRepo   *repository.AdminRepository
 	settingRepo *repository.SettingRepository
+	walletRepo  *repository.WalletRepository
+	webhookRepo *repository.WebhookEventRepository
+	inbox       *webhook.Inbox
+	reconRepo   *repository.ReconciliationRepository
 	authSvc     *service.AuthService
 }
 
 func NewAdminHandler(
 	adminRepo *repository.AdminRepository,
 	settingRepo *repository.SettingRepository,
+	walletRepo *repository.WalletRepository,
+	webhookRepo *repository.WebhookEventRepository,
+	inbox *webhook.Inbox,
+	reconRepo *repository.ReconciliationRepository,
 	authSvc *service.AuthService,
 ) *AdminHandler {
 	return &AdminHandler{
 		adminRepo:   adminRepo,
 		settingRepo: settingRepo,
+		walletRepo:  walletRepo,
+		webhookRepo: webhookRepo,
+		inbox:       inbox,
+		reconRepo:   reconRepo,
 		authSvc:     authSvc,
 	}
 }
@@ -183,6 +201,21 @@ func (h *AdminHandler) ListInteractions(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"data": list, "total": total, "page": page, "limit": limit})
 }
 
+// InteractionTransitions handles GET /admin/interactions/:id/transitions — status history of one interaction.
+func (h *AdminHandler) InteractionTransitions(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	list, err := h.adminRepo.ListInteractionTransitions(uint(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load transitions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": list})
+}
+
 // ListReports handles GET /admin/reports.
 func (h *AdminHandler) ListReports(c *gin.Context) {
 	status := c.Query("status")
@@ -283,6 +316,117 @@ func (h *AdminHandler) Analytics(c *gin.Context) {
 	})
 }
 
+// LedgerCheck handles GET /admin/ledger/check — wallets and ledger accounts that disagree with the postings.
+func (h *AdminHandler) LedgerCheck(c *gin.Context) {
+	drift, unbalanced, err := h.walletRepo.CheckIntegrity()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ledger check failed"})
+		return
+	}
+	if drift == nil {
+		drift = []repository.LedgerDrift{}
+	}
+	if unbalanced == nil {
+		unbalanced = []uint{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"ok":                 len(drift) == 0 && len(unbalanced) == 0,
+		"drift":              drift,
+		"unbalanced_entries": unbalanced,
+	})
+}
+
+// ListWebhookEvents handles GET /admin/webhooks/events. Defaults to events that need attention (FAILED, DEAD);
+// pass status=ALL for everything.
+func (h *AdminHandler) ListWebhookEvents(c *gin.Context) {
+	statuses := []string{domain.WebhookEventFailed, domain.WebhookEventDead}
+	switch status := c.Query("status"); status {
+	case "":
+	case "ALL":
+		statuses = nil
+	default:
+		statuses = strings.Split(status, ",")
+	}
+	page, limit := parsePagination(c)
+	list, total, err := h.webhookRepo.List(statuses, c.Query("provider"), page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list webhook events"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": list, "total": total, "page": page, "limit": limit})
+}
+
+// ReplayWebhookEvent handles POST /admin/webhooks/events/:id/replay — reprocesses the stored callback now.
+func (h *AdminHandler) ReplayWebhookEvent(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	ev, err := h.inbox.Replay(c.Request.Context(), uint(id))
+	if errors.Is(err, webhook.ErrBusy) {
+		c.JSON(http.StatusConflict, gin.H{"error": "event is being processed"})
+		return
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "event not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "replay failed"})
+		return
+	}
+	c.JSON(http.StatusOK, ev)
+}
+
+// ListDiscrepancies handles GET /admin/reconciliation/discrepancies — the reconciliation report. Defaults to
+// OPEN; pass status=ALL for everything, kind=payment|withdrawal to filter.
+func (h *AdminHandler) ListDiscrepancies(c *gin.Context) {
+	status := c.DefaultQuery("status", domain.DiscrepancyStatusOpen)
+	if status == "ALL" {
+		status = ""
+	}
+	page, limit := parsePagination(c)
+	list, total, err := h.reconRepo.List(status, c.Query("kind"), page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list discrepancies"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": list, "total": total, "page": page, "limit": limit})
+}
+
+// ResolveDiscrepancy handles POST /admin/reconciliation/discrepancies/:id/resolve. The admin fixes the money
+// by hand; this records what was done.
+func (h *AdminHandler) ResolveDiscrepancy(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	var req struct {
+		Resolution string `json:"resolution" binding:"required,max=500"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ok, err := h.reconRepo.Resolve(uint(id), middleware.GetUserID(c), req.Resolution)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "update failed"})
+		return
+	}
+	if !ok {
+		if _, err := h.reconRepo.GetByID(uint(id)); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "discrepancy not found"})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": "discrepancy is not open"})
+		return
+	}
+	d, _ := h.reconRepo.GetByID(uint(id))
+	c.JSON(http.StatusOK, d)
+}
+
 func parsePagination(c *gin.Context) (int, int) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"lusty/internal/middleware"
+	"lusty/internal/models"
+	"lusty/internal/repository"
+	"lusty/internal/service/interaction"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AvailabilityHandler lets companions publish a weekly schedule with exceptions, and clients see the times
+// they can book.
+type AvailabilityHandler struct {
+	availabilityRepo *repository.AvailabilityRepository
+	companionRepo    *repository.CompanionRepository
+	interactionSvc   *interaction.Service
+}
+
+func NewAvailabilityHandler(availabilityRepo *repository.AvailabilityRepository, companionRepo *repository.CompanionRepository, interactionSvc *interaction.Service) *AvailabilityHandler {
+	return &AvailabilityHandler{availabilityRepo: availabilityRepo, companionRepo: companionRepo, interactionSvc: interactionSvc}
+}
+
+// GetMine handles GET /companions/availability — the caller's weekly schedule and upcoming exceptions.
+func (h *AvailabilityHandler) GetMine(c *gin.Context) {
+	profile, err := h.companionRepo.GetByUserID(middleware.GetUserID(c))
+	if err != nil || profile == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "profile not found"})
+		return
+	}
+	rules, err := h.availabilityRepo.ListRules(profile.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load availability"})
+		return
+	}
+	now := time.Now()
+	exceptions, err := h.availabilityRepo.ListExceptions(profile.ID, now, now.Add(interaction.MaxBookingAhead))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load availability"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"timezone":               interaction.Location(profile).String(),
+		"booking_buffer_minutes": profile.BookingBufferMinutes,
+		"rules":                  rules,
+		"exceptions":             exceptions,
+	})
+}
+
+// UpdateMine handles PUT /companions/availability — replaces the weekly schedule, and optionally sets the
+// timezone it is in and the buffer kept around bookings.
+func (h *AvailabilityHandler) UpdateMine(c *gin.Context) {
+	profile, err := h.companionRepo.GetByUserID(middleware.GetUserID(c))
+	if err != nil || profile == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "profile not found"})
+		return
+	}
+	var req struct {
+		Timezone             *string `json:"timezone"`
+		BookingBufferMinutes *int    `json:"booking_buffer_minutes" binding:"omitempty,min=0,max=240"`
+		Rules                []struct {
+			Weekday     int `json:"weekday" binding:"min=0,max=6"`
+			StartMinute int `json:"start_minute" binding:"min=0,max=1439"`
+			EndMinute   int `json:"end_minute" binding:"min=0,max=1440"`
+		} `json:"rules" binding:"max=50,dive"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Timezone != nil {
+		if _, err := time.LoadLocation(*req.Timezone); err != nil || *req.Timezone == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown timezone"})
+			return
+		}
+	}
+	rules := make([]models.AvailabilityRule, 0, len(req.Rules))
+	for _, r := range req.Rules {
+		if r.StartMinute == r.EndMinute {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a rule must not start and end at the same minute"})
+			return
+		}
+		rules = append(rules, models.AvailabilityRule{Weekday: r.Weekday, StartMinute: r.StartMinute, EndMinute: r.EndMinute})
+	}
+	if err := h.availabilityRepo.ReplaceRules(profile.ID, rules); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save availability"})
+		return
+	}
+	if req.Timezone != nil || req.BookingBufferMinutes != nil {
+		if req.Timezone != nil {
+			profile.Timezone = *req.Timezone
+		}
+		if req.BookingBufferMinutes != nil {
+			profile.BookingBufferMinutes = *req.BookingBufferMinutes
+		}
+		if err := h.companionRepo.Update(profile); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save availability"})
+			return
+		}
+	}
+	h.GetMine(c)
+}
+
+// AddException handles POST /companions/availability/exceptions — time off (available false) or extra
+// hours (available true). Existing bookings are not affected.
+func (h *AvailabilityHandler) AddException(c *gin.Context) {
+	profile, err := h.companionRepo.GetByUserID(middleware.GetUserID(c))
+	if err != nil || profile == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "profile not found"})
+		return
+	}
+	var req struct {
+		StartAt   time.Time `json:"start_at" binding:"required"`
+		EndAt     time.Time `json:"end_at" binding:"required"`
+		Available bool      `json:"available"`
+		Note      string    `json:"note" binding:"max=255"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !req.EndAt.After(req.StartAt) || !req.EndAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "end_at must be after start_at and in the future"})
+		return
+	}
+	e := &models.AvailabilityException{
+		CompanionID: profile.ID,
+		StartAt:     req.StartAt,
+		EndAt:       req.EndAt,
+		Available:   req.Available,
+		Note:        req.Note,
+	}
+	if err := h.availabilityRepo.CreateException(e); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save exception"})
+		return
+	}
+	c.JSON(http.StatusCreated, e)
+}
+
+// DeleteException handles DELETE /companions/availability/exceptions/:id.
+func (h *AvailabilityHandler) DeleteException(c *gin.Context) {
+	profile, err := h.companionRepo.GetByUserID(middleware.GetUserID(c))
+	if err != nil || profile == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "profile not found"})
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	ok, err := h.availabilityRepo.DeleteException(uint(id), profile.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete exception"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "exception not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "deleted"})
+}
+
+// FreeSlots handles GET /companions/:id/availability?from=&to= (RFC 3339; default the next 7 days, at most
+// 31) — the times the companion can be booked. A booking's slot must fit inside one window.
+func (h *AvailabilityHandler) FreeSlots(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	profile, err := h.companionRepo.GetByID(uint(id))
+	if err != nil || profile == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "profile not found"})
+		return
+	}
+	from := time.Now()
+	if v := c.Query("from"); v != "" {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			from = t
+		}
+	}
+	to := from.Add(7 * 24 * time.Hour)
+	if v := c.Query("to"); v != "" {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			to = t
+		}
+	}
+	if to.Sub(from) > 31*24*time.Hour {
+		to = from.Add(31 * 24 * time.Hour)
+	}
+	windows, err := h.interactionSvc.FreeWindows(profile, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load availability"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"timezone":               interaction.Location(profile).String(),
+		"booking_buffer_minutes": profile.BookingBufferMinutes,
+		"windows":                windows,
+	})
+}
+
+// checkRequestable checks, before any money moves, that the companion can take the request: a free slot for
+// a booking with slot_start, capacity right now for anything else. It writes the error response and returns
+// false when she cannot.
+func checkRequestable(c *gin.Context, svc *interaction.Service, comp *models.CompanionProfile, interactionType string, slotStart *time.Time, durationMinutes int) bool {
+	var err error
+	switch {
+	case slotStart == nil:
+		err = svc.CheckAvailableNow(comp)
+	case interactionType != "BOOKING":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "slot_start is only valid for BOOKING"})
+		return false
+	default:
+		err = svc.CheckSlot(comp, *slotStart, durationMinutes)
+	}
+	if err != nil {
+		availabilityError(c, err)
+		return false
+	}
+	return true
+}
+
+func availabilityError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, interaction.ErrSlotInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, interaction.ErrSlotUnavailable), errors.Is(err, repository.ErrSlotTaken),
+		errors.Is(err, interaction.ErrCompanionUnavailable), errors.Is(err, interaction.ErrAtCapacity):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check availability"})
+	}
+}
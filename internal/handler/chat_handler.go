@@ -21,7 +21,9 @@ func NewChatHandler(interactionRepo *repository.InteractionRepository, companion
 	return &ChatHandler{interactionRepo: interactionRepo, companionRepo: companionRepo}
 }
 
-// GetMessages returns paginated messages for an accepted interaction (client or companion).
+// GetMessages returns messages for an accepted interaction (client or companion), oldest first, paged by
+// message ID: ?before_id= for older messages (default: the newest page), ?after_id= for newer ones, with
+// limit (default 50, max 200). has_more says whether more messages lie beyond the page in that direction.
 func (h *ChatHandler) GetMessages(c *gin.Context) {
 	userID := middleware.GetUserID(c)
 	interactionIDStr := c.Param("interaction_id")
@@ -57,12 +59,16 @@ func (h *ChatHandler) GetMessages(c *gin.Context) {
 		return
 	}
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
-	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
-	list, err := h.interactionRepo.GetMessagesBySessionID(session.ID, limit, offset)
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	beforeID, _ := strconv.ParseUint(c.Query("before_id"), 10, 64)
+	afterID, _ := strconv.ParseUint(c.Query("after_id"), 10, 64)
+	list, hasMore, err := h.interactionRepo.ListMessages(session.ID, uint(beforeID), uint(afterID), limit)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "list failed"})
 		return
 	}
-	resp := gin.H{"messages": list, "service_completed": ir.ServiceCompletedAt != nil, "session_ended": session.EndedAt != nil}
+	resp := gin.H{"messages": list, "has_more": hasMore, "service_completed": ir.ServiceCompletedAt != nil, "session_ended": session.EndedAt != nil}
 	c.JSON(http.StatusOK, resp)
 }
@@ -3,8 +3,11 @@ package handler
 import (
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"lusty/config"
@@ -23,6 +26,8 @@ const (
 	chatWriteWait  = 10 * time.Second
 	chatPongWait   = 60 * time.Second
 	chatPingPeriod = (chatPongWait * 9) / 10
+	// chatReplayLimit caps the messages replayed on reconnect; the rest are paged with ?after_id=.
+	chatReplayLimit = 200
 )
 
 var chatUpgrader = websocket.Upgrader{
@@ -32,6 +37,15 @@ var chatUpgrader = websocket.Upgrader{
 }
 
 // UpgradeChatWS upgrades to WebSocket for chat; query: token, interaction_id. User must be client or companion of that interaction; request must be accepted.
+// Client messages: {"type":"message","client_msg_id","content","media_url"} is stored once per client_msg_id and
+// acked to the sender with {"type":"ack","client_msg_id","id"}; {"type":"delivered"|"read","message_id"}
+// marks the other side's messages up to message_id and sends them a "receipt"; {"type":"typing","typing"}
+// is relayed without being stored. Anything else gets {"type":"error"}.
+// A reconnecting client passes since_message_id (the last message ID it has): the messages after it are sent
+// before any live traffic, followed by {"type":"sync","last_message_id","has_more"}; with has_more the rest
+// are fetched from GET /me/interactions/:id/messages?after_id=last_message_id. Without since_message_id the
+// replay starts at the oldest message the user has not confirmed as delivered, if any. A connection that
+// falls too far behind is closed with 1013 (try again later) and catches up the same way.
 func UpgradeChatWS(cfg *config.JWTConfig, chatHub *ws.ChatHub, interactionRepo *repository.InteractionRepository, userRepo *repository.UserRepository, notifSvc *service.NotificationService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		token := c.Query("token")
@@ -74,6 +88,18 @@ func UpgradeChatWS(cfg *config.JWTConfig, chatHub *ws.ChatHub, interactionRepo *
 			c.JSON(http.StatusForbidden, gin.H{"error": "chat access expired"})
 			return
 		}
+		var sinceID uint64
+		replay := true
+		if v := c.Query("since_message_id"); v != "" {
+			if sinceID, err = strconv.ParseUint(v, 10, 64); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid since_message_id"})
+				return
+			}
+		} else if id, err := interactionRepo.FirstUndeliveredID(session.ID, claims.UserID); err == nil && id > 0 {
+			sinceID = uint64(id - 1)
+		} else {
+			replay = false
+		}
 		conn, err := chatUpgrader.Upgrade(c.Writer, c.Request, nil)
 		if err != nil {
 			return
@@ -85,12 +111,31 @@ func UpgradeChatWS(cfg *config.JWTConfig, chatHub *ws.ChatHub, interactionRepo *
 			Send:   make(chan []byte, 256),
 			Hub:    ws.NewHub(),
 		}
+		var evict sync.Once
+		client.Evict = func() {
+			evict.Do(func() {
+				log.Printf("[Chat] user %d on interaction %d is too slow, disconnecting", claims.UserID, interactionID)
+				_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "slow consumer"), time.Now().Add(chatWriteWait))
+				conn.Close()
+			})
+		}
 		room := chatHub.GetOrCreateRoom(interactionID, clientID, ir.CompanionID)
 		room.Join(client)
 		defer func() {
 			room.Leave(client)
 			client.Close()
 		}()
+		// Joined before the replay is read so nothing sent meanwhile is missed; live messages queue in
+		// client.Send until the replay is written, and those the replay already covered are skipped.
+		var replayedUpTo uint
+		queuedDuringReplay := 0
+		if replay {
+			replayedUpTo, err = replayChat(conn, interactionRepo, session.ID, uint(sinceID))
+			if err != nil {
+				return
+			}
+			queuedDuringReplay = len(client.Send)
+		}
 		conn.SetReadDeadline(time.Now().Add(chatPongWait))
 		conn.SetPongHandler(func(string) error {
 			conn.SetReadDeadline(time.Now().Add(chatPongWait))
@@ -105,6 +150,12 @@ func UpgradeChatWS(cfg *config.JWTConfig, chatHub *ws.ChatHub, interactionRepo *
 					if !ok {
 						return
 					}
+					if queuedDuringReplay > 0 {
+						queuedDuringReplay--
+						if alreadyReplayed(msg, replayedUpTo) {
+							continue
+						}
+					}
 					conn.SetWriteDeadline(time.Now().Add(chatWriteWait))
 					if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
 						return
@@ -117,17 +168,64 @@ func UpgradeChatWS(cfg *config.JWTConfig, chatHub *ws.ChatHub, interactionRepo *
 				}
 			}
 		}()
+		sendError := func(msg string) {
+			room.SendTo(client, map[string]interface{}{"type": "error", "error": msg})
+		}
 		for {
 			_, raw, err := conn.ReadMessage()
 			if err != nil {
 				break
 			}
 			var msg struct {
-				Type     string `json:"type"`
-				Content  string `json:"content"`
-				MediaURL string `json:"media_url"`
+				Type        string `json:"type"`
+				ClientMsgID string `json:"client_msg_id"`
+				Content     string `json:"content"`
+				MediaURL    string `json:"media_url"`
+				MessageID   uint   `json:"message_id"`
+				Typing      bool   `json:"typing"`
+			}
+			if json.Unmarshal(raw, &msg) != nil {
+				sendError("invalid message")
+				continue
 			}
-			if json.Unmarshal(raw, &msg) != nil || msg.Type != "message" {
+			switch msg.Type {
+			case "typing":
+				room.BroadcastTyping(client, claims.UserID, msg.Typing)
+				continue
+			case "delivered", "read":
+				if msg.MessageID == 0 {
+					sendError("message_id required")
+					continue
+				}
+				now := time.Now()
+				mark := interactionRepo.MarkMessagesDelivered
+				if msg.Type == "read" {
+					mark = interactionRepo.MarkMessagesRead
+				}
+				if _, err := mark(session.ID, claims.UserID, msg.MessageID, now); err != nil {
+					sendError("receipt failed")
+					continue
+				}
+				room.Broadcast(client, map[string]interface{}{
+					"type":     "receipt",
+					"status":   msg.Type,
+					"up_to_id": msg.MessageID,
+					"user_id":  claims.UserID,
+					"at":       now,
+				})
+				continue
+			case "message":
+				// stored and relayed below
+			default:
+				sendError("unknown message type")
+				continue
+			}
+			if strings.TrimSpace(msg.Content) == "" && msg.MediaURL == "" {
+				sendError("content or media_url required")
+				continue
+			}
+			if len(msg.ClientMsgID) > 64 {
+				sendError("client_msg_id too long")
 				continue
 			}
 			cm := &models.ChatMessage{
@@ -135,53 +233,82 @@ func UpgradeChatWS(cfg *config.JWTConfig, chatHub *ws.ChatHub, interactionRepo *
 				SenderID:  claims.UserID,
 				Content:   msg.Content,
 				MediaURL:  msg.MediaURL,
+				Kind:      domain.ChatMessageKindText,
+			}
+			if msg.ClientMsgID != "" {
+				cm.ClientMsgID = &msg.ClientMsgID
 			}
-			if err := interactionRepo.CreateMessage(cm); err != nil {
+			cm, created, err := interactionRepo.CreateMessageOnce(cm)
+			if err != nil {
+				sendError("message not saved")
 				continue
 			}
-			payload := map[string]interface{}{
-				"type":       "message",
-				"id":         cm.ID,
-				"sender_id":  cm.SenderID,
-				"content":    cm.Content,
-				"media_url":  cm.MediaURL,
-				"created_at": cm.CreatedAt,
+			room.SendTo(client, map[string]interface{}{
+				"type":          "ack",
+				"client_msg_id": msg.ClientMsgID,
+				"id":            cm.ID,
+				"created_at":    cm.CreatedAt,
+			})
+			if !created {
+				continue
 			}
-			room.Broadcast(client, payload)
-			// Push to recipient when they're not in app / not on chat
-			var recipientUserID uint
+			room.Broadcast(client, chatMessagePayload(cm))
+			// Push to the recipient when they are not on the chat; if they are but never confirm delivery,
+			// the push_undelivered_chat job pushes it later.
+			recipientUserID := clientID
 			if claims.UserID == clientID {
 				recipientUserID = companionUserID
-			} else {
-				recipientUserID = clientID
 			}
-			senderName := ""
-			if claims.UserID == clientID {
-				if u, _ := userRepo.GetByID(clientID); u != nil {
-					senderName = strings.TrimSpace(u.Username)
-					if senderName == "" {
-						senderName = u.Email
-					}
-					if senderName == "" {
-						senderName = "Someone"
-					}
+			if notifSvc != nil && !room.HasUser(recipientUserID) {
+				if _, err := interactionRepo.MarkMessagesPushed(session.ID, recipientUserID, cm.ID, time.Now()); err != nil {
+					log.Printf("[Chat] mark message %d pushed: %v", cm.ID, err)
 				}
-			} else {
-				senderName = ir.Companion.DisplayName
-			}
-			if senderName == "" {
-				senderName = "Someone"
-			}
-			preview := strings.TrimSpace(msg.Content)
-			if msg.MediaURL != "" && preview == "" {
-				preview = "📷 Photo"
-			}
-			if strings.HasPrefix(preview, "LOCATION:") {
-				preview = "📍 Location"
-			}
-			if preview != "" && notifSvc != nil {
-				notifSvc.NotifyNewChatMessage(recipientUserID, senderName, interactionID, preview)
+				notifSvc.NotifyNewChatMessage(ir, cm, 1)
 			}
 		}
 	}
 }
+
+// replayChat writes the session's messages after sinceID straight to conn, then the sync marker. Returns the
+// last message ID written (sinceID if there were none).
+func replayChat(conn *websocket.Conn, interactionRepo *repository.InteractionRepository, sessionID, sinceID uint) (uint, error) {
+	list, hasMore, err := interactionRepo.ListMessagesAfter(sessionID, sinceID, chatReplayLimit)
+	if err != nil {
+		return 0, err
+	}
+	lastID := sinceID
+	for i := range list {
+		conn.SetWriteDeadline(time.Now().Add(chatWriteWait))
+		if err := conn.WriteJSON(chatMessagePayload(&list[i])); err != nil {
+			return 0, err
+		}
+		lastID = list[i].ID
+	}
+	conn.SetWriteDeadline(time.Now().Add(chatWriteWait))
+	return lastID, conn.WriteJSON(map[string]interface{}{"type": "sync", "last_message_id": lastID, "has_more": hasMore})
+}
+
+// alreadyReplayed reports whether a queued frame is a message the replay already sent.
+func alreadyReplayed(frame []byte, replayedUpTo uint) bool {
+	var m struct {
+		Type string `json:"type"`
+		ID   uint   `json:"id"`
+	}
+	return json.Unmarshal(frame, &m) == nil && m.Type == "message" && m.ID <= replayedUpTo
+}
+
+func chatMessagePayload(cm *models.ChatMessage) map[string]interface{} {
+	return map[string]interface{}{
+		"type":          "message",
+		"id":            cm.ID,
+		"client_msg_id": cm.ClientMsgID,
+		"sender_id":     cm.SenderID,
+		"content":       cm.Content,
+		"media_url":     cm.MediaURL,
+		"kind":          cm.Kind,
+		"call_id":       cm.CallID,
+		"delivered_at":  cm.DeliveredAt,
+		"read_at":       cm.ReadAt,
+		"created_at":    cm.CreatedAt,
+	}
+}
@@ -28,7 +28,7 @@ func NewCompanionHandler(repo *repository.CompanionRepository, userRepo *reposit
 	return &CompanionHandler{repo: repo, userRepo: userRepo, interactionRepo: interactionRepo, cloud: cloud}
 }
 
-// GetProfile returns a companion profile by ID (public or own). For CLIENT callers, includes engagement (interaction status) if any, and is_available (false when companion has active sessions).
+// GetProfile returns a companion profile by ID (public or own). For CLIENT callers, includes engagement (interaction status) if any, and is_available (false when companion is switched off, at capacity or in a booking).
 func (h *CompanionHandler) GetProfile(c *gin.Context) {
 	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
 	profile, err := h.repo.GetByID(uint(id))
@@ -43,9 +43,10 @@ func (h *CompanionHandler) GetProfile(c *gin.Context) {
 		if roleStr, _ := role.(string); roleStr == "CLIENT" && userID != 0 {
 			profMap, _ := toMap(profile)
 			if profMap != nil {
-				// Availability: manual toggle (companion sets; auto-off when she accepts a request)
-				profMap["is_available"] = profile.IsAvailable
-				profMap["availability_status"] = map[bool]string{true: "AVAILABLE", false: "NOT_AVAILABLE"}[profile.IsAvailable]
+				// Availability: her toggles, live sessions against her capacity, and any booking in progress
+				available, _ := h.interactionRepo.CompanionAvailableNow(profile.ID)
+				profMap["is_available"] = available
+				profMap["availability_status"] = map[bool]string{true: "AVAILABLE", false: "NOT_AVAILABLE"}[available]
 
 				// Interested button: inactive only when client has active chat with another companion
 				clientBusy, _ := h.interactionRepo.ClientHasActiveSessionWithOtherCompanion(userID, uint(id))
@@ -4,26 +4,30 @@ import (
 	"fmt"
 	"math"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 
 	"lusty/config"
+	"lusty/internal/domain"
 	"lusty/internal/middleware"
 	"lusty/internal/models"
 	"lusty/internal/repository"
 	"lusty/internal/service"
+	"lusty/internal/service/interaction"
 	"lusty/pkg/payment"
 )
 
 type CryptoHandler struct {
-	cfg           *config.Config
-	paymentRepo   *repository.PaymentRepository
-	companionRepo *repository.CompanionRepository
-	walletRepo    *repository.WalletRepository
-	userRepo      *repository.UserRepository
-	notifSvc      *service.NotificationService
-	swapuzi       *payment.SwapuziProvider
+	cfg            *config.Config
+	paymentRepo    *repository.PaymentRepository
+	companionRepo  *repository.CompanionRepository
+	walletRepo     *repository.WalletRepository
+	userRepo       *repository.UserRepository
+	notifSvc       *service.NotificationService
+	interactionSvc *interaction.Service
+	swapuzi        payment.CryptoProvider
 }
 
 func NewCryptoHandler(
@@ -33,15 +37,18 @@ func NewCryptoHandler(
 	walletRepo *repository.WalletRepository,
 	userRepo *repository.UserRepository,
 	notifSvc *service.NotificationService,
+	interactionSvc *interaction.Service,
+	swapuzi payment.CryptoProvider,
 ) *CryptoHandler {
 	return &CryptoHandler{
-		cfg:           cfg,
-		paymentRepo:   paymentRepo,
-		companionRepo: companionRepo,
-		walletRepo:    walletRepo,
-		userRepo:      userRepo,
-		notifSvc:      notifSvc,
-		swapuzi:       payment.NewSwapuziProvider(cfg.Swapuzi.BaseURL, cfg.Swapuzi.Email, cfg.Swapuzi.Password),
+		cfg:            cfg,
+		paymentRepo:    paymentRepo,
+		companionRepo:  companionRepo,
+		walletRepo:     walletRepo,
+		userRepo:       userRepo,
+		notifSvc:       notifSvc,
+		interactionSvc: interactionSvc,
+		swapuzi:        swapuzi,
 	}
 }
 
@@ -64,12 +71,13 @@ func (h *CryptoHandler) GetRates(c *gin.Context) {
 func (h *CryptoHandler) Initiate(c *gin.Context) {
 	clientID := middleware.GetUserID(c)
 	var req struct {
-		CompanionID     uint   `json:"companion_id" binding:"required"`
-		InteractionType string `json:"interaction_type" binding:"required,oneof=CHAT VIDEO BOOKING"`
-		ServiceType     string `json:"service_type"`
-		AmountKES       int64  `json:"amount_kes" binding:"required,min=1"`
-		WalletAmountKES int64  `json:"wallet_amount_kes"`
-		DurationMinutes int    `json:"duration_minutes"`
+		CompanionID     uint       `json:"companion_id" binding:"required"`
+		InteractionType string     `json:"interaction_type" binding:"required,oneof=CHAT VIDEO BOOKING"`
+		ServiceType     string     `json:"service_type"`
+		AmountKES       int64      `json:"amount_kes" binding:"required,min=1"`
+		WalletAmountKES int64      `json:"wallet_amount_kes"`
+		DurationMinutes int        `json:"duration_minutes"`
+		SlotStart       *time.Time `json:"slot_start"` // BOOKING only: book this future slot
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -87,14 +95,18 @@ func (h *CryptoHandler) Initiate(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "companion not found"})
 		return
 	}
+	if !checkRequestable(c, h.interactionSvc, companion, req.InteractionType, req.SlotStart, req.DurationMinutes) {
+		return
+	}
 
 	amountCents := req.AmountKES * 100
 	walletCents := req.WalletAmountKES * 100
 	cryptoCents := amountCents - walletCents // portion to be paid via USDT
+	orderID := fmt.Sprintf("metchi-sol-%s", uuid.New().String())
 
 	// Deduct wallet portion upfront
 	if walletCents > 0 {
-		if err := h.walletRepo.Debit(clientID, walletCents); err != nil {
+		if err := h.walletRepo.Debit(clientID, walletCents, domain.LedgerAccountProviderClearing, domain.WalletTxTypePayment, orderID); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "insufficient wallet balance"})
 			return
 		}
@@ -104,14 +116,14 @@ func (h *CryptoHandler) Initiate(c *gin.Context) {
 	rates, err := h.swapuzi.GetRates(c.Request.Context())
 	if err != nil {
 		if walletCents > 0 {
-			_ = h.walletRepo.Credit(clientID, walletCents)
+			_ = h.walletRepo.Credit(clientID, walletCents, domain.LedgerAccountProviderClearing, domain.WalletTxTypeRefund, orderID)
 		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch exchange rates"})
 		return
 	}
 	if rates.UsdtBuyingRate <= 0 {
 		if walletCents > 0 {
-			_ = h.walletRepo.Credit(clientID, walletCents)
+			_ = h.walletRepo.Credit(clientID, walletCents, domain.LedgerAccountProviderClearing, domain.WalletTxTypeRefund, orderID)
 		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "invalid exchange rate received"})
 		return
@@ -121,7 +133,6 @@ func (h *CryptoHandler) Initiate(c *gin.Context) {
 	// Round up to 4 decimal places so we never under-request
 	usdtAmount := math.Ceil(cryptoKES/rates.UsdtBuyingRate*10000) / 10000
 
-	orderID := fmt.Sprintf("metchi-sol-%s", uuid.New().String())
 	webhookURL := ""
 	if h.cfg.Swapuzi.WebhookBaseURL != "" {
 		webhookURL = h.cfg.Swapuzi.WebhookBaseURL + "/api/v1/webhooks/crypto"
@@ -137,6 +148,10 @@ func (h *CryptoHandler) Initiate(c *gin.Context) {
 		`{"companion_id":%d,"interaction_type":%q,"service_type":%q,"wallet_cents":%d,"duration_minutes":%d}`,
 		req.CompanionID, req.InteractionType, req.ServiceType, walletCents, durationMinutes,
 	)
+	if req.SlotStart != nil {
+		// The slot is only held once the deposit confirms; the webhook rejects and refunds if it is gone by then
+		meta = meta[:len(meta)-1] + fmt.Sprintf(`,"slot_start":%q}`, req.SlotStart.UTC().Format(time.RFC3339))
+	}
 	pay := &models.Payment{
 		UserID:         clientID,
 		AmountCents:    amountCents,
@@ -147,9 +162,11 @@ func (h *CryptoHandler) Initiate(c *gin.Context) {
 		IdempotencyKey: orderID,
 		Metadata:       meta,
 	}
+	payExpiresAt := time.Now().Add(h.cfg.Payment.PaymentExpiry)
+	pay.ExpiresAt = &payExpiresAt
 	if err := h.paymentRepo.Create(pay); err != nil {
 		if walletCents > 0 {
-			_ = h.walletRepo.Credit(clientID, walletCents)
+			_ = h.walletRepo.Credit(clientID, walletCents, domain.LedgerAccountProviderClearing, domain.WalletTxTypeRefund, orderID)
 		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "payment record creation failed"})
 		return
@@ -162,12 +179,17 @@ func (h *CryptoHandler) Initiate(c *gin.Context) {
 		pay.Status = "FAILED"
 		_ = h.paymentRepo.Update(pay)
 		if walletCents > 0 {
-			_ = h.walletRepo.Credit(clientID, walletCents)
+			_ = h.walletRepo.Credit(clientID, walletCents, domain.LedgerAccountProviderClearing, domain.WalletTxTypeRefund, orderID)
 		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to initiate crypto payment: " + err.Error()})
 		return
 	}
 
+	// The deposit page has its own expiry; keep the payment open until then
+	if t, err := time.Parse(time.RFC3339, deposit.ExpiresAt); err == nil && t.After(payExpiresAt) {
+		_ = h.paymentRepo.SetExpiresAt(pay.ID, t)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"order_id":       orderID,
 		"page_url":       deposit.PageURL,
@@ -1,18 +1,18 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
 	"time"
 
-	"github.com/gin-gonic/gin"
-
 	"lusty/internal/domain"
 	"lusty/internal/models"
 	"lusty/internal/repository"
 	"lusty/internal/service"
+	"lusty/internal/service/interaction"
+	"lusty/pkg/payment"
 )
 
 type CryptoWebhookHandler struct {
@@ -20,9 +20,13 @@ type CryptoWebhookHandler struct {
 	interactionRepo *repository.InteractionRepository
 	companionRepo   *repository.CompanionRepository
 	walletRepo      *repository.WalletRepository
+	interactionSvc  *interaction.Service
 	userRepo        *repository.UserRepository
 	notifSvc        *service.NotificationService
 	referralRepo    *repository.ReferralRepository
+	auditRepo       *repository.AuditLogRepository
+	refundSvc       *service.RefundService
+	verifier        payment.Verifier // nil when verify-back is disabled
 }
 
 func NewCryptoWebhookHandler(
@@ -30,18 +34,26 @@ func NewCryptoWebhookHandler(
 	interactionRepo *repository.InteractionRepository,
 	companionRepo *repository.CompanionRepository,
 	walletRepo *repository.WalletRepository,
+	interactionSvc *interaction.Service,
 	userRepo *repository.UserRepository,
 	notifSvc *service.NotificationService,
 	referralRepo *repository.ReferralRepository,
+	auditRepo *repository.AuditLogRepository,
+	refundSvc *service.RefundService,
+	verifier payment.Verifier,
 ) *CryptoWebhookHandler {
 	return &CryptoWebhookHandler{
 		paymentRepo:     paymentRepo,
 		interactionRepo: interactionRepo,
 		companionRepo:   companionRepo,
 		walletRepo:      walletRepo,
+		interactionSvc:  interactionSvc,
 		userRepo:        userRepo,
 		notifSvc:        notifSvc,
 		referralRepo:    referralRepo,
+		auditRepo:       auditRepo,
+		refundSvc:       refundSvc,
+		verifier:        verifier,
 	}
 }
 
@@ -57,160 +69,159 @@ type swapuziCallback struct {
 	Timestamp         int64   `json:"timestamp"`
 }
 
-// Handle processes Swapuzi Solana deposit callbacks.
+// EventKey identifies a callback by deposit, event and status.
+func (h *CryptoWebhookHandler) EventKey(body []byte) (string, error) {
+	var payload swapuziCallback
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return "", err
+	}
+	return webhookEventKey(body, payload.MerchantDepositID, payload.Event, payload.Status), nil
+}
+
+// Process applies a stored Swapuzi Solana deposit callback.
 // On status=completed: marks payment done, creates interaction, notifies companion.
 // On status=expired/failed: marks payment failed, refunds any wallet portion.
-func (h *CryptoWebhookHandler) Handle(c *gin.Context) {
+// A retry after a partial failure picks up where the last attempt stopped (e.g. creates the interaction
+// for a payment that is already COMPLETED).
+func (h *CryptoWebhookHandler) Process(ctx context.Context, ev *models.WebhookEvent) error {
 	var payload swapuziCallback
-	if err := c.ShouldBindJSON(&payload); err != nil {
+	if err := json.Unmarshal([]byte(ev.Payload), &payload); err != nil {
 		log.Printf("[Crypto webhook] invalid payload: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
-		return
+		return nil
 	}
 	log.Printf("[Crypto webhook] event=%s merchant_deposit_id=%s status=%s received=%.4f expected=%.4f",
 		payload.Event, payload.MerchantDepositID, payload.Status, payload.ReceivedAmount, payload.ExpectedAmount)
 
 	if payload.MerchantDepositID == "" {
 		log.Printf("[Crypto webhook] no merchant_deposit_id in payload, acknowledging")
-		c.JSON(http.StatusOK, gin.H{"received": true})
-		return
+		return nil
 	}
 
 	// Look up payment by our order_id (stored as ProviderRef)
 	p, err := h.paymentRepo.GetByProviderRef(payload.MerchantDepositID)
 	if err != nil || p == nil {
 		log.Printf("[Crypto webhook] payment not found for merchant_deposit_id=%s", payload.MerchantDepositID)
-		c.JSON(http.StatusOK, gin.H{"received": true})
-		return
+		return nil
+	}
+	if p.Status == domain.PaymentStatusCancelled && payload.Status == "completed" {
+		// Paid after the abandoned-payment job cancelled it: refund it to the client's wallet
+		if err := verifyWebhookClaim(ctx, h.auditRepo, ev, h.verifier, payload.MerchantDepositID, true); err != nil {
+			return err
+		}
+		return refundLateCharge(ctx, h.refundSvc, p)
 	}
-	if p.Status == "COMPLETED" || p.Status == "FAILED" || p.Status == "CANCELLED" {
+	if p.Status == "FAILED" || p.Status == "CANCELLED" {
 		log.Printf("[Crypto webhook] payment %d already %s — ignoring", p.ID, p.Status)
-		c.JSON(http.StatusOK, gin.H{"received": true})
-		return
+		return nil
 	}
 
 	// Expired or failed: refund any wallet portion
 	if payload.Status == "expired" || payload.Status == "failed" || payload.Status == "cancelled" {
-		p.Status = "FAILED"
-		_ = h.paymentRepo.Update(p)
+		if p.Status != domain.PaymentStatusPending {
+			log.Printf("[Crypto webhook] payment %d already %s — ignoring %s", p.ID, p.Status, payload.Status)
+			return nil
+		}
+		if err := verifyWebhookClaim(ctx, h.auditRepo, ev, h.verifier, payload.MerchantDepositID, false); err != nil {
+			return err
+		}
+		var meta struct {
+			WalletCents int64 `json:"wallet_cents"`
+		}
 		if p.Metadata != "" {
-			var meta struct {
-				WalletCents int64 `json:"wallet_cents"`
-			}
-			if json.Unmarshal([]byte(p.Metadata), &meta) == nil && meta.WalletCents > 0 {
-				_ = h.walletRepo.Credit(p.UserID, meta.WalletCents)
-			}
+			_ = json.Unmarshal([]byte(p.Metadata), &meta)
+		}
+		// Only the writer that moves the payment out of PENDING refunds (the abandoned-payment job may have won)
+		ok, err := h.paymentRepo.CloseReturningWallet(p, domain.PaymentStatusFailed, meta.WalletCents)
+		if err != nil {
+			return fmt.Errorf("fail payment %d: %w", p.ID, err)
+		}
+		if !ok {
+			return nil
 		}
+		p.Status = "FAILED"
 		log.Printf("[Crypto webhook] payment %d marked FAILED (event=%s status=%s)", p.ID, payload.Event, payload.Status)
-		c.JSON(http.StatusOK, gin.H{"received": true})
-		return
+		_ = h.notifSvc.NotifyPaymentStatus(p, 0, false, paymentStatusMessage(p, "", false))
+		return nil
 	}
 
 	if payload.Status != "completed" {
 		log.Printf("[Crypto webhook] unhandled status=%s for payment %d — ignoring", payload.Status, p.ID)
-		c.JSON(http.StatusOK, gin.H{"received": true})
-		return
+		return nil
 	}
 
-	// Payment completed — mark COMPLETED
-	now := time.Now()
-	p.Status = "COMPLETED"
-	p.CompletedAt = &now
-	if err := h.paymentRepo.Update(p); err != nil {
-		log.Printf("[Crypto webhook] failed to mark payment %d COMPLETED: %v", p.ID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "update failed"})
-		return
+	// Payment completed — mark COMPLETED, unless the abandoned-payment job cancelled it meanwhile
+	if p.Status == domain.PaymentStatusPending {
+		if err := verifyWebhookClaim(ctx, h.auditRepo, ev, h.verifier, payload.MerchantDepositID, true); err != nil {
+			return err
+		}
+		ok, err := h.paymentRepo.CompleteIf(p.ID, domain.PaymentStatusPending)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if cur, _ := h.paymentRepo.GetByID(p.ID); cur != nil && cur.Status == domain.PaymentStatusCancelled {
+				return refundLateCharge(ctx, h.refundSvc, cur)
+			}
+			log.Printf("[Crypto webhook] payment %d no longer PENDING — ignoring completion", p.ID)
+			return nil
+		}
+		if p, err = h.paymentRepo.GetByID(p.ID); err != nil {
+			return err
+		}
+		_ = h.notifSvc.NotifyPaymentConfirmed(p.UserID, p.AmountCents, payload.MerchantDepositID)
 	}
-	_ = h.notifSvc.NotifyPaymentConfirmed(p.UserID, p.AmountCents, payload.MerchantDepositID)
 
 	// Referral commission: 5% for the referrer on first 2 orders
-	if h.referralRepo != nil {
-		clientUser, _ := h.userRepo.GetByID(p.UserID)
-		if clientUser != nil && clientUser.IsClient() {
-			ref, err := h.referralRepo.GetReferralByReferredUserID(p.UserID)
-			if err == nil && ref != nil && ref.CompletedCount < domain.ReferralMaxTransactions {
-				commission := int64(float64(p.AmountCents) * domain.ReferralCommissionRate)
-				if commission > 0 {
-					_ = h.walletRepo.Credit(ref.ReferrerID, commission)
-					_ = h.walletRepo.RecordTransaction(ref.ReferrerID, commission, domain.WalletTxTypeReferralCommission,
-						fmt.Sprintf("ref_%d_payment_%d", ref.ID, p.ID))
-					_ = h.referralRepo.IncrementCompletedCount(ref.ID)
-					log.Printf("[Crypto webhook] referral commission %d cents → referrer %d (ref %d)", commission, ref.ReferrerID, ref.ID)
-				}
-			}
-		}
+	if err := payClientReferralCommission(h.referralRepo, h.userRepo, h.walletRepo, p); err != nil {
+		return err
+	}
+
+	if existing, _ := h.interactionRepo.GetByPaymentID(p.ID); existing != nil {
+		return nil
 	}
 
 	// Parse metadata to build the interaction request
 	var meta struct {
-		CompanionID     uint   `json:"companion_id"`
-		InteractionType string `json:"interaction_type"`
-		ServiceType     string `json:"service_type"`
-		WalletCents     int64  `json:"wallet_cents"`
-		DurationMinutes int    `json:"duration_minutes"`
+		CompanionID     uint       `json:"companion_id"`
+		InteractionType string     `json:"interaction_type"`
+		ServiceType     string     `json:"service_type"`
+		WalletCents     int64      `json:"wallet_cents"`
+		DurationMinutes int        `json:"duration_minutes"`
+		SlotStart       *time.Time `json:"slot_start"`
 	}
 	if p.Metadata != "" {
 		_ = json.Unmarshal([]byte(p.Metadata), &meta)
 	}
 	if meta.CompanionID == 0 {
 		log.Printf("[Crypto webhook] payment %d has no companion_id in metadata — cannot create interaction", p.ID)
-		c.JSON(http.StatusOK, gin.H{"received": true})
-		return
+		return nil
 	}
 
 	companion, _ := h.companionRepo.GetByID(meta.CompanionID)
 	if companion == nil {
 		log.Printf("[Crypto webhook] companion %d not found for payment %d", meta.CompanionID, p.ID)
-		c.JSON(http.StatusOK, gin.H{"received": true})
-		return
+		return nil
 	}
 
-	clientUser, _ := h.userRepo.GetByID(p.UserID)
-	status := domain.RequestStatusPending
-	if clientUser != nil && !clientUser.KYC {
-		status = "PENDING_KYC"
-	}
 	durationMinutes := meta.DurationMinutes
 	if durationMinutes <= 0 {
 		durationMinutes = 1440
 	}
-	expiresAt := now.Add(30 * time.Minute)
+	expiresAt := time.Now().Add(30 * time.Minute)
 	ir := &models.InteractionRequest{
 		ClientID:        p.UserID,
 		CompanionID:     meta.CompanionID,
 		InteractionType: meta.InteractionType,
 		PaymentID:       &p.ID,
-		Status:          status,
 		DurationMinutes: durationMinutes,
 		ExpiresAt:       &expiresAt,
+		SlotStart:       meta.SlotStart,
+		Payment:         p,
 	}
-	if err := h.interactionRepo.Create(ir); err != nil {
-		log.Printf("[Crypto webhook] failed to create interaction for payment %d: %v", p.ID, err)
-		c.JSON(http.StatusOK, gin.H{"received": true})
-		return
-	}
-
-	if status == "PENDING_KYC" {
-		log.Printf("[Crypto webhook] payment %d: interaction %d set PENDING_KYC (client %d KYC not complete)", p.ID, ir.ID, p.UserID)
-	} else {
-		// KYC done: mark companion unavailable and notify
-		companion.IsAvailable = false
-		_ = h.companionRepo.Update(companion)
-		clientName := "A client"
-		if clientUser != nil {
-			if clientUser.Username != "" {
-				clientName = clientUser.Username
-			} else {
-				clientName = clientUser.Email
-			}
-		}
-		serviceType := meta.InteractionType
-		if meta.ServiceType != "" {
-			serviceType = meta.ServiceType
-		}
-		_ = h.notifSvc.NotifyPaidRequest(companion.UserID, ir.ID, clientName, serviceType)
-		log.Printf("[Crypto webhook] payment %d: interaction %d created, companion %d notified", p.ID, ir.ID, meta.CompanionID)
+	// Holds the payment in escrow and notifies the companion, or waits in PENDING_KYC for client KYC
+	if err := h.interactionSvc.Open(ir, true, nil); err != nil {
+		return fmt.Errorf("create interaction for payment %d: %w", p.ID, err)
 	}
-
-	c.JSON(http.StatusOK, gin.H{"received": true})
+	log.Printf("[Crypto webhook] payment %d: interaction %d created with status %s", p.ID, ir.ID, ir.Status)
+	return nil
 }
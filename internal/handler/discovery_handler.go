@@ -50,6 +50,12 @@ func (h *DiscoveryHandler) Discover(c *gin.Context) {
 			maxPrice = &p
 		}
 	}
+	var minRating *float64
+	if v := c.Query("min_rating"); v != "" {
+		if r, err := strconv.ParseFloat(v, 64); err == nil && r > 0 && r <= 5 {
+			minRating = &r
+		}
+	}
 	onlineOnly := c.Query("online_only") == "1" || c.Query("online_only") == "true"
 	boostedFirst := c.DefaultQuery("boost_first", "true") != "false"
 	sortBy := c.DefaultQuery("sort", "distance")
@@ -81,6 +87,7 @@ func (h *DiscoveryHandler) Discover(c *gin.Context) {
 		MinPrice:     minPrice,
 		MaxPrice:     maxPrice,
 		OnlineOnly:   onlineOnly,
+		MinRating:    minRating,
 		BoostedFirst: boostedFirst,
 		SortBy:       sortBy,
 		Limit:        9999,
@@ -133,6 +140,8 @@ func (h *DiscoveryHandler) Discover(c *gin.Context) {
 			"last_seen_at":       r.LastSeenAt,
 			"is_boosted":         r.IsBoosted,
 			"is_available":       r.IsAvailable,
+			"rating_avg":         r.RatingAvg,
+			"rating_count":       r.RatingCount,
 		}
 		if r.DistanceKm >= 0 {
 			progress := proximity.Progress(r.DistanceKm, radiusKm)
@@ -0,0 +1,325 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"lusty/internal/domain"
+	"lusty/internal/middleware"
+	"lusty/internal/models"
+	"lusty/internal/repository"
+	"lusty/internal/service/interaction"
+	"lusty/pkg/location"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DisputeHandler lets either party dispute an accepted interaction and admins work the dispute queue.
+type DisputeHandler struct {
+	disputeRepo     *repository.DisputeRepository
+	interactionRepo *repository.InteractionRepository
+	escrowRepo      *repository.EscrowRepository
+	locRepo         *repository.LocationRepository
+	interactionSvc  *interaction.Service
+}
+
+func NewDisputeHandler(
+	disputeRepo *repository.DisputeRepository,
+	interactionRepo *repository.InteractionRepository,
+	escrowRepo *repository.EscrowRepository,
+	locRepo *repository.LocationRepository,
+	interactionSvc *interaction.Service,
+) *DisputeHandler {
+	return &DisputeHandler{
+		disputeRepo:     disputeRepo,
+		interactionRepo: interactionRepo,
+		escrowRepo:      escrowRepo,
+		locRepo:         locRepo,
+		interactionSvc:  interactionSvc,
+	}
+}
+
+type evidenceInput struct {
+	Note     string `json:"note" binding:"max=1000"`
+	MediaURL string `json:"media_url" binding:"max=512"`
+}
+
+// Open handles POST /interactions/:id/disputes. Either party; the interaction must be ACCEPTED and paid.
+func (h *DisputeHandler) Open(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	ir, role := h.interactionForParty(c, userID)
+	if ir == nil {
+		return
+	}
+	var req struct {
+		Reason      string          `json:"reason" binding:"required,oneof=NO_SHOW NOT_CONFIRMED NOT_AS_AGREED OTHER"`
+		Description string          `json:"description" binding:"required,max=2000"`
+		Evidence    []evidenceInput `json:"evidence" binding:"max=10,dive"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if ir.Status != domain.RequestStatusAccepted {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "only an accepted interaction can be disputed"})
+		return
+	}
+	if hold, _ := h.escrowRepo.GetByInteractionID(ir.ID); hold == nil || hold.Status != domain.EscrowStatusHeld {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "interaction has no payment held in escrow"})
+		return
+	}
+	snapshot, _ := json.Marshal(h.evidence(ir))
+	d := &models.Dispute{
+		InteractionID: ir.ID,
+		ClientID:      ir.ClientID,
+		CompanionID:   ir.CompanionID,
+		OpenedBy:      userID,
+		OpenedByRole:  role,
+		Reason:        req.Reason,
+		Description:   req.Description,
+		Status:        domain.DisputeStatusOpen,
+		Snapshot:      string(snapshot),
+	}
+	for _, e := range req.Evidence {
+		if e.Note != "" || e.MediaURL != "" {
+			d.Evidence = append(d.Evidence, models.DisputeEvidence{SubmittedBy: userID, Note: e.Note, MediaURL: e.MediaURL})
+		}
+	}
+	if err := h.interactionSvc.Dispute(ir, d, &userID); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrEscrowNoPayment), errors.Is(err, repository.ErrEscrowSettled):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "interaction has no payment held in escrow"})
+		case errors.Is(err, interaction.ErrIllegalTransition), errors.Is(err, repository.ErrStatusChanged):
+			c.JSON(http.StatusConflict, gin.H{"error": "dispute failed: " + err.Error()})
+		default:
+			log.Printf("[dispute] open on interaction %d failed: %v", ir.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save dispute"})
+		}
+		return
+	}
+	log.Printf("[dispute] %d opened on interaction %d by %s %d: %s", d.ID, ir.ID, role, userID, req.Reason)
+	c.JSON(http.StatusCreated, d)
+}
+
+// Get handles GET /interactions/:id/dispute for either party.
+func (h *DisputeHandler) Get(c *gin.Context) {
+	ir, _ := h.interactionForParty(c, middleware.GetUserID(c))
+	if ir == nil {
+		return
+	}
+	d, err := h.disputeRepo.GetByInteractionID(ir.ID)
+	if err != nil || d == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "dispute not found"})
+		return
+	}
+	d.Snapshot = "" // admin evidence
+	c.JSON(http.StatusOK, d)
+}
+
+// AddEvidence handles POST /interactions/:id/dispute/evidence. Either party, while the dispute is open.
+// Files are uploaded first via /me/upload/chat.
+func (h *DisputeHandler) AddEvidence(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	ir, _ := h.interactionForParty(c, userID)
+	if ir == nil {
+		return
+	}
+	var req evidenceInput
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Note == "" && req.MediaURL == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "note or media_url required"})
+		return
+	}
+	d, err := h.disputeRepo.GetByInteractionID(ir.ID)
+	if err != nil || d == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "dispute not found"})
+		return
+	}
+	if d.Status != domain.DisputeStatusOpen {
+		c.JSON(http.StatusConflict, gin.H{"error": "dispute is already resolved"})
+		return
+	}
+	e := &models.DisputeEvidence{DisputeID: d.ID, SubmittedBy: userID, Note: req.Note, MediaURL: req.MediaURL}
+	if err := h.disputeRepo.AddEvidence(e); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save evidence"})
+		return
+	}
+	c.JSON(http.StatusCreated, e)
+}
+
+// AdminList handles GET /admin/disputes — the dispute queue, oldest first. Defaults to OPEN; status=ALL for everything.
+func (h *DisputeHandler) AdminList(c *gin.Context) {
+	status := c.DefaultQuery("status", domain.DisputeStatusOpen)
+	if status == "ALL" {
+		status = ""
+	}
+	page, limit := parsePagination(c)
+	list, total, err := h.disputeRepo.List(status, page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list disputes"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": list, "total": total, "page": page, "limit": limit})
+}
+
+// AdminGet handles GET /admin/disputes/:id: the dispute, its evidence, the escrow hold, and chat and location
+// evidence as of now next to the snapshot taken when it was opened. Status history is at
+// /admin/interactions/:id/transitions.
+func (h *DisputeHandler) AdminGet(c *gin.Context) {
+	d := h.disputeByParam(c)
+	if d == nil {
+		return
+	}
+	out := gin.H{"dispute": d}
+	if ir, _ := h.interactionRepo.GetByID(d.InteractionID); ir != nil {
+		out["interaction"] = ir
+		out["current_evidence"] = h.evidence(ir)
+	}
+	if hold, _ := h.escrowRepo.GetByInteractionID(d.InteractionID); hold != nil {
+		out["escrow"] = hold
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// AdminResolve handles POST /admin/disputes/:id/resolve. The resolution posts to the wallets through escrow:
+// REFUND returns the payment to the client, RELEASE pays the companion, SPLIT pays client_kes to the client
+// and companion_kes to the companion (or client_cents / companion_cents, for amounts that are not whole KES).
+func (h *DisputeHandler) AdminResolve(c *gin.Context) {
+	adminID := middleware.GetUserID(c)
+	d := h.disputeByParam(c)
+	if d == nil {
+		return
+	}
+	var req struct {
+		Resolution     string `json:"resolution" binding:"required,oneof=REFUND RELEASE SPLIT"`
+		ClientKES      int64  `json:"client_kes" binding:"min=0"`
+		CompanionKES   int64  `json:"companion_kes" binding:"min=0"`
+		ClientCents    *int64 `json:"client_cents" binding:"omitempty,min=0"` // takes precedence over client_kes
+		CompanionCents *int64 `json:"companion_cents" binding:"omitempty,min=0"`
+		Note           string `json:"note" binding:"required,max=500"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if d.Status != domain.DisputeStatusOpen {
+		c.JSON(http.StatusConflict, gin.H{"error": "dispute is already resolved"})
+		return
+	}
+	ir, err := h.interactionRepo.GetByID(d.InteractionID)
+	if err != nil || ir == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "interaction not found"})
+		return
+	}
+	clientCents, companionCents := req.ClientKES*100, req.CompanionKES*100
+	if req.ClientCents != nil {
+		clientCents = *req.ClientCents
+	}
+	if req.CompanionCents != nil {
+		companionCents = *req.CompanionCents
+	}
+	if req.Resolution == domain.DisputeResolutionSplit {
+		hold, _ := h.escrowRepo.GetByInteractionID(ir.ID)
+		if hold == nil || clientCents+companionCents > hold.AmountCents {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "split exceeds the amount held in escrow"})
+			return
+		}
+	} else {
+		clientCents, companionCents = 0, 0
+	}
+	hold, err := h.interactionSvc.ResolveDispute(d, ir, req.Resolution, clientCents, companionCents, &adminID, req.Note)
+	if err != nil {
+		log.Printf("[dispute] %d: resolve %s failed: %v", d.ID, req.Resolution, err)
+		switch {
+		case errors.Is(err, repository.ErrDisputeResolved):
+			c.JSON(http.StatusConflict, gin.H{"error": "dispute is already resolved"})
+		case errors.Is(err, interaction.ErrIllegalTransition), errors.Is(err, repository.ErrStatusChanged):
+			c.JSON(http.StatusConflict, gin.H{"error": "interaction is no longer disputed"})
+		case errors.Is(err, repository.ErrEscrowBadSplit):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "split exceeds the amount held in escrow"})
+		default:
+			// Nothing was changed: the dispute stays open and the admin can retry
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "escrow settlement failed, dispute left open: " + err.Error()})
+		}
+		return
+	}
+	if hold != nil {
+		clientCents, companionCents = hold.RefundedCents, hold.ReleasedCents
+	}
+	log.Printf("[dispute] %d resolved by admin %d: %s (client %d, companion %d cents)", d.ID, adminID, req.Resolution, clientCents, companionCents)
+	d, _ = h.disputeRepo.GetByID(d.ID)
+	c.JSON(http.StatusOK, gin.H{"dispute": d, "escrow": hold})
+}
+
+// interactionForParty loads :id and checks the caller is its client or companion. Writes the error response
+// and returns nil otherwise.
+func (h *DisputeHandler) interactionForParty(c *gin.Context, userID uint) (*models.InteractionRequest, string) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	ir, err := h.interactionRepo.GetByID(uint(id))
+	if err != nil || ir == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "interaction not found"})
+		return nil, ""
+	}
+	switch userID {
+	case ir.ClientID:
+		return ir, domain.RoleClient
+	case ir.Companion.UserID:
+		return ir, domain.RoleCompanion
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "interaction not found"})
+	return nil, ""
+}
+
+func (h *DisputeHandler) disputeByParam(c *gin.Context) *models.Dispute {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return nil
+	}
+	d, err := h.disputeRepo.GetByID(uint(id))
+	if err != nil || d == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "dispute not found"})
+		return nil
+	}
+	return d
+}
+
+// disputeEvidence is what the platform itself knows about a session: who wrote in the chat and when (not what),
+// and how far apart client and companion were by their last reported locations.
+type disputeEvidence struct {
+	CapturedAt       time.Time                    `json:"captured_at"`
+	AcceptedAt       *time.Time                   `json:"accepted_at"`
+	SessionStartedAt *time.Time                   `json:"session_started_at,omitempty"`
+	SessionEndsAt    *time.Time                   `json:"session_ends_at,omitempty"`
+	Chat             []repository.ChatSenderStats `json:"chat"`
+	DistanceKm       *float64                     `json:"distance_km"`
+	ClientLocationAt *time.Time                   `json:"client_location_at,omitempty"`
+	CompanionLocAt   *time.Time                   `json:"companion_location_at,omitempty"`
+}
+
+func (h *DisputeHandler) evidence(ir *models.InteractionRequest) disputeEvidence {
+	ev := disputeEvidence{CapturedAt: time.Now(), AcceptedAt: ir.AcceptedAt, Chat: []repository.ChatSenderStats{}}
+	if session, _ := h.interactionRepo.GetChatSessionByInteractionID(ir.ID); session != nil {
+		ev.SessionStartedAt, ev.SessionEndsAt = &session.StartedAt, &session.EndsAt
+		if stats, err := h.interactionRepo.ChatStatsBySessionID(session.ID); err == nil {
+			ev.Chat = stats
+		}
+	}
+	clientLoc, _ := h.locRepo.GetByUserID(ir.ClientID)
+	companionLoc, _ := h.locRepo.GetByUserID(ir.Companion.UserID)
+	if clientLoc != nil {
+		ev.ClientLocationAt = &clientLoc.LastUpdatedAt
+	}
+	if companionLoc != nil {
+		ev.CompanionLocAt = &companionLoc.LastUpdatedAt
+	}
+	if clientLoc != nil && companionLoc != nil {
+		km := location.HaversineKm(clientLoc.Latitude, clientLoc.Longitude, companionLoc.Latitude, companionLoc.Longitude)
+		km = float64(int(km*100+0.5)) / 100
+		ev.DistanceKm = &km
+	}
+	return ev
+}
@@ -0,0 +1,240 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"lusty/config"
+	"lusty/internal/domain"
+	"lusty/internal/middleware"
+	"lusty/internal/models"
+	"lusty/internal/repository"
+	"lusty/internal/service/interaction"
+	"lusty/pkg/payment"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExtensionHandler lets clients buy more time on an accepted session, from their wallet or by M-Pesa.
+type ExtensionHandler struct {
+	cfg             *config.Config
+	interactionRepo *repository.InteractionRepository
+	extensionRepo   *repository.ExtensionRepository
+	paymentRepo     *repository.PaymentRepository
+	interactionSvc  *interaction.Service
+	mpesaProvider   payment.Provider
+}
+
+func NewExtensionHandler(
+	cfg *config.Config,
+	interactionRepo *repository.InteractionRepository,
+	extensionRepo *repository.ExtensionRepository,
+	paymentRepo *repository.PaymentRepository,
+	interactionSvc *interaction.Service,
+	mpesaProvider payment.Provider,
+) *ExtensionHandler {
+	return &ExtensionHandler{
+		cfg:             cfg,
+		interactionRepo: interactionRepo,
+		extensionRepo:   extensionRepo,
+		paymentRepo:     paymentRepo,
+		interactionSvc:  interactionSvc,
+		mpesaProvider:   mpesaProvider,
+	}
+}
+
+// List handles GET /interactions/:id/extensions — the extensions bought so far and, for the client while the
+// session can be extended, the price of each length on offer.
+func (h *ExtensionHandler) List(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	ir, err := h.interactionRepo.GetByID(uint(id))
+	if err != nil || ir == nil || (ir.ClientID != userID && ir.Companion.UserID != userID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "interaction not found"})
+		return
+	}
+	list, err := h.extensionRepo.ListByInteractionID(ir.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load extensions"})
+		return
+	}
+	options := make([]gin.H, 0, len(interaction.ExtensionMinutes))
+	if ir.ClientID == userID {
+		for _, m := range interaction.ExtensionMinutes {
+			cents, err := h.interactionSvc.QuoteExtension(ir, m)
+			if err != nil {
+				break
+			}
+			options = append(options, gin.H{"minutes": m, "amount_cents": cents, "amount_kes": kesCeil(cents)})
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"extensions": list, "options": options})
+}
+
+// Create handles POST /interactions/:id/extensions — the client buys minutes (one of the offered lengths).
+// With use_wallet the wallet is charged and the session extended at once; otherwise an M-Pesa STK prompt is
+// sent and the session is extended when its webhook confirms the payment.
+func (h *ExtensionHandler) Create(c *gin.Context) {
+	clientID := middleware.GetUserID(c)
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	ir, err := h.interactionRepo.GetByID(uint(id))
+	if err != nil || ir == nil || ir.ClientID != clientID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "interaction not found"})
+		return
+	}
+	var req struct {
+		Minutes           int    `json:"minutes" binding:"required"`
+		UseWallet         bool   `json:"use_wallet"`
+		CustomerPhone     string `json:"customer_phone"`
+		CustomerFirstName string `json:"customer_first_name"`
+		CustomerLastName  string `json:"customer_last_name"`
+		CustomerEmail     string `json:"customer_email"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || !validExtensionMinutes(req.Minutes) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("minutes must be one of %v", interaction.ExtensionMinutes)})
+		return
+	}
+	amountCents, err := h.interactionSvc.QuoteExtension(ir, req.Minutes)
+	if err != nil {
+		switch {
+		case errors.Is(err, interaction.ErrExtensionNotAllowed):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, interaction.ErrNoChatPricing):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to price extension"})
+		}
+		return
+	}
+	if req.UseWallet {
+		h.payFromWallet(c, ir, req.Minutes, amountCents)
+		return
+	}
+	if req.CustomerPhone == "" || req.CustomerFirstName == "" || req.CustomerLastName == "" || req.CustomerEmail == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "customer_phone, customer_first_name, customer_last_name, customer_email required for M-Pesa"})
+		return
+	}
+	// M-Pesa charges whole shillings
+	amountCents = kesCeil(amountCents) * 100
+	orderID := fmt.Sprintf("lusty-x-%s", uuid.New().String())
+	pay := &models.Payment{
+		UserID:         clientID,
+		AmountCents:    amountCents,
+		Currency:       "KES",
+		Provider:       "mpesa_liberec",
+		ProviderRef:    orderID,
+		Status:         "PENDING",
+		IdempotencyKey: orderID,
+		Metadata:       fmt.Sprintf(`{"type":"EXTENSION","interaction_id":%d,"customer_phone":%q}`, ir.ID, req.CustomerPhone),
+	}
+	payExpiresAt := time.Now().Add(h.cfg.Payment.PaymentExpiry)
+	pay.ExpiresAt = &payExpiresAt
+	if err := h.paymentRepo.Create(pay); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "payment create failed"})
+		return
+	}
+	ext := &models.SessionExtension{
+		InteractionID: ir.ID,
+		ClientID:      clientID,
+		PaymentID:     pay.ID,
+		Minutes:       req.Minutes,
+		AmountCents:   amountCents,
+		Status:        domain.ExtensionStatusPending,
+	}
+	if err := h.extensionRepo.Create(ext); err != nil {
+		_, _ = h.paymentRepo.UpdateStatusIf(pay.ID, domain.PaymentStatusPending, domain.PaymentStatusFailed)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "extension create failed"})
+		return
+	}
+	callbackURL := ""
+	if h.cfg.LiberecMpesa.WebhookBaseURL != "" {
+		callbackURL = h.cfg.LiberecMpesa.WebhookBaseURL + "/api/v1/webhooks/mpesa"
+	}
+	resp, err := h.mpesaProvider.InitiatePayment(c.Request.Context(), payment.PaymentRequest{
+		UserID:            clientID,
+		AmountCents:       amountCents,
+		Currency:          "KES",
+		OrderID:           orderID,
+		CustomerPhone:     req.CustomerPhone,
+		CustomerFirstName: req.CustomerFirstName,
+		CustomerLastName:  req.CustomerLastName,
+		CustomerEmail:     req.CustomerEmail,
+		CallbackURL:       callbackURL,
+		Description:       fmt.Sprintf("Session extension (%d min)", req.Minutes),
+	})
+	if err != nil {
+		log.Printf("[MPESA Extension] InitiatePayment error: %v", err)
+		_, _ = h.paymentRepo.UpdateStatusIf(pay.ID, domain.PaymentStatusPending, domain.PaymentStatusFailed)
+		h.interactionSvc.ExtensionPaymentFailed(pay)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "mpesa init failed: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"extension_id":        ext.ID,
+		"payment_id":          pay.ID,
+		"order_id":            orderID,
+		"checkout_request_id": resp.CheckoutRequestID,
+		"minutes":             req.Minutes,
+		"amount_cents":        amountCents,
+		"payment_status":      pay.Status,
+		"expires_at":          pay.ExpiresAt,
+		"status_url":          fmt.Sprintf("/api/v1/payments/%d/status", pay.ID),
+		"message":             "Check your phone to complete the M-Pesa payment. Your session is extended once it goes through.",
+	})
+}
+
+func (h *ExtensionHandler) payFromWallet(c *gin.Context, ir *models.InteractionRequest, minutes int, amountCents int64) {
+	orderID := fmt.Sprintf("lusty-xw-%s", uuid.New().String())
+	now := time.Now()
+	pay := &models.Payment{
+		UserID:         ir.ClientID,
+		AmountCents:    amountCents,
+		Currency:       "KES",
+		Provider:       "wallet",
+		ProviderRef:    orderID,
+		Status:         "COMPLETED",
+		IdempotencyKey: orderID,
+		Metadata:       fmt.Sprintf(`{"type":"EXTENSION","interaction_id":%d}`, ir.ID),
+		CompletedAt:    &now,
+	}
+	ext := &models.SessionExtension{
+		InteractionID: ir.ID,
+		ClientID:      ir.ClientID,
+		Minutes:       minutes,
+		AmountCents:   amountCents,
+		Status:        domain.ExtensionStatusPending,
+	}
+	if err := h.interactionSvc.ExtendFromWallet(ir, ext, pay); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrInsufficientBalance):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "insufficient wallet balance"})
+		case errors.Is(err, interaction.ErrExtensionNotAllowed):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			log.Printf("[extension] wallet extension of %d: %v", ir.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to apply extension; your wallet was not charged"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, ext)
+}
+
+func validExtensionMinutes(m int) bool {
+	for _, v := range interaction.ExtensionMinutes {
+		if v == m {
+			return true
+		}
+	}
+	return false
+}
+
+// kesCeil rounds cents up to whole shillings.
+func kesCeil(cents int64) int64 {
+	return (cents + 99) / 100
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"lusty/config"
+	"lusty/internal/domain"
+	"lusty/internal/middleware"
+	"lusty/internal/repository"
+	"lusty/pkg/turn"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ICEHandler hands video call clients their STUN/TURN servers.
+type ICEHandler struct {
+	cfg             *config.WebRTCConfig
+	interactionRepo *repository.InteractionRepository
+}
+
+func NewICEHandler(cfg *config.WebRTCConfig, interactionRepo *repository.InteractionRepository) *ICEHandler {
+	return &ICEHandler{cfg: cfg, interactionRepo: interactionRepo}
+}
+
+// GetServers handles GET /me/interactions/:interaction_id/ice-servers — the ICE servers for a call on an
+// accepted interaction, in RTCPeerConnection iceServers form. TURN credentials are issued per user and
+// interaction and expire after WEBRTC_TURN_TTL or when the session is due to end, whichever is first; clients
+// fetch fresh ones before expires_at. coturn cannot revoke a credential it has not seen expire, so ending an
+// interaction early only stops new ones being issued: those already handed out stay valid until expires_at.
+func (h *ICEHandler) GetServers(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	interactionID, err := strconv.ParseUint(c.Param("interaction_id"), 10, 64)
+	if err != nil || interactionID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid interaction_id"})
+		return
+	}
+	ir, err := h.interactionRepo.GetByID(uint(interactionID))
+	if err != nil || ir == nil || (ir.ClientID != userID && ir.Companion.UserID != userID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "interaction not found"})
+		return
+	}
+	if ir.Status != domain.RequestStatusAccepted {
+		c.JSON(http.StatusForbidden, gin.H{"error": "interaction not accepted"})
+		return
+	}
+	now := time.Now()
+	session, _ := h.interactionRepo.GetChatSessionByInteractionID(ir.ID)
+	if session == nil || session.EndedAt != nil || !now.Before(session.EndsAt) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "session has ended"})
+		return
+	}
+	servers := []gin.H{}
+	if len(h.cfg.STUNURLs) > 0 {
+		servers = append(servers, gin.H{"urls": h.cfg.STUNURLs})
+	}
+	resp := gin.H{"ice_servers": servers}
+	if h.cfg.TURNSecret != "" && len(h.cfg.TURNURLs) > 0 {
+		expiresAt := now.Add(h.cfg.TURNCredentialTTL)
+		if session.EndsAt.Before(expiresAt) {
+			expiresAt = session.EndsAt
+		}
+		username, credential := turn.Credentials(h.cfg.TURNSecret, fmt.Sprintf("%d-%d", userID, ir.ID), expiresAt)
+		resp["ice_servers"] = append(servers, gin.H{"urls": h.cfg.TURNURLs, "username": username, "credential": credential})
+		resp["ttl_seconds"] = int(expiresAt.Sub(now) / time.Second)
+		resp["expires_at"] = expiresAt
+	}
+	c.JSON(http.StatusOK, resp)
+}
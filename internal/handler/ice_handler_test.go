@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"lusty/config"
+	"lusty/internal/database/databasetest"
+	"lusty/internal/domain"
+	"lusty/internal/models"
+	"lusty/internal/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+type iceResponse struct {
+	ICEServers []struct {
+		URLs       []string `json:"urls"`
+		Username   string   `json:"username"`
+		Credential string   `json:"credential"`
+	} `json:"ice_servers"`
+	TTLSeconds int       `json:"ttl_seconds"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// TestICEServersTURNExpiry checks TURN credentials are signed the way coturn checks them and expire after the
+// configured TTL, or at the session end if that comes first; none are issued once the session is over.
+func TestICEServersTURNExpiry(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const secret = "turn-secret"
+	cfg := &config.WebRTCConfig{STUNURLs: []string{"stun:stun.example.com:3478"}, TURNURLs: []string{"turn:turn.example.com:3478"},
+		TURNSecret: secret, TURNCredentialTTL: 10 * time.Minute}
+
+	for _, tc := range []struct {
+		name     string
+		left     time.Duration // until the session ends
+		caller   uint          // 0 for the client
+		wantCode int
+		wantTTL  time.Duration
+	}{
+		{name: "capped at the TTL", left: 2 * time.Hour, wantCode: http.StatusOK, wantTTL: 10 * time.Minute},
+		{name: "capped at the session end", left: 3 * time.Minute, wantCode: http.StatusOK, wantTTL: 3 * time.Minute},
+		{name: "session over", left: -time.Minute, wantCode: http.StatusForbidden},
+		{name: "not a party", left: time.Hour, caller: 999, wantCode: http.StatusNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db := databasetest.New(t)
+			client := models.User{Email: "client@example.com", Username: "client", Role: domain.RoleClient, KYC: true}
+			if err := db.Create(&client).Error; err != nil {
+				t.Fatalf("create client: %v", err)
+			}
+			comp := models.CompanionProfile{UserID: 100, DisplayName: "companion"}
+			if err := db.Create(&comp).Error; err != nil {
+				t.Fatalf("create companion: %v", err)
+			}
+			ir := models.InteractionRequest{ClientID: client.ID, CompanionID: comp.ID, InteractionType: "VIDEO", DurationMinutes: 60,
+				Status: domain.RequestStatusAccepted}
+			if err := db.Create(&ir).Error; err != nil {
+				t.Fatalf("create interaction: %v", err)
+			}
+			endsAt := time.Now().Add(tc.left)
+			if err := db.Create(&models.ChatSession{InteractionID: ir.ID, StartedAt: endsAt.Add(-time.Hour), EndsAt: endsAt}).Error; err != nil {
+				t.Fatalf("create session: %v", err)
+			}
+
+			caller := client.ID
+			if tc.caller != 0 {
+				caller = tc.caller
+			}
+			h := NewICEHandler(cfg, repository.NewInteractionRepository(db))
+			r := gin.New()
+			r.GET("/me/interactions/:interaction_id/ice-servers", func(c *gin.Context) { c.Set("user_id", caller) }, h.GetServers)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/me/interactions/%d/ice-servers", ir.ID), nil))
+			if w.Code != tc.wantCode {
+				t.Fatalf("status = %d body = %s, want %d", w.Code, w.Body, tc.wantCode)
+			}
+			if tc.wantCode != http.StatusOK {
+				return
+			}
+
+			var resp iceResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if got := time.Duration(resp.TTLSeconds) * time.Second; got > tc.wantTTL || got < tc.wantTTL-5*time.Second {
+				t.Fatalf("ttl = %v, want about %v", got, tc.wantTTL)
+			}
+			if len(resp.ICEServers) != 2 {
+				t.Fatalf("ice servers = %+v, want STUN and TURN", resp.ICEServers)
+			}
+			turnServer := resp.ICEServers[1]
+			expiry, user, _ := strings.Cut(turnServer.Username, ":")
+			if expiry != strconv.FormatInt(resp.ExpiresAt.Unix(), 10) || user != fmt.Sprintf("%d-%d", client.ID, ir.ID) {
+				t.Fatalf("username = %q, want %d:%d-%d", turnServer.Username, resp.ExpiresAt.Unix(), client.ID, ir.ID)
+			}
+			mac := hmac.New(sha1.New, []byte(secret))
+			mac.Write([]byte(turnServer.Username))
+			if want := base64.StdEncoding.EncodeToString(mac.Sum(nil)); turnServer.Credential != want {
+				t.Fatalf("credential = %q, want %q", turnServer.Credential, want)
+			}
+		})
+	}
+}
@@ -2,7 +2,7 @@ package handler
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
@@ -13,37 +13,46 @@ import (
 	"lusty/internal/models"
 	"lusty/internal/repository"
 	"lusty/internal/service"
+	"lusty/internal/service/interaction"
+	"lusty/internal/service/videocall"
+	"lusty/internal/ws"
 
 	"github.com/gin-gonic/gin"
 )
 
 type InteractionHandler struct {
-	interactionRepo *repository.InteractionRepository
-	companionRepo   *repository.CompanionRepository
-	paymentRepo     *repository.PaymentRepository
-	walletRepo      *repository.WalletRepository
-	userRepo        *repository.UserRepository
-	notifSvc        *service.NotificationService
-	referralRepo    *repository.ReferralRepository
+	interactionRepo   *repository.InteractionRepository
+	companionRepo     *repository.CompanionRepository
+	paymentRepo       *repository.PaymentRepository
+	userRepo          *repository.UserRepository
+	notifSvc          *service.NotificationService
+	interactionSvc    *interaction.Service
+	videoHub          *ws.VideoHub
+	videoSvc          *videocall.Service
+	autoCompleteGrace time.Duration // client's window to confirm or dispute after the companion marks delivered
 }
 
 func NewInteractionHandler(
 	interactionRepo *repository.InteractionRepository,
 	companionRepo *repository.CompanionRepository,
 	paymentRepo *repository.PaymentRepository,
-	walletRepo *repository.WalletRepository,
 	userRepo *repository.UserRepository,
 	notifSvc *service.NotificationService,
-	referralRepo *repository.ReferralRepository,
+	interactionSvc *interaction.Service,
+	videoHub *ws.VideoHub,
+	videoSvc *videocall.Service,
+	autoCompleteGrace time.Duration,
 ) *InteractionHandler {
 	return &InteractionHandler{
-		interactionRepo: interactionRepo,
-		companionRepo:   companionRepo,
-		paymentRepo:     paymentRepo,
-		walletRepo:      walletRepo,
-		userRepo:        userRepo,
-		notifSvc:        notifSvc,
-		referralRepo:    referralRepo,
+		interactionRepo:   interactionRepo,
+		companionRepo:     companionRepo,
+		paymentRepo:       paymentRepo,
+		userRepo:          userRepo,
+		notifSvc:          notifSvc,
+		interactionSvc:    interactionSvc,
+		videoHub:          videoHub,
+		videoSvc:          videoSvc,
+		autoCompleteGrace: autoCompleteGrace,
 	}
 }
 
@@ -55,53 +64,52 @@ func (h *InteractionHandler) Create(c *gin.Context) {
 		PaymentID       *uint  `json:"payment_id"`
 		PaymentRef      string `json:"payment_reference"`
 		DurationMinutes int    `json:"duration_minutes"`
+		SlotStart       *time.Time `json:"slot_start"` // BOOKING only: book this future slot
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	var paymentID *uint
+	comp, _ := h.companionRepo.GetByID(req.CompanionID)
+	if comp == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "companion not found"})
+		return
+	}
+	if !checkRequestable(c, h.interactionSvc, comp, req.InteractionType, req.SlotStart, req.DurationMinutes) {
+		return
+	}
+	var pay *models.Payment
 	if req.PaymentID != nil {
-		paymentID = req.PaymentID
+		pay, _ = h.paymentRepo.GetByID(*req.PaymentID)
 	} else if req.PaymentRef != "" {
-		p, err := h.paymentRepo.GetByProviderRef(req.PaymentRef)
-		if err != nil || p == nil || p.Status != "COMPLETED" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "valid payment required"})
-			return
-		}
-		paymentID = &p.ID
-	}
-	if paymentID == nil {
+		pay, _ = h.paymentRepo.GetByProviderRef(req.PaymentRef)
+	} else {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "payment_id or payment_reference required"})
 		return
 	}
+	if pay == nil || pay.UserID != clientID || pay.Status != "COMPLETED" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "valid payment required"})
+		return
+	}
+	if existing, _ := h.interactionRepo.GetByPaymentID(pay.ID); existing != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "payment already used"})
+		return
+	}
 	expiresAt := time.Now().Add(30 * time.Minute)
 	ir := &models.InteractionRequest{
 		ClientID:         clientID,
 		CompanionID:      req.CompanionID,
 		InteractionType:  req.InteractionType,
-		PaymentID:        paymentID,
-		Status:           domain.RequestStatusPending,
+		PaymentID:        &pay.ID,
 		DurationMinutes: req.DurationMinutes,
 		ExpiresAt:        &expiresAt,
+		SlotStart:        req.SlotStart,
+		Payment:          pay,
 	}
-	if err := h.interactionRepo.Create(ir); err != nil {
+	if err := h.interactionSvc.Open(ir, true, &clientID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "create failed"})
 		return
 	}
-	companion, _ := h.companionRepo.GetByID(req.CompanionID)
-	if companion != nil {
-		client, _ := h.userRepo.GetByID(clientID)
-		clientName := ""
-		if client != nil {
-			if client.Username != "" {
-				clientName = client.Username
-			} else {
-				clientName = client.Email
-			}
-		}
-		_ = h.notifSvc.NotifyNewRequest(companion.UserID, ir.ID, clientName)
-	}
 	c.JSON(http.StatusCreated, ir)
 }
 
@@ -125,11 +133,13 @@ func (h *InteractionHandler) ListMine(c *gin.Context) {
 		out := make([]gin.H, 0, len(list))
 		for _, ir := range list {
 			// Companion does not see PENDING_KYC (request not sent until client completes KYC)
-			if ir.Status == "PENDING_KYC" {
+			if ir.Status == domain.RequestStatusPendingKYC {
 				continue
 			}
 			clientDisplay := ""
 			clientAvatarURL := ""
+			var clientReliability *float64
+			clientRatingAvg, clientRatingCount := 0.0, 0
 			if c, _ := h.userRepo.GetByID(ir.ClientID); c != nil {
 				if c.Username != "" {
 					clientDisplay = c.Username
@@ -137,6 +147,8 @@ func (h *InteractionHandler) ListMine(c *gin.Context) {
 					clientDisplay = c.Email
 				}
 				clientAvatarURL = c.AvatarURL
+				clientReliability = c.ReliabilityScore
+				clientRatingAvg, clientRatingCount = c.ClientRatingAvg, c.ClientRatingCount
 			}
 			paymentStatus := ""
 			requestedService := ""
@@ -162,7 +174,8 @@ func (h *InteractionHandler) ListMine(c *gin.Context) {
 				"payment_status":     paymentStatus,
 				"duration_minutes":   ir.DurationMinutes,
 				"created_at":         ir.CreatedAt,
-				"client":             gin.H{"username": clientDisplay, "email": clientDisplay, "avatar_url": clientAvatarURL},
+				"client":             gin.H{"username": clientDisplay, "email": clientDisplay, "avatar_url": clientAvatarURL,
+					"reliability_score": clientReliability, "rating_avg": clientRatingAvg, "rating_count": clientRatingCount},
 				"payment":            ir.Payment,
 			}
 			if ir.Status == domain.RequestStatusAccepted {
@@ -170,6 +183,12 @@ func (h *InteractionHandler) ListMine(c *gin.Context) {
 					entry["session_ends_at"] = session.EndsAt
 					entry["session_ended"] = session.EndedAt != nil
 				}
+				entry["delivered_at"] = ir.DeliveredAt
+				entry["auto_complete_at"] = ir.AutoCompleteAt
+			}
+			if ir.SlotStart != nil {
+				entry["slot_start"] = ir.SlotStart
+				entry["slot_end"] = ir.SlotEnd
 			}
 			out = append(out, entry)
 		}
@@ -207,6 +226,12 @@ func (h *InteractionHandler) ListMine(c *gin.Context) {
 				entry["session_ends_at"] = session.EndsAt
 				entry["session_ended"] = session.EndedAt != nil
 			}
+			entry["delivered_at"] = ir.DeliveredAt
+			entry["auto_complete_at"] = ir.AutoCompleteAt
+		}
+		if ir.SlotStart != nil {
+			entry["slot_start"] = ir.SlotStart
+			entry["slot_end"] = ir.SlotEnd
 		}
 		out = append(out, entry)
 	}
@@ -235,32 +260,14 @@ func (h *InteractionHandler) Accept(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "payment not completed yet"})
 		return
 	}
-	now := time.Now()
-	ir.Status = domain.RequestStatusAccepted
-	ir.AcceptedAt = &now
-	if err := h.interactionRepo.Update(ir); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "accept failed"})
-		return
-	}
-	endsAt := now.Add(time.Duration(ir.DurationMinutes) * time.Minute)
-	if ir.DurationMinutes <= 0 {
-		endsAt = now.Add(24 * time.Hour)
-	}
-	session := &models.ChatSession{
-		InteractionID: ir.ID,
-		StartedAt:     now,
-		EndsAt:        endsAt,
-	}
-	_ = h.interactionRepo.CreateChatSession(session)
-	// Credit companion's wallet with base price (excluding platform markup)
-	baseCents := domain.CompanionBaseCents(ir.Payment.AmountCents)
-	_ = h.walletRepo.Credit(profile.UserID, baseCents)
-	_ = h.notifSvc.NotifyAccepted(ir.ClientID, profile.DisplayName, ir.ID)
-	// Auto-remove other pending requests when companion accepts one
-	_ = h.interactionRepo.RejectOtherPendingByCompanionID(profile.ID, ir.ID)
-	// Mark companion as not available until she toggles back on
-	profile.IsAvailable = false
-	_ = h.companionRepo.Update(profile)
+	if err := h.interactionSvc.Accept(ir, &userID); err != nil {
+		if errors.Is(err, interaction.ErrAtCapacity) {
+			c.JSON(http.StatusConflict, gin.H{"error": "you already have max_concurrent_chats sessions running; finish one or raise the limit in settings"})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": "accept failed: " + err.Error()})
+		return
+	}
 	c.JSON(http.StatusOK, ir)
 }
 
@@ -281,29 +288,15 @@ func (h *InteractionHandler) Reject(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "request not pending"})
 		return
 	}
-	// Refund to client wallet if payment was completed
-	if ir.PaymentID != nil && ir.Payment != nil && ir.Payment.Status == "COMPLETED" {
-		_ = h.walletRepo.Credit(ir.ClientID, ir.Payment.AmountCents)
-	}
-	now := time.Now()
-	ir.Status = domain.RequestStatusRejected
-	ir.RejectedAt = &now
-	if err := h.interactionRepo.Update(ir); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "reject failed"})
+	if err := h.interactionSvc.Reject(ir, &userID, "rejected by companion"); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "reject failed: " + err.Error()})
 		return
 	}
-	// Re-enable companion: request rejected, available for new clients again
-	profile.IsAvailable = true
-	_ = h.companionRepo.Update(profile)
-	client, _ := h.userRepo.GetByID(ir.ClientID)
-	if client != nil {
-		_ = h.notifSvc.NotifyRejected(ir.ClientID, profile.DisplayName)
-	}
 	c.JSON(http.StatusOK, ir)
 }
 
 // ServiceDone is called by the client when they confirm the service is complete.
-// Marks interaction as done, ends chat session, deletes messages, credits companion's withdrawable balance.
+// Moves the interaction to COMPLETED: ends chat session, deletes messages, releases escrow to the companion.
 func (h *InteractionHandler) ServiceDone(c *gin.Context) {
 	userID := middleware.GetUserID(c)
 	role, _ := c.Get("role")
@@ -325,52 +318,102 @@ func (h *InteractionHandler) ServiceDone(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "service already marked done"})
 		return
 	}
-	now := time.Now()
-	ir.ServiceCompletedAt = &now
-	if err := h.interactionRepo.Update(ir); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "update failed"})
+	if err := h.interactionSvc.Complete(ir, &userID); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "update failed: " + err.Error()})
 		return
 	}
-	session, _ := h.interactionRepo.GetChatSessionByInteractionID(ir.ID)
-	if session != nil {
-		session.EndedAt = &now
-		_ = h.interactionRepo.UpdateChatSession(session)
-		_ = h.interactionRepo.DeleteMessagesBySessionID(session.ID)
-	}
-	// Credit companion's withdrawable balance (95% of her base price)
-	if ir.PaymentID != nil && ir.Payment != nil && ir.Payment.Status == "COMPLETED" {
-		comp, _ := h.companionRepo.GetByID(ir.CompanionID)
-		if comp != nil {
-			baseCents := domain.CompanionBaseCents(ir.Payment.AmountCents)
-			payoutCents := domain.CompanionPayout(baseCents)
-			_ = h.walletRepo.CreditWithdrawable(comp.UserID, payoutCents)
-			_ = h.walletRepo.RecordTransaction(comp.UserID, payoutCents, domain.WalletTxTypeEarning, fmt.Sprintf("%d", ir.ID))
-
-			// Record platform profit: markup + 5% commission
-			platformProfit := domain.PlatformFee(baseCents) + (baseCents - payoutCents)
-			_ = h.walletRepo.RecordTransaction(comp.UserID, platformProfit, domain.WalletTxTypePlatformFee, fmt.Sprintf("interaction_%d", ir.ID))
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "message": "Service confirmed. Companion can now withdraw."})
+}
 
-			// Pay 5% referral commission to whoever referred this companion, for their first 2 transactions
-			if h.referralRepo != nil {
-				ref, err := h.referralRepo.GetReferralByReferredUserID(comp.UserID)
-				if err == nil && ref != nil && ref.CompletedCount < domain.ReferralMaxTransactions {
-					commission := int64(float64(baseCents) * domain.ReferralCommissionRate)
-					if commission > 0 {
-						_ = h.walletRepo.Credit(ref.ReferrerID, commission)
-						_ = h.walletRepo.RecordTransaction(ref.ReferrerID, commission, domain.WalletTxTypeReferralCommission,
-							fmt.Sprintf("ref_%d_interaction_%d", ref.ID, ir.ID))
-						_ = h.referralRepo.IncrementCompletedCount(ref.ID)
-						log.Printf("[referral] companion %d: credited %d cents commission to referrer %d (ref %d, count now %d)",
-							comp.UserID, commission, ref.ReferrerID, ref.ID, ref.CompletedCount+1)
-					}
-				}
-			}
+// Cancel is called by the client to cancel a PENDING, PENDING_KYC or ACCEPTED request. The refund follows the
+// cancellation policy in system settings: full before accept, possibly less the longer the session has run.
+func (h *InteractionHandler) Cancel(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	ir := h.clientInteraction(c, userID)
+	if ir == nil {
+		return
+	}
+	var req struct {
+		Reason string `json:"reason" binding:"max=255"`
+	}
+	_ = c.ShouldBindJSON(&req)
+	quote, err := h.interactionSvc.CancelByClient(ir, &userID, req.Reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, interaction.ErrCancelAfterDelivery), errors.Is(err, interaction.ErrCancelDisputed):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, interaction.ErrIllegalTransition):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "request can no longer be cancelled"})
+		default:
+			c.JSON(http.StatusConflict, gin.H{"error": "cancel failed: " + err.Error()})
 		}
+		return
 	}
-	c.JSON(http.StatusOK, gin.H{"status": "ok", "message": "Service confirmed. Companion can now withdraw."})
+	c.JSON(http.StatusOK, gin.H{"status": ir.Status, "cancellation": quote})
 }
 
-// VideoCallRequest is called when a user initiates a video call. Sends push to the other party.
+// CancellationQuote returns what the client would get back by cancelling the request now.
+func (h *InteractionHandler) CancellationQuote(c *gin.Context) {
+	ir := h.clientInteraction(c, middleware.GetUserID(c))
+	if ir == nil {
+		return
+	}
+	quote, err := h.interactionSvc.QuoteCancellation(ir)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"cancellable": false, "reason": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"cancellable": true, "cancellation": quote})
+}
+
+// clientInteraction loads :id for its client, or writes a 404.
+func (h *InteractionHandler) clientInteraction(c *gin.Context, userID uint) *models.InteractionRequest {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	ir, err := h.interactionRepo.GetByID(uint(id))
+	if err != nil || ir == nil || ir.ClientID != userID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "interaction not found"})
+		return nil
+	}
+	return ir
+}
+
+// MarkDelivered is called by the companion when the service has been delivered. The client then has the grace
+// period to confirm (service-done) or dispute; otherwise the interaction completes automatically.
+func (h *InteractionHandler) MarkDelivered(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	profile, err := h.companionRepo.GetByUserID(userID)
+	if err != nil || profile == nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "companion only"})
+		return
+	}
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	ir, err := h.interactionRepo.GetByID(uint(id))
+	if err != nil || ir == nil || ir.CompanionID != profile.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "interaction not found"})
+		return
+	}
+	if ir.Status != domain.RequestStatusAccepted {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "interaction not accepted"})
+		return
+	}
+	if ir.DeliveredAt != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "service already marked delivered"})
+		return
+	}
+	ok, err := h.interactionSvc.MarkDelivered(ir, time.Now().Add(h.autoCompleteGrace))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "update failed"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": "interaction changed, please refresh"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "delivered_at": ir.DeliveredAt, "auto_complete_at": ir.AutoCompleteAt})
+}
+
+// VideoCallRequest is called when a user initiates a video call. Records the call as ringing and rings the
+// other party, by push if they are not in the video room; the call then continues on /ws/video.
 func (h *InteractionHandler) VideoCallRequest(c *gin.Context) {
 	callerID := middleware.GetUserID(c)
 	interactionIDStr := c.Param("interaction_id")
@@ -392,32 +435,21 @@ func (h *InteractionHandler) VideoCallRequest(c *gin.Context) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "interaction not accepted"})
 		return
 	}
-	// Determine callee: if caller is client, callee is companion; else callee is client
-	var calleeUserID uint
-	var callerName string
-	if ir.ClientID == callerID {
-		calleeUserID = ir.Companion.UserID
-		callerName = "A client"
-		if u, _ := h.userRepo.GetByID(callerID); u != nil {
-			if u.Username != "" {
-				callerName = u.Username
-			} else {
-				callerName = u.Email
-			}
-		}
-	} else if ir.Companion.UserID == callerID {
-		calleeUserID = ir.ClientID
-		callerName = ir.Companion.DisplayName
-		if callerName == "" {
-			callerName = "A companion"
-		}
-	} else {
+	if ir.ClientID != callerID && ir.Companion.UserID != callerID {
 		log.Printf("[VideoCall] callerID=%d is not part of interactionID=%d (clientID=%d companionUserID=%d)", callerID, interactionID, ir.ClientID, ir.Companion.UserID)
 		c.JSON(http.StatusForbidden, gin.H{"error": "not part of this interaction"})
 		return
 	}
-	log.Printf("[VideoCall] sending push: callerID=%d callerName=%q calleeUserID=%d interactionID=%d", callerID, callerName, calleeUserID, ir.ID)
-	h.notifSvc.NotifyVideoCall(calleeUserID, callerName, ir.ID)
-	log.Printf("[VideoCall] push dispatched OK interactionID=%d", ir.ID)
-	c.JSON(http.StatusOK, gin.H{"status": "ok", "message": "Call request sent"})
+	call, err := inviteVideoCall(h.videoHub, h.videoSvc, h.notifSvc, ir, callerID)
+	if err != nil {
+		if errors.Is(err, repository.ErrVideoCallInProgress) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("[VideoCall] invite failed interactionID=%d: %v", ir.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not place call"})
+		return
+	}
+	log.Printf("[VideoCall] call %d ringing callerID=%d interactionID=%d", call.ID, callerID, ir.ID)
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "message": "Call request sent", "call_id": call.ID})
 }
@@ -1,8 +1,8 @@
 package handler
 
 import (
-	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"time"
 
@@ -10,6 +10,7 @@ import (
 	"lusty/internal/middleware"
 	"lusty/internal/repository"
 	"lusty/internal/service"
+	"lusty/internal/service/interaction"
 
 	"github.com/gin-gonic/gin"
 )
@@ -22,7 +23,9 @@ type MeHandler struct {
 	paymentRepo     *repository.PaymentRepository
 	interactionRepo *repository.InteractionRepository
 	walletRepo      *repository.WalletRepository
+	escrowRepo      *repository.EscrowRepository
 	notifSvc        *service.NotificationService
+	interactionSvc  *interaction.Service
 }
 
 func NewMeHandler(
@@ -33,7 +36,9 @@ func NewMeHandler(
 	paymentRepo *repository.PaymentRepository,
 	interactionRepo *repository.InteractionRepository,
 	walletRepo *repository.WalletRepository,
+	escrowRepo *repository.EscrowRepository,
 	notifSvc *service.NotificationService,
+	interactionSvc *interaction.Service,
 ) *MeHandler {
 	return &MeHandler{
 		userRepo:        userRepo,
@@ -43,7 +48,9 @@ func NewMeHandler(
 		paymentRepo:     paymentRepo,
 		interactionRepo: interactionRepo,
 		walletRepo:      walletRepo,
+		escrowRepo:      escrowRepo,
 		notifSvc:        notifSvc,
+		interactionSvc:  interactionSvc,
 	}
 }
 
@@ -137,6 +144,7 @@ func (h *MeHandler) UpdateSettings(c *gin.Context) {
 		AcceptNewRequests *bool    `json:"accept_new_requests"`
 		IsLocationVisible *bool    `json:"is_location_visible"`
 		Available         *bool    `json:"available"` // companion: manual "I'm available" toggle
+		MaxConcurrentChats *int    `json:"max_concurrent_chats"` // companion: unscheduled sessions at once
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -182,6 +190,13 @@ func (h *MeHandler) UpdateSettings(c *gin.Context) {
 		if req.Available != nil {
 			profile.IsAvailable = *req.Available
 		}
+		if req.MaxConcurrentChats != nil {
+			if limit := h.interactionSvc.MaxChatCapacity(); *req.MaxConcurrentChats < 1 || *req.MaxConcurrentChats > limit {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("max_concurrent_chats must be between 1 and %d", limit)})
+				return
+			}
+			profile.MaxConcurrentChats = *req.MaxConcurrentChats
+		}
 		if req.IsLocationVisible != nil {
 			loc, _ := h.locRepo.GetByUserID(userID)
 			if loc != nil {
@@ -213,12 +228,15 @@ func (h *MeHandler) GetDashboard(c *gin.Context) {
 		return
 	}
 
-	// Wallet: balance (from payments) and withdrawable (after client confirms service done)
+	// Wallet: pending (client payments in escrow, awaiting service done), withdrawable (after client confirms service done)
 	wallet, _ := h.walletRepo.GetOrCreate(userID)
-	balanceCents := int64(0)
+	heldCents, _ := h.escrowRepo.PendingPayoutByCompanionID(profile.ID)
+	balanceCents := heldCents
+	pendingCents := heldCents
 	withdrawableCents := int64(0)
 	if wallet != nil {
-		balanceCents = wallet.BalanceCents
+		balanceCents += wallet.BalanceCents + wallet.PendingCents
+		pendingCents += wallet.PendingCents
 		withdrawableCents = wallet.WithdrawableCents
 	}
 
@@ -238,8 +256,12 @@ func (h *MeHandler) GetDashboard(c *gin.Context) {
 	// Pending requests count (for badge)
 	pendingRequests, _ := h.interactionRepo.CountPendingByCompanionID(profile.ID)
 
+	// What clients see: toggles, capacity and any booking in progress
+	availableNow, _ := h.interactionRepo.CompanionAvailableNow(profile.ID)
+
 	c.JSON(http.StatusOK, gin.H{
 		"earnings_cents":        balanceCents,
+		"pending_cents":         pendingCents,
 		"withdrawable_cents":    withdrawableCents,
 		"is_boosted":            boost != nil,
 		"boost_ends_at":         boostEndsAt,
@@ -247,6 +269,8 @@ func (h *MeHandler) GetDashboard(c *gin.Context) {
 		"active_sessions":      activeSessions,
 		"pending_requests":     pendingRequests,
 		"is_available":         profile.IsAvailable,
+		"available_now":        availableNow,
+		"max_concurrent_chats": profile.ChatCapacity(),
 	})
 }
 
@@ -327,56 +351,19 @@ func (h *MeHandler) CompleteKYC(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"status": "ok", "kyc": true, "released": 0})
 		return
 	}
-	clientName := u.Email
-	if u.Username != "" {
-		clientName = u.Username
-	}
 	released := 0
 	for i := range list {
 		ir := &list[i]
 		if ir.Payment == nil || ir.Payment.Status != "COMPLETED" {
 			continue
 		}
-		serviceType := ir.InteractionType
-		if ir.Payment.Metadata != "" {
-			var meta struct {
-				ServiceType string `json:"service_type"`
-			}
-			if json.Unmarshal([]byte(ir.Payment.Metadata), &meta) == nil && meta.ServiceType != "" {
-				serviceType = meta.ServiceType
-			}
-		}
-		comp, _ := h.companionRepo.GetByID(ir.CompanionID)
-		// If the companion is no longer accepting new requests, cancel and refund the client.
-		if comp == nil || !comp.AcceptNewRequests || !comp.IsAvailable {
-			ir.Status = domain.RequestStatusRejected
-			_ = h.interactionRepo.Update(ir)
-			if h.walletRepo != nil {
-				amtCents := ir.Payment.AmountCents
-				_ = h.walletRepo.Credit(userID, amtCents)
-				_ = h.walletRepo.RecordTransaction(userID, amtCents, domain.WalletTxTypeRefund, fmt.Sprintf("interaction_%d", ir.ID))
-			}
-			companionName := "your companion"
-			if comp != nil {
-				companionName = comp.DisplayName
-			}
-			if h.notifSvc != nil {
-				_ = h.notifSvc.Notify(userID, "KYC_REFUND", "Companion unavailable",
-					companionName+" is no longer available. Your payment has been refunded to your wallet.",
-					map[string]interface{}{"interaction_id": ir.ID})
-			}
-			continue
-		}
-		ir.Status = domain.RequestStatusPending
-		if err := h.interactionRepo.Update(ir); err != nil {
+		ok, err := h.interactionSvc.ReleaseKYC(ir, &userID)
+		if err != nil {
+			log.Printf("[KYC] release of interaction %d failed: %v", ir.ID, err)
 			continue
 		}
-		released++
-		// KYC now done: mark companion unavailable (request is now active)
-		comp.IsAvailable = false
-		_ = h.companionRepo.Update(comp)
-		if h.notifSvc != nil {
-			_ = h.notifSvc.NotifyPaidRequest(comp.UserID, ir.ID, clientName, serviceType)
+		if ok {
+			released++
 		}
 	}
 	c.JSON(http.StatusOK, gin.H{"status": "ok", "kyc": true, "released": released})
@@ -15,6 +15,7 @@ import (
 	"lusty/internal/models"
 	"lusty/internal/repository"
 	"lusty/internal/service"
+	"lusty/internal/service/interaction"
 	"lusty/pkg/payment"
 
 	"github.com/gin-gonic/gin"
@@ -26,6 +27,7 @@ type MpesaHandler struct {
 	interactionRepo *repository.InteractionRepository
 	companionRepo   *repository.CompanionRepository
 	walletRepo      *repository.WalletRepository
+	interactionSvc  *interaction.Service
 	userRepo        *repository.UserRepository
 	notifSvc        *service.NotificationService
 	mpesaProvider   payment.Provider
@@ -37,25 +39,22 @@ func NewMpesaHandler(
 	interactionRepo *repository.InteractionRepository,
 	companionRepo *repository.CompanionRepository,
 	walletRepo *repository.WalletRepository,
+	interactionSvc *interaction.Service,
 	userRepo *repository.UserRepository,
 	notifSvc *service.NotificationService,
+	mpesaProvider payment.Provider,
 ) *MpesaHandler {
-	m := &MpesaHandler{
+	return &MpesaHandler{
 		cfg:             cfg,
 		paymentRepo:     paymentRepo,
 		interactionRepo: interactionRepo,
 		companionRepo:   companionRepo,
 		walletRepo:      walletRepo,
+		interactionSvc:  interactionSvc,
 		userRepo:       userRepo,
 		notifSvc:        notifSvc,
+		mpesaProvider:   mpesaProvider,
 	}
-	m.mpesaProvider = payment.NewLiberecMpesaProvider(
-		cfg.LiberecMpesa.BaseURL,
-		cfg.LiberecMpesa.Email,
-		cfg.LiberecMpesa.Password,
-		cfg.LiberecMpesa.WebhookBaseURL,
-	)
-	return m
 }
 
 // Initiate starts payment: wallet-only (instant) or M-Pesa STK (or wallet + M-Pesa partial).
@@ -73,6 +72,7 @@ func (h *MpesaHandler) Initiate(c *gin.Context) {
 		CustomerLastName  string `json:"customer_last_name"`
 		CustomerEmail     string `json:"customer_email"`
 		DurationMinutes   int    `json:"duration_minutes"`
+		SlotStart         *time.Time `json:"slot_start"` // BOOKING only: book this future slot
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -89,17 +89,20 @@ func (h *MpesaHandler) Initiate(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "companion not found"})
 		return
 	}
+	if !checkRequestable(c, h.interactionSvc, companion, req.InteractionType, req.SlotStart, req.DurationMinutes) {
+		return
+	}
 	amountCents := req.AmountKES * 100
 	walletCents := req.WalletAmountKES * 100
 	mpesaCents := amountCents - walletCents
 
 	// Wallet-only: deduct and create completed payment + request immediately
 	if mpesaCents <= 0 {
-		if err := h.walletRepo.Debit(clientID, walletCents); err != nil {
+		orderID := fmt.Sprintf("lusty-w-%s", uuid.New().String())
+		if err := h.walletRepo.Debit(clientID, walletCents, domain.LedgerAccountProviderClearing, domain.WalletTxTypePayment, orderID); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "insufficient wallet balance"})
 			return
 		}
-		orderID := fmt.Sprintf("lusty-w-%s", uuid.New().String())
 		walletOnlyMeta := ""
 		if req.ServiceType != "" {
 			walletOnlyMeta = fmt.Sprintf(`{"service_type":%q}`, req.ServiceType)
@@ -117,50 +120,36 @@ func (h *MpesaHandler) Initiate(c *gin.Context) {
 		now := time.Now()
 		pay.CompletedAt = &now
 		if err := h.paymentRepo.Create(pay); err != nil {
-			h.walletRepo.Credit(clientID, walletCents) // rollback
+			h.walletRepo.Credit(clientID, walletCents, domain.LedgerAccountProviderClearing, domain.WalletTxTypeRefund, orderID) // rollback
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "payment create failed"})
 			return
 		}
 		expiresAt := now.Add(30 * time.Minute)
-		clientUser, _ := h.userRepo.GetByID(clientID)
-		status := domain.RequestStatusPending
-		if clientUser != nil && !clientUser.KYC {
-			status = "PENDING_KYC" // request not sent to companion until KYC complete
-		}
 		ir := &models.InteractionRequest{
 			ClientID:         clientID,
 			CompanionID:      req.CompanionID,
 			InteractionType:  req.InteractionType,
 			PaymentID:        &pay.ID,
-			Status:           status,
 			DurationMinutes:  req.DurationMinutes,
 			ExpiresAt:        &expiresAt,
+			SlotStart:        req.SlotStart,
+			Payment:          pay,
 		}
 		if ir.DurationMinutes <= 0 {
 			ir.DurationMinutes = 1440 // 24 hours
 		}
-		if err := h.interactionRepo.Create(ir); err != nil {
-			h.walletRepo.Credit(clientID, walletCents)
+		// Request is not sent to companion until client KYC is complete (status PENDING_KYC)
+		if err := h.interactionSvc.Open(ir, true, &clientID); err != nil {
+			h.walletRepo.Credit(clientID, walletCents, domain.LedgerAccountProviderClearing, domain.WalletTxTypeRefund, orderID)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "interaction create failed"})
 			return
 		}
+		status := ir.Status
 		msg := "Payment successful! Waiting for " + companion.DisplayName + " to accept your request."
-		if status == "PENDING_KYC" {
-			// Client KYC not done: keep companion available for other clients; mark unavailable only after KYC
+		if status == domain.RequestStatusPendingKYC {
 			msg = "Payment successful! Complete KYC to send your request to " + companion.DisplayName + "."
-		} else {
-			// Client KYC done: request goes to companion now, mark her unavailable
-			companion.IsAvailable = false
-			_ = h.companionRepo.Update(companion)
-			clientName := "A client"
-			if clientUser != nil {
-				if clientUser.Username != "" {
-					clientName = clientUser.Username
-				} else {
-					clientName = clientUser.Email
-				}
-			}
-			_ = h.notifSvc.NotifyPaidRequest(companion.UserID, ir.ID, clientName, req.InteractionType)
+		} else if status == domain.RequestStatusRejected {
+			msg = "That time slot is no longer available. Your payment has been refunded to your wallet."
 		}
 		c.JSON(http.StatusOK, gin.H{
 			"order_id":        orderID,
@@ -169,7 +158,7 @@ func (h *MpesaHandler) Initiate(c *gin.Context) {
 			"currency":        "KES",
 			"payment_status":  "COMPLETED",
 			"message":         msg,
-			"requires_kyc":   status == "PENDING_KYC",
+			"requires_kyc":   status == domain.RequestStatusPendingKYC,
 		})
 		return
 	}
@@ -179,22 +168,20 @@ func (h *MpesaHandler) Initiate(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "customer_phone, customer_first_name, customer_last_name, customer_email required for M-Pesa"})
 		return
 	}
+	orderID := fmt.Sprintf("lusty-%s", uuid.New().String())
 	if walletCents > 0 {
-		if err := h.walletRepo.Debit(clientID, walletCents); err != nil {
+		if err := h.walletRepo.Debit(clientID, walletCents, domain.LedgerAccountProviderClearing, domain.WalletTxTypePayment, orderID); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "insufficient wallet balance"})
 			return
 		}
 	}
-	orderID := fmt.Sprintf("lusty-%s", uuid.New().String())
 	callbackURL := ""
 	if h.cfg.LiberecMpesa.WebhookBaseURL != "" {
 		callbackURL = h.cfg.LiberecMpesa.WebhookBaseURL + "/api/v1/webhooks/mpesa"
 	}
 	log.Printf("[MPESA] Initiate order_id=%s callback_url=%s amount_kes=%d mpesa_kes=%d", orderID, callbackURL, req.AmountKES, mpesaCents/100)
-	walletMeta := ""
-	if walletCents > 0 || req.ServiceType != "" {
-		walletMeta = fmt.Sprintf(`{"wallet_cents":%d,"service_type":%q}`, walletCents, req.ServiceType)
-	}
+	// customer_phone is where a refund to the original payment source goes
+	walletMeta := fmt.Sprintf(`{"wallet_cents":%d,"service_type":%q,"customer_phone":%q}`, walletCents, req.ServiceType, req.CustomerPhone)
 	pay := &models.Payment{
 		UserID:         clientID,
 		AmountCents:    amountCents,
@@ -205,13 +192,44 @@ func (h *MpesaHandler) Initiate(c *gin.Context) {
 		IdempotencyKey: orderID,
 		Metadata:       walletMeta,
 	}
+	payExpiresAt := time.Now().Add(h.cfg.Payment.PaymentExpiry)
+	pay.ExpiresAt = &payExpiresAt
 	if err := h.paymentRepo.Create(pay); err != nil {
 		if walletCents > 0 {
-			h.walletRepo.Credit(clientID, walletCents)
+			h.walletRepo.Credit(clientID, walletCents, domain.LedgerAccountProviderClearing, domain.WalletTxTypeRefund, orderID)
 		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "payment create failed"})
 		return
 	}
+	expiresAt := time.Now().Add(30 * time.Minute)
+	ir := &models.InteractionRequest{
+		ClientID:         clientID,
+		CompanionID:      req.CompanionID,
+		InteractionType:  req.InteractionType,
+		PaymentID:        &pay.ID,
+		DurationMinutes: req.DurationMinutes,
+		ExpiresAt:        &expiresAt,
+		SlotStart:        req.SlotStart,
+	}
+	if ir.DurationMinutes <= 0 {
+		ir.DurationMinutes = 1440 // 24 hours
+	}
+	// Created PENDING but unpaid before the STK prompt goes out, so a callback however fast finds it; the
+	// webhook completes it via interaction.Service.PaymentCompleted
+	if err := h.interactionSvc.Open(ir, false, &clientID); err != nil {
+		h.closePayment(pay, domain.PaymentStatusFailed, walletCents)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "interaction create failed"})
+		return
+	}
+	if ir.Status == domain.RequestStatusRejected {
+		// Slot taken since it was checked; no STK prompt was sent
+		h.closePayment(pay, domain.PaymentStatusFailed, walletCents)
+		c.JSON(http.StatusConflict, gin.H{
+			"error":          "time slot is no longer available; any wallet amount used has been returned",
+			"interaction_id": ir.ID,
+		})
+		return
+	}
 	stkReq := payment.PaymentRequest{
 		UserID:            clientID,
 		AmountCents:       mpesaCents,
@@ -230,104 +248,39 @@ func (h *MpesaHandler) Initiate(c *gin.Context) {
 	resp, err := h.mpesaProvider.InitiatePayment(c.Request.Context(), stkReq)
 	if err != nil {
 		log.Printf("[MPESA] InitiatePayment error: %v", err)
-		h.paymentRepo.Update(&models.Payment{ID: pay.ID, Status: "FAILED"})
-		if walletCents > 0 {
-			h.walletRepo.Credit(clientID, walletCents)
+		// The prompt may have reached the phone regardless: cancelled, a charge completed anyway is refunded
+		// to the wallet by the webhook
+		h.closePayment(pay, domain.PaymentStatusCancelled, walletCents)
+		if err := h.interactionSvc.Expire(ir, "payment could not be started"); err != nil {
+			log.Printf("[MPESA] order_id=%s expire interaction %d: %v", orderID, ir.ID, err)
 		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "mpesa init failed: " + err.Error()})
 		return
 	}
-	expiresAt := time.Now().Add(30 * time.Minute)
-	ir := &models.InteractionRequest{
-		ClientID:         clientID,
-		CompanionID:      req.CompanionID,
-		InteractionType:  req.InteractionType,
-		PaymentID:        &pay.ID,
-		Status:           domain.RequestStatusPending,
-		DurationMinutes: req.DurationMinutes,
-		ExpiresAt:        &expiresAt,
-	}
-	if ir.DurationMinutes <= 0 {
-		ir.DurationMinutes = 1440 // 24 hours
-	}
-	if err := h.interactionRepo.Create(ir); err != nil {
-		if walletCents > 0 {
-			h.walletRepo.Credit(clientID, walletCents)
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "interaction create failed"})
-		return
-	}
-	log.Printf("[MPESA] STK sent order_id=%s checkout_request_id=%s — polling for completion", orderID, resp.CheckoutRequestID)
-
-	// Poll the DB every 2 seconds until the M-Pesa webhook marks the payment COMPLETED or FAILED.
-	// Maximum wait is 90 seconds (M-Pesa STK push typically resolves within 60s).
-	ctx := c.Request.Context()
-	deadline := time.Now().Add(90 * time.Second)
-	var finalPayment *models.Payment
-	for time.Now().Before(deadline) {
-		if ctx.Err() != nil {
-			return // client disconnected
-		}
-		p2, err := h.paymentRepo.GetByID(pay.ID)
-		if err == nil && (p2.Status == "COMPLETED" || p2.Status == "FAILED") {
-			finalPayment = p2
-			break
-		}
-		select {
-		case <-ctx.Done():
-			return
-		case <-time.After(2 * time.Second):
-		}
-	}
+	log.Printf("[MPESA] STK sent order_id=%s checkout_request_id=%s payment_id=%d", orderID, resp.CheckoutRequestID, pay.ID)
 
-	if finalPayment == nil || finalPayment.Status != "COMPLETED" {
-		// Timed out or failed: cancel the payment so late webhooks are ignored, refund wallet portion.
-		pay.Status = "CANCELLED"
-		_ = h.paymentRepo.Update(pay)
-		ir.Status = domain.RequestStatusExpired
-		_ = h.interactionRepo.Update(ir)
-		if walletCents > 0 {
-			_ = h.walletRepo.Credit(clientID, walletCents)
-		}
-		resultStatus := "TIMEOUT"
-		msg := "Payment timed out. Please try again."
-		if finalPayment != nil && finalPayment.Status == "FAILED" {
-			resultStatus = "FAILED"
-			msg = "Payment was cancelled or failed. Please try again."
-		}
-		log.Printf("[MPESA] order_id=%s result=%s", orderID, resultStatus)
-		c.JSON(http.StatusOK, gin.H{
-			"order_id":       orderID,
-			"payment_status": resultStatus,
-			"message":        msg,
-		})
-		return
-	}
+	// The result arrives via the M-Pesa webhook: the app is told over /ws/user and FCM, and can poll
+	// GET /payments/:id/status. Unanswered STK prompts are cancelled by the abandoned-payment job.
+	c.JSON(http.StatusAccepted, gin.H{
+		"payment_id":          pay.ID,
+		"order_id":            orderID,
+		"interaction_id":      ir.ID,
+		"checkout_request_id": resp.CheckoutRequestID,
+		"amount":              req.AmountKES,
+		"currency":            "KES",
+		"payment_status":      pay.Status,
+		"expires_at":          pay.ExpiresAt,
+		"status_url":          fmt.Sprintf("/api/v1/payments/%d/status", pay.ID),
+		"message":             "Check your phone to complete the M-Pesa payment.",
+	})
+}
 
-	// Payment COMPLETED — re-read the interaction (webhook may have set PENDING_KYC).
-	ir2, err := h.interactionRepo.GetByID(ir.ID)
-	if err != nil || ir2 == nil {
-		ir2 = ir
+// closePayment moves a payment Initiate gave up on to status and returns the wallet portion in the same
+// transaction. If that fails the payment stays PENDING and the abandoned-payment job cancels it later.
+func (h *MpesaHandler) closePayment(pay *models.Payment, status string, walletCents int64) {
+	if _, err := h.paymentRepo.CloseReturningWallet(pay, status, walletCents); err != nil {
+		log.Printf("[MPESA] order_id=%s close payment %d as %s: %v", pay.ProviderRef, pay.ID, status, err)
 	}
-	requiresKyc := ir2.Status == "PENDING_KYC"
-	msg := "Payment successful! Waiting for " + companion.DisplayName + " to accept your request."
-	if requiresKyc {
-		msg = "Payment successful! Complete KYC to send your request to " + companion.DisplayName + "."
-	} else {
-		// KYC done: companion has the request, mark her unavailable for other clients
-		companion.IsAvailable = false
-		_ = h.companionRepo.Update(companion)
-	}
-	log.Printf("[MPESA] order_id=%s COMPLETED interaction_id=%d requires_kyc=%v", orderID, ir2.ID, requiresKyc)
-	c.JSON(http.StatusOK, gin.H{
-		"order_id":       orderID,
-		"interaction_id": ir2.ID,
-		"amount":         req.AmountKES,
-		"currency":       "KES",
-		"payment_status": "COMPLETED",
-		"message":        msg,
-		"requires_kyc":   requiresKyc,
-	})
 }
 
 // InitiateBoost starts M-Pesa payment for companion boost (1000 KES, 1 month). Companion only.
@@ -366,8 +319,10 @@ func (h *MpesaHandler) InitiateBoost(c *gin.Context) {
 		ProviderRef:    orderID,
 		Status:         "PENDING",
 		IdempotencyKey: orderID,
-		Metadata:       `{"type":"BOOST"}`,
+		Metadata:       fmt.Sprintf(`{"type":"BOOST","customer_phone":%q}`, req.CustomerPhone),
 	}
+	payExpiresAt := time.Now().Add(h.cfg.Payment.PaymentExpiry)
+	pay.ExpiresAt = &payExpiresAt
 	if err := h.paymentRepo.Create(pay); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "payment create failed"})
 		return
@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"lusty/config"
+	"lusty/internal/database/databasetest"
+	"lusty/internal/domain"
+	"lusty/internal/models"
+	"lusty/internal/repository"
+	"lusty/internal/service"
+	"lusty/internal/service/interaction"
+	"lusty/pkg/payment"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// stkStub stands in for the M-Pesa provider. It records whether the request for the order already existed
+// when the STK prompt was sent, since the callback may arrive before InitiatePayment returns.
+type stkStub struct {
+	payment.Provider
+	db           *gorm.DB
+	err          error
+	foundRequest bool
+	prompted     bool
+}
+
+func (s *stkStub) InitiatePayment(_ context.Context, req payment.PaymentRequest) (*payment.PaymentResponse, error) {
+	s.prompted = true
+	var n int64
+	s.db.Model(&models.InteractionRequest{}).Joins("JOIN payments ON payments.id = interaction_requests.payment_id").
+		Where("payments.provider_ref = ?", req.OrderID).Count(&n)
+	s.foundRequest = n > 0
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &payment.PaymentResponse{Reference: req.OrderID, CheckoutRequestID: "ws_CO_1"}, nil
+}
+
+// initiateMpesa books an hour tomorrow with a 1200 KES M-Pesa payment, 200 KES of it from the wallet, as the
+// client. (A booking avoids the immediate-availability query, which SQLite cannot run.)
+func initiateMpesa(t *testing.T, db *gorm.DB, stub *stkStub) (*httptest.ResponseRecorder, uint) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	userRepo := repository.NewUserRepository(db)
+	walletRepo := repository.NewWalletRepository(db)
+	companionRepo := repository.NewCompanionRepository(db)
+	interactionRepo := repository.NewInteractionRepository(db)
+	notifSvc := service.NewNotificationService(repository.NewNotificationRepository(db), userRepo, nil)
+	interactionSvc := interaction.NewService(interactionRepo, companionRepo, userRepo, walletRepo, repository.NewEscrowRepository(db),
+		repository.NewReferralRepository(db), repository.NewSettingRepository(db), repository.NewAvailabilityRepository(db),
+		repository.NewExtensionRepository(db), repository.NewDisputeRepository(db), notifSvc)
+	cfg := &config.Config{}
+	cfg.Payment.PaymentExpiry = 15 * time.Minute
+	h := NewMpesaHandler(cfg, repository.NewPaymentRepository(db), interactionRepo, companionRepo, walletRepo, interactionSvc,
+		userRepo, notifSvc, stub)
+
+	client := models.User{Email: "client@example.com", Username: "client", Role: domain.RoleClient, KYC: true}
+	companionUser := models.User{Email: "companion@example.com", Username: "companion", Role: domain.RoleCompanion}
+	for _, u := range []*models.User{&client, &companionUser} {
+		if err := db.Create(u).Error; err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+	}
+	comp := models.CompanionProfile{UserID: companionUser.ID, DisplayName: "companion", IsActive: true, IsAvailable: true, AcceptNewRequests: true}
+	if err := db.Create(&comp).Error; err != nil {
+		t.Fatalf("create companion: %v", err)
+	}
+	for day := 0; day < 7; day++ {
+		if err := db.Create(&models.AvailabilityRule{CompanionID: comp.ID, Weekday: day, StartMinute: 0, EndMinute: 24 * 60}).Error; err != nil {
+			t.Fatalf("create availability: %v", err)
+		}
+	}
+	if err := walletRepo.Credit(client.ID, 20_000, domain.LedgerAccountProviderClearing, domain.WalletTxTypeRefund, "seed"); err != nil {
+		t.Fatalf("fund wallet: %v", err)
+	}
+
+	r := gin.New()
+	r.POST("/payments/mpesa", func(c *gin.Context) { c.Set("user_id", client.ID) }, h.Initiate)
+	body, _ := json.Marshal(map[string]interface{}{
+		"companion_id": comp.ID, "interaction_type": "BOOKING", "amount_kes": 1200, "wallet_amount_kes": 200,
+		"slot_start": time.Now().Add(24 * time.Hour).Truncate(time.Hour), "duration_minutes": 60,
+		"customer_phone": "254700000000", "customer_first_name": "A", "customer_last_name": "B", "customer_email": "a@example.com",
+	})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/payments/mpesa", bytes.NewReader(body)))
+	return w, client.ID
+}
+
+// TestMpesaInitiateOpensRequestBeforeSTK checks the unpaid request exists before the STK prompt goes out, so
+// a callback that beats the handler's response still finds it.
+func TestMpesaInitiateOpensRequestBeforeSTK(t *testing.T) {
+	db := databasetest.New(t)
+	stub := &stkStub{db: db}
+	w, clientID := initiateMpesa(t, db, stub)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d body = %s, want 202", w.Code, w.Body)
+	}
+	if !stub.foundRequest {
+		t.Fatal("STK prompt sent before the interaction request was created")
+	}
+	if got := clientBalance(t, db, clientID); got != 0 {
+		t.Fatalf("client wallet = %d, want the 20000 wallet portion taken", got)
+	}
+}
+
+// TestMpesaInitiateSTKFailure checks a prompt that cannot be sent returns the wallet portion once and closes
+// both the payment and its request.
+func TestMpesaInitiateSTKFailure(t *testing.T) {
+	db := databasetest.New(t)
+	w, clientID := initiateMpesa(t, db, &stkStub{db: db, err: errors.New("provider down")})
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d body = %s, want 500", w.Code, w.Body)
+	}
+	if got := clientBalance(t, db, clientID); got != 20_000 {
+		t.Fatalf("client wallet = %d, want 20000 returned", got)
+	}
+	var pay models.Payment
+	db.First(&pay)
+	if pay.Status != domain.PaymentStatusCancelled {
+		t.Fatalf("payment status = %s, want CANCELLED", pay.Status)
+	}
+	var ir models.InteractionRequest
+	db.First(&ir)
+	if ir.Status != domain.RequestStatusExpired {
+		t.Fatalf("interaction status = %s, want EXPIRED", ir.Status)
+	}
+}
+
+func clientBalance(t *testing.T, db *gorm.DB, userID uint) int64 {
+	t.Helper()
+	wallet, err := repository.NewWalletRepository(db).GetByUserID(userID)
+	if err != nil {
+		t.Fatalf("wallet: %v", err)
+	}
+	return wallet.BalanceCents
+}
@@ -1,19 +1,21 @@
 package handler
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"time"
 
 	"lusty/internal/domain"
 	"lusty/internal/models"
 	"lusty/internal/repository"
 	"lusty/internal/service"
-
-	"github.com/gin-gonic/gin"
+	"lusty/internal/service/interaction"
+	"lusty/pkg/payment"
 )
 
 // LiberecMpesaCallback is the webhook payload from TheLiberec after M-Pesa payment.
@@ -44,10 +46,14 @@ type MpesaWebhookHandler struct {
 	interactionRepo *repository.InteractionRepository
 	companionRepo   *repository.CompanionRepository
 	walletRepo      *repository.WalletRepository
+	escrowRepo      *repository.EscrowRepository
+	interactionSvc  *interaction.Service
 	auditRepo       *repository.AuditLogRepository
 	notifSvc        *service.NotificationService
 	userRepo        *repository.UserRepository
 	referralRepo    *repository.ReferralRepository
+	refundSvc       *service.RefundService
+	verifier        payment.Verifier // nil when verify-back is disabled
 }
 
 func NewMpesaWebhookHandler(
@@ -55,97 +61,128 @@ func NewMpesaWebhookHandler(
 	interactionRepo *repository.InteractionRepository,
 	companionRepo *repository.CompanionRepository,
 	walletRepo *repository.WalletRepository,
+	escrowRepo *repository.EscrowRepository,
+	interactionSvc *interaction.Service,
 	auditRepo *repository.AuditLogRepository,
 	notifSvc *service.NotificationService,
 	userRepo *repository.UserRepository,
 	referralRepo *repository.ReferralRepository,
+	refundSvc *service.RefundService,
+	verifier payment.Verifier,
 ) *MpesaWebhookHandler {
 	return &MpesaWebhookHandler{
 		paymentRepo:     paymentRepo,
 		interactionRepo: interactionRepo,
 		companionRepo:   companionRepo,
 		walletRepo:      walletRepo,
+		escrowRepo:      escrowRepo,
+		interactionSvc:  interactionSvc,
 		auditRepo:       auditRepo,
 		notifSvc:        notifSvc,
 		userRepo:        userRepo,
 		referralRepo:    referralRepo,
+		refundSvc:       refundSvc,
+		verifier:        verifier,
 	}
 }
 
-// Handle processes TheLiberec M-Pesa callback. On status=COMPLETED: marks payment done, notifies companion to accept/deny. Companion must accept before chat unlocks.
-func (h *MpesaWebhookHandler) Handle(c *gin.Context) {
-	body, err := io.ReadAll(c.Request.Body)
-	if err != nil {
-		log.Printf("[MPESA callback] ReadBody error: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
-		return
+func (p *LiberecMpesaCallback) orderID() string {
+	if p.MerchantOrderID != "" {
+		return p.MerchantOrderID
+	}
+	if p.OrderID != "" {
+		return p.OrderID
 	}
-	log.Printf("[MPESA callback] raw body: %s", string(body))
+	return p.ReferenceOrderID
+}
+
+// EventKey identifies a callback by order and reported status: redeliveries collapse, while a later
+// callback with a different status for the same order is a new event.
+func (h *MpesaWebhookHandler) EventKey(body []byte) (string, error) {
 	var payload LiberecMpesaCallback
 	if err := json.Unmarshal(body, &payload); err != nil {
+		return "", err
+	}
+	return webhookEventKey(body, payload.orderID(), payload.Status, payload.TransactionUUID), nil
+}
+
+// Process applies a stored TheLiberec M-Pesa callback. On status=COMPLETED: marks payment done, holds it in
+// escrow and notifies the companion to accept/deny (companion must accept before chat unlocks). A payment the
+// abandoned-payment job already cancelled is refunded to the client's wallet instead. Every step checks
+// whether it already happened, so a retry after a partial failure finishes the job.
+func (h *MpesaWebhookHandler) Process(ctx context.Context, ev *models.WebhookEvent) error {
+	log.Printf("[MPESA callback] event %d raw body: %s", ev.ID, ev.Payload)
+	var payload LiberecMpesaCallback
+	if err := json.Unmarshal([]byte(ev.Payload), &payload); err != nil {
 		log.Printf("[MPESA callback] json unmarshal error: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
-		return
+		return nil
 	}
 	log.Printf("[MPESA callback] parsed: status=%s merchant_order_id=%s order_id=%s reference_order_id=%s amount=%s", payload.Status, payload.MerchantOrderID, payload.OrderID, payload.ReferenceOrderID, payload.Amount)
-	orderID := payload.MerchantOrderID
-	if orderID == "" {
-		orderID = payload.OrderID
-	}
-	if orderID == "" {
-		orderID = payload.ReferenceOrderID
-	}
+	orderID := payload.orderID()
 	if orderID == "" {
 		log.Printf("[MPESA callback] no order_id in payload, acknowledging")
-		c.JSON(http.StatusOK, gin.H{"received": true})
-		return
+		return nil
 	}
 	p, err := h.paymentRepo.GetByProviderRef(orderID)
 	if err != nil || p == nil {
 		log.Printf("[MPESA callback] payment not found for order_id=%s", orderID)
-		c.JSON(http.StatusOK, gin.H{"received": true})
-		return
+		return nil
 	}
-	if p.Status == "COMPLETED" || p.Status == "CANCELLED" {
-		log.Printf("[MPESA callback] payment %d already %s for order_id=%s — ignoring", p.ID, p.Status, orderID)
-		c.JSON(http.StatusOK, gin.H{"received": true})
-		return
+	if p.Status == domain.PaymentStatusCancelled {
+		if payload.Status != "COMPLETED" {
+			log.Printf("[MPESA callback] payment %d already %s for order_id=%s — ignoring %s", p.ID, p.Status, orderID, payload.Status)
+			return nil
+		}
+		if err := verifyWebhookClaim(ctx, h.auditRepo, ev, h.verifier, orderID, true); err != nil {
+			return err
+		}
+		return refundLateCharge(ctx, h.refundSvc, p)
 	}
 	if payload.Status != "COMPLETED" {
-		log.Printf("[MPESA callback] non-COMPLETED status=%s status_code=%s for order_id=%s, refunding wallet if any", payload.Status, payload.StatusCode, orderID)
-		// M-Pesa failed/cancelled - refund any wallet portion
-		if p.Metadata != "" {
-			var meta struct {
-				WalletCents int64 `json:"wallet_cents"`
-			}
-			if json.Unmarshal([]byte(p.Metadata), &meta) == nil && meta.WalletCents > 0 {
-				_ = h.walletRepo.Credit(p.UserID, meta.WalletCents)
-			}
+		if p.Status != domain.PaymentStatusPending {
+			log.Printf("[MPESA callback] payment %d already %s for order_id=%s — ignoring %s", p.ID, p.Status, orderID, payload.Status)
+			return nil
+		}
+		if err := verifyWebhookClaim(ctx, h.auditRepo, ev, h.verifier, orderID, false); err != nil {
+			return err
 		}
-		if p.Status == "PENDING" {
-			p.Status = "FAILED"
-			_ = h.paymentRepo.Update(p)
-		}
-		c.JSON(http.StatusOK, gin.H{"received": true})
-		return
-	}
-	log.Printf("[MPESA callback] marking payment %d COMPLETED for order_id=%s", p.ID, orderID)
-	now := time.Now()
-	p.Status = "COMPLETED"
-	p.CompletedAt = &now
-	if err := h.paymentRepo.Update(p); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "update failed"})
-		return
-	}
-	_ = h.notifSvc.NotifyPaymentConfirmed(p.UserID, p.AmountCents, orderID)
-	_ = h.auditRepo.Create(&models.AuditLog{
-		UserID:     &p.UserID,
-		Action:     "mpesa_payment_completed",
-		Resource:   "payment",
-		ResourceID: orderID,
-		IP:         c.ClientIP(),
-		UserAgent:  c.Request.UserAgent(),
-	})
+		return h.paymentFailed(p, orderID, payload)
+	}
+
+	justCompleted := false
+	if p.Status != domain.PaymentStatusCompleted {
+		if err := verifyWebhookClaim(ctx, h.auditRepo, ev, h.verifier, orderID, true); err != nil {
+			return err
+		}
+		log.Printf("[MPESA callback] marking payment %d COMPLETED for order_id=%s", p.ID, orderID)
+		ok, err := h.paymentRepo.CompleteIf(p.ID, p.Status)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			log.Printf("[MPESA callback] payment %d changed status concurrently for order_id=%s — re-reading", p.ID, orderID)
+		}
+		if p, err = h.paymentRepo.GetByID(p.ID); err != nil {
+			return err
+		}
+		if p.Status == domain.PaymentStatusCancelled {
+			return refundLateCharge(ctx, h.refundSvc, p)
+		}
+		if p.Status != domain.PaymentStatusCompleted {
+			return nil
+		}
+		justCompleted = ok
+		if ok {
+			_ = h.auditRepo.Create(&models.AuditLog{
+				UserID:     &p.UserID,
+				Action:     "mpesa_payment_completed",
+				Resource:   "payment",
+				ResourceID: orderID,
+				IP:         ev.SourceIP,
+				UserAgent:  ev.UserAgent,
+			})
+		}
+	}
 
 	// Boost payment: no interaction, create CompanionBoost for 1 month
 	var meta struct {
@@ -155,88 +192,199 @@ func (h *MpesaWebhookHandler) Handle(c *gin.Context) {
 		_ = json.Unmarshal([]byte(p.Metadata), &meta)
 	}
 	if meta.Type == "BOOST" {
-		profile, _ := h.companionRepo.GetByUserID(p.UserID)
-		if profile != nil {
-			boostEnd := now.Add(30 * 24 * time.Hour) // 1 month
-			b := &models.CompanionBoost{
-				CompanionID: profile.ID,
-				BoostType:   "30d",
-				StartAt:     now,
-				EndAt:       boostEnd,
-				IsActive:    true,
-			}
-			_ = h.companionRepo.CreateBoost(b)
-			log.Printf("[MPESA callback] boost activated for companion %d (payment %d)", profile.ID, p.ID)
+		if err := h.activateBoost(p, orderID); err != nil {
+			return err
+		}
+		if justCompleted {
+			_ = h.notifSvc.NotifyPaymentStatus(p, 0, false, "Payment successful! Your profile boost is active for 1 month.")
 		}
-		c.JSON(http.StatusOK, gin.H{"received": true})
-		return
+		return nil
 	}
 
-	// Pay 5% referral commission to whoever referred this client, for their first 2 orders.
-	if h.referralRepo != nil {
-		clientUser, _ := h.userRepo.GetByID(p.UserID)
-		if clientUser != nil && clientUser.IsClient() {
-			ref, err := h.referralRepo.GetReferralByReferredUserID(p.UserID)
-			if err == nil && ref != nil && ref.CompletedCount < domain.ReferralMaxTransactions {
-				commission := int64(float64(p.AmountCents) * domain.ReferralCommissionRate)
-				if commission > 0 {
-					_ = h.walletRepo.Credit(ref.ReferrerID, commission)
-					_ = h.walletRepo.RecordTransaction(ref.ReferrerID, commission, domain.WalletTxTypeReferralCommission,
-						fmt.Sprintf("ref_%d_payment_%d", ref.ID, p.ID))
-					_ = h.referralRepo.IncrementCompletedCount(ref.ID)
-					log.Printf("[referral] client %d: credited %d cents commission to referrer %d (ref %d, count now %d)",
-						p.UserID, commission, ref.ReferrerID, ref.ID, ref.CompletedCount+1)
-				}
-			}
+	// Session extension: joins the interaction's escrow and moves the session end
+	if meta.Type == "EXTENSION" {
+		interactionID, err := h.interactionSvc.ExtensionPaid(p)
+		if err != nil {
+			return fmt.Errorf("extension payment %d: %w", p.ID, err)
+		}
+		if justCompleted {
+			_ = h.notifSvc.NotifyPaymentStatus(p, interactionID, false, "Payment successful! Your session has been extended.")
 		}
+		return nil
+	}
+
+	// Pay 5% referral commission to whoever referred this client, for their first 2 orders.
+	if err := payClientReferralCommission(h.referralRepo, h.userRepo, h.walletRepo, p); err != nil {
+		return err
 	}
 
 	// Payment confirmed. If client KYC not complete, set PENDING_KYC and do not notify companion yet.
 	// When client completes KYC, request is released (status -> PENDING) and companion is notified.
-	ir, err := h.interactionRepo.GetByPaymentID(p.ID)
-	if err == nil && ir != nil {
-		if ir.Status == "REJECTED" {
-			if ir.Payment != nil {
-				_ = h.walletRepo.Credit(ir.ClientID, ir.Payment.AmountCents)
-				log.Printf("[MPESA callback] interaction %d already REJECTED, refunded %d cents to client %d", ir.ID, ir.Payment.AmountCents, ir.ClientID)
+	ir, _ := h.interactionRepo.GetByPaymentID(p.ID)
+	if ir == nil {
+		if justCompleted {
+			_ = h.notifSvc.NotifyPaymentStatus(p, 0, false, "Your payment was successful.")
+		}
+		return nil
+	}
+	msg := ""
+	switch ir.Status {
+	case domain.RequestStatusRejected, domain.RequestStatusExpired, domain.RequestStatusCancelled:
+		if _, err := h.escrowRepo.Refund(ir, "payment completed after request was closed", nil); err != nil {
+			return fmt.Errorf("refund of closed interaction %d: %w", ir.ID, err)
+		}
+		log.Printf("[MPESA callback] interaction %d already %s, refunded %d cents to client %d", ir.ID, ir.Status, p.AmountCents, ir.ClientID)
+		msg = "Payment received after your request had closed. It has been refunded to your wallet."
+	case domain.RequestStatusPending:
+		if hold, _ := h.escrowRepo.GetByInteractionID(ir.ID); hold == nil {
+			// Holds the payment in escrow, then sends the request to the companion (or waits for client KYC)
+			if err := h.interactionSvc.PaymentCompleted(ir); err != nil {
+				return fmt.Errorf("interaction %d: %w", ir.ID, err)
 			}
-		} else if ir.Status == "PENDING" {
-			comp, _ := h.companionRepo.GetByID(ir.CompanionID)
-			clientUser, _ := h.userRepo.GetByID(ir.ClientID)
-			if clientUser != nil && !clientUser.KYC {
-				// Client has not completed KYC: keep companion available for other clients
-				ir.Status = "PENDING_KYC"
-				_ = h.interactionRepo.Update(ir)
-				log.Printf("[MPESA callback] interaction %d set PENDING_KYC (client %d KYC not complete)", ir.ID, ir.ClientID)
-			} else {
-				// KYC done: mark companion unavailable and notify them
-				if comp != nil {
-					comp.IsAvailable = false
-					_ = h.companionRepo.Update(comp)
-				}
-				clientName := "A client"
-				if ir.Client.ID != 0 {
-					if ir.Client.Username != "" {
-						clientName = ir.Client.Username
-					} else {
-						clientName = ir.Client.Email
-					}
-				}
-				serviceType := ir.InteractionType
-				if p.Metadata != "" {
-					var meta struct {
-						ServiceType string `json:"service_type"`
-					}
-					if json.Unmarshal([]byte(p.Metadata), &meta) == nil && meta.ServiceType != "" {
-						serviceType = meta.ServiceType
-					}
-				}
-				if comp != nil {
-					_ = h.notifSvc.NotifyPaidRequest(comp.UserID, ir.ID, clientName, serviceType)
-				}
+			justCompleted = true
+		}
+	}
+	if justCompleted {
+		requiresKyc := ir.Status == domain.RequestStatusPendingKYC
+		if msg == "" {
+			msg = paymentStatusMessage(p, ir.Companion.DisplayName, requiresKyc)
+		}
+		_ = h.notifSvc.NotifyPaymentStatus(p, ir.ID, requiresKyc, msg)
+	}
+	return nil
+}
+
+// paymentFailed marks a PENDING payment FAILED and refunds any wallet portion in the same transaction, once:
+// only the writer that moves the payment out of PENDING refunds (redelivered callbacks and the
+// abandoned-payment job race here). An error leaves the payment PENDING for the inbox to retry.
+func (h *MpesaWebhookHandler) paymentFailed(p *models.Payment, orderID string, payload LiberecMpesaCallback) error {
+	log.Printf("[MPESA callback] non-COMPLETED status=%s status_code=%s for order_id=%s, refunding wallet if any", payload.Status, payload.StatusCode, orderID)
+	var meta struct {
+		WalletCents int64 `json:"wallet_cents"`
+	}
+	if p.Metadata != "" {
+		_ = json.Unmarshal([]byte(p.Metadata), &meta)
+	}
+	failed, err := h.paymentRepo.CloseReturningWallet(p, domain.PaymentStatusFailed, meta.WalletCents)
+	if err != nil {
+		return fmt.Errorf("fail payment %d: %w", p.ID, err)
+	}
+	if !failed {
+		return nil
+	}
+	p.Status = domain.PaymentStatusFailed
+	h.interactionSvc.ExtensionPaymentFailed(p)
+	var interactionID uint
+	if ir, _ := h.interactionRepo.GetByPaymentID(p.ID); ir != nil {
+		interactionID = ir.ID
+		if ir.Status == domain.RequestStatusPending {
+			if err := h.interactionSvc.Expire(ir, "payment failed"); err != nil {
+				log.Printf("[MPESA callback] order_id=%s expire interaction %d: %v", orderID, ir.ID, err)
 			}
 		}
 	}
+	_ = h.notifSvc.NotifyPaymentStatus(p, interactionID, false, paymentStatusMessage(p, "", false))
+	return nil
+}
+
+// activateBoost creates the paid boost and books its revenue, skipping whichever already happened.
+func (h *MpesaWebhookHandler) activateBoost(p *models.Payment, orderID string) error {
+	profile, _ := h.companionRepo.GetByUserID(p.UserID)
+	if profile == nil {
+		log.Printf("[MPESA callback] boost payment %d: no companion profile for user %d", p.ID, p.UserID)
+		return nil
+	}
+	if b, err := h.companionRepo.GetBoostByPaymentID(p.ID); err != nil {
+		return err
+	} else if b == nil {
+		now := time.Now()
+		if err := h.companionRepo.CreateBoost(&models.CompanionBoost{
+			CompanionID: profile.ID,
+			BoostType:   "30d",
+			StartAt:     now,
+			EndAt:       now.Add(30 * 24 * time.Hour), // 1 month
+			IsActive:    true,
+			PaymentID:   &p.ID,
+		}); err != nil {
+			return err
+		}
+		log.Printf("[MPESA callback] boost activated for companion %d (payment %d)", profile.ID, p.ID)
+	}
+	if posted, err := h.walletRepo.HasEntry(domain.WalletTxTypeBoostPayment, orderID); err != nil || posted {
+		return err
+	}
+	return h.walletRepo.Post(domain.WalletTxTypeBoostPayment, orderID,
+		repository.LedgerLeg{Account: domain.LedgerAccountProviderClearing, AmountCents: -p.AmountCents},
+		repository.LedgerLeg{Account: domain.LedgerAccountPlatformRevenue, AmountCents: p.AmountCents},
+	)
+}
+
+// refundLateCharge refunds a charge the provider confirmed after the abandoned-payment job cancelled the
+// payment: the client paid and nothing was delivered, so the money goes to their wallet (RefundService
+// notifies them). A charge that was already refunded is acknowledged.
+func refundLateCharge(ctx context.Context, refundSvc *service.RefundService, p *models.Payment) error {
+	rf, err := refundSvc.RefundLateCharge(ctx, p)
+	if errors.Is(err, service.ErrNotRefundable) {
+		log.Printf("[webhook] payment %d was paid after it was cancelled and is already refunded", p.ID)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("refund late charge of payment %d: %w", p.ID, err)
+	}
+	log.Printf("[webhook] payment %d was paid after it was cancelled: %d cents refunded to wallet of user %d (%s)", p.ID, rf.AmountCents, p.UserID, rf.Reference)
+	return nil
+}
 
-	c.JSON(http.StatusOK, gin.H{"received": true})
+// payClientReferralCommission pays 5% of a client's payment to whoever referred them, for their first
+// 2 orders. The ledger reference makes it safe to call again for the same payment.
+func payClientReferralCommission(referralRepo *repository.ReferralRepository, userRepo *repository.UserRepository,
+	walletRepo *repository.WalletRepository, p *models.Payment) error {
+	if referralRepo == nil {
+		return nil
+	}
+	clientUser, _ := userRepo.GetByID(p.UserID)
+	if clientUser == nil || !clientUser.IsClient() {
+		return nil
+	}
+	ref, err := referralRepo.GetReferralByReferredUserID(p.UserID)
+	if err != nil || ref == nil {
+		return nil
+	}
+	reference := fmt.Sprintf("ref_%d_payment_%d", ref.ID, p.ID)
+	if paid, err := walletRepo.HasEntry(domain.WalletTxTypeReferralCommission, reference); err != nil || paid {
+		return err
+	}
+	if ref.CompletedCount >= domain.ReferralMaxTransactions {
+		return nil
+	}
+	commission := int64(float64(p.AmountCents) * domain.ReferralCommissionRate)
+	if commission <= 0 {
+		return nil
+	}
+	if err := walletRepo.Credit(ref.ReferrerID, commission, domain.LedgerAccountReferralPayable,
+		domain.WalletTxTypeReferralCommission, reference); err != nil {
+		return err
+	}
+	_ = referralRepo.IncrementCompletedCount(ref.ID)
+	log.Printf("[referral] client %d: credited %d cents commission to referrer %d (ref %d, count now %d)",
+		p.UserID, commission, ref.ReferrerID, ref.ID, ref.CompletedCount+1)
+	return nil
+}
+
+// webhookEventKey joins the identifying fields of a callback. Callbacks without an ID fall back to a
+// hash of the body.
+func webhookEventKey(body []byte, id string, parts ...string) string {
+	if id == "" {
+		sum := sha256.Sum256(body)
+		return "sha256:" + hex.EncodeToString(sum[:])
+	}
+	key := id
+	for _, p := range parts {
+		key += ":" + p
+	}
+	if len(key) > 191 {
+		sum := sha256.Sum256([]byte(key))
+		key = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	return key
 }
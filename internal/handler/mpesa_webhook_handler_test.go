@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"lusty/config"
+	"lusty/internal/database/databasetest"
+	"lusty/internal/domain"
+	"lusty/internal/middleware"
+	"lusty/internal/models"
+	"lusty/internal/repository"
+	"lusty/internal/service"
+	"lusty/internal/service/interaction"
+	"lusty/internal/webhook"
+	"lusty/pkg/payment"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestFakeSTKPaymentHoldsEscrow runs an M-Pesa payment end to end against the fake provider picked from the
+// registry: the STK push settles, the provider calls back signed, the callback gets past the webhook guard
+// into the inbox, and the payment ends up held in escrow with the request sent to the companion.
+func TestFakeSTKPaymentHoldsEscrow(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := databasetest.New(t)
+	userRepo := repository.NewUserRepository(db)
+	paymentRepo := repository.NewPaymentRepository(db)
+	interactionRepo := repository.NewInteractionRepository(db)
+	companionRepo := repository.NewCompanionRepository(db)
+	walletRepo := repository.NewWalletRepository(db)
+	escrowRepo := repository.NewEscrowRepository(db)
+	auditRepo := repository.NewAuditLogRepository(db)
+	referralRepo := repository.NewReferralRepository(db)
+	notifSvc := service.NewNotificationService(repository.NewNotificationRepository(db), userRepo, nil)
+	interactionSvc := interaction.NewService(interactionRepo, companionRepo, userRepo, walletRepo, escrowRepo, referralRepo,
+		repository.NewSettingRepository(db), repository.NewAvailabilityRepository(db), repository.NewExtensionRepository(db),
+		repository.NewDisputeRepository(db), notifSvc)
+	refundSvc := service.NewRefundService(repository.NewRefundRepository(db), paymentRepo, interactionRepo, escrowRepo, walletRepo,
+		repository.NewReconciliationRepository(db), notifSvc, nil, nil, "", "")
+
+	const secret = "test-mpesa-secret"
+	inbox := webhook.NewInbox(repository.NewWebhookEventRepository(db))
+	inbox.Register("mpesa", NewMpesaWebhookHandler(paymentRepo, interactionRepo, companionRepo, walletRepo, escrowRepo, interactionSvc,
+		auditRepo, notifSvc, userRepo, referralRepo, refundSvc, nil))
+	r := gin.New()
+	guard := middleware.NewWebhookGuard("mpesa", config.WebhookAuthConfig{Secret: secret, ReplayWindow: 5 * time.Minute}, auditRepo)
+	r.POST("/api/v1/webhooks/mpesa", guard.Handler(), inbox.Receive("mpesa"))
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	payments := payment.NewRegistry()
+	payments.RegisterMobileMoney("fake", payment.NewFakeProvider(payment.FakeConfig{
+		Delay:   10 * time.Millisecond,
+		Secrets: map[string]string{"mpesa": secret},
+	}))
+	provider, err := payments.MobileMoney("fake")
+	if err != nil {
+		t.Fatalf("registry: %v", err)
+	}
+
+	client := models.User{Email: "client@example.com", Username: "client", Role: domain.RoleClient, KYC: true}
+	companionUser := models.User{Email: "companion@example.com", Username: "companion", Role: domain.RoleCompanion}
+	for _, u := range []*models.User{&client, &companionUser} {
+		if err := db.Create(u).Error; err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+	}
+	comp := models.CompanionProfile{UserID: companionUser.ID, DisplayName: "companion"}
+	if err := db.Create(&comp).Error; err != nil {
+		t.Fatalf("create companion: %v", err)
+	}
+	orderID := "lusty-e2e-1"
+	pay := &models.Payment{UserID: client.ID, AmountCents: 120_000, Currency: "KES", Provider: "mpesa_liberec",
+		ProviderRef: orderID, IdempotencyKey: orderID, Status: domain.PaymentStatusPending}
+	if err := paymentRepo.Create(pay); err != nil {
+		t.Fatalf("create payment: %v", err)
+	}
+	ir := &models.InteractionRequest{ClientID: client.ID, CompanionID: comp.ID, InteractionType: "CHAT", PaymentID: &pay.ID, DurationMinutes: 60}
+	if err := interactionSvc.Open(ir, false, &client.ID); err != nil {
+		t.Fatalf("open interaction: %v", err)
+	}
+
+	// A callback without a valid signature is refused before it reaches the inbox
+	forged, _ := http.Post(srv.URL+"/api/v1/webhooks/mpesa", "application/json",
+		bytes.NewBufferString(`{"merchant_order_id":"`+orderID+`","status":"COMPLETED"}`))
+	if forged == nil || forged.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unsigned callback: got %v, want 401", forged)
+	}
+	forged.Body.Close()
+
+	if _, err := provider.InitiatePayment(context.Background(), payment.PaymentRequest{
+		UserID:        client.ID,
+		AmountCents:   pay.AmountCents,
+		Currency:      "KES",
+		OrderID:       orderID,
+		CustomerPhone: "254700000000",
+		CallbackURL:   srv.URL + "/api/v1/webhooks/mpesa",
+	}); err != nil {
+		t.Fatalf("initiate STK: %v", err)
+	}
+
+	// The inbox processes the callback in the background; wait until it is done with it
+	var ev models.WebhookEvent
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if db.Where("provider = ?", "mpesa").Limit(1).Find(&ev); ev.Status == "PROCESSED" || ev.Status == "FAILED" {
+			break
+		}
+	}
+	if ev.Status != "PROCESSED" {
+		t.Fatalf("callback event = %+v, want PROCESSED", ev)
+	}
+	hold, _ := escrowRepo.GetByInteractionID(ir.ID)
+	if hold == nil || hold.Status != domain.EscrowStatusHeld || hold.AmountCents != pay.AmountCents {
+		t.Fatalf("escrow hold = %+v, want %d cents HELD", hold, pay.AmountCents)
+	}
+	if p, _ := paymentRepo.GetByID(pay.ID); p.Status != domain.PaymentStatusCompleted {
+		t.Fatalf("payment status = %s, want COMPLETED", p.Status)
+	}
+	if stored, _ := interactionRepo.GetByID(ir.ID); stored.Status != domain.RequestStatusPending {
+		t.Fatalf("interaction status = %s, want PENDING", stored.Status)
+	}
+}
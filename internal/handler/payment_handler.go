@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"lusty/internal/domain"
+	"lusty/internal/middleware"
+	"lusty/internal/models"
+	"lusty/internal/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PaymentHandler struct {
+	paymentRepo     *repository.PaymentRepository
+	interactionRepo *repository.InteractionRepository
+}
+
+func NewPaymentHandler(paymentRepo *repository.PaymentRepository, interactionRepo *repository.InteractionRepository) *PaymentHandler {
+	return &PaymentHandler{paymentRepo: paymentRepo, interactionRepo: interactionRepo}
+}
+
+// GetStatus returns the current status of one of the caller's payments. Apps use it after initiating an
+// M-Pesa or crypto payment, and to resync after reconnecting to /ws/user.
+func (h *PaymentHandler) GetStatus(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	p, err := h.paymentRepo.GetByID(uint(id))
+	if err != nil || p == nil || p.UserID != userID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "payment not found"})
+		return
+	}
+	out := gin.H{
+		"payment_id":     p.ID,
+		"order_id":       p.ProviderRef,
+		"provider":       p.Provider,
+		"amount_cents":   p.AmountCents,
+		"currency":       p.Currency,
+		"payment_status": p.Status,
+		"expires_at":     p.ExpiresAt,
+		"completed_at":   p.CompletedAt,
+	}
+	companionName := ""
+	requiresKyc := false
+	if ir, _ := h.interactionRepo.GetByPaymentID(p.ID); ir != nil {
+		requiresKyc = ir.Status == domain.RequestStatusPendingKYC
+		out["interaction_id"] = ir.ID
+		out["interaction_status"] = ir.Status
+		out["requires_kyc"] = requiresKyc
+		if ir.Companion.ID != 0 {
+			companionName = ir.Companion.DisplayName
+		}
+	}
+	out["message"] = paymentStatusMessage(p, companionName, requiresKyc)
+	c.JSON(http.StatusOK, out)
+}
+
+// paymentStatusMessage is the user-facing text for a payment's status, shared by the status endpoint and
+// the push sent when a provider webhook settles the payment.
+func paymentStatusMessage(p *models.Payment, companionName string, requiresKyc bool) string {
+	if companionName == "" {
+		companionName = "your companion"
+	}
+	switch p.Status {
+	case domain.PaymentStatusPending:
+		return "Waiting for payment confirmation. Check your phone to complete the payment."
+	case domain.PaymentStatusCompleted:
+		if requiresKyc {
+			return "Payment successful! Complete KYC to send your request to " + companionName + "."
+		}
+		return "Payment successful! Waiting for " + companionName + " to accept your request."
+	case domain.PaymentStatusCancelled:
+		return "Payment timed out. Please try again."
+	default:
+		return "Payment was cancelled or failed. Please try again."
+	}
+}
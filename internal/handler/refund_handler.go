@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"lusty/internal/domain"
+	"lusty/internal/middleware"
+	"lusty/internal/models"
+	"lusty/internal/repository"
+	"lusty/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RefundHandler struct {
+	refundSvc   *service.RefundService
+	refundRepo  *repository.RefundRepository
+	paymentRepo *repository.PaymentRepository
+}
+
+func NewRefundHandler(refundSvc *service.RefundService, refundRepo *repository.RefundRepository, paymentRepo *repository.PaymentRepository) *RefundHandler {
+	return &RefundHandler{refundSvc: refundSvc, refundRepo: refundRepo, paymentRepo: paymentRepo}
+}
+
+// Request handles POST /me/payments/:id/refunds — the client asks for money they are owed on a payment,
+// to their wallet or back to the M-Pesa number / crypto address that paid.
+func (h *RefundHandler) Request(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	var req struct {
+		Destination string `json:"destination" binding:"required,oneof=WALLET SOURCE"`
+		Reason      string `json:"reason" binding:"max=255"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "destination must be WALLET or SOURCE"})
+		return
+	}
+	p, err := h.paymentRepo.GetByID(uint(id))
+	if err != nil || p == nil || p.UserID != userID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "payment not found"})
+		return
+	}
+	rf, err := h.refundSvc.RequestByClient(c.Request.Context(), p, req.Destination, req.Reason)
+	if err != nil {
+		refundError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, refundJSON(rf))
+}
+
+// List handles GET /me/refunds — the caller's refunds, newest first.
+func (h *RefundHandler) List(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	limit := 50
+	if l := c.Query("limit"); l != "" {
+		if n, err := parseInt(l); err == nil && n > 0 && n <= 100 {
+			limit = n
+		}
+	}
+	offset := 0
+	if o := c.Query("offset"); o != "" {
+		if n, err := parseInt(o); err == nil && n >= 0 {
+			offset = n
+		}
+	}
+	list, err := h.refundRepo.ListByUserID(userID, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load refunds"})
+		return
+	}
+	out := make([]gin.H, 0, len(list))
+	for i := range list {
+		out = append(out, refundJSON(&list[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{"refunds": out})
+}
+
+// AdminIssue handles POST /admin/payments/:id/refunds — a goodwill or correction refund paid out of platform
+// revenue. amount_kes omitted refunds everything not yet refunded.
+func (h *RefundHandler) AdminIssue(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	var req struct {
+		AmountKES   int64  `json:"amount_kes" binding:"min=0"`
+		Destination string `json:"destination" binding:"required,oneof=WALLET SOURCE"`
+		Reason      string `json:"reason" binding:"required,max=255"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	p, err := h.paymentRepo.GetByID(uint(id))
+	if err != nil || p == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "payment not found"})
+		return
+	}
+	rf, err := h.refundSvc.IssueByAdmin(c.Request.Context(), p, req.AmountKES*100, req.Destination, req.Reason, middleware.GetUserID(c))
+	if err != nil {
+		refundError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, refundJSON(rf))
+}
+
+// AdminList handles GET /admin/refunds?status=.
+func (h *RefundHandler) AdminList(c *gin.Context) {
+	page, limit := parsePagination(c)
+	list, total, err := h.refundRepo.List(c.Query("status"), page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list refunds"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": list, "total": total, "page": page, "limit": limit})
+}
+
+func refundError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrNotRefundable), errors.Is(err, service.ErrRefundAmount),
+		errors.Is(err, service.ErrRefundInWallet), errors.Is(err, service.ErrRefundNoSource):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrRefundHeldInEscrow):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, repository.ErrInsufficientBalance):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "insufficient wallet balance"})
+	case errors.Is(err, service.ErrRefundProvider):
+		c.JSON(http.StatusBadGateway, gin.H{"error": "refund could not be sent, please try again later"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "refund failed"})
+	}
+}
+
+func refundJSON(rf *models.Refund) gin.H {
+	out := gin.H{
+		"id":           rf.ID,
+		"payment_id":   rf.PaymentID,
+		"amount_cents": rf.AmountCents,
+		"currency":     rf.Currency,
+		"destination":  rf.Destination,
+		"status":       rf.Status,
+		"reason":       rf.Reason,
+		"created_at":   rf.CreatedAt,
+		"completed_at": rf.CompletedAt,
+	}
+	if rf.Status == domain.RefundStatusFailed {
+		out["failure_reason"] = rf.FailureReason
+	}
+	return out
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"lusty/internal/middleware"
+	"lusty/internal/models"
+	"lusty/internal/repository"
+	"lusty/internal/service"
+	"lusty/pkg/payment"
+)
+
+// RefundCryptoCallback is the webhook payload from Swapuzi for a deposit refund.
+type RefundCryptoCallback struct {
+	Event             string `json:"event"`
+	RefundID          string `json:"refund_id"`
+	MerchantDepositID string `json:"merchant_deposit_id"`
+	Amount            string `json:"amount"`
+	Status            string `json:"status"` // completed, failed
+	TxHash            string `json:"tx_hash"`
+}
+
+// RefundWebhookHandler processes refund results for one rail: "mpesa" (a B2C payout back to the paying
+// number, same payload as withdrawals) or "crypto" (a Swapuzi deposit refund). A failed refund puts money
+// back in the ledger, so every callback is confirmed with the provider before it is applied, whatever
+// <PROVIDER>_WEBHOOK_VERIFY_BACK says.
+type RefundWebhookHandler struct {
+	rail      string
+	refundSvc *service.RefundService
+	auditRepo *repository.AuditLogRepository
+	mpesa     payment.MobileMoneyProvider // B2C status lookup
+	crypto    payment.CryptoProvider      // deposit refund status lookup
+}
+
+func NewRefundWebhookHandler(rail string, refundSvc *service.RefundService, auditRepo *repository.AuditLogRepository, mpesa payment.MobileMoneyProvider, crypto payment.CryptoProvider) *RefundWebhookHandler {
+	return &RefundWebhookHandler{rail: rail, refundSvc: refundSvc, auditRepo: auditRepo, mpesa: mpesa, crypto: crypto}
+}
+
+// EventKey identifies a callback by refund and reported status.
+func (h *RefundWebhookHandler) EventKey(body []byte) (string, error) {
+	if h.rail == "crypto" {
+		var payload RefundCryptoCallback
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return "", err
+		}
+		return webhookEventKey(body, payload.RefundID, payload.Status, payload.TxHash), nil
+	}
+	var payload B2CCallback
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return "", err
+	}
+	return webhookEventKey(body, payload.orderID(), payload.Status, payload.TransactionUUID), nil
+}
+
+// Process applies a stored refund callback. A failed refund returns the reserved amount to where it came from.
+func (h *RefundWebhookHandler) Process(ctx context.Context, ev *models.WebhookEvent) error {
+	log.Printf("[Refund callback] %s event %d raw body: %s", h.rail, ev.ID, ev.Payload)
+	var reference, detail, reported string
+	var succeeded bool
+	lookup, completed := h.mpesa.TransactionStatus, "COMPLETED"
+	if h.rail == "crypto" {
+		lookup, completed = h.crypto.RefundStatus, "completed"
+		var payload RefundCryptoCallback
+		if err := json.Unmarshal([]byte(ev.Payload), &payload); err != nil {
+			log.Printf("[Refund callback] json unmarshal error: %v", err)
+			return nil
+		}
+		reference, reported = payload.RefundID, payload.Status
+		succeeded, detail = payload.Status == completed, "provider reported "+payload.Status
+	} else {
+		var payload B2CCallback
+		if err := json.Unmarshal([]byte(ev.Payload), &payload); err != nil {
+			log.Printf("[Refund callback] json unmarshal error: %v", err)
+			return nil
+		}
+		reference, reported = payload.orderID(), payload.Status
+		succeeded = payload.Status == completed
+		detail = fmt.Sprintf("provider reported %s: %s", payload.Status, payload.StatusDescription)
+	}
+	if reference == "" {
+		log.Printf("[Refund callback] no refund id in payload")
+		return nil
+	}
+	// Confirm with the provider before settling: a forged "failed" would otherwise credit the money back
+	// while the payout still goes through
+	status, err := lookup(ctx, reference)
+	if err != nil {
+		reason := fmt.Sprintf("verify-back failed for %s: %v", reference, err)
+		middleware.RecordWebhookRejection(h.auditRepo, ev.Provider, ev.SourceIP, ev.UserAgent, reason)
+		return errors.New(reason)
+	}
+	// The provider must agree on the outcome, and a failure must be final there, not still in flight
+	done := strings.EqualFold(status, completed)
+	inFlight := status == "" || strings.EqualFold(status, "PENDING") || strings.EqualFold(status, "PROCESSING")
+	if done != succeeded || (!succeeded && inFlight) {
+		reason := fmt.Sprintf("verify-back mismatch for %s: callback status=%s, provider status=%s", reference, reported, status)
+		middleware.RecordWebhookRejection(h.auditRepo, ev.Provider, ev.SourceIP, ev.UserAgent, reason)
+		return errors.New(reason)
+	}
+	return h.refundSvc.HandleCallback(reference, succeeded, detail)
+}
@@ -0,0 +1,207 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"lusty/internal/domain"
+	"lusty/internal/middleware"
+	"lusty/internal/models"
+	"lusty/internal/repository"
+	"lusty/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReviewHandler lets both parties review a completed interaction and admins moderate reviews.
+type ReviewHandler struct {
+	reviewSvc       *service.ReviewService
+	reviewRepo      *repository.ReviewRepository
+	interactionRepo *repository.InteractionRepository
+	companionRepo   *repository.CompanionRepository
+}
+
+func NewReviewHandler(reviewSvc *service.ReviewService, reviewRepo *repository.ReviewRepository, interactionRepo *repository.InteractionRepository, companionRepo *repository.CompanionRepository) *ReviewHandler {
+	return &ReviewHandler{reviewSvc: reviewSvc, reviewRepo: reviewRepo, interactionRepo: interactionRepo, companionRepo: companionRepo}
+}
+
+type reviewInput struct {
+	Rating  int    `json:"rating" binding:"required,min=1,max=5"`
+	Comment string `json:"comment" binding:"max=1000"`
+}
+
+// Create handles POST /interactions/:id/reviews. Either party, once the interaction is COMPLETED.
+func (h *ReviewHandler) Create(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	ir, err := h.interactionRepo.GetByID(uint(id))
+	if err != nil || ir == nil || (ir.ClientID != userID && ir.Companion.UserID != userID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "interaction not found"})
+		return
+	}
+	var req reviewInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "rating must be between 1 and 5, comment at most 1000 characters"})
+		return
+	}
+	rv, err := h.reviewSvc.Submit(ir, userID, req.Rating, req.Comment)
+	if err != nil {
+		reviewError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, rv)
+}
+
+// ListForInteraction handles GET /interactions/:id/reviews: the caller's own review and the other party's
+// review if it is published.
+func (h *ReviewHandler) ListForInteraction(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	ir, err := h.interactionRepo.GetByID(uint(id))
+	if err != nil || ir == nil || (ir.ClientID != userID && ir.Companion.UserID != userID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "interaction not found"})
+		return
+	}
+	list, err := h.reviewRepo.ListByInteractionID(ir.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load reviews"})
+		return
+	}
+	out := make([]models.Review, 0, len(list))
+	for _, rv := range list {
+		if rv.ReviewerID == userID || rv.Status == domain.ReviewStatusPublished {
+			out = append(out, rv)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"reviews": out})
+}
+
+// Update handles PATCH /reviews/:id — the reviewer edits their review while the edit window is open.
+func (h *ReviewHandler) Update(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	rv := h.reviewByParam(c)
+	if rv == nil {
+		return
+	}
+	if rv.ReviewerID != userID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "review not found"})
+		return
+	}
+	var req reviewInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "rating must be between 1 and 5, comment at most 1000 characters"})
+		return
+	}
+	rv, err := h.reviewSvc.Edit(rv, userID, req.Rating, req.Comment)
+	if err != nil {
+		reviewError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, rv)
+}
+
+// ListForCompanion handles GET /companions/:id/reviews: published client reviews, newest first, with the
+// profile's aggregate rating.
+func (h *ReviewHandler) ListForCompanion(c *gin.Context) {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	profile, err := h.companionRepo.GetByID(uint(id))
+	if err != nil || profile == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "profile not found"})
+		return
+	}
+	limit := 20
+	if l := c.Query("limit"); l != "" {
+		if n, err := parseInt(l); err == nil && n > 0 && n <= 100 {
+			limit = n
+		}
+	}
+	offset := 0
+	if o := c.Query("offset"); o != "" {
+		if n, err := parseInt(o); err == nil && n >= 0 {
+			offset = n
+		}
+	}
+	list, err := h.reviewRepo.ListPublishedForCompanion(profile.ID, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load reviews"})
+		return
+	}
+	out := make([]gin.H, 0, len(list))
+	for _, rv := range list {
+		out = append(out, gin.H{
+			"id":         rv.ID,
+			"rating":     rv.Rating,
+			"comment":    rv.Comment,
+			"created_at": rv.CreatedAt,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"rating_avg":   profile.RatingAvg,
+		"rating_count": profile.RatingCount,
+		"reviews":      out,
+	})
+}
+
+// AdminList handles GET /admin/reviews?status= — the moderation queue, FLAGGED by default.
+func (h *ReviewHandler) AdminList(c *gin.Context) {
+	status := c.DefaultQuery("status", domain.ReviewStatusFlagged)
+	if status == "ALL" {
+		status = ""
+	}
+	page, limit := parsePagination(c)
+	list, total, err := h.reviewRepo.List(status, page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list reviews"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": list, "total": total, "page": page, "limit": limit})
+}
+
+// AdminModerate handles POST /admin/reviews/:id/moderate — publish or hide a review.
+func (h *ReviewHandler) AdminModerate(c *gin.Context) {
+	rv := h.reviewByParam(c)
+	if rv == nil {
+		return
+	}
+	var req struct {
+		Status string `json:"status" binding:"required,oneof=PUBLISHED HIDDEN"`
+		Note   string `json:"note" binding:"max=255"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be PUBLISHED or HIDDEN"})
+		return
+	}
+	if err := h.reviewSvc.Moderate(rv, req.Status, middleware.GetUserID(c), req.Note); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to moderate review"})
+		return
+	}
+	c.JSON(http.StatusOK, rv)
+}
+
+func (h *ReviewHandler) reviewByParam(c *gin.Context) *models.Review {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return nil
+	}
+	rv, err := h.reviewRepo.GetByID(uint(id))
+	if err != nil || rv == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "review not found"})
+		return nil
+	}
+	return rv
+}
+
+func reviewError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrReviewNotParty):
+		c.JSON(http.StatusNotFound, gin.H{"error": "interaction not found"})
+	case errors.Is(err, service.ErrReviewExists), errors.Is(err, service.ErrReviewEditClosed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, service.ErrReviewNotCompleted), errors.Is(err, service.ErrReviewRating):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save review"})
+	}
+}
@@ -2,13 +2,19 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"time"
 
 	"lusty/config"
 	"lusty/internal/auth"
 	"lusty/internal/domain"
+	"lusty/internal/models"
 	"lusty/internal/repository"
+	"lusty/internal/service"
+	"lusty/internal/service/videocall"
 	"lusty/internal/ws"
 
 	"github.com/gin-gonic/gin"
@@ -21,8 +27,13 @@ var videoUpgrader = websocket.Upgrader{
 	CheckOrigin:     func(r *http.Request) bool { return true },
 }
 
-// UpgradeVideoWS handles WebRTC signaling: offer, answer, ice. Query: token, interaction_id.
-func UpgradeVideoWS(cfg *config.JWTConfig, videoHub *ws.VideoHub, interactionRepo *repository.InteractionRepository) gin.HandlerFunc {
+// UpgradeVideoWS handles video calls on an interaction: the call lifecycle and WebRTC signaling. Query: token, interaction_id.
+// Lifecycle messages are invite, ringing (the callee's device is ringing), accept, decline, cancel and hangup;
+// the room's state machine rejects any that do not fit the current call with {"type":"error"}. An unanswered
+// invite times out after videocall.RingTimeout. offer, answer, ice and ready are relayed only during an
+// accepted call. While it lasts both peers send {"type":"heartbeat"} about every 10 seconds and get
+// {"type":"meter"} back; "low_funds" and "call_ended" are sent to both. A joining peer first gets "call_state".
+func UpgradeVideoWS(cfg *config.JWTConfig, videoHub *ws.VideoHub, interactionRepo *repository.InteractionRepository, notifSvc *service.NotificationService, videoSvc *videocall.Service) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		token := c.Query("token")
 		interactionIDStr := c.Query("interaction_id")
@@ -66,16 +77,25 @@ func UpgradeVideoWS(cfg *config.JWTConfig, videoHub *ws.VideoHub, interactionRep
 			Hub:    ws.NewHub(),
 		}
 		room := videoHub.GetOrCreateRoom(interactionID)
+		syncVideoRoom(room, videoSvc, interactionID)
 		room.Join(client)
 		defer func() {
 			room.Leave(claims.UserID)
 			client.Close()
+			if room.PeerCount() == 0 {
+				abandonVideoCall(room, videoSvc, claims.UserID)
+			}
 		}()
 		go func() {
 			for msg := range client.Send {
 				_ = conn.WriteMessage(websocket.TextMessage, msg)
 			}
 		}()
+		state, callID, callerID := room.CallState()
+		room.SendTo(claims.UserID, map[string]interface{}{"type": "call_state", "state": state, "call_id": callID, "caller_id": callerID})
+		sendError := func(msg string) {
+			room.SendTo(claims.UserID, map[string]interface{}{"type": "error", "error": msg})
+		}
 		for {
 			_, raw, err := conn.ReadMessage()
 			if err != nil {
@@ -89,9 +109,185 @@ func UpgradeVideoWS(cfg *config.JWTConfig, videoHub *ws.VideoHub, interactionRep
 				continue
 			}
 			switch msg.Type {
+			case "invite":
+				if _, err := inviteVideoCall(videoHub, videoSvc, notifSvc, ir, claims.UserID); err != nil {
+					if errors.Is(err, repository.ErrVideoCallInProgress) {
+						sendError(err.Error())
+					} else {
+						log.Printf("[VideoCall] invite on interaction %d: %v", interactionID, err)
+						sendError("could not place call")
+					}
+				}
+			case "ringing":
+				if state, callID, callerID := room.CallState(); state == ws.CallRinging && callerID != claims.UserID {
+					room.SendToOther(claims.UserID, map[string]interface{}{"type": "ringing", "call_id": callID})
+				}
+			case "accept":
+				callID, err := room.Accept(claims.UserID)
+				if err != nil {
+					sendError("no incoming call to accept")
+					continue
+				}
+				call, err := videoSvc.Accept(callID)
+				if err != nil {
+					room.End(callID)
+					switch {
+					case errors.Is(err, videocall.ErrInsufficientFunds):
+						room.Broadcast(map[string]interface{}{"type": "call_ended", "call_id": callID, "reason": videocall.EndReasonInsufficientFunds})
+					case errors.Is(err, repository.ErrVideoCallNotRinging):
+						sendError(err.Error())
+					default:
+						log.Printf("[VideoCall] accept call %d: %v", callID, err)
+						sendError("could not accept call")
+					}
+					continue
+				}
+				room.Broadcast(map[string]interface{}{
+					"type":                "accept",
+					"call_id":             call.ID,
+					"rate_per_5min_cents": call.RatePer5MinCents,
+					"authorized_seconds":  call.AuthorizedSeconds(),
+				})
+			case "decline", "cancel":
+				var callID uint
+				var status string
+				if msg.Type == "decline" {
+					callID, err = room.Decline(claims.UserID)
+					status = domain.VideoCallStatusDeclined
+				} else {
+					callID, err = room.Cancel(claims.UserID)
+					status = domain.VideoCallStatusCancelled
+				}
+				if err != nil {
+					sendError("no ringing call to " + msg.Type)
+					continue
+				}
+				if _, err := videoSvc.Close(callID, status); err != nil {
+					log.Printf("[VideoCall] %s call %d: %v", msg.Type, callID, err)
+				}
+				room.Broadcast(map[string]interface{}{"type": msg.Type, "call_id": callID})
+			case "hangup":
+				callID, err := room.Hangup()
+				if err != nil {
+					sendError("no call to hang up")
+					continue
+				}
+				endVideoCall(room, videoSvc, callID, videocall.EndReasonHangup)
 			case "offer", "answer", "ice", "ready":
+				if state, _, _ := room.CallState(); state != ws.CallActive {
+					sendError("no active call")
+					continue
+				}
 				room.SendToOther(claims.UserID, map[string]interface{}{"type": msg.Type, "payload": msg.Payload})
+			case "heartbeat":
+				m, err := videoSvc.Heartbeat(interactionID, claims.UserID)
+				if err != nil {
+					log.Printf("[VideoCall] heartbeat for interaction %d: %v", interactionID, err)
+					continue
+				}
+				if m == nil {
+					continue
+				}
+				if m.Ended {
+					room.End(m.Call.ID)
+					room.Broadcast(videocall.EventEnded(m.Call))
+					continue
+				}
+				room.SendTo(claims.UserID, map[string]interface{}{
+					"type":              "meter",
+					"call_id":           m.Call.ID,
+					"connected_seconds": m.Call.ConnectedSeconds,
+					"seconds_left":      m.SecondsLeft,
+				})
+				if m.LowFunds {
+					room.Broadcast(map[string]interface{}{"type": "low_funds", "call_id": m.Call.ID, "seconds_left": m.SecondsLeft})
+				}
+			}
+		}
+	}
+}
+
+// inviteVideoCall places a call from callerID on ir and rings the other side: in the video room if they are
+// connected, otherwise by push.
+func inviteVideoCall(videoHub *ws.VideoHub, videoSvc *videocall.Service, notifSvc *service.NotificationService, ir *models.InteractionRequest, callerID uint) (*models.VideoCall, error) {
+	call, err := videoSvc.Invite(ir, callerID)
+	if err != nil {
+		return nil, err
+	}
+	room := videoHub.GetOrCreateRoom(ir.ID)
+	// The recorded call is authoritative: a room still holding an older call missed its end
+	if state, stale, _ := room.CallState(); state != ws.CallIdle {
+		room.End(stale)
+	}
+	if err := room.Invite(call.ID, callerID, videocall.RingTimeout, ringOut(room, videoSvc, call.ID)); err != nil {
+		return nil, err
+	}
+	room.Broadcast(map[string]interface{}{"type": "invite", "call_id": call.ID, "caller_id": callerID})
+	callee := ir.ClientID
+	if callerID == ir.ClientID {
+		callee = ir.Companion.UserID
+	}
+	if !room.HasPeer(callee) {
+		notifSvc.NotifyVideoCall(callee, videoSvc.CallerName(ir, callerID), ir.ID)
+	}
+	return call, nil
+}
+
+// ringOut closes an invite nobody answered as missed.
+func ringOut(room *ws.VideoRoom, videoSvc *videocall.Service, callID uint) func() {
+	return func() {
+		if _, err := videoSvc.Close(callID, domain.VideoCallStatusMissed); err != nil {
+			log.Printf("[VideoCall] time out call %d: %v", callID, err)
+		}
+		room.Broadcast(map[string]interface{}{"type": "timeout", "call_id": callID})
+	}
+}
+
+// syncVideoRoom brings a room that has no call up to date with the interaction's recorded call, e.g. after
+// a restart.
+func syncVideoRoom(room *ws.VideoRoom, videoSvc *videocall.Service, interactionID uint) {
+	if state, _, _ := room.CallState(); state != ws.CallIdle {
+		return
+	}
+	call, err := videoSvc.Live(interactionID)
+	if err != nil || call == nil {
+		return
+	}
+	if call.Status == domain.VideoCallStatusActive {
+		room.Restore(call.ID, call.CallerID)
+		return
+	}
+	left := videocall.RingTimeout - time.Since(call.StartedAt)
+	if left <= 0 {
+		ringOut(room, videoSvc, call.ID)()
+		return
+	}
+	_ = room.Invite(call.ID, call.CallerID, left, ringOut(room, videoSvc, call.ID))
+}
+
+// abandonVideoCall closes the room's call once both peers have left: an active call ends and is settled; an
+// invite is cancelled if its caller was the last to leave.
+func abandonVideoCall(room *ws.VideoRoom, videoSvc *videocall.Service, lastUserID uint) {
+	state, callID, callerID := room.CallState()
+	switch {
+	case state == ws.CallActive:
+		if _, err := room.Hangup(); err == nil {
+			endVideoCall(room, videoSvc, callID, videocall.EndReasonDisconnected)
+		}
+	case state == ws.CallRinging && callerID == lastUserID:
+		if _, err := room.Cancel(lastUserID); err == nil {
+			if _, err := videoSvc.Close(callID, domain.VideoCallStatusCancelled); err != nil {
+				log.Printf("[VideoCall] cancel call %d: %v", callID, err)
 			}
 		}
 	}
 }
+
+func endVideoCall(room *ws.VideoRoom, videoSvc *videocall.Service, callID uint, reason string) {
+	call, err := videoSvc.End(callID, reason)
+	if err != nil {
+		log.Printf("[VideoCall] end call %d: %v", callID, err)
+		return
+	}
+	room.Broadcast(videocall.EventEnded(call))
+}
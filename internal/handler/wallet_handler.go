@@ -28,6 +28,7 @@ func (h *WalletHandler) GetBalance(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, gin.H{
 		"balance_cents":       w.BalanceCents,
+		"pending_cents":       w.PendingCents,
 		"withdrawable_cents":  w.WithdrawableCents,
 		"currency":            w.Currency,
 	})
@@ -59,6 +60,7 @@ func (h *WalletHandler) GetTransactions(c *gin.Context) {
 			"id":            t.ID,
 			"amount_cents":  t.AmountCents,
 			"type":          t.Type,
+			"account":       t.Account,
 			"reference":     t.Reference,
 			"created_at":    t.CreatedAt,
 		})
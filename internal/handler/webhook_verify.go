@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"lusty/internal/middleware"
+	"lusty/internal/models"
+	"lusty/internal/repository"
+	"lusty/pkg/payment"
+)
+
+// verifyWebhookClaim asks the provider whether reference is paid and checks that matches what the callback
+// claims. A lookup failure or mismatch is audited and returned as an error, so the inbox retries the event
+// (the provider's API may lag its callback) and eventually parks it for an admin. A nil verifier skips the check.
+func verifyWebhookClaim(ctx context.Context, auditRepo *repository.AuditLogRepository, ev *models.WebhookEvent, verifier payment.Verifier, reference string, claimsPaid bool) error {
+	if verifier == nil {
+		return nil
+	}
+	paid, err := verifier.VerifyPayment(ctx, reference)
+	if err != nil {
+		reason := fmt.Sprintf("verify-back failed for %s: %v", reference, err)
+		middleware.RecordWebhookRejection(auditRepo, ev.Provider, ev.SourceIP, ev.UserAgent, reason)
+		return errors.New(reason)
+	}
+	if paid != claimsPaid {
+		reason := fmt.Sprintf("verify-back mismatch for %s: callback paid=%v, provider paid=%v", reference, claimsPaid, paid)
+		middleware.RecordWebhookRejection(auditRepo, ev.Provider, ev.SourceIP, ev.UserAgent, reason)
+		return errors.New(reason)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -24,7 +25,7 @@ type WithdrawalHandler struct {
 	walletRepo      *repository.WalletRepository
 	withdrawalRepo  *repository.WithdrawalRepository
 	companionRepo   *repository.CompanionRepository
-	mpesaProvider   *payment.LiberecMpesaProvider
+	mpesaProvider   payment.MobileMoneyProvider
 }
 
 func NewWithdrawalHandler(
@@ -32,20 +33,15 @@ func NewWithdrawalHandler(
 	walletRepo *repository.WalletRepository,
 	withdrawalRepo *repository.WithdrawalRepository,
 	companionRepo *repository.CompanionRepository,
+	mpesaProvider payment.MobileMoneyProvider,
 ) *WithdrawalHandler {
-	h := &WithdrawalHandler{
+	return &WithdrawalHandler{
 		cfg:            cfg,
 		walletRepo:     walletRepo,
 		withdrawalRepo: withdrawalRepo,
 		companionRepo:  companionRepo,
+		mpesaProvider:  mpesaProvider,
 	}
-	h.mpesaProvider = payment.NewLiberecMpesaProvider(
-		cfg.LiberecMpesa.BaseURL,
-		cfg.LiberecMpesa.Email,
-		cfg.LiberecMpesa.Password,
-		cfg.LiberecMpesa.WebhookBaseURL,
-	)
-	return h
 }
 
 // Create initiates a withdrawal to M-Pesa (B2C). Companion only.
@@ -96,14 +92,13 @@ func (h *WithdrawalHandler) Create(c *gin.Context) {
 		OrderID:     orderID,
 		CallbackURL: callbackURL,
 	}
-	resp, err := h.mpesaProvider.InitiateB2C(c.Request.Context(), b2cReq)
-	if err != nil {
-		log.Printf("[Withdrawal] B2C init failed: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "withdrawal init failed: " + err.Error()})
-		return
-	}
-	if err := h.walletRepo.DebitWithdrawable(userID, amountCents); err != nil {
-		log.Printf("[Withdrawal] debit failed after B2C: %v", err)
+	// Reserve the funds before calling the provider so parallel withdrawals cannot both pass the balance check
+	if err := h.walletRepo.DebitWithdrawable(userID, amountCents, domain.LedgerAccountProviderClearing, domain.WalletTxTypeWithdrawal, orderID); err != nil {
+		if errors.Is(err, repository.ErrInsufficientBalance) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "insufficient withdrawable balance"})
+			return
+		}
+		log.Printf("[Withdrawal] debit failed: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to deduct balance"})
 		return
 	}
@@ -113,13 +108,25 @@ func (h *WithdrawalHandler) Create(c *gin.Context) {
 		AmountCents: amountCents,
 		PhoneNumber: phone,
 		Status:      "PENDING",
-		ProviderRef: resp.UUID,
 	}
 	if err := h.withdrawalRepo.Create(w); err != nil {
-		h.walletRepo.CreditWithdrawable(userID, amountCents)
+		_ = h.walletRepo.CreditWithdrawable(userID, amountCents, domain.LedgerAccountProviderClearing, domain.WalletTxTypeRefund, orderID)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to record withdrawal"})
 		return
 	}
+	resp, err := h.mpesaProvider.InitiateB2C(c.Request.Context(), b2cReq)
+	if err != nil {
+		log.Printf("[Withdrawal] B2C init failed: %v", err)
+		w.Status = "FAILED"
+		_ = h.withdrawalRepo.Update(w)
+		_ = h.walletRepo.CreditWithdrawable(userID, amountCents, domain.LedgerAccountProviderClearing, domain.WalletTxTypeRefund, orderID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "withdrawal init failed: " + err.Error()})
+		return
+	}
+	w.ProviderRef = resp.UUID
+	if err := h.withdrawalRepo.Update(w); err != nil {
+		log.Printf("[Withdrawal] failed to save provider ref for order_id=%s: %v", orderID, err)
+	}
 	c.JSON(http.StatusCreated, gin.H{
 		"id":           w.ID,
 		"order_id":     orderID,
@@ -1,15 +1,18 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
-	"io"
+	"errors"
+	"fmt"
 	"log"
-	"net/http"
 	"time"
 
+	"lusty/internal/domain"
+	"lusty/internal/middleware"
+	"lusty/internal/models"
 	"lusty/internal/repository"
-
-	"github.com/gin-gonic/gin"
+	"lusty/pkg/payment"
 )
 
 // B2CCallback is the webhook payload from M-Pesa B2C.
@@ -40,71 +43,102 @@ type B2CCallback struct {
 type WithdrawalWebhookHandler struct {
 	withdrawalRepo *repository.WithdrawalRepository
 	walletRepo     *repository.WalletRepository
+	auditRepo      *repository.AuditLogRepository
+	mpesa          payment.MobileMoneyProvider // B2C status lookup; nil when verify-back is disabled
 }
 
 func NewWithdrawalWebhookHandler(
 	withdrawalRepo *repository.WithdrawalRepository,
 	walletRepo *repository.WalletRepository,
+	auditRepo *repository.AuditLogRepository,
+	mpesa payment.MobileMoneyProvider,
 ) *WithdrawalWebhookHandler {
 	return &WithdrawalWebhookHandler{
 		withdrawalRepo: withdrawalRepo,
 		walletRepo:     walletRepo,
+		auditRepo:      auditRepo,
+		mpesa:          mpesa,
 	}
 }
 
-// Handle processes the B2C callback. On COMPLETED: mark withdrawal done. On failure: refund withdrawable.
-func (h *WithdrawalWebhookHandler) Handle(c *gin.Context) {
-	body, err := io.ReadAll(c.Request.Body)
-	if err != nil {
-		log.Printf("[Withdrawal callback] ReadBody error: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
-		return
+func (p *B2CCallback) orderID() string {
+	if p.MerchantOrderID != "" {
+		return p.MerchantOrderID
+	}
+	if p.OrderID != "" {
+		return p.OrderID
 	}
-	log.Printf("[Withdrawal callback] raw body: %s", string(body))
+	return p.ReferenceOrderID
+}
+
+// EventKey identifies a callback by order and reported status.
+func (h *WithdrawalWebhookHandler) EventKey(body []byte) (string, error) {
 	var payload B2CCallback
 	if err := json.Unmarshal(body, &payload); err != nil {
-		log.Printf("[Withdrawal callback] json unmarshal error: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
-		return
+		return "", err
 	}
-	orderID := payload.MerchantOrderID
-	if orderID == "" {
-		orderID = payload.OrderID
-	}
-	if orderID == "" {
-		orderID = payload.ReferenceOrderID
+	return webhookEventKey(body, payload.orderID(), payload.Status, payload.TransactionUUID), nil
+}
+
+// Process applies a stored B2C callback. On COMPLETED: mark withdrawal done. On failure: refund withdrawable.
+// The refund is keyed on the order ID in the ledger, so a retry after a partial failure refunds exactly once.
+func (h *WithdrawalWebhookHandler) Process(ctx context.Context, ev *models.WebhookEvent) error {
+	log.Printf("[Withdrawal callback] event %d raw body: %s", ev.ID, ev.Payload)
+	var payload B2CCallback
+	if err := json.Unmarshal([]byte(ev.Payload), &payload); err != nil {
+		log.Printf("[Withdrawal callback] json unmarshal error: %v", err)
+		return nil
 	}
+	orderID := payload.orderID()
 	if orderID == "" {
 		log.Printf("[Withdrawal callback] no order_id in payload")
-		c.JSON(http.StatusOK, gin.H{"received": true})
-		return
+		return nil
 	}
 	w, err := h.withdrawalRepo.GetByOrderID(orderID)
 	if err != nil || w == nil {
 		log.Printf("[Withdrawal callback] withdrawal not found for order_id=%s", orderID)
-		c.JSON(http.StatusOK, gin.H{"received": true})
-		return
+		return nil
 	}
-	if w.Status != "PENDING" {
-		log.Printf("[Withdrawal callback] withdrawal %d already %s for order_id=%s", w.ID, w.Status, orderID)
-		c.JSON(http.StatusOK, gin.H{"received": true})
-		return
-	}
-	if payload.Status == "COMPLETED" {
-		now := time.Now()
-		w.Status = "COMPLETED"
-		w.CompletedAt = &now
-		if err := h.withdrawalRepo.Update(w); err != nil {
-			log.Printf("[Withdrawal callback] update failed: %v", err)
+	if w.Status == "PENDING" {
+		// Confirm with M-Pesa before paying out or refunding: a completed B2C must not be refunded, and a
+		// failed one must not be marked done.
+		if h.mpesa != nil {
+			status, err := h.mpesa.TransactionStatus(ctx, orderID)
+			if err != nil {
+				reason := fmt.Sprintf("verify-back failed for %s: %v", orderID, err)
+				middleware.RecordWebhookRejection(h.auditRepo, ev.Provider, ev.SourceIP, ev.UserAgent, reason)
+				return errors.New(reason)
+			}
+			if (status == "COMPLETED") != (payload.Status == "COMPLETED") {
+				reason := fmt.Sprintf("verify-back mismatch for %s: callback status=%s, provider status=%s", orderID, payload.Status, status)
+				middleware.RecordWebhookRejection(h.auditRepo, ev.Provider, ev.SourceIP, ev.UserAgent, reason)
+				return errors.New(reason)
+			}
+		}
+		if payload.Status == "COMPLETED" {
+			now := time.Now()
+			w.Status = "COMPLETED"
+			w.CompletedAt = &now
+		} else {
+			w.Status = "FAILED"
 		}
-		log.Printf("[Withdrawal callback] withdrawal %d COMPLETED for order_id=%s", w.ID, orderID)
-	} else {
-		w.Status = "FAILED"
 		if err := h.withdrawalRepo.Update(w); err != nil {
-			log.Printf("[Withdrawal callback] update failed: %v", err)
+			return fmt.Errorf("update withdrawal %d: %w", w.ID, err)
 		}
-		_ = h.walletRepo.CreditWithdrawable(w.UserID, w.AmountCents)
-		log.Printf("[Withdrawal callback] withdrawal %d FAILED, refunded %d cents to user %d", w.ID, w.AmountCents, w.UserID)
+		log.Printf("[Withdrawal callback] withdrawal %d %s for order_id=%s", w.ID, w.Status, orderID)
+	} else if w.Status != "FAILED" || payload.Status == "COMPLETED" {
+		log.Printf("[Withdrawal callback] withdrawal %d already %s for order_id=%s", w.ID, w.Status, orderID)
+		return nil
+	}
+	if w.Status != "FAILED" {
+		return nil
+	}
+	if refunded, err := h.walletRepo.HasEntry(domain.WalletTxTypeRefund, orderID); err != nil || refunded {
+		return err
+	}
+	if err := h.walletRepo.CreditWithdrawable(w.UserID, w.AmountCents, domain.LedgerAccountProviderClearing, domain.WalletTxTypeRefund, orderID); err != nil {
+		return fmt.Errorf("refund withdrawal %d: %w", w.ID, err)
 	}
-	c.JSON(http.StatusOK, gin.H{"received": true})
+	log.Printf("[Withdrawal callback] withdrawal %d FAILED, refunded %d cents to user %d", w.ID, w.AmountCents, w.UserID)
+	return nil
 }
@@ -0,0 +1,279 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"lusty/internal/domain"
+	"lusty/internal/models"
+	"lusty/internal/repository"
+	"lusty/internal/webhook"
+	"lusty/pkg/payment"
+)
+
+// Provider outcomes, normalised from the status strings Liberec and Swapuzi report.
+const (
+	outcomeMissing = "missing" // the provider has no such transaction
+	outcomePending = "pending"
+	outcomePaid    = "paid"
+	outcomeFailed  = "failed"
+)
+
+// Reconciler checks payments and withdrawals against the provider's records. Where the provider settled
+// something whose webhook never arrived, it feeds the provider's status through the webhook inbox, so the
+// same processor that handles callbacks applies it. Mismatches on records already settled here (money that
+// may have moved on) are written to the discrepancy report for an admin.
+type Reconciler struct {
+	paymentRepo    *repository.PaymentRepository
+	withdrawalRepo *repository.WithdrawalRepository
+	reconRepo      *repository.ReconciliationRepository
+	inbox          *webhook.Inbox
+	mpesa          payment.MobileMoneyProvider
+	crypto         payment.CryptoProvider
+	minAge         time.Duration
+	lookback       time.Duration
+}
+
+func NewReconciler(
+	paymentRepo *repository.PaymentRepository,
+	withdrawalRepo *repository.WithdrawalRepository,
+	reconRepo *repository.ReconciliationRepository,
+	inbox *webhook.Inbox,
+	mpesa payment.MobileMoneyProvider,
+	crypto payment.CryptoProvider,
+	minAge, lookback time.Duration,
+) *Reconciler {
+	return &Reconciler{
+		paymentRepo:    paymentRepo,
+		withdrawalRepo: withdrawalRepo,
+		reconRepo:      reconRepo,
+		inbox:          inbox,
+		mpesa:          mpesa,
+		crypto:         crypto,
+		minAge:         minAge,
+		lookback:       lookback,
+	}
+}
+
+// Run reconciles PENDING records older than minAge and settled records updated within lookback.
+func (r *Reconciler) Run(ctx context.Context) error {
+	now := time.Now()
+	pendingBefore, settledSince := now.Add(-r.minAge), now.Add(-r.lookback)
+	var checked int
+	for afterID := uint(0); ; {
+		list, err := r.paymentRepo.ListForReconciliation(pendingBefore, settledSince, afterID, sweepBatch)
+		if err != nil {
+			return err
+		}
+		for i := range list {
+			if ctx.Err() != nil {
+				return nil
+			}
+			r.checkPayment(ctx, &list[i])
+			afterID = list[i].ID
+		}
+		checked += len(list)
+		if len(list) < sweepBatch {
+			break
+		}
+	}
+	for afterID := uint(0); ; {
+		list, err := r.withdrawalRepo.ListForReconciliation(pendingBefore, settledSince, afterID, sweepBatch)
+		if err != nil {
+			return err
+		}
+		for i := range list {
+			if ctx.Err() != nil {
+				return nil
+			}
+			r.checkWithdrawal(ctx, &list[i])
+			afterID = list[i].ID
+		}
+		checked += len(list)
+		if len(list) < sweepBatch {
+			break
+		}
+	}
+	log.Printf("[reconcile] checked %d records in %s", checked, time.Since(now).Round(time.Millisecond))
+	return nil
+}
+
+func (r *Reconciler) checkPayment(ctx context.Context, p *models.Payment) {
+	var status, inboxProvider string
+	var err error
+	switch p.Provider {
+	case "mpesa_liberec":
+		inboxProvider = "mpesa"
+		status, err = r.mpesa.TransactionStatus(ctx, p.ProviderRef)
+	case "solana":
+		inboxProvider = "crypto"
+		status, err = r.crypto.DepositStatus(ctx, p.ProviderRef)
+	default:
+		return
+	}
+	if err != nil {
+		log.Printf("[reconcile] payment %d (%s): provider lookup failed: %v", p.ID, p.ProviderRef, err)
+		return
+	}
+	outcome := providerOutcome(status)
+	d := &models.ReconciliationDiscrepancy{
+		Kind:           domain.ReconcileKindPayment,
+		RecordID:       p.ID,
+		LocalStatus:    p.Status,
+		ProviderStatus: status,
+		Provider:       p.Provider,
+		Reference:      p.ProviderRef,
+		UserID:         p.UserID,
+		AmountCents:    p.AmountCents,
+	}
+	switch p.Status {
+	case domain.PaymentStatusPending:
+		// Safe: nothing has been done with the money yet, so apply the provider's verdict as its webhook would
+		if outcome == outcomePaid || outcome == outcomeFailed {
+			d.Reason = "webhook never arrived; provider reports " + status
+			r.resolveViaInbox(ctx, d, inboxProvider, paymentCallback(inboxProvider, p.ProviderRef, outcome))
+		}
+	case domain.PaymentStatusCompleted:
+		if outcome == outcomePaid {
+			r.consistent(d)
+			return
+		}
+		d.Reason = "completed here but provider reports " + describeStatus(status)
+		r.report(d)
+	default: // FAILED, CANCELLED
+		if outcome != outcomePaid {
+			r.consistent(d)
+			return
+		}
+		d.Reason = "provider charged the customer but the payment is " + p.Status + " here"
+		r.report(d)
+	}
+}
+
+func (r *Reconciler) checkWithdrawal(ctx context.Context, w *models.Withdrawal) {
+	status, err := r.mpesa.TransactionStatus(ctx, w.OrderID)
+	if err != nil {
+		log.Printf("[reconcile] withdrawal %d (%s): provider lookup failed: %v", w.ID, w.OrderID, err)
+		return
+	}
+	outcome := providerOutcome(status)
+	d := &models.ReconciliationDiscrepancy{
+		Kind:           domain.ReconcileKindWithdrawal,
+		RecordID:       w.ID,
+		LocalStatus:    w.Status,
+		ProviderStatus: status,
+		Provider:       "mpesa_b2c",
+		Reference:      w.OrderID,
+		UserID:         w.UserID,
+		AmountCents:    w.AmountCents,
+	}
+	switch w.Status {
+	case "PENDING":
+		if outcome == outcomePaid || outcome == outcomeFailed {
+			d.Reason = "webhook never arrived; provider reports " + status
+			r.resolveViaInbox(ctx, d, "withdrawal", paymentCallback("withdrawal", w.OrderID, outcome))
+		}
+	case "COMPLETED":
+		if outcome == outcomePaid {
+			r.consistent(d)
+			return
+		}
+		d.Reason = "paid out here but provider reports " + describeStatus(status)
+		r.report(d)
+	case "FAILED":
+		if outcome != outcomePaid {
+			r.consistent(d)
+			return
+		}
+		// The amount was refunded to the wallet and also reached the phone
+		d.Reason = "refunded here but provider completed the payout"
+		r.report(d)
+	}
+}
+
+// resolveViaInbox applies the provider's status through the webhook processor. If processing does not go
+// through (e.g. the record changed meanwhile), the mismatch is left open for an admin.
+func (r *Reconciler) resolveViaInbox(ctx context.Context, d *models.ReconciliationDiscrepancy, provider string, body []byte) {
+	key := fmt.Sprintf("reconcile:%s:%s", d.Reference, d.ProviderStatus)
+	ev, created, err := r.inbox.Enqueue(ctx, provider, key, body, "reconciler")
+	if err != nil {
+		log.Printf("[reconcile] %s %d: enqueue failed: %v", d.Kind, d.RecordID, err)
+		return
+	}
+	if !created {
+		return // queued on an earlier run; the inbox retries it
+	}
+	if ev.Status == domain.WebhookEventProcessed {
+		d.Status = domain.DiscrepancyStatusAutoResolved
+		d.Resolution = fmt.Sprintf("applied provider status via webhook event %d", ev.ID)
+		now := time.Now()
+		d.ResolvedAt = &now
+	} else {
+		d.Status = domain.DiscrepancyStatusOpen
+		d.Resolution = fmt.Sprintf("webhook event %d is %s: %s", ev.ID, ev.Status, ev.LastError)
+	}
+	log.Printf("[reconcile] %s %d (%s): %s — %s", d.Kind, d.RecordID, d.Reference, d.Reason, d.Resolution)
+	if err := r.reconRepo.Record(d); err != nil {
+		log.Printf("[reconcile] %s %d: record discrepancy: %v", d.Kind, d.RecordID, err)
+	}
+}
+
+func (r *Reconciler) report(d *models.ReconciliationDiscrepancy) {
+	d.Status = domain.DiscrepancyStatusOpen
+	log.Printf("[reconcile] %s %d (%s): %s", d.Kind, d.RecordID, d.Reference, d.Reason)
+	if err := r.reconRepo.Record(d); err != nil {
+		log.Printf("[reconcile] %s %d: record discrepancy: %v", d.Kind, d.RecordID, err)
+	}
+}
+
+func (r *Reconciler) consistent(d *models.ReconciliationDiscrepancy) {
+	if err := r.reconRepo.ResolveOpen(d.Kind, d.RecordID, "consistent with provider on a later run"); err != nil {
+		log.Printf("[reconcile] %s %d: resolve discrepancies: %v", d.Kind, d.RecordID, err)
+	}
+}
+
+// paymentCallback builds the callback body the provider would have sent for outcome, in the shape the
+// inbox processor for provider reads.
+func paymentCallback(provider, reference, outcome string) []byte {
+	var body map[string]interface{}
+	switch provider {
+	case "crypto":
+		status := "completed"
+		if outcome == outcomeFailed {
+			status = "failed"
+		}
+		body = map[string]interface{}{"event": "reconciliation", "merchant_deposit_id": reference, "status": status}
+	default: // mpesa, withdrawal
+		status := "COMPLETED"
+		if outcome == outcomeFailed {
+			status = "FAILED"
+		}
+		body = map[string]interface{}{"merchant_order_id": reference, "status": status, "status_description": "reconciliation"}
+	}
+	b, _ := json.Marshal(body)
+	return b
+}
+
+func providerOutcome(status string) string {
+	switch strings.ToUpper(status) {
+	case "":
+		return outcomeMissing
+	case "COMPLETED":
+		return outcomePaid
+	case "FAILED", "CANCELLED", "EXPIRED":
+		return outcomeFailed
+	default:
+		return outcomePending
+	}
+}
+
+func describeStatus(status string) string {
+	if status == "" {
+		return "no such transaction"
+	}
+	return status
+}
@@ -0,0 +1,122 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"lusty/internal/database/databasetest"
+	"lusty/internal/domain"
+	"lusty/internal/models"
+	"lusty/internal/repository"
+	"lusty/internal/webhook"
+	"lusty/pkg/payment"
+)
+
+// statusStub reports the M-Pesa transaction statuses in statuses; orders it does not know are missing.
+type statusStub struct {
+	payment.MobileMoneyProvider
+	statuses map[string]string
+}
+
+func (s *statusStub) TransactionStatus(_ context.Context, orderID string) (string, error) {
+	return s.statuses[orderID], nil
+}
+
+// recordingProcessor keeps the callbacks the reconciler feeds through the inbox.
+type recordingProcessor struct {
+	bodies []map[string]interface{}
+}
+
+func (p *recordingProcessor) EventKey(body []byte) (string, error) {
+	if len(body) == 0 {
+		return "", errors.New("empty body")
+	}
+	return string(body), nil
+}
+
+func (p *recordingProcessor) Process(_ context.Context, ev *models.WebhookEvent) error {
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(ev.Payload), &body); err != nil {
+		return err
+	}
+	p.bodies = append(p.bodies, body)
+	return nil
+}
+
+func discrepanciesFor(t *testing.T, reconRepo *repository.ReconciliationRepository, recordID uint) []models.ReconciliationDiscrepancy {
+	t.Helper()
+	list, _, err := reconRepo.List("", domain.ReconcileKindPayment, 1, 100)
+	if err != nil {
+		t.Fatalf("list discrepancies: %v", err)
+	}
+	var out []models.ReconciliationDiscrepancy
+	for _, d := range list {
+		if d.RecordID == recordID {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// TestReconcilerPayments checks a PENDING payment the provider settled is applied through the inbox, a
+// completed one the provider has no record of is reported for an admin, and consistent ones are left alone.
+func TestReconcilerPayments(t *testing.T) {
+	db := databasetest.New(t)
+	paymentRepo := repository.NewPaymentRepository(db)
+	reconRepo := repository.NewReconciliationRepository(db)
+	inbox := webhook.NewInbox(repository.NewWebhookEventRepository(db))
+	processor := &recordingProcessor{}
+	inbox.Register("mpesa", processor)
+	mpesa := &statusStub{statuses: map[string]string{"order-paid": "COMPLETED", "order-ok": "COMPLETED", "order-waiting": "PENDING"}}
+	reconciler := NewReconciler(paymentRepo, repository.NewWithdrawalRepository(db), reconRepo, inbox, mpesa, nil, 0, time.Hour)
+
+	payments := map[string]*models.Payment{}
+	for ref, status := range map[string]string{
+		"order-paid":    domain.PaymentStatusPending,
+		"order-waiting": domain.PaymentStatusPending,
+		"order-ok":      domain.PaymentStatusCompleted,
+		"order-lost":    domain.PaymentStatusCompleted,
+	} {
+		p := &models.Payment{UserID: 1, AmountCents: 120_000, Currency: "KES", Provider: "mpesa_liberec", ProviderRef: ref,
+			IdempotencyKey: ref, Status: status}
+		if err := paymentRepo.Create(p); err != nil {
+			t.Fatalf("create payment: %v", err)
+		}
+		payments[ref] = p
+	}
+
+	for range 2 {
+		if err := reconciler.Run(context.Background()); err != nil {
+			t.Fatalf("run: %v", err)
+		}
+	}
+
+	// The missed callback is applied once, in the shape the M-Pesa processor reads
+	if len(processor.bodies) != 1 || processor.bodies[0]["merchant_order_id"] != "order-paid" || processor.bodies[0]["status"] != "COMPLETED" {
+		t.Fatalf("processed callbacks = %v, want one COMPLETED for order-paid", processor.bodies)
+	}
+	if got := discrepanciesFor(t, reconRepo, payments["order-paid"].ID); len(got) != 1 || got[0].Status != domain.DiscrepancyStatusAutoResolved {
+		t.Fatalf("order-paid discrepancies = %+v, want one auto-resolved", got)
+	}
+	lost := discrepanciesFor(t, reconRepo, payments["order-lost"].ID)
+	if len(lost) != 1 || lost[0].Status != domain.DiscrepancyStatusOpen || lost[0].ProviderStatus != "" {
+		t.Fatalf("order-lost discrepancies = %+v, want one open with no provider status", lost)
+	}
+	for _, ref := range []string{"order-ok", "order-waiting"} {
+		if got := discrepanciesFor(t, reconRepo, payments[ref].ID); len(got) != 0 {
+			t.Fatalf("%s discrepancies = %+v, want none", ref, got)
+		}
+	}
+
+	// Once the provider agrees, the open mismatch is closed on the next run
+	mpesa.statuses["order-lost"] = "COMPLETED"
+	if err := reconciler.Run(context.Background()); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if got := discrepanciesFor(t, reconRepo, payments["order-lost"].ID); len(got) != 1 || got[0].Status != domain.DiscrepancyStatusResolved {
+		t.Fatalf("order-lost discrepancies = %+v, want resolved", got)
+	}
+}
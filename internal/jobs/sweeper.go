@@ -0,0 +1,230 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"lusty/internal/domain"
+	"lusty/internal/models"
+	"lusty/internal/repository"
+	"lusty/internal/service"
+	"lusty/internal/service/interaction"
+)
+
+// sweepBatch caps how many rows one sweep handles; the rest are picked up on the next tick.
+const sweepBatch = 100
+
+// chatPushWindow bounds how old an undelivered chat message may be and still be pushed.
+const chatPushWindow = time.Hour
+
+// Sweeper expires interaction requests nobody answered, cancels payments the client never completed, sends
+// booking reminders and pushes chat messages that never reached their recipient.
+type Sweeper struct {
+	paymentRepo         *repository.PaymentRepository
+	interactionRepo     *repository.InteractionRepository
+	walletRepo          *repository.WalletRepository
+	interactionSvc      *interaction.Service
+	notifSvc            *service.NotificationService
+	paymentExpiry       time.Duration
+	bookingReminderLead time.Duration
+	chatPushDelay       time.Duration
+}
+
+func NewSweeper(
+	paymentRepo *repository.PaymentRepository,
+	interactionRepo *repository.InteractionRepository,
+	walletRepo *repository.WalletRepository,
+	interactionSvc *interaction.Service,
+	notifSvc *service.NotificationService,
+	paymentExpiry time.Duration,
+	bookingReminderLead time.Duration,
+	chatPushDelay time.Duration,
+) *Sweeper {
+	return &Sweeper{
+		paymentRepo:         paymentRepo,
+		interactionRepo:     interactionRepo,
+		walletRepo:          walletRepo,
+		interactionSvc:      interactionSvc,
+		notifSvc:            notifSvc,
+		paymentExpiry:       paymentExpiry,
+		bookingReminderLead: bookingReminderLead,
+		chatPushDelay:       chatPushDelay,
+	}
+}
+
+// ExpireRequests moves PENDING / PENDING_KYC requests past expires_at to EXPIRED. interaction.Service refunds
+// the client's escrow to their wallet and notifies both sides.
+func (s *Sweeper) ExpireRequests(ctx context.Context) error {
+	list, err := s.interactionRepo.ListExpired(time.Now(), sweepBatch)
+	if err != nil {
+		return err
+	}
+	for i := range list {
+		if ctx.Err() != nil {
+			return nil
+		}
+		ir := &list[i]
+		if err := s.interactionSvc.Expire(ir, "request expired"); err != nil {
+			if !errors.Is(err, repository.ErrStatusChanged) {
+				log.Printf("[jobs] expire interaction %d: %v", ir.ID, err)
+			}
+			continue
+		}
+		log.Printf("[jobs] expired interaction %d", ir.ID)
+	}
+	return nil
+}
+
+// AutoCompleteDelivered completes ACCEPTED requests the companion marked delivered whose grace period has
+// passed without the client confirming or disputing. The companion is paid exactly as on service done.
+func (s *Sweeper) AutoCompleteDelivered(ctx context.Context) error {
+	list, err := s.interactionRepo.ListAutoCompleteDue(time.Now(), sweepBatch)
+	if err != nil {
+		return err
+	}
+	for i := range list {
+		if ctx.Err() != nil {
+			return nil
+		}
+		ir := &list[i]
+		if err := s.interactionSvc.AutoComplete(ir); err != nil {
+			if !errors.Is(err, repository.ErrStatusChanged) {
+				log.Printf("[jobs] auto-complete interaction %d: %v", ir.ID, err)
+			}
+			continue
+		}
+		log.Printf("[jobs] auto-completed interaction %d", ir.ID)
+	}
+	return nil
+}
+
+// RemindUpcomingBookings notifies both sides of accepted bookings starting within the reminder lead time.
+// Each booking is reminded once.
+func (s *Sweeper) RemindUpcomingBookings(ctx context.Context) error {
+	now := time.Now()
+	list, err := s.interactionRepo.ListBookingRemindersDue(now, now.Add(s.bookingReminderLead), sweepBatch)
+	if err != nil {
+		return err
+	}
+	for i := range list {
+		if ctx.Err() != nil {
+			return nil
+		}
+		ir := &list[i]
+		ok, err := s.interactionRepo.MarkReminderSent(ir.ID, now)
+		if err != nil {
+			log.Printf("[jobs] booking reminder %d: %v", ir.ID, err)
+			continue
+		}
+		if !ok {
+			continue // sent by another instance
+		}
+		s.interactionSvc.RemindBooking(ir)
+	}
+	return nil
+}
+
+// PushUndeliveredMessages pushes chat messages their recipient has not confirmed receiving chatPushDelay after
+// they were sent, e.g. because the connection dropped or was evicted as too slow. One push covers all of a
+// sender's undelivered messages in a chat; NotificationService collapses it with earlier ones for that chat.
+func (s *Sweeper) PushUndeliveredMessages(ctx context.Context) error {
+	now := time.Now()
+	list, err := s.interactionRepo.ListUnpushed(now.Add(-chatPushWindow), now.Add(-s.chatPushDelay), sweepBatch)
+	if err != nil {
+		return err
+	}
+	// The latest undelivered message per session and sender
+	type chat struct{ sessionID, senderID uint }
+	latest := make(map[chat]*models.ChatMessage)
+	var order []chat
+	for i := range list {
+		k := chat{list[i].SessionID, list[i].SenderID}
+		if latest[k] == nil {
+			order = append(order, k)
+		}
+		latest[k] = &list[i]
+	}
+	for _, k := range order {
+		if ctx.Err() != nil {
+			return nil
+		}
+		m := latest[k]
+		ir, err := s.interactionRepo.GetByID(m.Session.InteractionID)
+		if err != nil {
+			log.Printf("[jobs] push chat message %d: %v", m.ID, err)
+			continue
+		}
+		recipientID := ir.ClientID
+		if m.SenderID == ir.ClientID {
+			recipientID = ir.Companion.UserID
+		}
+		n, err := s.interactionRepo.MarkMessagesPushed(m.SessionID, recipientID, m.ID, now)
+		if err != nil {
+			log.Printf("[jobs] push chat message %d: %v", m.ID, err)
+			continue
+		}
+		if n == 0 {
+			continue // delivered meanwhile, or pushed by another instance
+		}
+		s.notifSvc.NotifyNewChatMessage(ir, m, int(n))
+	}
+	return nil
+}
+
+// CancelAbandonedPayments cancels PENDING payments past their expiry, returns any wallet portion the client
+// put towards them and expires the unpaid request created alongside. If the provider confirms the charge
+// after all, the payment webhooks refund it to the client's wallet.
+func (s *Sweeper) CancelAbandonedPayments(ctx context.Context) error {
+	now := time.Now()
+	list, err := s.paymentRepo.ListAbandoned(now, now.Add(-s.paymentExpiry), sweepBatch)
+	if err != nil {
+		return err
+	}
+	for i := range list {
+		if ctx.Err() != nil {
+			return nil
+		}
+		s.cancelPayment(&list[i])
+	}
+	return nil
+}
+
+func (s *Sweeper) cancelPayment(pay *models.Payment) {
+	var meta struct {
+		WalletCents int64 `json:"wallet_cents"`
+	}
+	if pay.Metadata != "" {
+		_ = json.Unmarshal([]byte(pay.Metadata), &meta)
+	}
+	// The wallet portion goes back in the same transaction, so a failure leaves the payment PENDING for the
+	// next sweep rather than cancelled without its refund.
+	ok, err := s.paymentRepo.CloseReturningWallet(pay, domain.PaymentStatusCancelled, meta.WalletCents)
+	if err != nil {
+		log.Printf("[jobs] cancel payment %d: %v", pay.ID, err)
+		return
+	}
+	if !ok {
+		return // completed or cancelled meanwhile
+	}
+	log.Printf("[jobs] cancelled abandoned payment %d order_id=%s", pay.ID, pay.ProviderRef)
+	s.interactionSvc.ExtensionPaymentFailed(pay)
+	ir, _ := s.interactionRepo.GetByPaymentID(pay.ID)
+	if ir != nil && (ir.Status == domain.RequestStatusPending || ir.Status == domain.RequestStatusPendingKYC) {
+		if err := s.interactionSvc.Expire(ir, "payment cancelled"); err != nil && !errors.Is(err, repository.ErrStatusChanged) {
+			log.Printf("[jobs] payment %d: expire interaction %d: %v", pay.ID, ir.ID, err)
+		}
+	}
+	pay.Status = domain.PaymentStatusCancelled
+	var interactionID uint
+	if ir != nil {
+		interactionID = ir.ID
+	}
+	body := "Your payment was not completed in time and has been cancelled."
+	if meta.WalletCents > 0 {
+		body += " The wallet amount you used has been returned to your wallet."
+	}
+	_ = s.notifSvc.NotifyPaymentStatus(pay, interactionID, false, body)
+}
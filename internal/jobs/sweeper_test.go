@@ -0,0 +1,189 @@
+package jobs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"lusty/internal/database/databasetest"
+	"lusty/internal/domain"
+	"lusty/internal/models"
+	"lusty/internal/repository"
+	"lusty/internal/service"
+	"lusty/internal/service/interaction"
+
+	"gorm.io/gorm"
+)
+
+func newTestSweeper(db *gorm.DB) (*Sweeper, *interaction.Service) {
+	userRepo := repository.NewUserRepository(db)
+	interactionRepo := repository.NewInteractionRepository(db)
+	walletRepo := repository.NewWalletRepository(db)
+	notifSvc := service.NewNotificationService(repository.NewNotificationRepository(db), userRepo, nil)
+	interactionSvc := interaction.NewService(interactionRepo, repository.NewCompanionRepository(db), userRepo, walletRepo,
+		repository.NewEscrowRepository(db), repository.NewReferralRepository(db), repository.NewSettingRepository(db),
+		repository.NewAvailabilityRepository(db), repository.NewExtensionRepository(db), repository.NewDisputeRepository(db), notifSvc)
+	return NewSweeper(repository.NewPaymentRepository(db), interactionRepo, walletRepo, interactionSvc, notifSvc, 15*time.Minute,
+		time.Hour, time.Minute), interactionSvc
+}
+
+// TestCancelAbandonedPayments checks an expired STK payment is cancelled with its wallet portion returned
+// once, and the unpaid request opened for it expires with it.
+func TestCancelAbandonedPayments(t *testing.T) {
+	db := databasetest.New(t)
+	sweeper, interactionSvc := newTestSweeper(db)
+	paymentRepo := repository.NewPaymentRepository(db)
+	interactionRepo := repository.NewInteractionRepository(db)
+	walletRepo := repository.NewWalletRepository(db)
+
+	client := models.User{Email: "client@example.com", Username: "client", Role: domain.RoleClient, KYC: true}
+	if err := db.Create(&client).Error; err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	comp := models.CompanionProfile{UserID: 100, DisplayName: "companion"}
+	if err := db.Create(&comp).Error; err != nil {
+		t.Fatalf("create companion: %v", err)
+	}
+	if err := walletRepo.Credit(client.ID, 20_000, domain.LedgerAccountProviderClearing, domain.WalletTxTypeRefund, "seed"); err != nil {
+		t.Fatalf("fund wallet: %v", err)
+	}
+	if err := walletRepo.Debit(client.ID, 20_000, domain.LedgerAccountProviderClearing, domain.WalletTxTypePayment, "order-1"); err != nil {
+		t.Fatalf("debit wallet portion: %v", err)
+	}
+	expired := time.Now().Add(-time.Minute)
+	pay := &models.Payment{UserID: client.ID, AmountCents: 120_000, Currency: "KES", Provider: "mpesa_liberec", ProviderRef: "order-1",
+		IdempotencyKey: "order-1", Status: domain.PaymentStatusPending, Metadata: `{"wallet_cents":20000}`, ExpiresAt: &expired}
+	if err := paymentRepo.Create(pay); err != nil {
+		t.Fatalf("create payment: %v", err)
+	}
+	ir := &models.InteractionRequest{ClientID: client.ID, CompanionID: comp.ID, InteractionType: "CHAT", PaymentID: &pay.ID, DurationMinutes: 60}
+	if err := interactionSvc.Open(ir, false, &client.ID); err != nil {
+		t.Fatalf("open interaction: %v", err)
+	}
+
+	for range 2 {
+		if err := sweeper.CancelAbandonedPayments(context.Background()); err != nil {
+			t.Fatalf("sweep: %v", err)
+		}
+	}
+	if p, _ := paymentRepo.GetByID(pay.ID); p.Status != domain.PaymentStatusCancelled {
+		t.Fatalf("payment status = %s, want CANCELLED", p.Status)
+	}
+	if w, err := walletRepo.GetByUserID(client.ID); err != nil || w.BalanceCents != 20_000 {
+		t.Fatalf("client wallet = %+v (%v), want 20000 back once", w, err)
+	}
+	if stored, _ := interactionRepo.GetByID(ir.ID); stored.Status != domain.RequestStatusExpired {
+		t.Fatalf("interaction status = %s, want EXPIRED", stored.Status)
+	}
+}
+
+// TestAutoCompleteDelivered checks a delivered interaction completes and pays the companion once its grace
+// period is over, while one still in its grace period or under dispute is left alone.
+func TestAutoCompleteDelivered(t *testing.T) {
+	db := databasetest.New(t)
+	sweeper, interactionSvc := newTestSweeper(db)
+	interactionRepo := repository.NewInteractionRepository(db)
+	escrowRepo := repository.NewEscrowRepository(db)
+
+	comp := models.CompanionProfile{UserID: 100, DisplayName: "companion"}
+	if err := db.Create(&comp).Error; err != nil {
+		t.Fatalf("create companion: %v", err)
+	}
+	seed := func(ref string, autoCompleteAt time.Time) *models.InteractionRequest {
+		pay := models.Payment{UserID: 1, AmountCents: 120_000, Currency: "KES", Provider: "fake", ProviderRef: ref, IdempotencyKey: ref,
+			Status: domain.PaymentStatusCompleted}
+		if err := db.Create(&pay).Error; err != nil {
+			t.Fatalf("create payment: %v", err)
+		}
+		accepted := time.Now().Add(-2 * time.Hour)
+		ir := &models.InteractionRequest{ClientID: 1, CompanionID: comp.ID, InteractionType: "CHAT", PaymentID: &pay.ID, DurationMinutes: 60,
+			Status: domain.RequestStatusAccepted, AcceptedAt: &accepted}
+		if err := db.Create(ir).Error; err != nil {
+			t.Fatalf("create interaction: %v", err)
+		}
+		if _, err := escrowRepo.Hold(ir); err != nil {
+			t.Fatalf("hold: %v", err)
+		}
+		if ok, err := interactionSvc.MarkDelivered(ir, autoCompleteAt); err != nil || !ok {
+			t.Fatalf("mark delivered: ok=%v err=%v", ok, err)
+		}
+		return ir
+	}
+	due := seed("order-due", time.Now().Add(-time.Minute))
+	waiting := seed("order-waiting", time.Now().Add(time.Hour))
+	disputed := seed("order-disputed", time.Now().Add(-time.Minute))
+	if err := interactionSvc.Dispute(disputed, &models.Dispute{Reason: "not delivered"}, &disputed.ClientID); err != nil {
+		t.Fatalf("dispute: %v", err)
+	}
+
+	for range 2 {
+		if err := sweeper.AutoCompleteDelivered(context.Background()); err != nil {
+			t.Fatalf("sweep: %v", err)
+		}
+	}
+	for ir, want := range map[*models.InteractionRequest]string{
+		due:      domain.RequestStatusCompleted,
+		waiting:  domain.RequestStatusAccepted,
+		disputed: domain.RequestStatusDisputed,
+	} {
+		if stored, _ := interactionRepo.GetByID(ir.ID); stored.Status != want {
+			t.Fatalf("interaction %d status = %s, want %s", ir.ID, stored.Status, want)
+		}
+	}
+	hold, _ := escrowRepo.GetByInteractionID(due.ID)
+	if hold == nil || hold.Status != domain.EscrowStatusReleased {
+		t.Fatalf("hold = %+v, want RELEASED", hold)
+	}
+	w, err := repository.NewWalletRepository(db).GetByUserID(comp.UserID)
+	if want := domain.CompanionPayout(hold.CompanionBaseCents()); err != nil || w.WithdrawableCents != want {
+		t.Fatalf("companion wallet = %+v (%v), want %d withdrawable, paid once", w, err, want)
+	}
+}
+
+// TestPushUndeliveredMessages checks messages left undelivered past the push delay are marked pushed,
+// while delivered and recent ones are left for the live connection.
+func TestPushUndeliveredMessages(t *testing.T) {
+	db := databasetest.New(t)
+	sweeper, _ := newTestSweeper(db)
+	interactionRepo := repository.NewInteractionRepository(db)
+
+	comp := models.CompanionProfile{UserID: 100, DisplayName: "companion"}
+	if err := db.Create(&comp).Error; err != nil {
+		t.Fatalf("create companion: %v", err)
+	}
+	ir := models.InteractionRequest{ClientID: 1, CompanionID: comp.ID, InteractionType: "CHAT", DurationMinutes: 60,
+		Status: domain.RequestStatusAccepted}
+	if err := db.Create(&ir).Error; err != nil {
+		t.Fatalf("create interaction: %v", err)
+	}
+	session := models.ChatSession{InteractionID: ir.ID, StartedAt: time.Now(), EndsAt: time.Now().Add(time.Hour)}
+	if err := db.Create(&session).Error; err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	send := func(sender uint, age time.Duration) *models.ChatMessage {
+		m := &models.ChatMessage{SessionID: session.ID, SenderID: sender, Content: "hi", Kind: domain.ChatMessageKindText,
+			CreatedAt: time.Now().Add(-age)}
+		if err := interactionRepo.CreateMessage(m); err != nil {
+			t.Fatalf("create message: %v", err)
+		}
+		return m
+	}
+	waiting := []*models.ChatMessage{send(ir.ClientID, 5*time.Minute), send(ir.ClientID, 4*time.Minute)}
+	delivered := send(comp.UserID, 5*time.Minute)
+	if _, err := interactionRepo.MarkMessagesDelivered(session.ID, ir.ClientID, delivered.ID, time.Now()); err != nil {
+		t.Fatalf("deliver: %v", err)
+	}
+	recent := send(ir.ClientID, 0)
+
+	for range 2 {
+		if err := sweeper.PushUndeliveredMessages(context.Background()); err != nil {
+			t.Fatalf("push: %v", err)
+		}
+	}
+	var pushed []uint
+	db.Model(&models.ChatMessage{}).Where("pushed_at IS NOT NULL").Order("id ASC").Pluck("id", &pushed)
+	if len(pushed) != 2 || pushed[0] != waiting[0].ID || pushed[1] != waiting[1].ID {
+		t.Fatalf("pushed messages = %v, want %d and %d (not delivered %d or recent %d)", pushed, waiting[0].ID, waiting[1].ID,
+			delivered.ID, recent.ID)
+	}
+}
@@ -0,0 +1,236 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"lusty/config"
+	"lusty/internal/models"
+	"lusty/internal/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	WebhookSignatureHeader = "X-Webhook-Signature" // hex HMAC-SHA256 of "<timestamp>.<body>", optionally prefixed "sha256="
+	WebhookTimestampHeader = "X-Webhook-Timestamp" // unix seconds
+)
+
+// WebhookGuard authenticates one provider's callbacks before the handler runs: source IP allowlist,
+// HMAC signature over a timestamped body, and a replay window. Every rejection is written to audit_logs.
+//
+// The replay check is kept in this process's memory, so it does not span replicas or restarts. It only
+// spares the handler obvious duplicates: the webhook inbox's unique event key is what makes a redelivered
+// event harmless everywhere.
+type WebhookGuard struct {
+	provider  string
+	cfg       config.WebhookAuthConfig
+	nets      []*net.IPNet
+	auditRepo *repository.AuditLogRepository
+
+	mu       sync.Mutex
+	seen     map[string]time.Time // delivery key -> first accepted at
+	inFlight map[string]struct{}  // delivery keys whose handler is still running
+}
+
+func NewWebhookGuard(provider string, cfg config.WebhookAuthConfig, auditRepo *repository.AuditLogRepository) *WebhookGuard {
+	g := &WebhookGuard{
+		provider:  provider,
+		cfg:       cfg,
+		auditRepo: auditRepo,
+		seen:      make(map[string]time.Time),
+		inFlight:  make(map[string]struct{}),
+	}
+	for _, s := range cfg.AllowedIPs {
+		if !strings.Contains(s, "/") {
+			if strings.Contains(s, ":") {
+				s += "/128"
+			} else {
+				s += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			log.Printf("[webhook] %s: ignoring invalid allowed IP %q", provider, s)
+			continue
+		}
+		g.nets = append(g.nets, n)
+	}
+	switch {
+	case cfg.Secret == "" && len(g.nets) == 0:
+		log.Printf("[webhook] %s: no signing secret or allowed IPs configured, callbacks will be refused", provider)
+	case cfg.Secret == "":
+		log.Printf("[webhook] %s: no signing secret configured, only the IP allowlist is checked", provider)
+	}
+	go g.cleanup()
+	return g
+}
+
+// Handler returns the middleware. A delivery's key is reserved before the handler runs, so a concurrent copy
+// of it is turned away (409, for the provider to retry), and kept only if the handler answered 2xx, so a
+// provider retrying a failed delivery is not mistaken for a replay. A provider with neither a secret nor an
+// allowlist has no way to authenticate, so all of its callbacks are refused.
+func (g *WebhookGuard) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if g.cfg.Secret == "" && len(g.nets) == 0 {
+			g.reject(c, http.StatusServiceUnavailable, "webhook authentication not configured")
+			return
+		}
+		if !g.ipAllowed(c.ClientIP()) {
+			g.reject(c, http.StatusForbidden, "source ip not allowed")
+			return
+		}
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		key := ""
+		if g.cfg.Secret != "" {
+			ts := c.GetHeader(WebhookTimestampHeader)
+			sig := strings.TrimPrefix(c.GetHeader(WebhookSignatureHeader), "sha256=")
+			if ts == "" || sig == "" {
+				g.reject(c, http.StatusUnauthorized, "missing signature")
+				return
+			}
+			sec, err := strconv.ParseInt(ts, 10, 64)
+			if err != nil {
+				g.reject(c, http.StatusUnauthorized, "invalid timestamp")
+				return
+			}
+			if age := time.Since(time.Unix(sec, 0)); age > g.cfg.ReplayWindow || age < -g.cfg.ReplayWindow {
+				g.reject(c, http.StatusUnauthorized, "timestamp outside replay window")
+				return
+			}
+			if !hmac.Equal([]byte(sig), []byte(SignWebhook(g.cfg.Secret, ts, body))) {
+				g.reject(c, http.StatusUnauthorized, "invalid signature")
+				return
+			}
+			key = sig
+		} else {
+			sum := sha256.Sum256(body)
+			key = hex.EncodeToString(sum[:])
+		}
+		switch g.reserve(key) {
+		case deliveryReplayed:
+			// 200 so the provider stops redelivering; the first delivery was already processed
+			RecordWebhookRejection(g.auditRepo, g.provider, c.ClientIP(), c.Request.UserAgent(), "replayed delivery")
+			c.AbortWithStatusJSON(http.StatusOK, gin.H{"received": true, "duplicate": true})
+			return
+		case deliveryInFlight:
+			RecordWebhookRejection(g.auditRepo, g.provider, c.ClientIP(), c.Request.UserAgent(), "delivery already in progress")
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "delivery already in progress"})
+			return
+		}
+		accepted := false
+		defer func() { g.release(key, accepted) }() // also when the handler panics
+		c.Next()
+		accepted = c.Writer.Status() < 300
+	}
+}
+
+// SignWebhook returns the hex HMAC-SHA256 a provider must send for body at timestamp ts.
+func SignWebhook(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// RecordWebhookRejection audits a refused provider callback. Webhook processors call it for verify-back
+// failures with the source recorded on the stored event.
+func RecordWebhookRejection(auditRepo *repository.AuditLogRepository, provider, ip, userAgent, reason string) {
+	log.Printf("[webhook] %s rejected from %s: %s", provider, ip, reason)
+	if auditRepo == nil {
+		return
+	}
+	meta, _ := json.Marshal(map[string]string{"reason": reason})
+	_ = auditRepo.Create(&models.AuditLog{
+		Action:     "webhook_rejected",
+		Resource:   "webhook",
+		ResourceID: provider,
+		IP:         ip,
+		UserAgent:  userAgent,
+		Metadata:   string(meta),
+	})
+}
+
+func (g *WebhookGuard) reject(c *gin.Context, status int, reason string) {
+	RecordWebhookRejection(g.auditRepo, g.provider, c.ClientIP(), c.Request.UserAgent(), reason)
+	c.AbortWithStatusJSON(status, gin.H{"error": reason})
+}
+
+func (g *WebhookGuard) ipAllowed(ip string) bool {
+	if len(g.nets) == 0 {
+		return true
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range g.nets {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Outcomes of WebhookGuard.reserve.
+const (
+	deliveryNew = iota
+	deliveryReplayed
+	deliveryInFlight
+)
+
+// reserve claims key for the delivery about to be handled, unless it was already accepted within the replay
+// window or another copy is being handled right now.
+func (g *WebhookGuard) reserve(key string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if at, ok := g.seen[key]; ok && time.Since(at) <= g.cfg.ReplayWindow {
+		return deliveryReplayed
+	}
+	if _, ok := g.inFlight[key]; ok {
+		return deliveryInFlight
+	}
+	g.inFlight[key] = struct{}{}
+	return deliveryNew
+}
+
+// release ends the reservation of key, remembering it as accepted if the handler succeeded.
+func (g *WebhookGuard) release(key string, accepted bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.inFlight, key)
+	if accepted {
+		g.seen[key] = time.Now()
+	}
+}
+
+func (g *WebhookGuard) cleanup() {
+	tick := time.NewTicker(time.Minute)
+	for range tick.C {
+		g.mu.Lock()
+		for k, at := range g.seen {
+			if time.Since(at) > g.cfg.ReplayWindow {
+				delete(g.seen, k)
+			}
+		}
+		g.mu.Unlock()
+	}
+}
@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"lusty/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testWebhookSecret = "test-secret"
+
+// newGuardedRouter serves POST /hook behind a WebhookGuard; handle answers the requests that get through.
+func newGuardedRouter(cfg config.WebhookAuthConfig, handle gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/hook", NewWebhookGuard("test", cfg, nil).Handler(), handle)
+	return r
+}
+
+func signedRequest(secret string, at time.Time, body string) *http.Request {
+	ts := strconv.FormatInt(at.Unix(), 10)
+	req := httptest.NewRequest(http.MethodPost, "/hook", bytes.NewBufferString(body))
+	req.Header.Set(WebhookTimestampHeader, ts)
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(secret, ts, []byte(body)))
+	return req
+}
+
+func serve(r http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestWebhookGuardRejectsBadRequests(t *testing.T) {
+	cfg := config.WebhookAuthConfig{Secret: testWebhookSecret, ReplayWindow: 5 * time.Minute}
+	handled := 0
+	r := newGuardedRouter(cfg, func(c *gin.Context) { handled++; c.Status(http.StatusOK) })
+
+	unsigned := httptest.NewRequest(http.MethodPost, "/hook", bytes.NewBufferString(`{}`))
+	tampered := signedRequest(testWebhookSecret, time.Now(), `{"status":"FAILED"}`)
+	tampered.Body = io.NopCloser(bytes.NewBufferString(`{"status":"COMPLETED"}`))
+	for name, req := range map[string]*http.Request{
+		"unsigned":    unsigned,
+		"wrong key":   signedRequest("other-secret", time.Now(), `{}`),
+		"tampered":    tampered,
+		"stale":       signedRequest(testWebhookSecret, time.Now().Add(-10*time.Minute), `{}`),
+		"from future": signedRequest(testWebhookSecret, time.Now().Add(10*time.Minute), `{}`),
+	} {
+		if w := serve(r, req); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status = %d, want 401", name, w.Code)
+		}
+	}
+	if handled != 0 {
+		t.Fatalf("handler ran %d times for rejected deliveries", handled)
+	}
+
+	ipOnly := newGuardedRouter(config.WebhookAuthConfig{AllowedIPs: []string{"10.0.0.0/8"}, ReplayWindow: time.Minute},
+		func(c *gin.Context) { c.Status(http.StatusOK) })
+	req := httptest.NewRequest(http.MethodPost, "/hook", bytes.NewBufferString(`{}`))
+	req.RemoteAddr = "192.0.2.1:1234"
+	if w := serve(ipOnly, req); w.Code != http.StatusForbidden {
+		t.Errorf("outside allowlist: status = %d, want 403", w.Code)
+	}
+
+	open := newGuardedRouter(config.WebhookAuthConfig{ReplayWindow: time.Minute}, func(c *gin.Context) { c.Status(http.StatusOK) })
+	if w := serve(open, httptest.NewRequest(http.MethodPost, "/hook", bytes.NewBufferString(`{}`))); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("unconfigured: status = %d, want 503", w.Code)
+	}
+}
+
+func TestWebhookGuardReplay(t *testing.T) {
+	cfg := config.WebhookAuthConfig{Secret: testWebhookSecret, ReplayWindow: 5 * time.Minute}
+	fail := true
+	handled := 0
+	r := newGuardedRouter(cfg, func(c *gin.Context) {
+		handled++
+		if fail {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+	at := time.Now()
+
+	// A delivery the handler failed is not remembered: the provider's retry goes through
+	if w := serve(r, signedRequest(testWebhookSecret, at, `{"id":1}`)); w.Code != http.StatusInternalServerError {
+		t.Fatalf("first delivery: status = %d, want 500", w.Code)
+	}
+	fail = false
+	if w := serve(r, signedRequest(testWebhookSecret, at, `{"id":1}`)); w.Code != http.StatusOK {
+		t.Fatalf("retry: status = %d, want 200", w.Code)
+	}
+	// Once accepted, the same signed delivery is acknowledged without running the handler again
+	if w := serve(r, signedRequest(testWebhookSecret, at, `{"id":1}`)); w.Code != http.StatusOK || !bytes.Contains(w.Body.Bytes(), []byte("duplicate")) {
+		t.Fatalf("replay: status = %d body = %s, want 200 duplicate", w.Code, w.Body)
+	}
+	if handled != 2 {
+		t.Fatalf("handler ran %d times, want 2", handled)
+	}
+}
+
+// TestWebhookGuardConcurrentDelivery checks a copy of a delivery that arrives while the first is still being
+// handled is turned away instead of being handled twice.
+func TestWebhookGuardConcurrentDelivery(t *testing.T) {
+	cfg := config.WebhookAuthConfig{Secret: testWebhookSecret, ReplayWindow: 5 * time.Minute}
+	entered, release := make(chan struct{}), make(chan struct{})
+	r := newGuardedRouter(cfg, func(c *gin.Context) {
+		close(entered)
+		<-release
+		c.Status(http.StatusOK)
+	})
+	at := time.Now()
+
+	first := make(chan int)
+	go func() { first <- serve(r, signedRequest(testWebhookSecret, at, `{"id":2}`)).Code }()
+	<-entered
+	if w := serve(r, signedRequest(testWebhookSecret, at, `{"id":2}`)); w.Code != http.StatusConflict {
+		t.Fatalf("concurrent copy: status = %d, want 409", w.Code)
+	}
+	close(release)
+	if code := <-first; code != http.StatusOK {
+		t.Fatalf("first delivery: status = %d, want 200", code)
+	}
+}
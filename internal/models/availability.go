@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// AvailabilityRule is one weekly window in which a companion takes bookings, in her profile's timezone.
+// EndMinute <= StartMinute means the window runs past midnight into the next day.
+type AvailabilityRule struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	CompanionID uint      `gorm:"not null;index" json:"companion_id"`
+	Weekday     int       `gorm:"not null" json:"weekday"`      // 0 = Sunday
+	StartMinute int       `gorm:"not null" json:"start_minute"` // minutes after midnight
+	EndMinute   int       `gorm:"not null" json:"end_minute"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (AvailabilityRule) TableName() string {
+	return "companion_availability_rules"
+}
+
+// AvailabilityException overrides the weekly schedule for a period: time off (Available false) or extra
+// hours (Available true).
+type AvailabilityException struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	CompanionID uint      `gorm:"not null;index" json:"companion_id"`
+	StartAt     time.Time `gorm:"not null;index" json:"start_at"`
+	EndAt       time.Time `gorm:"not null;index" json:"end_at"`
+	Available   bool      `gorm:"not null;default:false" json:"available"`
+	Note        string    `gorm:"size:255" json:"note"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (AvailabilityException) TableName() string {
+	return "companion_availability_exceptions"
+}
@@ -13,6 +13,7 @@ type CompanionBoost struct {
 	StartAt     time.Time      `gorm:"not null;index" json:"start_at"`
 	EndAt       time.Time      `gorm:"not null;index" json:"end_at"`
 	IsActive    bool           `gorm:"default:true;index" json:"is_active"`
+	PaymentID   *uint          `gorm:"index" json:"payment_id"` // set for paid boosts
 	CreatedAt   time.Time      `json:"created_at"`
 	UpdatedAt   time.Time      `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
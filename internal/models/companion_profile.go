@@ -20,8 +20,13 @@ type CompanionProfile struct {
 	IsActive          bool           `gorm:"default:true;index" json:"is_active"`
 	AppearInSearch    bool           `gorm:"default:true;index" json:"appear_in_search"`
 	AcceptNewRequests bool           `gorm:"default:true" json:"accept_new_requests"`
-	IsAvailable       bool           `gorm:"default:true;index" json:"is_available"` // manual toggle; set false when companion accepts a request
+	IsAvailable       bool           `gorm:"default:true;index" json:"is_available"` // manual toggle; see InteractionRepository.CompanionAvailableNow for live availability
+	MaxConcurrentChats int           `gorm:"default:1" json:"max_concurrent_chats"`   // live unscheduled sessions she takes at once; bookings are limited by her calendar instead
 	OnboardingCompletedAt *time.Time `json:"onboarding_completed_at"` // nil = needs onboarding
+	RatingAvg         float64        `gorm:"default:0;index" json:"rating_avg"` // published client reviews, see CompanionRepository.RefreshRating
+	RatingCount       int            `gorm:"default:0" json:"rating_count"`
+	Timezone          string         `gorm:"size:64;default:'Africa/Nairobi'" json:"timezone"` // IANA zone the weekly availability is in
+	BookingBufferMinutes int         `gorm:"default:30" json:"booking_buffer_minutes"`         // kept free before and after each booking
 	CreatedAt         time.Time      `json:"created_at"`
 	UpdatedAt         time.Time      `json:"updated_at"`
 	DeletedAt         gorm.DeletedAt `gorm:"index" json:"-"`
@@ -35,6 +40,14 @@ func (CompanionProfile) TableName() string {
 	return "companion_profiles"
 }
 
+// ChatCapacity is how many unscheduled sessions she can have running at once (at least one).
+func (p *CompanionProfile) ChatCapacity() int {
+	if p.MaxConcurrentChats < 1 {
+		return 1
+	}
+	return p.MaxConcurrentChats
+}
+
 type CompanionMedia struct {
 	ID           uint           `gorm:"primaryKey" json:"id"`
 	CompanionID  uint           `gorm:"not null;index" json:"companion_id"`
@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// Dispute is a claim by either party that an accepted interaction went wrong. While it is OPEN the
+// interaction is DISPUTED and its escrow hold cannot be released or refunded except by resolving it.
+type Dispute struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	InteractionID  uint       `gorm:"uniqueIndex;not null" json:"interaction_id"`
+	ClientID       uint       `gorm:"not null;index" json:"client_id"`
+	CompanionID    uint       `gorm:"not null;index" json:"companion_id"` // companion profile ID
+	OpenedBy       uint       `gorm:"not null" json:"opened_by"`
+	OpenedByRole   string     `gorm:"size:20;not null" json:"opened_by_role"` // CLIENT, COMPANION
+	Reason         string     `gorm:"size:30;not null" json:"reason"`         // NO_SHOW, NOT_CONFIRMED, NOT_AS_AGREED, OTHER
+	Description    string     `gorm:"type:text" json:"description"`
+	Status         string     `gorm:"size:20;not null;index" json:"status"` // OPEN, RESOLVED
+	Snapshot       string     `gorm:"type:text" json:"snapshot"`            // chat and location evidence captured when opened (JSON)
+	Resolution     string     `gorm:"size:20" json:"resolution"`            // REFUND, RELEASE, SPLIT
+	ClientCents    int64      `gorm:"not null;default:0" json:"client_cents"`
+	CompanionCents int64      `gorm:"not null;default:0" json:"companion_cents"`
+	ResolutionNote string     `gorm:"size:500" json:"resolution_note"`
+	ResolvedBy     *uint      `json:"resolved_by"`
+	ResolvedAt     *time.Time `json:"resolved_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+
+	Evidence []DisputeEvidence `gorm:"foreignKey:DisputeID" json:"evidence,omitempty"`
+}
+
+func (Dispute) TableName() string {
+	return "disputes"
+}
+
+// DisputeEvidence is a statement or uploaded file (via /me/upload/chat) submitted by a party.
+type DisputeEvidence struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	DisputeID   uint      `gorm:"not null;index" json:"dispute_id"`
+	SubmittedBy uint      `gorm:"not null" json:"submitted_by"`
+	Note        string    `gorm:"size:1000" json:"note"`
+	MediaURL    string    `gorm:"size:512" json:"media_url"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (DisputeEvidence) TableName() string {
+	return "dispute_evidence"
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"lusty/internal/domain"
+)
+
+// EscrowHold is the client's payment for one InteractionRequest, held by the platform until the service
+// is settled. Funds sit in the ESCROW ledger account while Status is HELD; the settle columns record how
+// they left it.
+type EscrowHold struct {
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	InteractionID   uint       `gorm:"uniqueIndex;not null" json:"interaction_id"`
+	PaymentID       uint       `gorm:"index" json:"payment_id"`
+	ClientID        uint       `gorm:"not null;index" json:"client_id"`
+	CompanionID     uint       `gorm:"not null;index" json:"companion_id"` // companion profile ID
+	CompanionUserID uint       `gorm:"not null;index" json:"companion_user_id"`
+	AmountCents     int64      `gorm:"not null" json:"amount_cents"`              // what the client paid (base + platform fee), extensions included
+	ExtensionCents  int64      `gorm:"not null;default:0" json:"extension_cents"` // part of AmountCents from session extensions, which carry no platform fee
+	Status          string     `gorm:"size:20;not null;index" json:"status"`      // HELD, RELEASED, REFUNDED, SPLIT
+	ReleasedCents   int64      `gorm:"not null;default:0" json:"released_cents"`  // to companion withdrawable
+	RefundedCents   int64      `gorm:"not null;default:0" json:"refunded_cents"`  // back to client wallet
+	FeeCents        int64      `gorm:"not null;default:0" json:"fee_cents"`       // kept by platform
+	Reason          string     `gorm:"size:255" json:"reason"`
+	HoldEntryID     uint       `json:"hold_entry_id"`
+	SettleEntryID   uint       `json:"settle_entry_id"`
+	SettledAt       *time.Time `json:"settled_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+func (EscrowHold) TableName() string {
+	return "escrow_holds"
+}
+
+// CompanionBaseCents is the companion's price for everything held: the original request less its platform
+// fee, plus any extensions.
+func (h *EscrowHold) CompanionBaseCents() int64 {
+	return domain.CompanionBaseCents(h.AmountCents-h.ExtensionCents) + h.ExtensionCents
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// SessionExtension is extra time a client buys on an accepted session. Once its payment completes the
+// amount is added to the interaction's escrow hold and the session's EndsAt moves forward by Minutes.
+type SessionExtension struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	InteractionID uint       `gorm:"not null;index" json:"interaction_id"`
+	ClientID      uint       `gorm:"not null;index" json:"client_id"`
+	PaymentID     uint       `gorm:"uniqueIndex;not null" json:"payment_id"`
+	Minutes       int        `gorm:"not null" json:"minutes"`
+	AmountCents   int64      `gorm:"not null" json:"amount_cents"`
+	Status        string     `gorm:"size:20;not null;index" json:"status"` // PENDING, APPLIED, REFUNDED, FAILED
+	EndsAt        *time.Time `json:"ends_at,omitempty"`                    // session end after this extension was applied
+	AppliedAt     *time.Time `json:"applied_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+func (SessionExtension) TableName() string {
+	return "session_extensions"
+}
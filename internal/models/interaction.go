@@ -14,12 +14,17 @@ type InteractionRequest struct {
 	CompanionID      uint           `gorm:"not null;index" json:"companion_id"`
 	InteractionType  string         `gorm:"size:20;not null;index" json:"interaction_type"` // CHAT, VIDEO, BOOKING
 	PaymentID        *uint          `gorm:"index" json:"payment_id"`
-	Status           string         `gorm:"size:20;not null;index" json:"status"` // PENDING, PENDING_KYC, ACCEPTED, REJECTED, EXPIRED
+	Status           string         `gorm:"size:20;not null;index" json:"status"` // see interaction.Transitions; only changed through the interaction service
 	DurationMinutes  int            `json:"duration_minutes"`
 	ExpiresAt          *time.Time     `json:"expires_at"`
 	AcceptedAt         *time.Time     `json:"accepted_at"`
 	RejectedAt         *time.Time     `json:"rejected_at"`
 	ServiceCompletedAt *time.Time     `json:"service_completed_at"` // set when client confirms service done
+	DeliveredAt        *time.Time     `json:"delivered_at"`                 // companion marked the service delivered
+	AutoCompleteAt     *time.Time     `gorm:"index" json:"auto_complete_at"` // completed automatically then unless confirmed or disputed
+	SlotStart          *time.Time     `gorm:"index" json:"slot_start"`       // BOOKING for a future time slot; nil for an immediate request
+	SlotEnd            *time.Time     `gorm:"index" json:"slot_end"`         // SlotStart + DurationMinutes
+	ReminderSentAt     *time.Time     `json:"-"`                             // booking reminder sent to both sides
 	CreatedAt        time.Time      `json:"created_at"`
 	UpdatedAt        time.Time      `json:"updated_at"`
 	DeletedAt        gorm.DeletedAt `gorm:"index" json:"-"`
@@ -35,6 +40,21 @@ func (InteractionRequest) TableName() string {
 
 func (r *InteractionRequest) IsAccepted() bool { return r.Status == domain.RequestStatusAccepted }
 
+// InteractionTransition is one status change of an InteractionRequest. FromStatus is empty for creation.
+type InteractionTransition struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	InteractionID uint      `gorm:"not null;index" json:"interaction_id"`
+	FromStatus    string    `gorm:"size:20" json:"from_status"`
+	ToStatus      string    `gorm:"size:20;not null" json:"to_status"`
+	ActorID       *uint     `gorm:"index" json:"actor_id"` // nil for system (webhooks, jobs)
+	Reason        string    `gorm:"size:255" json:"reason"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+func (InteractionTransition) TableName() string {
+	return "interaction_transitions"
+}
+
 type ChatSession struct {
 	ID                uint           `gorm:"primaryKey" json:"id"`
 	InteractionID     uint           `gorm:"uniqueIndex;not null" json:"interaction_id"`
@@ -52,14 +72,23 @@ func (ChatSession) TableName() string {
 	return "chat_sessions"
 }
 
+// ChatMessage is one message in a chat session. DeliveredAt and ReadAt are the receipts from the other
+// participant; ClientMsgID is the sender's own ID for the message, so a resend is stored once. Messages
+// without DeliveredAt are the recipient's offline queue: replayed when they reconnect and pushed once.
 type ChatMessage struct {
-	ID         uint           `gorm:"primaryKey" json:"id"`
-	SessionID  uint           `gorm:"not null;index" json:"session_id"`
-	SenderID   uint           `gorm:"not null;index" json:"sender_id"`
-	Content    string         `gorm:"type:text" json:"content"`
-	MediaURL   string         `gorm:"size:512" json:"media_url"`
-	CreatedAt  time.Time      `json:"created_at"`
-	DeletedAt  gorm.DeletedAt `gorm:"index" json:"-"`
+	ID          uint           `gorm:"primaryKey" json:"id"`
+	SessionID   uint           `gorm:"not null;index;uniqueIndex:idx_chat_messages_client_msg,priority:1" json:"session_id"`
+	SenderID    uint           `gorm:"not null;index;uniqueIndex:idx_chat_messages_client_msg,priority:2" json:"sender_id"`
+	ClientMsgID *string        `gorm:"size:64;uniqueIndex:idx_chat_messages_client_msg,priority:3" json:"client_msg_id,omitempty"`
+	Content     string         `gorm:"type:text" json:"content"`
+	MediaURL    string         `gorm:"size:512" json:"media_url"`
+	Kind        string         `gorm:"size:20;not null;default:'text'" json:"kind"` // text, or call for a video call record
+	CallID      *uint          `gorm:"index" json:"call_id,omitempty"`
+	DeliveredAt *time.Time     `json:"delivered_at"`
+	ReadAt      *time.Time     `json:"read_at"`
+	PushedAt    *time.Time     `json:"-"` // when the recipient was sent a push for it because it was not delivered
+	CreatedAt   time.Time      `json:"created_at"`
+	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
 
 	Session ChatSession `gorm:"foreignKey:SessionID" json:"-"`
 	Sender  User        `gorm:"foreignKey:SenderID" json:"-"`
@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// LedgerAccount is one balance in the double-entry ledger. Per-user accounts (client balance,
+// companion pending, companion withdrawable) carry the owner's UserID; platform accounts use UserID 0.
+// BalanceCents is maintained alongside every posting and must equal the sum of the account's postings.
+type LedgerAccount struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	UserID       uint      `gorm:"not null;default:0;uniqueIndex:idx_ledger_account_owner_type" json:"user_id"`
+	Type         string    `gorm:"size:30;not null;uniqueIndex:idx_ledger_account_owner_type" json:"type"`
+	BalanceCents int64     `gorm:"not null;default:0" json:"balance_cents"`
+	Currency     string    `gorm:"size:3;default:'KES'" json:"currency"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+func (LedgerAccount) TableName() string { return "ledger_accounts" }
+
+// LedgerEntry groups the postings of a single money movement. The postings of an entry always sum to zero.
+// Entries are append-only; corrections are made with a new reversing entry.
+type LedgerEntry struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Type      string    `gorm:"size:30;not null;index" json:"type"` // same vocabulary as WalletTransaction.Type
+	Reference string    `gorm:"size:128;index" json:"reference"`
+	CreatedAt time.Time `json:"created_at"`
+
+	Postings []LedgerPosting `gorm:"foreignKey:EntryID" json:"postings,omitempty"`
+}
+
+func (LedgerEntry) TableName() string { return "ledger_entries" }
+
+// LedgerPosting is one leg of a LedgerEntry. Positive AmountCents increases the account balance, negative decreases it.
+type LedgerPosting struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	EntryID     uint      `gorm:"not null;index" json:"entry_id"`
+	AccountID   uint      `gorm:"not null;index" json:"account_id"`
+	AmountCents int64     `gorm:"not null" json:"amount_cents"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	Account LedgerAccount `gorm:"foreignKey:AccountID" json:"-"`
+}
+
+func (LedgerPosting) TableName() string { return "ledger_postings" }
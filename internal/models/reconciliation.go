@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// ReconciliationDiscrepancy is a payment or withdrawal whose status here disagrees with the provider's
+// records. One row per record and status pair; later runs that see the same mismatch bump LastSeenAt.
+type ReconciliationDiscrepancy struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	Kind           string     `gorm:"size:20;not null;uniqueIndex:idx_discrepancy_record" json:"kind"` // payment, withdrawal
+	RecordID       uint       `gorm:"not null;uniqueIndex:idx_discrepancy_record" json:"record_id"`
+	LocalStatus    string     `gorm:"size:20;not null;uniqueIndex:idx_discrepancy_record" json:"local_status"`
+	ProviderStatus string     `gorm:"size:32;not null;uniqueIndex:idx_discrepancy_record" json:"provider_status"` // empty when the provider has no record
+	Provider       string     `gorm:"size:50" json:"provider"`
+	Reference      string     `gorm:"size:255;index" json:"reference"` // our order ID at the provider
+	UserID         uint       `gorm:"index" json:"user_id"`
+	AmountCents    int64      `json:"amount_cents"`
+	Reason         string     `gorm:"size:255" json:"reason"`
+	Status         string     `gorm:"size:20;not null;index" json:"status"` // OPEN, RESOLVED, AUTO_RESOLVED
+	Resolution     string     `gorm:"size:500" json:"resolution"`
+	ResolvedBy     *uint      `json:"resolved_by"`
+	ResolvedAt     *time.Time `json:"resolved_at"`
+	LastSeenAt     time.Time  `json:"last_seen_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+func (ReconciliationDiscrepancy) TableName() string {
+	return "reconciliation_discrepancies"
+}
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// Refund sends (part of) a payment back to the client, to their wallet or to the M-Pesa number / crypto
+// address that paid. FundedBy records which ledger account the money comes out of, so a failed payout can
+// be put back there.
+type Refund struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	PaymentID     uint       `gorm:"not null;index" json:"payment_id"`
+	UserID        uint       `gorm:"not null;index" json:"user_id"`
+	Reference     string     `gorm:"size:64;uniqueIndex;not null" json:"reference"` // our refund ID, sent to the provider
+	AmountCents   int64      `gorm:"not null" json:"amount_cents"`
+	Currency      string     `gorm:"size:3;default:'KES'" json:"currency"`
+	Destination   string     `gorm:"size:20;not null" json:"destination"`  // WALLET, SOURCE
+	FundedBy      string     `gorm:"size:20;not null" json:"funded_by"`    // CLIENT_WALLET, PLATFORM, PROVIDER_CLEARING
+	Status        string     `gorm:"size:20;not null;index" json:"status"` // PENDING, PROCESSING, COMPLETED, FAILED
+	Reason        string     `gorm:"size:255" json:"reason"`
+	InitiatedBy   string     `gorm:"size:20;not null" json:"initiated_by"` // client, admin, system
+	ActorID       *uint      `json:"actor_id"`
+	Provider      string     `gorm:"size:50" json:"provider"`
+	ProviderRef   string     `gorm:"size:128" json:"provider_ref"`
+	FailureReason string     `gorm:"size:255" json:"failure_reason"`
+	CompletedAt   *time.Time `json:"completed_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+func (Refund) TableName() string {
+	return "refunds"
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Review is one party's rating of the other after a COMPLETED interaction. Each side leaves at most one,
+// and can edit it until EditableUntil.
+type Review struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	InteractionID  uint      `gorm:"not null;uniqueIndex:idx_reviews_interaction_reviewer" json:"interaction_id"`
+	ReviewerID     uint      `gorm:"not null;uniqueIndex:idx_reviews_interaction_reviewer" json:"reviewer_id"`
+	ReviewerRole   string    `gorm:"size:20;not null" json:"reviewer_role"` // CLIENT rates the companion, COMPANION rates the client
+	RevieweeID     uint      `gorm:"not null;index" json:"reviewee_id"`     // user ID
+	CompanionID    uint      `gorm:"not null;index" json:"companion_id"`    // companion profile ID of the interaction
+	Rating         int       `gorm:"not null" json:"rating"`                // 1-5
+	Comment        string    `gorm:"size:1000" json:"comment"`
+	Status         string    `gorm:"size:20;not null;index" json:"status"` // PUBLISHED, FLAGGED, HIDDEN
+	ModerationNote string    `gorm:"size:255" json:"moderation_note,omitempty"`
+	ModeratedBy    *uint     `json:"moderated_by,omitempty"`
+	EditableUntil  time.Time `json:"editable_until"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+func (Review) TableName() string {
+	return "reviews"
+}
@@ -22,6 +22,9 @@ type User struct {
 	SearchRadiusKm    float64        `gorm:"default:10" json:"search_radius_km"` // Client: max search radius (default 10km)
 	KYC               bool           `gorm:"default:false" json:"kyc"`
 	FCMToken          string         `gorm:"size:512" json:"-"` // For push notifications
+	ClientRatingAvg   float64        `gorm:"default:0" json:"client_rating_avg"` // Client: published companion reviews
+	ClientRatingCount int            `gorm:"default:0" json:"client_rating_count"`
+	ReliabilityScore  *float64       `json:"reliability_score"` // Client: 0-100 from ratings and late cancellations, nil until there is history
 	CreatedAt         time.Time      `json:"created_at"`
 	UpdatedAt         time.Time      `json:"updated_at"`
 	DeletedAt         gorm.DeletedAt `gorm:"index" json:"-"`
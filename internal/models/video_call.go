@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"lusty/internal/domain"
+)
+
+// VideoCall is one call between the client and companion of an interaction, from the invite until it is
+// answered or not. Once accepted the client's wallet is pre-authorized one billing block
+// (domain.VideoBlockSeconds) at a time into escrow; when the call ends the connected time is billed at
+// RatePer5MinCents per started block, the companion is paid her share and the unused authorization returns
+// to the client.
+type VideoCall struct {
+	ID                   uint       `gorm:"primaryKey" json:"id"`
+	InteractionID        uint       `gorm:"not null;index" json:"interaction_id"`
+	ActiveInteractionID  *uint      `gorm:"uniqueIndex" json:"-"` // = InteractionID while RINGING or ACTIVE, so an interaction has one live call
+	CallerID             uint       `gorm:"not null;index" json:"caller_id"`
+	ClientID             uint       `gorm:"not null;index" json:"client_id"`
+	CompanionID          uint       `gorm:"not null;index" json:"companion_id"` // companion profile ID
+	CompanionUserID      uint       `gorm:"not null;index" json:"companion_user_id"`
+	RatePer5MinCents     int64      `gorm:"not null;default:0" json:"rate_per_5min_cents"` // companion's VIDEO_PER_5MIN price; 0 = not billed
+	Status               string     `gorm:"size:20;not null;index" json:"status"`          // RINGING, ACTIVE, ENDED, MISSED, DECLINED, CANCELLED
+	ConnectedSeconds     int64      `gorm:"not null;default:0" json:"connected_seconds"`   // time both sides were heartbeating
+	AuthorizedCents      int64      `gorm:"not null;default:0" json:"authorized_cents"`    // pre-authorized from the client's wallet
+	ChargedCents         int64      `gorm:"not null;default:0" json:"charged_cents"`       // billed at settlement
+	CompanionCents       int64      `gorm:"not null;default:0" json:"companion_cents"`     // companion's share of ChargedCents
+	ClientHeartbeatAt    *time.Time `json:"client_heartbeat_at,omitempty"`
+	CompanionHeartbeatAt *time.Time `json:"companion_heartbeat_at,omitempty"`
+	MeteredAt            *time.Time `json:"-"` // connected time is counted up to here
+	LowFundsWarnedAt     *time.Time `json:"low_funds_warned_at,omitempty"`
+	EndReason            string     `gorm:"size:50" json:"end_reason,omitempty"` // why an ACTIVE call ended: hangup, disconnected, insufficient_funds, timeout
+	StartedAt            time.Time  `json:"started_at"`                          // invite sent
+	AnsweredAt           *time.Time `json:"answered_at,omitempty"`
+	EndedAt              *time.Time `json:"ended_at,omitempty"`
+	CreatedAt            time.Time  `json:"created_at"`
+	UpdatedAt            time.Time  `json:"updated_at"`
+}
+
+func (VideoCall) TableName() string {
+	return "video_calls"
+}
+
+// AuthorizedSeconds is how much connected time the pre-authorized funds cover.
+func (c *VideoCall) AuthorizedSeconds() int64 {
+	if c.RatePer5MinCents <= 0 {
+		return 0
+	}
+	return c.AuthorizedCents / c.RatePer5MinCents * domain.VideoBlockSeconds
+}
@@ -6,12 +6,16 @@ import (
 	"gorm.io/gorm"
 )
 
+// Wallet is the per-user projection of the ledger accounts (see LedgerAccount). Its columns are only
+// changed by WalletRepository.Post, in the same transaction as the postings they mirror.
 type Wallet struct {
 	ID                 uint           `gorm:"primaryKey" json:"id"`
 	UserID             uint           `gorm:"uniqueIndex;not null" json:"user_id"`
-	BalanceCents       int64          `gorm:"not null;default:0" json:"balance_cents"`         // total earnings (for companions: from completed payments)
+	BalanceCents       int64          `gorm:"not null;default:0" json:"balance_cents"`         // spendable balance (refunds, referral commission)
+	PendingCents       int64          `gorm:"not null;default:0" json:"pending_cents"`         // companion: earned on accept, not yet released
 	WithdrawableCents   int64          `gorm:"not null;default:0" json:"withdrawable_cents"`   // companion: amount available to withdraw (after service done)
 	Currency           string         `gorm:"size:3;default:'KES'" json:"currency"`
+	Version            int64          `gorm:"not null;default:0" json:"-"` // bumped on every balance change; guards conditional updates
 	CreatedAt    time.Time      `json:"created_at"`
 	UpdatedAt    time.Time      `json:"updated_at"`
 	DeletedAt    gorm.DeletedAt `gorm:"index" json:"-"`
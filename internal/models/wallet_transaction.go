@@ -7,12 +7,15 @@ import (
 )
 
 // WalletTransaction records credits/debits for wallet history (companion earnings, withdrawals, boost).
+// One row is written per user-owned ledger posting, so history always matches the wallet balances.
 type WalletTransaction struct {
 	ID          uint           `gorm:"primaryKey" json:"id"`
 	UserID      uint           `gorm:"not null;index" json:"user_id"`
 	AmountCents int64          `gorm:"not null" json:"amount_cents"` // positive = credit, negative = debit
 	Type        string         `gorm:"size:30;not null;index" json:"type"` // EARNING, WITHDRAWAL, BOOST_PAYMENT
 	Reference   string         `gorm:"size:128" json:"reference"`        // e.g. interaction_id, withdrawal_id
+	Account     string         `gorm:"size:30;index" json:"account"`     // ledger account type: CLIENT_BALANCE, COMPANION_PENDING, COMPANION_WITHDRAWABLE
+	EntryID     uint           `gorm:"index" json:"entry_id"`            // ledger_entries.id
 	CreatedAt   time.Time      `json:"created_at"`
 	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
 
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// WebhookEvent is a provider callback stored raw before it is processed. (Provider, EventKey) is unique,
+// so a provider redelivering the same event is stored once. A worker processes it with retries; events
+// that keep failing end up DEAD and can be replayed by an admin.
+type WebhookEvent struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	Provider      string     `gorm:"size:30;not null;uniqueIndex:idx_webhook_provider_key" json:"provider"` // mpesa, crypto, withdrawal
+	EventKey      string     `gorm:"size:191;not null;uniqueIndex:idx_webhook_provider_key" json:"event_key"`
+	Payload       string     `gorm:"type:mediumtext" json:"payload"` // raw request body
+	SourceIP      string     `gorm:"size:45" json:"source_ip"`
+	UserAgent     string     `gorm:"size:512" json:"user_agent"`
+	Status        string     `gorm:"size:20;not null;index" json:"status"` // PENDING, PROCESSING, PROCESSED, FAILED, DEAD
+	Attempts      int        `gorm:"not null;default:0" json:"attempts"`
+	LastError     string     `gorm:"type:text" json:"last_error"`
+	NextAttemptAt *time.Time `gorm:"index" json:"next_attempt_at"`
+	LockedUntil   *time.Time `json:"locked_until"`
+	ProcessedAt   *time.Time `json:"processed_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+func (WebhookEvent) TableName() string {
+	return "webhook_events"
+}
@@ -3,6 +3,7 @@ package repository
 import (
 	"time"
 
+	"lusty/internal/domain"
 	"lusty/internal/models"
 
 	"gorm.io/gorm"
@@ -57,9 +58,13 @@ func (r *AdminRepository) GetDashboardStats() (*DashboardStats, error) {
 	r.db.Model(&models.Withdrawal{}).Count(&s.TotalWithdrawals)
 	r.db.Model(&models.InteractionRequest{}).Count(&s.TotalInteractions)
 
+	// Platform profit: PLATFORM_FEE rows recorded before the ledger, plus the PLATFORM_REVENUE ledger account
 	var profit struct{ Total int64 }
 	r.db.Model(&models.WalletTransaction{}).Select("COALESCE(SUM(amount_cents), 0) as total").Where("type = ?", "PLATFORM_FEE").Scan(&profit)
-	s.PlatformProfit = profit.Total
+	var revenue struct{ Total int64 }
+	r.db.Model(&models.LedgerAccount{}).Select("COALESCE(SUM(balance_cents), 0) as total").
+		Where("user_id = 0 AND type = ?", domain.LedgerAccountPlatformRevenue).Scan(&revenue)
+	s.PlatformProfit = profit.Total + revenue.Total
 
 	return &s, nil
 }
@@ -235,3 +240,10 @@ func (r *AdminRepository) InteractionsByDay(days int) ([]TimeSeriesPoint, error)
 func (r *AdminRepository) UpdateUser(id uint, updates map[string]interface{}) error {
 	return r.db.Model(&models.User{}).Where("id = ?", id).Updates(updates).Error
 }
+
+// ListInteractionTransitions returns the status history of an interaction, oldest first.
+func (r *AdminRepository) ListInteractionTransitions(interactionID uint) ([]models.InteractionTransition, error) {
+	var list []models.InteractionTransition
+	err := r.db.Where("interaction_id = ?", interactionID).Order("id ASC").Find(&list).Error
+	return list, err
+}
@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"time"
+
+	"lusty/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// AvailabilityRepository stores companions' weekly availability and the exceptions to it.
+type AvailabilityRepository struct {
+	db *gorm.DB
+}
+
+func NewAvailabilityRepository(db *gorm.DB) *AvailabilityRepository {
+	return &AvailabilityRepository{db: db}
+}
+
+func (r *AvailabilityRepository) ListRules(companionID uint) ([]models.AvailabilityRule, error) {
+	var list []models.AvailabilityRule
+	err := r.db.Where("companion_id = ?", companionID).Order("weekday ASC, start_minute ASC").Find(&list).Error
+	return list, err
+}
+
+// ReplaceRules swaps the companion's whole weekly schedule for rules in one transaction.
+func (r *AvailabilityRepository) ReplaceRules(companionID uint, rules []models.AvailabilityRule) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("companion_id = ?", companionID).Delete(&models.AvailabilityRule{}).Error; err != nil {
+			return err
+		}
+		if len(rules) == 0 {
+			return nil
+		}
+		for i := range rules {
+			rules[i].ID = 0
+			rules[i].CompanionID = companionID
+		}
+		return tx.Create(&rules).Error
+	})
+}
+
+// ListExceptions returns the companion's exceptions overlapping [from, to).
+func (r *AvailabilityRepository) ListExceptions(companionID uint, from, to time.Time) ([]models.AvailabilityException, error) {
+	var list []models.AvailabilityException
+	err := r.db.Where("companion_id = ? AND start_at < ? AND end_at > ?", companionID, to, from).
+		Order("start_at ASC").Find(&list).Error
+	return list, err
+}
+
+func (r *AvailabilityRepository) CreateException(e *models.AvailabilityException) error {
+	return r.db.Create(e).Error
+}
+
+// DeleteException removes one of the companion's exceptions. Returns false if she has no such exception.
+func (r *AvailabilityRepository) DeleteException(id, companionID uint) (bool, error) {
+	res := r.db.Where("id = ? AND companion_id = ?", id, companionID).Delete(&models.AvailabilityException{})
+	return res.RowsAffected > 0, res.Error
+}
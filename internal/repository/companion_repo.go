@@ -1,6 +1,9 @@
 package repository
 
 import (
+	"math"
+
+	"lusty/internal/domain"
 	"lusty/internal/models"
 
 	"gorm.io/gorm"
@@ -88,6 +91,35 @@ func (r *CompanionRepository) GetActiveBoost(companionID uint) (*models.Companio
 	return &b, nil
 }
 
+// GetBoostByPaymentID returns the boost bought with the payment, or nil if none was created yet.
+func (r *CompanionRepository) GetBoostByPaymentID(paymentID uint) (*models.CompanionBoost, error) {
+	var b models.CompanionBoost
+	err := r.db.Where("payment_id = ?", paymentID).Limit(1).Find(&b).Error
+	if err != nil || b.ID == 0 {
+		return nil, err
+	}
+	return &b, nil
+}
+
 func (r *CompanionRepository) CreateBoost(b *models.CompanionBoost) error {
 	return r.db.Create(b).Error
 }
+
+// RefreshRating recomputes the profile's rating from its published client reviews.
+func (r *CompanionRepository) RefreshRating(companionID uint) error {
+	var agg struct {
+		Avg   float64
+		Count int
+	}
+	err := r.db.Table("reviews").
+		Select("COALESCE(AVG(rating), 0) AS avg, COUNT(*) AS count").
+		Where("companion_id = ? AND reviewer_role = ? AND status = ?", companionID, domain.RoleClient, domain.ReviewStatusPublished).
+		Scan(&agg).Error
+	if err != nil {
+		return err
+	}
+	return r.db.Model(&models.CompanionProfile{}).Where("id = ?", companionID).Updates(map[string]interface{}{
+		"rating_avg":   math.Round(agg.Avg*100) / 100,
+		"rating_count": agg.Count,
+	}).Error
+}
@@ -21,8 +21,9 @@ type DiscoveryFilters struct {
 	MinPrice     *int64
 	MaxPrice     *int64
 	OnlineOnly   bool
+	MinRating    *float64 // published client reviews average; unrated companions are excluded
 	BoostedFirst bool
-	SortBy       string // distance, recently_active, boost
+	SortBy       string // distance, recently_active, boost, rating
 	Limit        int
 	Offset       int
 }
@@ -35,7 +36,9 @@ type DiscoveryResult struct {
 	IsOnline         bool
 	LastSeenAt       time.Time
 	IsBoosted        bool
-	IsAvailable      bool // companion's manual "available" toggle
+	IsAvailable      bool // can take an immediate request now, see CompanionAvailableNow
+	RatingAvg        float64
+	RatingCount      int
 }
 
 // DiscoveryRepository performs location-based companion discovery.
@@ -62,7 +65,8 @@ func (r *DiscoveryRepository) DiscoverCompanions(f DiscoveryFilters) ([]Discover
 	query := r.db.Table("companion_profiles cp").
 		Select(`
 			cp.id as companion_id, cp.user_id, cp.display_name, cp.bio, cp.main_profile_image_url,
-			cp.city_or_area, cp.is_active, COALESCE(cp.is_available, 1) as is_available,
+			cp.city_or_area, cp.is_active, `+companionAvailableSQL+` as is_available,
+			COALESCE(cp.rating_avg, 0) as rating_avg, COALESCE(cp.rating_count, 0) as rating_count,
 			u.date_of_birth,
 			ul.latitude, ul.longitude,
 			up.is_online, up.last_seen_at,
@@ -89,6 +93,9 @@ func (r *DiscoveryRepository) DiscoverCompanions(f DiscoveryFilters) ([]Discover
 	if f.OnlineOnly {
 		query = query.Where("up.is_online = ?", true)
 	}
+	if f.MinRating != nil {
+		query = query.Where("cp.rating_count > 0 AND cp.rating_avg >= ?", *f.MinRating)
+	}
 
 	// Subquery to compute distance and filter by Haversine in app (or raw SQL with formula)
 	var rows []struct {
@@ -100,6 +107,8 @@ func (r *DiscoveryRepository) DiscoverCompanions(f DiscoveryFilters) ([]Discover
 		CityOrArea        string
 		IsActive          bool
 		IsAvailable       bool
+		RatingAvg         float64
+		RatingCount       int
 		Latitude          float64
 		Longitude         float64
 		IsOnline          bool
@@ -148,6 +157,8 @@ func (r *DiscoveryRepository) DiscoverCompanions(f DiscoveryFilters) ([]Discover
 				CityOrArea:          row.CityOrArea,
 				IsActive:            row.IsActive,
 				IsAvailable:          row.IsAvailable,
+				RatingAvg:            row.RatingAvg,
+				RatingCount:          row.RatingCount,
 			},
 			User:        models.User{ID: row.UserID, DateOfBirth: row.DateOfBirth},
 			DistanceKm:  distKm,
@@ -156,6 +167,8 @@ func (r *DiscoveryRepository) DiscoverCompanions(f DiscoveryFilters) ([]Discover
 			LastSeenAt:  lastSeen,
 			IsBoosted:   row.BoostID != nil,
 			IsAvailable: row.IsAvailable,
+			RatingAvg:   row.RatingAvg,
+			RatingCount: row.RatingCount,
 		})
 	}
 
@@ -181,6 +194,14 @@ func sortDiscoveryResults(r []DiscoveryResult, sortBy string, boostedFirst bool)
 		switch sortBy {
 		case "recently_active":
 			return r[i].LastSeenAt.After(r[j].LastSeenAt)
+		case "rating":
+			if r[i].RatingAvg != r[j].RatingAvg {
+				return r[i].RatingAvg > r[j].RatingAvg
+			}
+			if r[i].RatingCount != r[j].RatingCount {
+				return r[i].RatingCount > r[j].RatingCount
+			}
+			return r[i].DistanceKm < r[j].DistanceKm
 		case "distance":
 			return r[i].DistanceKm < r[j].DistanceKm
 		default:
@@ -199,7 +220,8 @@ func (r *DiscoveryRepository) DiscoverCompanionsFallback(f DiscoveryFilters) ([]
 	query := r.db.Table("companion_profiles cp").
 		Select(`
 			cp.id as companion_id, cp.user_id, cp.display_name, cp.bio, cp.main_profile_image_url,
-			cp.city_or_area, cp.is_active, COALESCE(cp.is_available, 1) as is_available,
+			cp.city_or_area, cp.is_active, `+companionAvailableSQL+` as is_available,
+			COALESCE(cp.rating_avg, 0) as rating_avg, COALESCE(cp.rating_count, 0) as rating_count,
 			u.date_of_birth,
 			0.0 as latitude, 0.0 as longitude,
 			up.is_online, up.last_seen_at,
@@ -219,6 +241,9 @@ func (r *DiscoveryRepository) DiscoverCompanionsFallback(f DiscoveryFilters) ([]
 			query = query.Where("CONCAT(',', COALESCE(cp.interests,''), ',') LIKE ?", "%,"+svc+",%")
 		}
 	}
+	if f.MinRating != nil {
+		query = query.Where("cp.rating_count > 0 AND cp.rating_avg >= ?", *f.MinRating)
+	}
 
 	var rows []struct {
 		CompanionID       uint
@@ -229,6 +254,8 @@ func (r *DiscoveryRepository) DiscoverCompanionsFallback(f DiscoveryFilters) ([]
 		CityOrArea        string
 		IsActive          bool
 		IsAvailable       bool
+		RatingAvg         float64
+		RatingCount       int
 		Latitude          float64
 		Longitude         float64
 		IsOnline          bool
@@ -272,6 +299,8 @@ func (r *DiscoveryRepository) DiscoverCompanionsFallback(f DiscoveryFilters) ([]
 				CityOrArea:         row.CityOrArea,
 				IsActive:           row.IsActive,
 				IsAvailable:        row.IsAvailable,
+				RatingAvg:          row.RatingAvg,
+				RatingCount:        row.RatingCount,
 			},
 			User:        models.User{ID: row.UserID, DateOfBirth: row.DateOfBirth},
 			DistanceKm:  -1, // Unknown - companion has no location
@@ -280,6 +309,8 @@ func (r *DiscoveryRepository) DiscoverCompanionsFallback(f DiscoveryFilters) ([]
 			LastSeenAt:  lastSeen,
 			IsBoosted:   row.BoostID != nil,
 			IsAvailable: row.IsAvailable,
+			RatingAvg:   row.RatingAvg,
+			RatingCount: row.RatingCount,
 		})
 	}
 
@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"lusty/internal/domain"
+	"lusty/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrDisputeResolved is returned when resolving a dispute that is no longer OPEN.
+var ErrDisputeResolved = errors.New("dispute is already resolved")
+
+type DisputeRepository struct {
+	db *gorm.DB
+}
+
+func NewDisputeRepository(db *gorm.DB) *DisputeRepository {
+	return &DisputeRepository{db: db}
+}
+
+// Create stores the dispute together with any evidence attached to it.
+func (r *DisputeRepository) Create(d *models.Dispute) error {
+	return r.db.Create(d).Error
+}
+
+// Open stores the dispute with its evidence and moves the interaction to DISPUTED (t) in one transaction, so an
+// interaction is never frozen without a dispute in the admin queue. The interaction's escrow hold is locked and
+// must still be HELD: ErrEscrowNoPayment without one, ErrEscrowSettled once it was paid out.
+func (r *DisputeRepository) Open(d *models.Dispute, ir *models.InteractionRequest, t *models.InteractionTransition) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var hold models.EscrowHold
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("interaction_id = ?", ir.ID).Limit(1).Find(&hold).Error; err != nil {
+			return err
+		}
+		if hold.ID == 0 {
+			return ErrEscrowNoPayment
+		}
+		if hold.Status != domain.EscrowStatusHeld {
+			return ErrEscrowSettled
+		}
+		if err := applyTransition(tx, ir, nil, t); err != nil {
+			return err
+		}
+		return tx.Create(d).Error
+	})
+	if err != nil {
+		return err
+	}
+	ir.Status = t.ToStatus
+	return nil
+}
+
+func (r *DisputeRepository) GetByID(id uint) (*models.Dispute, error) {
+	var d models.Dispute
+	err := r.db.Preload("Evidence").First(&d, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (r *DisputeRepository) GetByInteractionID(interactionID uint) (*models.Dispute, error) {
+	var d models.Dispute
+	err := r.db.Preload("Evidence").Where("interaction_id = ?", interactionID).First(&d).Error
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (r *DisputeRepository) AddEvidence(e *models.DisputeEvidence) error {
+	return r.db.Create(e).Error
+}
+
+// Resolve records an admin's resolution (d.Resolution, d.ResolutionNote, d.ResolvedBy) of an OPEN dispute,
+// moves its interaction (t, with fields) and settles the escrow (step) in one transaction: either all of it
+// happens or none of it does. The amounts recorded on the dispute are the ones the escrow actually paid.
+// Returns ErrDisputeResolved if the dispute was no longer open.
+func (r *DisputeRepository) Resolve(d *models.Dispute, ir *models.InteractionRequest, fields map[string]interface{}, t *models.InteractionTransition, step EscrowStep) (*models.EscrowHold, error) {
+	now := time.Now()
+	var hold *models.EscrowHold
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := applyTransition(tx, ir, fields, t); err != nil {
+			return err
+		}
+		var err error
+		if hold, err = runEscrowStep(tx, step); err != nil {
+			return err
+		}
+		var clientCents, companionCents int64
+		if hold != nil {
+			clientCents, companionCents = hold.RefundedCents, hold.ReleasedCents
+		}
+		res := tx.Model(&models.Dispute{}).Where("id = ? AND status = ?", d.ID, domain.DisputeStatusOpen).Updates(map[string]interface{}{
+			"status":          domain.DisputeStatusResolved,
+			"resolution":      d.Resolution,
+			"client_cents":    clientCents,
+			"companion_cents": companionCents,
+			"resolution_note": d.ResolutionNote,
+			"resolved_by":     d.ResolvedBy,
+			"resolved_at":     now,
+			"updated_at":      now,
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrDisputeResolved
+		}
+		d.ClientCents, d.CompanionCents = clientCents, companionCents
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ir.Status = t.ToStatus
+	d.Status, d.ResolvedAt, d.UpdatedAt = domain.DisputeStatusResolved, &now, now
+	return hold, nil
+}
+
+// List returns disputes for the admin queue, optionally filtered by status. Oldest first, so the queue is
+// worked in order.
+func (r *DisputeRepository) List(status string, page, limit int) ([]models.Dispute, int64, error) {
+	q := r.db.Model(&models.Dispute{})
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	var total int64
+	q.Count(&total)
+	var list []models.Dispute
+	err := q.Order("id ASC").Limit(limit).Offset((page - 1) * limit).Find(&list).Error
+	return list, total, err
+}
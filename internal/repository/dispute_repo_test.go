@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+
+	"lusty/internal/database/databasetest"
+	"lusty/internal/domain"
+	"lusty/internal/models"
+)
+
+func newDispute(ir *models.InteractionRequest) *models.Dispute {
+	return &models.Dispute{InteractionID: ir.ID, ClientID: ir.ClientID, CompanionID: ir.CompanionID, OpenedBy: ir.ClientID,
+		OpenedByRole: domain.RoleClient, Reason: "NO_SHOW", Status: domain.DisputeStatusOpen}
+}
+
+// TestDisputeOpenNeedsHeldEscrow checks a dispute is not stored, nor the interaction frozen, without money in escrow.
+func TestDisputeOpenNeedsHeldEscrow(t *testing.T) {
+	db := databasetest.New(t)
+	disputes := NewDisputeRepository(db)
+	ir := seedPaidInteraction(t, db, domain.RequestStatusAccepted, 120_000)
+
+	err := disputes.Open(newDispute(ir), ir, transitionTo(ir, domain.RequestStatusDisputed))
+	if !errors.Is(err, ErrEscrowNoPayment) {
+		t.Fatalf("open without hold: err = %v, want ErrEscrowNoPayment", err)
+	}
+	if d, _ := disputes.GetByInteractionID(ir.ID); d != nil {
+		t.Fatalf("dispute stored without escrow: %+v", d)
+	}
+	if stored, _ := NewInteractionRepository(db).GetByID(ir.ID); stored.Status != domain.RequestStatusAccepted {
+		t.Fatalf("status = %s, want ACCEPTED", stored.Status)
+	}
+
+	if _, err := NewEscrowRepository(db).Hold(ir); err != nil {
+		t.Fatalf("hold: %v", err)
+	}
+	if err := disputes.Open(newDispute(ir), ir, transitionTo(ir, domain.RequestStatusDisputed)); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if ir.Status != domain.RequestStatusDisputed {
+		t.Fatalf("status = %s, want DISPUTED", ir.Status)
+	}
+}
+
+// TestDisputeResolveIsAtomic checks a resolution whose settlement fails leaves the dispute open and the
+// interaction disputed, and that a retry settles all three together.
+func TestDisputeResolveIsAtomic(t *testing.T) {
+	db := databasetest.New(t)
+	disputes, escrow := NewDisputeRepository(db), NewEscrowRepository(db)
+	ir := seedPaidInteraction(t, db, domain.RequestStatusAccepted, 120_000)
+	if _, err := escrow.Hold(ir); err != nil {
+		t.Fatalf("hold: %v", err)
+	}
+	d := newDispute(ir)
+	if err := disputes.Open(d, ir, transitionTo(ir, domain.RequestStatusDisputed)); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	adminID := uint(9)
+	d.Resolution, d.ResolvedBy = domain.DisputeResolutionSplit, &adminID
+
+	_, err := disputes.Resolve(d, ir, nil, transitionTo(ir, domain.RequestStatusCompleted), escrow.SplitStep(ir, 100_000, 100_000, "", &adminID))
+	if !errors.Is(err, ErrEscrowBadSplit) {
+		t.Fatalf("oversized split: err = %v, want ErrEscrowBadSplit", err)
+	}
+	stored, _ := disputes.GetByID(d.ID)
+	if stored.Status != domain.DisputeStatusOpen {
+		t.Fatalf("dispute status = %s after failed settlement, want OPEN", stored.Status)
+	}
+	if ir, _ := NewInteractionRepository(db).GetByID(ir.ID); ir.Status != domain.RequestStatusDisputed {
+		t.Fatalf("interaction status = %s after failed settlement, want DISPUTED", ir.Status)
+	}
+
+	hold, err := disputes.Resolve(d, ir, nil, transitionTo(ir, domain.RequestStatusCompleted), escrow.SplitStep(ir, 60_050, 40_000, "", &adminID))
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if hold.Status != domain.EscrowStatusSplit || hold.RefundedCents != 60_050 || hold.ReleasedCents != 40_000 {
+		t.Fatalf("hold = %+v, want SPLIT 60050/40000", hold)
+	}
+	stored, _ = disputes.GetByID(d.ID)
+	if stored.Status != domain.DisputeStatusResolved || stored.ClientCents != 60_050 || stored.CompanionCents != 40_000 {
+		t.Fatalf("dispute = %+v, want RESOLVED 60050/40000", stored)
+	}
+	if _, err := disputes.Resolve(d, ir, nil, transitionTo(ir, domain.RequestStatusCompleted), escrow.ReleaseStep(ir, &adminID)); err == nil {
+		t.Fatalf("second resolution succeeded")
+	}
+}
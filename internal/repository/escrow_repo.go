@@ -0,0 +1,246 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"lusty/internal/domain"
+	"lusty/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrEscrowSettled   = errors.New("escrow hold already settled")
+	ErrEscrowNoPayment = errors.New("interaction has no completed payment")
+	ErrEscrowBadSplit  = errors.New("split exceeds held amount")
+)
+
+// EscrowRepository moves interaction payments in and out of escrow. Every operation locks the hold,
+// posts the ledger entry and writes an audit log row in one transaction. Repeating an operation that
+// already happened is a no-op, so webhook retries and double taps never move money twice.
+type EscrowRepository struct {
+	db *gorm.DB
+}
+
+func NewEscrowRepository(db *gorm.DB) *EscrowRepository {
+	return &EscrowRepository{db: db}
+}
+
+func (r *EscrowRepository) GetByInteractionID(interactionID uint) (*models.EscrowHold, error) {
+	var h models.EscrowHold
+	err := r.db.Where("interaction_id = ?", interactionID).First(&h).Error
+	if err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// PendingPayoutByCompanionID returns what the companion (profile ID) will be paid once every HELD hold is released.
+func (r *EscrowRepository) PendingPayoutByCompanionID(companionID uint) (int64, error) {
+	var holds []models.EscrowHold
+	err := r.db.Select("amount_cents", "extension_cents").Where("companion_id = ? AND status = ?", companionID, domain.EscrowStatusHeld).
+		Find(&holds).Error
+	var total int64
+	for i := range holds {
+		total += domain.CompanionPayout(holds[i].CompanionBaseCents())
+	}
+	return total, err
+}
+
+// EscrowStep is an escrow movement run inside the transaction of another write, such as an interaction being
+// created or changing status, so the two commit or roll back together. It returns ErrEscrowNoPayment if the
+// interaction has no completed payment.
+type EscrowStep func(tx *gorm.DB) (*models.EscrowHold, error)
+
+// Hold moves the interaction's completed payment from provider clearing into escrow.
+func (r *EscrowRepository) Hold(ir *models.InteractionRequest) (*models.EscrowHold, error) {
+	return r.run(r.HoldStep(ir))
+}
+
+// Release pays the companion on service done: 95% of the base price to withdrawable, the rest to the platform.
+func (r *EscrowRepository) Release(ir *models.InteractionRequest, actorID *uint) (*models.EscrowHold, error) {
+	return r.run(r.ReleaseStep(ir, actorID))
+}
+
+// Refund returns the full held amount to the client's wallet (reject, expiry, cancellation).
+func (r *EscrowRepository) Refund(ir *models.InteractionRequest, reason string, actorID *uint) (*models.EscrowHold, error) {
+	return r.run(r.RefundStep(ir, reason, actorID))
+}
+
+// Split refunds clientCents to the client and releases companionCents to the companion; the remainder
+// stays with the platform.
+func (r *EscrowRepository) Split(ir *models.InteractionRequest, clientCents, companionCents int64, reason string, actorID *uint) (*models.EscrowHold, error) {
+	return r.run(r.SplitStep(ir, clientCents, companionCents, reason, actorID))
+}
+
+// HoldStep is Hold as an EscrowStep.
+func (r *EscrowRepository) HoldStep(ir *models.InteractionRequest) EscrowStep {
+	return func(tx *gorm.DB) (*models.EscrowHold, error) {
+		return lockOrCreateHold(tx, ir)
+	}
+}
+
+// ReleaseStep is Release as an EscrowStep.
+func (r *EscrowRepository) ReleaseStep(ir *models.InteractionRequest, actorID *uint) EscrowStep {
+	return func(tx *gorm.DB) (*models.EscrowHold, error) {
+		return settle(tx, ir, domain.EscrowStatusReleased, actorID, "", func(h *models.EscrowHold) (int64, int64, error) {
+			return 0, domain.CompanionPayout(h.CompanionBaseCents()), nil
+		})
+	}
+}
+
+// RefundStep is Refund as an EscrowStep.
+func (r *EscrowRepository) RefundStep(ir *models.InteractionRequest, reason string, actorID *uint) EscrowStep {
+	return func(tx *gorm.DB) (*models.EscrowHold, error) {
+		return settle(tx, ir, domain.EscrowStatusRefunded, actorID, reason, func(h *models.EscrowHold) (int64, int64, error) {
+			return h.AmountCents, 0, nil
+		})
+	}
+}
+
+// SplitStep is Split as an EscrowStep.
+func (r *EscrowRepository) SplitStep(ir *models.InteractionRequest, clientCents, companionCents int64, reason string, actorID *uint) EscrowStep {
+	return func(tx *gorm.DB) (*models.EscrowHold, error) {
+		return settle(tx, ir, domain.EscrowStatusSplit, actorID, reason, func(h *models.EscrowHold) (int64, int64, error) {
+			if clientCents < 0 || companionCents < 0 || clientCents+companionCents > h.AmountCents {
+				return 0, 0, ErrEscrowBadSplit
+			}
+			return clientCents, companionCents, nil
+		})
+	}
+}
+
+// run applies step in a transaction of its own.
+func (r *EscrowRepository) run(step EscrowStep) (*models.EscrowHold, error) {
+	var hold *models.EscrowHold
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		hold, err = step(tx)
+		return err
+	})
+	return hold, err
+}
+
+// settle empties a HELD hold inside tx. amounts returns (to client, to companion); the platform keeps the rest.
+func settle(tx *gorm.DB, ir *models.InteractionRequest, status string, actorID *uint, reason string,
+	amounts func(h *models.EscrowHold) (int64, int64, error)) (*models.EscrowHold, error) {
+	hold, err := lockOrCreateHold(tx, ir)
+	if err != nil {
+		return nil, err
+	}
+	if hold.Status == status {
+		return hold, nil
+	}
+	if hold.Status != domain.EscrowStatusHeld {
+		return hold, ErrEscrowSettled
+	}
+	clientCents, companionCents, err := amounts(hold)
+	if err != nil {
+		return hold, err
+	}
+	feeCents := hold.AmountCents - clientCents - companionCents
+	legs := []LedgerLeg{
+		{Account: domain.LedgerAccountEscrow, AmountCents: -hold.AmountCents},
+		{Account: domain.LedgerAccountClientBalance, UserID: hold.ClientID, AmountCents: clientCents},
+		{Account: domain.LedgerAccountCompanionWithdrawable, UserID: hold.CompanionUserID, AmountCents: companionCents},
+		{Account: domain.LedgerAccountPlatformRevenue, AmountCents: feeCents},
+	}
+	entryType := domain.WalletTxTypeEscrowSplit
+	switch status {
+	case domain.EscrowStatusReleased:
+		entryType = domain.WalletTxTypeEarning
+	case domain.EscrowStatusRefunded:
+		entryType = domain.WalletTxTypeRefund
+	}
+	entryID, err := postEntry(tx, entryType, fmt.Sprintf("interaction_%d", hold.InteractionID), legs, true)
+	if err != nil {
+		return hold, err
+	}
+	now := time.Now()
+	hold.Status = status
+	hold.RefundedCents = clientCents
+	hold.ReleasedCents = companionCents
+	hold.FeeCents = feeCents
+	hold.Reason = reason
+	hold.SettleEntryID = entryID
+	hold.SettledAt = &now
+	if err := tx.Save(hold).Error; err != nil {
+		return hold, err
+	}
+	return hold, auditEscrow(tx, hold, "escrow_"+strings.ToLower(status), actorID)
+}
+
+// lockOrCreateHold returns the interaction's hold locked FOR UPDATE. If none exists yet (payment completed
+// before escrow was introduced, or the hold step was missed) it is created from the completed payment.
+func lockOrCreateHold(tx *gorm.DB, ir *models.InteractionRequest) (*models.EscrowHold, error) {
+	var h models.EscrowHold
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("interaction_id = ?", ir.ID).Limit(1).Find(&h).Error
+	if err != nil {
+		return nil, err
+	}
+	if h.ID != 0 {
+		return &h, nil
+	}
+	if ir.PaymentID == nil {
+		return nil, ErrEscrowNoPayment
+	}
+	var pay models.Payment
+	if err := tx.First(&pay, *ir.PaymentID).Error; err != nil {
+		return nil, err
+	}
+	if pay.Status != "COMPLETED" {
+		return nil, ErrEscrowNoPayment
+	}
+	var comp models.CompanionProfile
+	if err := tx.Select("id", "user_id").First(&comp, ir.CompanionID).Error; err != nil {
+		return nil, err
+	}
+	h = models.EscrowHold{
+		InteractionID:   ir.ID,
+		PaymentID:       pay.ID,
+		ClientID:        ir.ClientID,
+		CompanionID:     comp.ID,
+		CompanionUserID: comp.UserID,
+		AmountCents:     pay.AmountCents,
+		Status:          domain.EscrowStatusHeld,
+	}
+	entryID, err := postEntry(tx, domain.WalletTxTypeEscrowHold, fmt.Sprintf("interaction_%d", ir.ID), []LedgerLeg{
+		{Account: domain.LedgerAccountProviderClearing, AmountCents: -pay.AmountCents},
+		{Account: domain.LedgerAccountEscrow, AmountCents: pay.AmountCents},
+	}, true)
+	if err != nil {
+		return nil, err
+	}
+	h.HoldEntryID = entryID
+	if err := tx.Create(&h).Error; err != nil {
+		return nil, err
+	}
+	if err := auditEscrow(tx, &h, "escrow_held", nil); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+func auditEscrow(tx *gorm.DB, h *models.EscrowHold, action string, actorID *uint) error {
+	meta, _ := json.Marshal(map[string]interface{}{
+		"interaction_id": h.InteractionID,
+		"amount_cents":   h.AmountCents,
+		"refunded_cents": h.RefundedCents,
+		"released_cents": h.ReleasedCents,
+		"fee_cents":      h.FeeCents,
+		"reason":         h.Reason,
+	})
+	return tx.Create(&models.AuditLog{
+		UserID:     actorID,
+		Action:     action,
+		Resource:   "escrow_hold",
+		ResourceID: strconv.FormatUint(uint64(h.ID), 10),
+		Metadata:   string(meta),
+	}).Error
+}
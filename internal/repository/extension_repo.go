@@ -0,0 +1,168 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"lusty/internal/domain"
+	"lusty/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrExtensionSettled is returned when an extension has already been applied, refunded or failed.
+var ErrExtensionSettled = errors.New("session extension already settled")
+
+// ExtensionRepository stores session extensions and applies paid ones to the interaction's escrow hold and
+// chat session.
+type ExtensionRepository struct {
+	db *gorm.DB
+}
+
+func NewExtensionRepository(db *gorm.DB) *ExtensionRepository {
+	return &ExtensionRepository{db: db}
+}
+
+func (r *ExtensionRepository) Create(ext *models.SessionExtension) error {
+	return r.db.Create(ext).Error
+}
+
+func (r *ExtensionRepository) GetByPaymentID(paymentID uint) (*models.SessionExtension, error) {
+	var ext models.SessionExtension
+	err := r.db.Where("payment_id = ?", paymentID).Limit(1).Find(&ext).Error
+	if err != nil || ext.ID == 0 {
+		return nil, err
+	}
+	return &ext, nil
+}
+
+// ListByInteractionID returns the interaction's extensions, oldest first.
+func (r *ExtensionRepository) ListByInteractionID(interactionID uint) ([]models.SessionExtension, error) {
+	var list []models.SessionExtension
+	err := r.db.Where("interaction_id = ?", interactionID).Order("id ASC").Find(&list).Error
+	return list, err
+}
+
+// MarkFailed moves a PENDING extension to FAILED. Returns false if it was no longer pending.
+func (r *ExtensionRepository) MarkFailed(id uint) (bool, error) {
+	res := r.db.Model(&models.SessionExtension{}).Where("id = ? AND status = ?", id, domain.ExtensionStatusPending).
+		Update("status", domain.ExtensionStatusFailed)
+	return res.RowsAffected > 0, res.Error
+}
+
+// Apply settles a paid PENDING extension in one transaction: its amount moves from provider clearing into
+// the interaction's escrow hold, and the session's EndsAt moves forward by its minutes (from now, if the
+// session had already run out). Returns ErrEscrowSettled if the hold was already paid out and
+// ErrExtensionSettled if the extension is no longer pending.
+func (r *ExtensionRepository) Apply(ext *models.SessionExtension, ir *models.InteractionRequest) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		locked, err := lockExtension(tx, ext.ID)
+		if err != nil {
+			return err
+		}
+		if err := applyExtension(tx, locked, ir); err != nil {
+			return err
+		}
+		*ext = *locked
+		return nil
+	})
+}
+
+// ApplyFromWallet buys ext with the client's wallet in one transaction: pay (a COMPLETED wallet payment) and
+// ext are created, the amount moves from the client's balance to provider clearing and the extension is
+// applied as by Apply. If any step fails nothing is charged: ErrInsufficientBalance when the balance is
+// short, ErrEscrowSettled when the hold was already paid out.
+func (r *ExtensionRepository) ApplyFromWallet(ext *models.SessionExtension, pay *models.Payment, ir *models.InteractionRequest) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(pay).Error; err != nil {
+			return err
+		}
+		if _, err := postEntry(tx, domain.WalletTxTypePayment, pay.ProviderRef, []LedgerLeg{
+			{Account: domain.LedgerAccountClientBalance, UserID: pay.UserID, AmountCents: -pay.AmountCents},
+			{Account: domain.LedgerAccountProviderClearing, AmountCents: pay.AmountCents},
+		}, true); err != nil {
+			return err
+		}
+		ext.PaymentID = pay.ID
+		if err := tx.Create(ext).Error; err != nil {
+			return err
+		}
+		return applyExtension(tx, ext, ir)
+	})
+}
+
+// Refund returns a paid PENDING extension to the client's wallet, for when it can no longer be applied.
+func (r *ExtensionRepository) Refund(ext *models.SessionExtension) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		locked, err := lockExtension(tx, ext.ID)
+		if err != nil {
+			return err
+		}
+		if _, err := postEntry(tx, domain.WalletTxTypeRefund, fmt.Sprintf("extension_%d", locked.ID), []LedgerLeg{
+			{Account: domain.LedgerAccountProviderClearing, AmountCents: -locked.AmountCents},
+			{Account: domain.LedgerAccountClientBalance, UserID: locked.ClientID, AmountCents: locked.AmountCents},
+		}, true); err != nil {
+			return err
+		}
+		locked.Status = domain.ExtensionStatusRefunded
+		if err := tx.Save(locked).Error; err != nil {
+			return err
+		}
+		*ext = *locked
+		return nil
+	})
+}
+
+// applyExtension moves the PENDING extension ext into the interaction's escrow hold and the session end
+// forward, inside tx, and marks ext APPLIED.
+func applyExtension(tx *gorm.DB, ext *models.SessionExtension, ir *models.InteractionRequest) error {
+	hold, err := lockOrCreateHold(tx, ir)
+	if err != nil {
+		return err
+	}
+	if hold.Status != domain.EscrowStatusHeld {
+		return ErrEscrowSettled
+	}
+	var session models.ChatSession
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("interaction_id = ?", ir.ID).First(&session).Error; err != nil {
+		return err
+	}
+	if _, err := postEntry(tx, domain.WalletTxTypeEscrowTopUp, fmt.Sprintf("extension_%d", ext.ID), []LedgerLeg{
+		{Account: domain.LedgerAccountProviderClearing, AmountCents: -ext.AmountCents},
+		{Account: domain.LedgerAccountEscrow, AmountCents: ext.AmountCents},
+	}, true); err != nil {
+		return err
+	}
+	hold.AmountCents += ext.AmountCents
+	hold.ExtensionCents += ext.AmountCents
+	if err := tx.Save(hold).Error; err != nil {
+		return err
+	}
+	if err := auditEscrow(tx, hold, "escrow_extended", &ext.ClientID); err != nil {
+		return err
+	}
+	now := time.Now()
+	endsAt := session.EndsAt
+	if endsAt.Before(now) {
+		endsAt = now
+	}
+	endsAt = endsAt.Add(time.Duration(ext.Minutes) * time.Minute)
+	if err := tx.Model(&session).Update("ends_at", endsAt).Error; err != nil {
+		return err
+	}
+	ext.Status, ext.EndsAt, ext.AppliedAt = domain.ExtensionStatusApplied, &endsAt, &now
+	return tx.Save(ext).Error
+}
+
+func lockExtension(tx *gorm.DB, id uint) (*models.SessionExtension, error) {
+	var ext models.SessionExtension
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&ext, id).Error; err != nil {
+		return nil, err
+	}
+	if ext.Status != domain.ExtensionStatusPending {
+		return nil, ErrExtensionSettled
+	}
+	return &ext, nil
+}
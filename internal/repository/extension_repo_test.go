@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"lusty/internal/database/databasetest"
+	"lusty/internal/domain"
+	"lusty/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// seedWalletExtension returns an accepted, held interaction with a session ending in an hour, a client wallet
+// holding balanceCents, and a wallet payment and extension of 5000 cents ready for ApplyFromWallet.
+func seedWalletExtension(t *testing.T, db *gorm.DB, balanceCents int64) (*models.InteractionRequest, *models.SessionExtension, *models.Payment, *models.ChatSession) {
+	t.Helper()
+	ir := seedPaidInteraction(t, db, domain.RequestStatusAccepted, 120_000)
+	if _, err := NewEscrowRepository(db).Hold(ir); err != nil {
+		t.Fatalf("hold: %v", err)
+	}
+	session := &models.ChatSession{InteractionID: ir.ID, StartedAt: time.Now(), EndsAt: time.Now().Add(time.Hour)}
+	if err := db.Create(session).Error; err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	if balanceCents > 0 {
+		if err := NewWalletRepository(db).Credit(ir.ClientID, balanceCents, domain.LedgerAccountProviderClearing, domain.WalletTxTypeRefund, "seed"); err != nil {
+			t.Fatalf("fund wallet: %v", err)
+		}
+	}
+	pay := &models.Payment{UserID: ir.ClientID, AmountCents: 5000, Currency: "KES", Provider: "wallet", ProviderRef: "xw_1",
+		Status: domain.PaymentStatusCompleted, IdempotencyKey: "xw_1"}
+	ext := &models.SessionExtension{InteractionID: ir.ID, ClientID: ir.ClientID, Minutes: 30, AmountCents: 5000,
+		Status: domain.ExtensionStatusPending}
+	return ir, ext, pay, session
+}
+
+func clientBalance(t *testing.T, db *gorm.DB, userID uint) int64 {
+	t.Helper()
+	w, err := NewWalletRepository(db).GetByUserID(userID)
+	if err != nil {
+		t.Fatalf("wallet: %v", err)
+	}
+	return w.BalanceCents
+}
+
+// TestApplyFromWallet checks a wallet-funded extension charges the client, tops up escrow and moves the
+// session end together.
+func TestApplyFromWallet(t *testing.T) {
+	db := databasetest.New(t)
+	extensions := NewExtensionRepository(db)
+	ir, ext, pay, session := seedWalletExtension(t, db, 8000)
+
+	if err := extensions.ApplyFromWallet(ext, pay, ir); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if ext.Status != domain.ExtensionStatusApplied || ext.PaymentID != pay.ID {
+		t.Fatalf("extension = %+v, want APPLIED for payment %d", ext, pay.ID)
+	}
+	if got := clientBalance(t, db, ir.ClientID); got != 3000 {
+		t.Fatalf("client balance = %d, want 3000", got)
+	}
+	hold, _ := NewEscrowRepository(db).GetByInteractionID(ir.ID)
+	if hold.AmountCents != 125_000 || hold.ExtensionCents != 5000 {
+		t.Fatalf("hold = %+v, want 125000 with 5000 from extensions", hold)
+	}
+	stored, _ := NewInteractionRepository(db).GetChatSessionByInteractionID(ir.ID)
+	if want := session.EndsAt.Add(30 * time.Minute); !stored.EndsAt.Equal(want) {
+		t.Fatalf("ends_at = %v, want %v", stored.EndsAt, want)
+	}
+}
+
+// TestApplyFromWalletChargesNothingOnFailure checks that when the extension cannot be applied no money leaves
+// the wallet and no payment or extension is left behind for anyone to settle.
+func TestApplyFromWalletChargesNothingOnFailure(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		balance int64
+		release bool
+		want    error
+	}{
+		{name: "insufficient balance", balance: 1000, want: ErrInsufficientBalance},
+		{name: "escrow already released", balance: 8000, release: true, want: ErrEscrowSettled},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db := databasetest.New(t)
+			ir, ext, pay, _ := seedWalletExtension(t, db, tc.balance)
+			if tc.release {
+				if _, err := NewEscrowRepository(db).Release(ir, nil); err != nil {
+					t.Fatalf("release: %v", err)
+				}
+			}
+
+			err := NewExtensionRepository(db).ApplyFromWallet(ext, pay, ir)
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("apply: err = %v, want %v", err, tc.want)
+			}
+			if got := clientBalance(t, db, ir.ClientID); got != tc.balance {
+				t.Fatalf("client balance = %d, want %d", got, tc.balance)
+			}
+			var payments, exts int64
+			db.Model(&models.Payment{}).Where("provider = ?", "wallet").Count(&payments)
+			db.Model(&models.SessionExtension{}).Count(&exts)
+			if payments != 0 || exts != 0 {
+				t.Fatalf("left %d wallet payments and %d extensions, want none", payments, exts)
+			}
+		})
+	}
+}
@@ -2,13 +2,33 @@ package repository
 
 import (
 	"encoding/json"
+	"errors"
 	"time"
 
+	"lusty/internal/domain"
 	"lusty/internal/models"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// ErrStatusChanged is returned by Transition when the request's status changed since it was read.
+var ErrStatusChanged = errors.New("interaction status changed concurrently")
+
+// ErrSlotTaken is returned when a booking overlaps another booking of the same companion.
+var ErrSlotTaken = errors.New("time slot is already booked")
+
+// ErrNoChatCapacity is returned by AcceptWithSession when the companion already runs as many unscheduled
+// sessions as her capacity allows.
+var ErrNoChatCapacity = errors.New("companion has no chat capacity left")
+
+// slotHoldingStatuses are the statuses in which a booking keeps its slot. Rejecting, expiring or cancelling a
+// booking releases the slot.
+var slotHoldingStatuses = []string{
+	domain.RequestStatusPending, domain.RequestStatusPendingKYC, domain.RequestStatusAccepted,
+	domain.RequestStatusDisputed, domain.RequestStatusCompleted,
+}
+
 type InteractionRepository struct {
 	db *gorm.DB
 }
@@ -21,6 +41,177 @@ func (r *InteractionRepository) Create(req *models.InteractionRequest) error {
 	return r.db.Create(req).Error
 }
 
+// CreateWithTransition inserts the request and its initial history row in one transaction. A non-nil hold
+// (the payment moving into escrow) runs in the same transaction.
+func (r *InteractionRepository) CreateWithTransition(req *models.InteractionRequest, t *models.InteractionTransition, hold EscrowStep) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(req).Error; err != nil {
+			return err
+		}
+		t.InteractionID = req.ID
+		if err := tx.Create(t).Error; err != nil {
+			return err
+		}
+		_, err := runEscrowStep(tx, hold)
+		return err
+	})
+}
+
+// CreateBookingWithTransition is CreateWithTransition for a request with a slot. It fails with ErrSlotTaken
+// if the slot, widened by buffer on both sides, overlaps another booking of the companion. Her profile row
+// is locked for the check so two clients cannot book the same slot at once.
+func (r *InteractionRepository) CreateBookingWithTransition(req *models.InteractionRequest, t *models.InteractionTransition, buffer time.Duration, hold EscrowStep) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var comp models.CompanionProfile
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&comp, req.CompanionID).Error; err != nil {
+			return err
+		}
+		var n int64
+		err := tx.Model(&models.InteractionRequest{}).
+			Where("companion_id = ? AND status IN ? AND slot_start < ? AND slot_end > ?",
+				req.CompanionID, slotHoldingStatuses, req.SlotEnd.Add(buffer), req.SlotStart.Add(-buffer)).
+			Count(&n).Error
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return ErrSlotTaken
+		}
+		if err := tx.Create(req).Error; err != nil {
+			return err
+		}
+		t.InteractionID = req.ID
+		if err := tx.Create(t).Error; err != nil {
+			return err
+		}
+		_, err = runEscrowStep(tx, hold)
+		return err
+	})
+}
+
+// ListBookings returns the companion's bookings holding a slot that overlaps [from, to).
+func (r *InteractionRepository) ListBookings(companionID uint, from, to time.Time) ([]models.InteractionRequest, error) {
+	var list []models.InteractionRequest
+	err := r.db.Where("companion_id = ? AND status IN ? AND slot_start < ? AND slot_end > ?", companionID, slotHoldingStatuses, to, from).
+		Order("slot_start ASC").Find(&list).Error
+	return list, err
+}
+
+// ListBookingRemindersDue returns ACCEPTED bookings starting between now and until that have not been
+// reminded yet.
+func (r *InteractionRepository) ListBookingRemindersDue(now, until time.Time, limit int) ([]models.InteractionRequest, error) {
+	var list []models.InteractionRequest
+	err := r.db.Where("status = ? AND reminder_sent_at IS NULL AND slot_start > ? AND slot_start <= ?", domain.RequestStatusAccepted, now, until).
+		Preload("Companion").Order("slot_start ASC").Limit(limit).Find(&list).Error
+	return list, err
+}
+
+// MarkReminderSent records the booking reminder. Returns false if it was already sent.
+func (r *InteractionRepository) MarkReminderSent(id uint, at time.Time) (bool, error) {
+	res := r.db.Model(&models.InteractionRequest{}).Where("id = ? AND reminder_sent_at IS NULL", id).
+		Update("reminder_sent_at", at)
+	return res.RowsAffected > 0, res.Error
+}
+
+// Transition moves the request from t.FromStatus to t.ToStatus, applying fields in the same UPDATE, and records t.
+// The update only matches while the row still has FromStatus, so concurrent transitions cannot both win.
+func (r *InteractionRepository) Transition(req *models.InteractionRequest, fields map[string]interface{}, t *models.InteractionTransition) error {
+	_, err := r.TransitionSettling(req, fields, t, nil)
+	return err
+}
+
+// TransitionSettling is Transition with an escrow movement committed in the same transaction: if the money
+// cannot move, the status does not change either. The returned hold is nil when the request was never paid.
+func (r *InteractionRepository) TransitionSettling(req *models.InteractionRequest, fields map[string]interface{}, t *models.InteractionTransition, step EscrowStep) (*models.EscrowHold, error) {
+	var hold *models.EscrowHold
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := applyTransition(tx, req, fields, t); err != nil {
+			return err
+		}
+		var err error
+		hold, err = runEscrowStep(tx, step)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	req.Status = t.ToStatus
+	return hold, nil
+}
+
+// AcceptWithSession accepts the request and creates its chat session in one transaction, with step (the
+// escrow hold) committed alongside. With capacity > 0 the companion's profile row is locked and her live
+// unscheduled sessions counted first, so concurrent accepts cannot run past capacity: it fails with
+// ErrNoChatCapacity when she is full. It returns her live unscheduled sessions including this one (0 when
+// capacity is not checked).
+func (r *InteractionRepository) AcceptWithSession(req *models.InteractionRequest, fields map[string]interface{}, t *models.InteractionTransition, step EscrowStep, session *models.ChatSession, capacity int) (int64, error) {
+	var live int64
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if capacity > 0 {
+			var comp models.CompanionProfile
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&comp, req.CompanionID).Error; err != nil {
+				return err
+			}
+			now := time.Now()
+			err := tx.Table("chat_sessions cs").
+				Joins("INNER JOIN interaction_requests ir ON ir.id = cs.interaction_id").
+				Where("ir.companion_id = ? AND ir.slot_start IS NULL AND cs.deleted_at IS NULL AND cs.ended_at IS NULL AND cs.started_at <= ? AND cs.ends_at > ?",
+					req.CompanionID, now, now).
+				Count(&live).Error
+			if err != nil {
+				return err
+			}
+			if live >= int64(capacity) {
+				return ErrNoChatCapacity
+			}
+			live++
+		}
+		if err := applyTransition(tx, req, fields, t); err != nil {
+			return err
+		}
+		if _, err := runEscrowStep(tx, step); err != nil {
+			return err
+		}
+		session.InteractionID = req.ID
+		return tx.Create(session).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	req.Status = t.ToStatus
+	return live, nil
+}
+
+// applyTransition is the guarded status UPDATE and history insert of Transition, inside tx. req.Status is left
+// for the caller to set once tx commits.
+func applyTransition(tx *gorm.DB, req *models.InteractionRequest, fields map[string]interface{}, t *models.InteractionTransition) error {
+	updates := map[string]interface{}{"status": t.ToStatus, "updated_at": time.Now()}
+	for k, v := range fields {
+		updates[k] = v
+	}
+	res := tx.Model(&models.InteractionRequest{}).Where("id = ? AND status = ?", req.ID, t.FromStatus).Updates(updates)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrStatusChanged
+	}
+	t.InteractionID = req.ID
+	return tx.Create(t).Error
+}
+
+// runEscrowStep applies step inside tx. A request without a completed payment has nothing to move.
+func runEscrowStep(tx *gorm.DB, step EscrowStep) (*models.EscrowHold, error) {
+	if step == nil {
+		return nil, nil
+	}
+	hold, err := step(tx)
+	if errors.Is(err, ErrEscrowNoPayment) {
+		return nil, nil
+	}
+	return hold, err
+}
+
 func (r *InteractionRepository) GetByID(id uint) (*models.InteractionRequest, error) {
 	var req models.InteractionRequest
 	err := r.db.Preload("Payment").Preload("Companion").First(&req, id).Error
@@ -47,18 +238,37 @@ func (r *InteractionRepository) CountPendingByCompanionID(companionID uint) (int
 	return c, err
 }
 
-// RejectOtherPendingByCompanionID sets all other PENDING requests for this companion to REJECTED (except exceptInteractionID).
-// Call after companion accepts one request so others are auto-removed.
-func (r *InteractionRepository) RejectOtherPendingByCompanionID(companionID, exceptInteractionID uint) error {
-	return r.db.Model(&models.InteractionRequest{}).
-		Where("companion_id = ? AND status = ? AND id != ?", companionID, "PENDING", exceptInteractionID).
-		Updates(map[string]interface{}{"status": "REJECTED", "rejected_at": time.Now()}).Error
-}
-
 // ListPendingKycByClientID returns interactions with status PENDING_KYC for the client (payment done, KYC not complete yet).
 func (r *InteractionRepository) ListPendingKycByClientID(clientID uint, limit int) ([]models.InteractionRequest, error) {
 	var list []models.InteractionRequest
-	err := r.db.Where("client_id = ? AND status = ?", clientID, "PENDING_KYC").Preload("Payment").Preload("Companion").Limit(limit).Find(&list).Error
+	err := r.db.Where("client_id = ? AND status = ?", clientID, domain.RequestStatusPendingKYC).Preload("Payment").Preload("Companion").Limit(limit).Find(&list).Error
+	return list, err
+}
+
+// ListExpired returns PENDING and PENDING_KYC requests whose expires_at has passed.
+func (r *InteractionRepository) ListExpired(now time.Time, limit int) ([]models.InteractionRequest, error) {
+	var list []models.InteractionRequest
+	err := r.db.Where("status IN ? AND expires_at IS NOT NULL AND expires_at < ?",
+		[]string{domain.RequestStatusPending, domain.RequestStatusPendingKYC}, now).
+		Preload("Payment").Order("expires_at ASC").Limit(limit).Find(&list).Error
+	return list, err
+}
+
+// MarkDelivered records that the companion delivered an ACCEPTED interaction and when it completes on its
+// own. Returns false if the interaction is no longer ACCEPTED or was already marked.
+func (r *InteractionRepository) MarkDelivered(id uint, at, autoCompleteAt time.Time) (bool, error) {
+	res := r.db.Model(&models.InteractionRequest{}).
+		Where("id = ? AND status = ? AND delivered_at IS NULL", id, domain.RequestStatusAccepted).
+		Updates(map[string]interface{}{"delivered_at": at, "auto_complete_at": autoCompleteAt, "updated_at": time.Now()})
+	return res.RowsAffected > 0, res.Error
+}
+
+// ListAutoCompleteDue returns ACCEPTED requests whose grace period after delivery has passed. Disputed
+// requests are no longer ACCEPTED and so never listed.
+func (r *InteractionRepository) ListAutoCompleteDue(now time.Time, limit int) ([]models.InteractionRequest, error) {
+	var list []models.InteractionRequest
+	err := r.db.Where("status = ? AND auto_complete_at IS NOT NULL AND auto_complete_at < ?", domain.RequestStatusAccepted, now).
+		Preload("Payment").Order("auto_complete_at ASC").Limit(limit).Find(&list).Error
 	return list, err
 }
 
@@ -118,17 +328,138 @@ func (r *InteractionRepository) CreateMessage(m *models.ChatMessage) error {
 	return r.db.Create(m).Error
 }
 
-func (r *InteractionRepository) GetMessagesBySessionID(sessionID uint, limit, offset int) ([]models.ChatMessage, error) {
+// CreateMessageOnce stores m unless its sender already sent a message with the same ClientMsgID in the
+// session, in which case that message is returned instead. created reports whether m was stored.
+func (r *InteractionRepository) CreateMessageOnce(m *models.ChatMessage) (*models.ChatMessage, bool, error) {
+	if m.ClientMsgID == nil {
+		return m, true, r.db.Create(m).Error
+	}
+	res := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(m)
+	if res.Error != nil {
+		return nil, false, res.Error
+	}
+	if res.RowsAffected > 0 {
+		return m, true, nil
+	}
+	var existing models.ChatMessage
+	err := r.db.Where("session_id = ? AND sender_id = ? AND client_msg_id = ?", m.SessionID, m.SenderID, *m.ClientMsgID).First(&existing).Error
+	if err != nil {
+		return nil, false, err
+	}
+	return &existing, false, nil
+}
+
+// MarkMessagesDelivered records that recipientID received every message up to upToID the other side sent in
+// the session. Returns how many messages changed.
+func (r *InteractionRepository) MarkMessagesDelivered(sessionID, recipientID, upToID uint, at time.Time) (int64, error) {
+	res := r.db.Model(&models.ChatMessage{}).
+		Where("session_id = ? AND sender_id <> ? AND id <= ? AND delivered_at IS NULL", sessionID, recipientID, upToID).
+		Update("delivered_at", at)
+	return res.RowsAffected, res.Error
+}
+
+// MarkMessagesRead records that recipientID read every message up to upToID the other side sent in the
+// session; read messages count as delivered too. Returns how many messages changed.
+func (r *InteractionRepository) MarkMessagesRead(sessionID, recipientID, upToID uint, at time.Time) (int64, error) {
+	if _, err := r.MarkMessagesDelivered(sessionID, recipientID, upToID, at); err != nil {
+		return 0, err
+	}
+	res := r.db.Model(&models.ChatMessage{}).
+		Where("session_id = ? AND sender_id <> ? AND id <= ? AND read_at IS NULL", sessionID, recipientID, upToID).
+		Update("read_at", at)
+	return res.RowsAffected, res.Error
+}
+
+// FirstUndeliveredID returns the oldest message in the session the other side sent recipientID that they have
+// not received, or 0 if there is none.
+func (r *InteractionRepository) FirstUndeliveredID(sessionID, recipientID uint) (uint, error) {
+	var ids []uint
+	err := r.db.Model(&models.ChatMessage{}).
+		Where("session_id = ? AND sender_id <> ? AND delivered_at IS NULL", sessionID, recipientID).
+		Order("id ASC").Limit(1).Pluck("id", &ids).Error
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+	return ids[0], nil
+}
+
+// ListUnpushed returns text messages sent between from and before that were neither delivered nor pushed,
+// oldest first, with their session.
+func (r *InteractionRepository) ListUnpushed(from, before time.Time, limit int) ([]models.ChatMessage, error) {
 	var list []models.ChatMessage
-	err := r.db.Where("session_id = ?", sessionID).Order("created_at ASC").Limit(limit).Offset(offset).Find(&list).Error
+	err := r.db.Preload("Session").
+		Where("delivered_at IS NULL AND pushed_at IS NULL AND kind = ?", domain.ChatMessageKindText).
+		Where("created_at >= ? AND created_at < ?", from, before).
+		Order("id ASC").Limit(limit).Find(&list).Error
 	return list, err
 }
 
+// MarkMessagesPushed records that recipientID was pushed every undelivered message up to upToID the other
+// side sent in the session. Returns how many messages changed.
+func (r *InteractionRepository) MarkMessagesPushed(sessionID, recipientID, upToID uint, at time.Time) (int64, error) {
+	res := r.db.Model(&models.ChatMessage{}).
+		Where("session_id = ? AND sender_id <> ? AND id <= ? AND delivered_at IS NULL AND pushed_at IS NULL", sessionID, recipientID, upToID).
+		Update("pushed_at", at)
+	return res.RowsAffected, res.Error
+}
+
+// ListMessages pages a session's messages by ID, oldest first. With afterID it returns the first limit
+// messages after it; otherwise the last limit messages before beforeID (0 = the newest). hasMore reports
+// whether more messages lie beyond the page in the direction paged.
+func (r *InteractionRepository) ListMessages(sessionID, beforeID, afterID uint, limit int) (list []models.ChatMessage, hasMore bool, err error) {
+	if afterID > 0 {
+		return r.ListMessagesAfter(sessionID, afterID, limit)
+	}
+	q := r.db.Where("session_id = ?", sessionID)
+	if beforeID > 0 {
+		q = q.Where("id < ?", beforeID)
+	}
+	err = q.Order("id DESC").Limit(limit + 1).Find(&list).Error
+	if len(list) > limit {
+		list, hasMore = list[:limit], true
+	}
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+	return list, hasMore, err
+}
+
+// ListMessagesAfter returns the first limit messages of the session after afterID (0 = from the start), oldest
+// first; hasMore reports whether newer ones remain.
+func (r *InteractionRepository) ListMessagesAfter(sessionID, afterID uint, limit int) (list []models.ChatMessage, hasMore bool, err error) {
+	err = r.db.Where("session_id = ? AND id > ?", sessionID, afterID).Order("id ASC").Limit(limit + 1).Find(&list).Error
+	if len(list) > limit {
+		list, hasMore = list[:limit], true
+	}
+	return list, hasMore, err
+}
+
 // DeleteMessagesBySessionID soft-deletes all messages in a session.
 func (r *InteractionRepository) DeleteMessagesBySessionID(sessionID uint) error {
 	return r.db.Where("session_id = ?", sessionID).Delete(&models.ChatMessage{}).Error
 }
 
+// ChatSenderStats summarises one participant's messages in a session, without their content.
+type ChatSenderStats struct {
+	SenderID      uint      `json:"sender_id"`
+	Messages      int64     `json:"messages"`
+	MediaMessages int64     `json:"media_messages"`
+	FirstAt       time.Time `json:"first_at"`
+	LastAt        time.Time `json:"last_at"`
+}
+
+// ChatStatsBySessionID returns per-sender message counts and times for dispute evidence. Includes messages
+// already soft-deleted when the session ended.
+func (r *InteractionRepository) ChatStatsBySessionID(sessionID uint) ([]ChatSenderStats, error) {
+	var list []ChatSenderStats
+	err := r.db.Unscoped().Model(&models.ChatMessage{}).
+		Select("sender_id, COUNT(*) AS messages, SUM(CASE WHEN media_url <> '' THEN 1 ELSE 0 END) AS media_messages, MIN(created_at) AS first_at, MAX(created_at) AS last_at").
+		Where("session_id = ?", sessionID).
+		Group("sender_id").
+		Scan(&list).Error
+	return list, err
+}
+
 // GetEarningsByCompanionID returns total earnings (amount_cents) from completed payments for accepted interactions.
 func (r *InteractionRepository) GetEarningsByCompanionID(companionID uint) (int64, error) {
 	var sum int64
@@ -140,16 +471,42 @@ func (r *InteractionRepository) GetEarningsByCompanionID(companionID uint) (int6
 	return sum, err
 }
 
-// CountActiveSessionsByCompanionID returns count of chat sessions that are active (not ended, ends_at > now).
+// CountActiveSessionsByCompanionID returns count of chat sessions that are active (started, not ended, ends_at > now).
+// An accepted booking's session starts at its slot.
 func (r *InteractionRepository) CountActiveSessionsByCompanionID(companionID uint) (int64, error) {
 	var c int64
 	err := r.db.Table("chat_sessions cs").
 		Joins("INNER JOIN interaction_requests ir ON cs.interaction_id = ir.id").
-		Where("ir.companion_id = ? AND cs.deleted_at IS NULL AND cs.ended_at IS NULL AND cs.ends_at > NOW()", companionID).
+		Where("ir.companion_id = ? AND cs.deleted_at IS NULL AND cs.ended_at IS NULL AND cs.started_at <= NOW() AND cs.ends_at > NOW()", companionID).
 		Count(&c).Error
 	return c, err
 }
 
+// liveUnscheduledSessionsSQL counts the live sessions of requests without a booking slot for the companion
+// profile row cp.
+const liveUnscheduledSessionsSQL = `(SELECT COUNT(*) FROM chat_sessions cs
+	INNER JOIN interaction_requests ir ON ir.id = cs.interaction_id
+	WHERE ir.companion_id = cp.id AND ir.slot_start IS NULL AND cs.deleted_at IS NULL AND cs.ended_at IS NULL
+	AND cs.started_at <= NOW() AND cs.ends_at > NOW())`
+
+// companionAvailableSQL is true when the companion profile row cp can take an immediate request: she has her
+// toggles on, is not in an accepted booking's slot and has fewer live unscheduled sessions than her capacity.
+const companionAvailableSQL = `(COALESCE(cp.is_available, 1) = 1 AND COALESCE(cp.accept_new_requests, 1) = 1
+	AND NOT EXISTS (SELECT 1 FROM interaction_requests b WHERE b.companion_id = cp.id AND b.deleted_at IS NULL
+		AND b.status = 'ACCEPTED' AND b.slot_start <= NOW() AND b.slot_end > NOW())
+	AND ` + liveUnscheduledSessionsSQL + ` < GREATEST(COALESCE(cp.max_concurrent_chats, 1), 1))`
+
+// CompanionAvailableNow reports whether the companion can take an immediate (unscheduled) request right now.
+// Availability is derived from her toggles, live sessions and calendar; nothing flips it as requests come and go.
+func (r *InteractionRepository) CompanionAvailableNow(companionID uint) (bool, error) {
+	var row struct{ Available bool }
+	err := r.db.Table("companion_profiles cp").
+		Select(companionAvailableSQL+" AS available").
+		Where("cp.id = ? AND cp.deleted_at IS NULL", companionID).
+		Scan(&row).Error
+	return row.Available, err
+}
+
 // ActiveSessionRow is a row for companion active sessions list.
 type ActiveSessionRow struct {
 	InteractionID   uint
@@ -175,7 +532,7 @@ func (r *InteractionRepository) ListActiveSessionsByCompanionID(companionID uint
 	}
 	err := r.db.Table("interaction_requests ir").
 		Select("ir.id, ir.client_id, ir.interaction_type, ir.duration_minutes, cs.started_at, cs.ends_at, u.username, u.email, p.metadata as payment_metadata").
-		Joins("INNER JOIN chat_sessions cs ON cs.interaction_id = ir.id AND cs.deleted_at IS NULL AND cs.ended_at IS NULL AND cs.ends_at > NOW()").
+		Joins("INNER JOIN chat_sessions cs ON cs.interaction_id = ir.id AND cs.deleted_at IS NULL AND cs.ended_at IS NULL AND cs.started_at <= NOW() AND cs.ends_at > NOW()").
 		Joins("INNER JOIN users u ON u.id = ir.client_id").
 		Joins("LEFT JOIN payments p ON p.id = ir.payment_id AND p.deleted_at IS NULL").
 		Where("ir.companion_id = ? AND ir.status = ? AND ir.deleted_at IS NULL", companionID, "ACCEPTED").
@@ -223,7 +580,7 @@ func (r *InteractionRepository) ClientHasActiveSessionWithOtherCompanion(clientI
 	var c int64
 	err := r.db.Table("chat_sessions cs").
 		Joins("INNER JOIN interaction_requests ir ON cs.interaction_id = ir.id").
-		Where("ir.client_id = ? AND ir.companion_id != ? AND ir.status = ? AND cs.deleted_at IS NULL AND cs.ended_at IS NULL AND cs.ends_at > NOW()",
+		Where("ir.client_id = ? AND ir.companion_id != ? AND ir.status = ? AND cs.deleted_at IS NULL AND cs.ended_at IS NULL AND cs.started_at <= NOW() AND cs.ends_at > NOW()",
 			clientID, excludeCompanionID, "ACCEPTED").
 		Limit(1).
 		Count(&c).Error
@@ -0,0 +1,309 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"lusty/internal/database/databasetest"
+	"lusty/internal/domain"
+	"lusty/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// seedPaidInteraction creates a companion, a COMPLETED payment of amountCents in provider clearing and an
+// interaction in status for it.
+func seedPaidInteraction(t *testing.T, db *gorm.DB, status string, amountCents int64) *models.InteractionRequest {
+	t.Helper()
+	comp := models.CompanionProfile{UserID: 100, DisplayName: "companion"}
+	if err := db.Create(&comp).Error; err != nil {
+		t.Fatalf("create companion: %v", err)
+	}
+	pay := models.Payment{UserID: 1, AmountCents: amountCents, Provider: "fake", Status: "COMPLETED",
+		ProviderRef: fmt.Sprintf("ref_%d", comp.ID), IdempotencyKey: fmt.Sprintf("key_%d", comp.ID)}
+	if err := db.Create(&pay).Error; err != nil {
+		t.Fatalf("create payment: %v", err)
+	}
+	ir := &models.InteractionRequest{ClientID: 1, CompanionID: comp.ID, InteractionType: "CHAT", PaymentID: &pay.ID, Status: status}
+	if err := db.Create(ir).Error; err != nil {
+		t.Fatalf("create interaction: %v", err)
+	}
+	return ir
+}
+
+func transitionTo(ir *models.InteractionRequest, to string) *models.InteractionTransition {
+	return &models.InteractionTransition{FromStatus: ir.Status, ToStatus: to}
+}
+
+// TestTransitionSettlingCommitsTogether checks a completion moves the status and pays the companion at once.
+func TestTransitionSettlingCommitsTogether(t *testing.T) {
+	db := databasetest.New(t)
+	interactions, escrow := NewInteractionRepository(db), NewEscrowRepository(db)
+	ir := seedPaidInteraction(t, db, domain.RequestStatusAccepted, 120_000)
+	if _, err := escrow.Hold(ir); err != nil {
+		t.Fatalf("hold: %v", err)
+	}
+
+	hold, err := interactions.TransitionSettling(ir, nil, transitionTo(ir, domain.RequestStatusCompleted), escrow.ReleaseStep(ir, nil))
+	if err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if hold == nil || hold.Status != domain.EscrowStatusReleased {
+		t.Fatalf("hold = %+v, want RELEASED", hold)
+	}
+	stored, _ := interactions.GetByID(ir.ID)
+	if stored.Status != domain.RequestStatusCompleted {
+		t.Fatalf("status = %s, want COMPLETED", stored.Status)
+	}
+	payout := domain.CompanionPayout(domain.CompanionBaseCents(120_000))
+	w, err := NewWalletRepository(db).GetByUserID(100)
+	if err != nil || w.WithdrawableCents != payout {
+		t.Fatalf("companion wallet = %+v (%v), want %d withdrawable", w, err, payout)
+	}
+}
+
+// TestTransitionSettlingRollsBack checks a status change is undone when its escrow movement fails, so the
+// request cannot be COMPLETED while its money is still (or no longer) in escrow.
+func TestTransitionSettlingRollsBack(t *testing.T) {
+	db := databasetest.New(t)
+	interactions, escrow := NewInteractionRepository(db), NewEscrowRepository(db)
+	ir := seedPaidInteraction(t, db, domain.RequestStatusAccepted, 120_000)
+	if _, err := escrow.Refund(ir, "refunded elsewhere", nil); err != nil {
+		t.Fatalf("refund: %v", err)
+	}
+
+	_, err := interactions.TransitionSettling(ir, nil, transitionTo(ir, domain.RequestStatusCompleted), escrow.ReleaseStep(ir, nil))
+	if !errors.Is(err, ErrEscrowSettled) {
+		t.Fatalf("complete: err = %v, want ErrEscrowSettled", err)
+	}
+	if ir.Status != domain.RequestStatusAccepted {
+		t.Fatalf("in-memory status = %s after rollback, want ACCEPTED", ir.Status)
+	}
+	stored, _ := interactions.GetByID(ir.ID)
+	if stored.Status != domain.RequestStatusAccepted {
+		t.Fatalf("stored status = %s after rollback, want ACCEPTED", stored.Status)
+	}
+	var n int64
+	db.Model(&models.InteractionTransition{}).Where("interaction_id = ?", ir.ID).Count(&n)
+	if n != 0 {
+		t.Fatalf("%d transition rows recorded for a rolled back change", n)
+	}
+}
+
+// TestTransitionSettlingUnpaid checks a request without a completed payment still transitions, with no hold.
+func TestTransitionSettlingUnpaid(t *testing.T) {
+	db := databasetest.New(t)
+	interactions, escrow := NewInteractionRepository(db), NewEscrowRepository(db)
+	ir := &models.InteractionRequest{ClientID: 1, CompanionID: 1, InteractionType: "CHAT", Status: domain.RequestStatusPending}
+	if err := db.Create(ir).Error; err != nil {
+		t.Fatalf("create interaction: %v", err)
+	}
+	hold, err := interactions.TransitionSettling(ir, nil, transitionTo(ir, domain.RequestStatusRejected), escrow.RefundStep(ir, "", nil))
+	if err != nil || hold != nil {
+		t.Fatalf("reject unpaid: hold = %+v, err = %v; want nil, nil", hold, err)
+	}
+	if ir.Status != domain.RequestStatusRejected {
+		t.Fatalf("status = %s, want REJECTED", ir.Status)
+	}
+}
+
+// TestAcceptWithSessionCapacity accepts several unscheduled requests of one companion at once: only as many
+// as her capacity may win, and every winner has its session.
+func TestAcceptWithSessionCapacity(t *testing.T) {
+	db := databasetest.New(t)
+	interactions := NewInteractionRepository(db)
+	comp := models.CompanionProfile{UserID: 100, DisplayName: "companion", MaxConcurrentChats: 2}
+	if err := db.Create(&comp).Error; err != nil {
+		t.Fatalf("create companion: %v", err)
+	}
+	const requests = 6
+	reqs := make([]*models.InteractionRequest, requests)
+	for i := range reqs {
+		reqs[i] = &models.InteractionRequest{ClientID: uint(i + 1), CompanionID: comp.ID, InteractionType: "CHAT", Status: domain.RequestStatusPending}
+		if err := db.Create(reqs[i]).Error; err != nil {
+			t.Fatalf("create interaction: %v", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, requests)
+	for i, ir := range reqs {
+		wg.Add(1)
+		go func(i int, ir *models.InteractionRequest) {
+			defer wg.Done()
+			now := time.Now()
+			session := &models.ChatSession{StartedAt: now.Add(-time.Second), EndsAt: now.Add(time.Hour)}
+			_, errs[i] = interactions.AcceptWithSession(ir, nil, transitionTo(ir, domain.RequestStatusAccepted), nil, session, comp.ChatCapacity())
+		}(i, ir)
+	}
+	wg.Wait()
+
+	accepted := 0
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			accepted++
+			if _, err := interactions.GetChatSessionByInteractionID(reqs[i].ID); err != nil {
+				t.Fatalf("accepted request %d has no session: %v", reqs[i].ID, err)
+			}
+		case errors.Is(err, ErrNoChatCapacity):
+			stored, _ := interactions.GetByID(reqs[i].ID)
+			if stored.Status != domain.RequestStatusPending {
+				t.Fatalf("refused request %d is %s, want PENDING", reqs[i].ID, stored.Status)
+			}
+		default:
+			t.Fatalf("accept %d: %v", reqs[i].ID, err)
+		}
+	}
+	if accepted != comp.ChatCapacity() {
+		t.Fatalf("accepted %d requests, want %d", accepted, comp.ChatCapacity())
+	}
+}
+
+// TestCreateBookingRejectsOverlap checks a booking cannot overlap another of the companion's bookings or the
+// buffer around it, and that a rejected booking frees its slot.
+func TestCreateBookingRejectsOverlap(t *testing.T) {
+	db := databasetest.New(t)
+	interactions := NewInteractionRepository(db)
+	comp := models.CompanionProfile{UserID: 100, DisplayName: "companion"}
+	if err := db.Create(&comp).Error; err != nil {
+		t.Fatalf("create companion: %v", err)
+	}
+	base := time.Now().Add(24 * time.Hour).Truncate(time.Hour)
+	book := func(from, to time.Duration) (*models.InteractionRequest, error) {
+		start, end := base.Add(from), base.Add(to)
+		ir := &models.InteractionRequest{ClientID: 1, CompanionID: comp.ID, InteractionType: "BOOKING",
+			Status: domain.RequestStatusPending, SlotStart: &start, SlotEnd: &end}
+		return ir, interactions.CreateBookingWithTransition(ir, transitionTo(ir, domain.RequestStatusPending), 15*time.Minute, nil)
+	}
+
+	first, err := book(0, time.Hour)
+	if err != nil {
+		t.Fatalf("first booking: %v", err)
+	}
+	for _, slot := range [][2]time.Duration{
+		{30 * time.Minute, 90 * time.Minute}, // overlaps
+		{-time.Hour, 10 * time.Minute},       // overlaps the start
+		{70 * time.Minute, 2 * time.Hour},    // inside the buffer after
+		{-time.Hour, -10 * time.Minute},      // inside the buffer before
+	} {
+		if _, err := book(slot[0], slot[1]); !errors.Is(err, ErrSlotTaken) {
+			t.Errorf("booking %v-%v: err = %v, want ErrSlotTaken", slot[0], slot[1], err)
+		}
+	}
+	if _, err := book(75*time.Minute, 2*time.Hour); err != nil {
+		t.Fatalf("booking right after the buffer: %v", err)
+	}
+
+	if err := db.Model(first).Update("status", domain.RequestStatusRejected).Error; err != nil {
+		t.Fatalf("reject: %v", err)
+	}
+	if _, err := book(0, time.Hour); err != nil {
+		t.Fatalf("booking the freed slot: %v", err)
+	}
+}
+
+// seedChat returns an accepted interaction between client 1 and companion user 100 and its chat session.
+func seedChat(t *testing.T, db *gorm.DB) (*models.InteractionRequest, *models.ChatSession) {
+	t.Helper()
+	ir := seedPaidInteraction(t, db, domain.RequestStatusAccepted, 120_000)
+	session := &models.ChatSession{InteractionID: ir.ID, StartedAt: time.Now(), EndsAt: time.Now().Add(time.Hour)}
+	if err := db.Create(session).Error; err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	return ir, session
+}
+
+func sendMessages(t *testing.T, repo *InteractionRepository, sessionID uint, senders ...uint) []*models.ChatMessage {
+	t.Helper()
+	var list []*models.ChatMessage
+	for _, sender := range senders {
+		m := &models.ChatMessage{SessionID: sessionID, SenderID: sender, Content: "hi", Kind: domain.ChatMessageKindText}
+		if err := repo.CreateMessage(m); err != nil {
+			t.Fatalf("create message: %v", err)
+		}
+		list = append(list, m)
+	}
+	return list
+}
+
+// TestMessageReceipts checks delivery and read receipts cover only the other side's messages up to the acked
+// one, and that reading a message also marks it delivered.
+func TestMessageReceipts(t *testing.T) {
+	db := databasetest.New(t)
+	repo := NewInteractionRepository(db)
+	ir, session := seedChat(t, db)
+	const companion = uint(100)
+	msgs := sendMessages(t, repo, session.ID, ir.ClientID, companion, ir.ClientID, ir.ClientID)
+
+	if n, err := repo.MarkMessagesDelivered(session.ID, companion, msgs[2].ID, time.Now()); err != nil || n != 2 {
+		t.Fatalf("delivered: n=%d err=%v, want the client's first two", n, err)
+	}
+	if first, _ := repo.FirstUndeliveredID(session.ID, companion); first != msgs[3].ID {
+		t.Fatalf("first undelivered = %d, want %d", first, msgs[3].ID)
+	}
+	if n, err := repo.MarkMessagesRead(session.ID, companion, msgs[3].ID, time.Now()); err != nil || n != 3 {
+		t.Fatalf("read: n=%d err=%v, want 3", n, err)
+	}
+	if first, _ := repo.FirstUndeliveredID(session.ID, companion); first != 0 {
+		t.Fatalf("first undelivered = %d after reading, want none", first)
+	}
+	if n, _ := repo.MarkMessagesRead(session.ID, companion, msgs[3].ID, time.Now()); n != 0 {
+		t.Fatalf("second read receipt changed %d messages, want 0", n)
+	}
+	var own models.ChatMessage
+	db.First(&own, msgs[1].ID)
+	if own.DeliveredAt != nil || own.ReadAt != nil {
+		t.Fatalf("companion's own message = %+v, want no receipts from her acks", own)
+	}
+}
+
+// TestMessageResendAndSync checks a message resent after a reconnect is stored once, and that paging after
+// the last seen ID returns everything missed in order with nothing skipped.
+func TestMessageResendAndSync(t *testing.T) {
+	db := databasetest.New(t)
+	repo := NewInteractionRepository(db)
+	ir, session := seedChat(t, db)
+
+	msgID := "c-1"
+	first, created, err := repo.CreateMessageOnce(&models.ChatMessage{SessionID: session.ID, SenderID: ir.ClientID, ClientMsgID: &msgID,
+		Content: "hello", Kind: domain.ChatMessageKindText})
+	if err != nil || !created {
+		t.Fatalf("send: created=%v err=%v", created, err)
+	}
+	again, created, err := repo.CreateMessageOnce(&models.ChatMessage{SessionID: session.ID, SenderID: ir.ClientID, ClientMsgID: &msgID,
+		Content: "hello", Kind: domain.ChatMessageKindText})
+	if err != nil || created || again.ID != first.ID {
+		t.Fatalf("resend: message=%+v created=%v err=%v, want message %d back", again, created, err, first.ID)
+	}
+
+	sendMessages(t, repo, session.ID, 100, ir.ClientID, 100, 100, ir.ClientID)
+	var synced []uint
+	for afterID, hasMore := first.ID, true; hasMore; {
+		var page []models.ChatMessage
+		page, hasMore, err = repo.ListMessagesAfter(session.ID, afterID, 2)
+		if err != nil {
+			t.Fatalf("sync: %v", err)
+		}
+		for _, m := range page {
+			synced = append(synced, m.ID)
+			afterID = m.ID
+		}
+	}
+	if len(synced) != 5 {
+		t.Fatalf("synced %v, want the 5 messages after %d", synced, first.ID)
+	}
+	for i := 1; i < len(synced); i++ {
+		if synced[i] <= synced[i-1] {
+			t.Fatalf("synced %v out of order", synced)
+		}
+	}
+
+	latest, hasMore, err := repo.ListMessages(session.ID, 0, 0, 3)
+	if err != nil || !hasMore || len(latest) != 3 || latest[2].ID != synced[4] {
+		t.Fatalf("latest page = %+v hasMore=%v err=%v, want the newest 3 oldest first", latest, hasMore, err)
+	}
+}
@@ -1,6 +1,9 @@
 package repository
 
 import (
+	"time"
+
+	"lusty/internal/domain"
 	"lusty/internal/models"
 
 	"gorm.io/gorm"
@@ -48,3 +51,77 @@ func (r *PaymentRepository) GetByIdempotencyKey(key string) (*models.Payment, er
 func (r *PaymentRepository) Update(p *models.Payment) error {
 	return r.db.Save(p).Error
 }
+
+// UpdateStatusIf moves the payment from one status to another only if it still has the from status.
+// Returns false if another writer (webhook, handler, job) changed it first.
+func (r *PaymentRepository) UpdateStatusIf(id uint, from, to string) (bool, error) {
+	res := r.db.Model(&models.Payment{}).Where("id = ? AND status = ?", id, from).
+		Updates(map[string]interface{}{"status": to, "updated_at": time.Now()})
+	return res.RowsAffected > 0, res.Error
+}
+
+// CloseReturningWallet moves a PENDING payment to status (FAILED or CANCELLED) and, in the same transaction,
+// returns the walletCents the client put towards it to their wallet. Returns false, with nothing refunded, if
+// the payment already left PENDING. The refund is keyed on the payment's provider reference and posted once.
+func (r *PaymentRepository) CloseReturningWallet(p *models.Payment, status string, walletCents int64) (bool, error) {
+	closed := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.Payment{}).Where("id = ? AND status = ?", p.ID, domain.PaymentStatusPending).
+			Updates(map[string]interface{}{"status": status, "updated_at": time.Now()})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		closed = true
+		if walletCents <= 0 {
+			return nil
+		}
+		var n int64
+		if err := tx.Model(&models.LedgerEntry{}).Where("type = ? AND reference = ?", domain.WalletTxTypeRefund, p.ProviderRef).
+			Count(&n).Error; err != nil || n > 0 {
+			return err
+		}
+		_, err := postEntry(tx, domain.WalletTxTypeRefund, p.ProviderRef, []LedgerLeg{
+			{Account: domain.LedgerAccountProviderClearing, AmountCents: -walletCents},
+			{Account: domain.LedgerAccountClientBalance, UserID: p.UserID, AmountCents: walletCents},
+		}, true)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return closed, nil
+}
+
+// CompleteIf marks the payment COMPLETED (with completed_at) only if it still has the from status.
+func (r *PaymentRepository) CompleteIf(id uint, from string) (bool, error) {
+	now := time.Now()
+	res := r.db.Model(&models.Payment{}).Where("id = ? AND status = ?", id, from).
+		Updates(map[string]interface{}{"status": domain.PaymentStatusCompleted, "completed_at": now, "updated_at": now})
+	return res.RowsAffected > 0, res.Error
+}
+
+func (r *PaymentRepository) SetExpiresAt(id uint, t time.Time) error {
+	return r.db.Model(&models.Payment{}).Where("id = ?", id).Update("expires_at", t).Error
+}
+
+// ListAbandoned returns PENDING payments past their expires_at, or created before createdBefore when they
+// have no expiry recorded.
+func (r *PaymentRepository) ListAbandoned(now, createdBefore time.Time, limit int) ([]models.Payment, error) {
+	var list []models.Payment
+	err := r.db.Where("status = ? AND ((expires_at IS NOT NULL AND expires_at < ?) OR (expires_at IS NULL AND created_at < ?))",
+		domain.PaymentStatusPending, now, createdBefore).
+		Order("id ASC").Limit(limit).Find(&list).Error
+	return list, err
+}
+
+// ListForReconciliation pages (by id, after afterID) through provider payments worth checking against the
+// provider: PENDING ones created before pendingBefore, and settled ones updated since settledSince.
+// Wallet-only payments have nothing at a provider and are skipped.
+func (r *PaymentRepository) ListForReconciliation(pendingBefore, settledSince time.Time, afterID uint, limit int) ([]models.Payment, error) {
+	var list []models.Payment
+	err := r.db.Where("id > ? AND provider <> ? AND ((status = ? AND created_at < ?) OR (status IN ? AND updated_at >= ?))",
+		afterID, "wallet", domain.PaymentStatusPending, pendingBefore,
+		[]string{domain.PaymentStatusCompleted, domain.PaymentStatusFailed, domain.PaymentStatusCancelled}, settledSince).
+		Order("id ASC").Limit(limit).Find(&list).Error
+	return list, err
+}
@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"testing"
+
+	"lusty/internal/database/databasetest"
+	"lusty/internal/domain"
+	"lusty/internal/models"
+)
+
+// TestCloseReturningWalletOnce checks a failed or cancelled payment returns its wallet portion exactly once,
+// whichever of the webhook and the abandoned-payment job gets there first.
+func TestCloseReturningWalletOnce(t *testing.T) {
+	db := databasetest.New(t)
+	payments, wallets := NewPaymentRepository(db), NewWalletRepository(db)
+	if err := wallets.Credit(1, 50_000, domain.LedgerAccountProviderClearing, domain.WalletTxTypeRefund, "seed"); err != nil {
+		t.Fatalf("fund wallet: %v", err)
+	}
+	if err := wallets.Debit(1, 30_000, domain.LedgerAccountProviderClearing, domain.WalletTxTypePayment, "order-1"); err != nil {
+		t.Fatalf("debit wallet portion: %v", err)
+	}
+	p := &models.Payment{UserID: 1, AmountCents: 120_000, Currency: "KES", Provider: "mpesa_liberec", ProviderRef: "order-1",
+		IdempotencyKey: "order-1", Status: domain.PaymentStatusPending, Metadata: `{"wallet_cents":30000}`}
+	if err := payments.Create(p); err != nil {
+		t.Fatalf("create payment: %v", err)
+	}
+
+	closed, err := payments.CloseReturningWallet(p, domain.PaymentStatusFailed, 30_000)
+	if err != nil || !closed {
+		t.Fatalf("first close = %v, %v; want true", closed, err)
+	}
+	closed, err = payments.CloseReturningWallet(p, domain.PaymentStatusCancelled, 30_000)
+	if err != nil || closed {
+		t.Fatalf("second close = %v, %v; want false", closed, err)
+	}
+
+	if got := clientBalance(t, db, 1); got != 50_000 {
+		t.Fatalf("client balance = %d, want 50000", got)
+	}
+	if stored, _ := payments.GetByID(p.ID); stored.Status != domain.PaymentStatusFailed {
+		t.Fatalf("payment status = %s, want FAILED", stored.Status)
+	}
+	if drift, unbalanced, err := wallets.CheckIntegrity(); err != nil || len(drift) > 0 || len(unbalanced) > 0 {
+		t.Fatalf("ledger out of balance: drift=%+v unbalanced=%v err=%v", drift, unbalanced, err)
+	}
+}
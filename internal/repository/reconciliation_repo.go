@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"time"
+
+	"lusty/internal/domain"
+	"lusty/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ReconciliationRepository struct {
+	db *gorm.DB
+}
+
+func NewReconciliationRepository(db *gorm.DB) *ReconciliationRepository {
+	return &ReconciliationRepository{db: db}
+}
+
+// Record stores a discrepancy, or bumps last_seen_at if the same record and status pair was reported before.
+// An existing row keeps its status, so a mismatch an admin already closed is not reopened.
+func (r *ReconciliationRepository) Record(d *models.ReconciliationDiscrepancy) error {
+	d.LastSeenAt = time.Now()
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "kind"}, {Name: "record_id"}, {Name: "local_status"}, {Name: "provider_status"}},
+		DoUpdates: clause.AssignmentColumns([]string{"last_seen_at", "reason", "updated_at"}),
+	}).Create(d).Error
+}
+
+// ResolveOpen closes every OPEN discrepancy of a record, e.g. once it is consistent with the provider again.
+func (r *ReconciliationRepository) ResolveOpen(kind string, recordID uint, note string) error {
+	now := time.Now()
+	return r.db.Model(&models.ReconciliationDiscrepancy{}).
+		Where("kind = ? AND record_id = ? AND status = ?", kind, recordID, domain.DiscrepancyStatusOpen).
+		Updates(map[string]interface{}{
+			"status":      domain.DiscrepancyStatusResolved,
+			"resolution":  note,
+			"resolved_at": now,
+		}).Error
+}
+
+// Resolve closes one OPEN discrepancy on behalf of an admin. Returns false if it was not open.
+func (r *ReconciliationRepository) Resolve(id, adminID uint, note string) (bool, error) {
+	now := time.Now()
+	res := r.db.Model(&models.ReconciliationDiscrepancy{}).
+		Where("id = ? AND status = ?", id, domain.DiscrepancyStatusOpen).
+		Updates(map[string]interface{}{
+			"status":      domain.DiscrepancyStatusResolved,
+			"resolution":  note,
+			"resolved_by": adminID,
+			"resolved_at": now,
+		})
+	return res.RowsAffected > 0, res.Error
+}
+
+func (r *ReconciliationRepository) GetByID(id uint) (*models.ReconciliationDiscrepancy, error) {
+	var d models.ReconciliationDiscrepancy
+	err := r.db.First(&d, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// List returns discrepancies for the admin report, newest first, with optional status and kind filters.
+func (r *ReconciliationRepository) List(status, kind string, page, limit int) ([]models.ReconciliationDiscrepancy, int64, error) {
+	q := r.db.Model(&models.ReconciliationDiscrepancy{})
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	if kind != "" {
+		q = q.Where("kind = ?", kind)
+	}
+	var total int64
+	q.Count(&total)
+	var list []models.ReconciliationDiscrepancy
+	err := q.Order("id DESC").Limit(limit).Offset((page - 1) * limit).Find(&list).Error
+	return list, total, err
+}
@@ -5,9 +5,11 @@ import (
 	"encoding/hex"
 	"fmt"
 
+	"lusty/internal/domain"
 	"lusty/internal/models"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type ReferralRepository struct {
@@ -80,6 +82,44 @@ func (r *ReferralRepository) IncrementCompletedCount(referralID uint) error {
 		UpdateColumn("completed_count", gorm.Expr("completed_count + 1")).Error
 }
 
+// CommissionStep wraps release, the EscrowStep paying a companion, so that in the same transaction whoever
+// referred her is credited the referral commission on her base price, for her first
+// domain.ReferralMaxTransactions transactions. The referral row is locked while it is counted and the ledger
+// entry is keyed on the interaction, so a retried completion pays at most once.
+func (r *ReferralRepository) CommissionStep(release EscrowStep, interactionID uint) EscrowStep {
+	return func(tx *gorm.DB) (*models.EscrowHold, error) {
+		hold, err := runEscrowStep(tx, release)
+		if err != nil || hold == nil {
+			return hold, err
+		}
+		var ref models.Referral
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("referred_user_id = ?", hold.CompanionUserID).Limit(1).Find(&ref).Error; err != nil {
+			return hold, err
+		}
+		if ref.ID == 0 || ref.CompletedCount >= domain.ReferralMaxTransactions {
+			return hold, nil
+		}
+		commission := int64(float64(hold.CompanionBaseCents()) * domain.ReferralCommissionRate)
+		if commission <= 0 {
+			return hold, nil
+		}
+		reference := fmt.Sprintf("ref_%d_interaction_%d", ref.ID, interactionID)
+		var n int64
+		if err := tx.Model(&models.LedgerEntry{}).Where("type = ? AND reference = ?", domain.WalletTxTypeReferralCommission, reference).
+			Count(&n).Error; err != nil || n > 0 {
+			return hold, err
+		}
+		if _, err := postEntry(tx, domain.WalletTxTypeReferralCommission, reference, []LedgerLeg{
+			{Account: domain.LedgerAccountReferralPayable, AmountCents: -commission},
+			{Account: domain.LedgerAccountClientBalance, UserID: ref.ReferrerID, AmountCents: commission},
+		}, true); err != nil {
+			return hold, err
+		}
+		return hold, tx.Model(&ref).UpdateColumn("completed_count", gorm.Expr("completed_count + 1")).Error
+	}
+}
+
 // ListByReferrerID returns all referrals created by the given referrer, with referred user preloaded.
 func (r *ReferralRepository) ListByReferrerID(referrerID uint, limit, offset int) ([]models.Referral, error) {
 	var list []models.Referral
@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+
+	"lusty/internal/database/databasetest"
+	"lusty/internal/domain"
+	"lusty/internal/models"
+)
+
+// TestCommissionStepPaysOnceWithRelease checks the referrer's commission is posted in the completion that
+// releases escrow, counted against the referral, and not paid again when the completion is retried.
+func TestCommissionStepPaysOnceWithRelease(t *testing.T) {
+	db := databasetest.New(t)
+	interactions, escrow, referrals := NewInteractionRepository(db), NewEscrowRepository(db), NewReferralRepository(db)
+	ir := seedPaidInteraction(t, db, domain.RequestStatusAccepted, 120_000)
+	ref := &models.Referral{ReferrerID: 50, ReferredUserID: 100}
+	if err := referrals.CreateReferral(ref); err != nil {
+		t.Fatalf("create referral: %v", err)
+	}
+	if _, err := escrow.Hold(ir); err != nil {
+		t.Fatalf("hold: %v", err)
+	}
+
+	hold, err := interactions.TransitionSettling(ir, nil, transitionTo(ir, domain.RequestStatusCompleted),
+		referrals.CommissionStep(escrow.ReleaseStep(ir, nil), ir.ID))
+	if err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	commission := int64(float64(hold.CompanionBaseCents()) * domain.ReferralCommissionRate)
+	if got := clientBalance(t, db, 50); got != commission {
+		t.Fatalf("referrer balance = %d, want %d", got, commission)
+	}
+
+	// Release is idempotent, so a retried completion gets this far again; the commission is not paid twice
+	_, _ = interactions.TransitionSettling(ir, nil, transitionTo(ir, domain.RequestStatusCompleted),
+		referrals.CommissionStep(escrow.ReleaseStep(ir, nil), ir.ID))
+	if got := clientBalance(t, db, 50); got != commission {
+		t.Fatalf("referrer balance after retry = %d, want %d", got, commission)
+	}
+	stored, _ := referrals.GetReferralByReferredUserID(100)
+	if stored.CompletedCount != 1 {
+		t.Fatalf("completed count = %d, want 1", stored.CompletedCount)
+	}
+}
+
+// TestCommissionStepRollsBack checks no commission is paid when the release it wraps fails.
+func TestCommissionStepRollsBack(t *testing.T) {
+	db := databasetest.New(t)
+	interactions, escrow, referrals := NewInteractionRepository(db), NewEscrowRepository(db), NewReferralRepository(db)
+	ir := seedPaidInteraction(t, db, domain.RequestStatusAccepted, 120_000)
+	if err := referrals.CreateReferral(&models.Referral{ReferrerID: 50, ReferredUserID: 100}); err != nil {
+		t.Fatalf("create referral: %v", err)
+	}
+	if _, err := escrow.Refund(ir, "refunded elsewhere", nil); err != nil {
+		t.Fatalf("refund: %v", err)
+	}
+
+	_, err := interactions.TransitionSettling(ir, nil, transitionTo(ir, domain.RequestStatusCompleted),
+		referrals.CommissionStep(escrow.ReleaseStep(ir, nil), ir.ID))
+	if !errors.Is(err, ErrEscrowSettled) {
+		t.Fatalf("complete: err = %v, want ErrEscrowSettled", err)
+	}
+	if _, err := NewWalletRepository(db).GetByUserID(50); err == nil {
+		if got := clientBalance(t, db, 50); got != 0 {
+			t.Fatalf("referrer balance = %d, want 0", got)
+		}
+	}
+	stored, _ := referrals.GetReferralByReferredUserID(100)
+	if stored.CompletedCount != 0 {
+		t.Fatalf("completed count = %d, want 0", stored.CompletedCount)
+	}
+}
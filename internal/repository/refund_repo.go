@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"lusty/internal/domain"
+	"lusty/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrRefundOverCap is returned by CreateWithinCap when the refund is more than what is left to refund.
+var ErrRefundOverCap = errors.New("refund exceeds what is left of the payment")
+
+type RefundRepository struct {
+	db *gorm.DB
+}
+
+func NewRefundRepository(db *gorm.DB) *RefundRepository {
+	return &RefundRepository{db: db}
+}
+
+func (r *RefundRepository) Create(rf *models.Refund) error {
+	return r.db.Create(rf).Error
+}
+
+func (r *RefundRepository) GetByReference(ref string) (*models.Refund, error) {
+	var rf models.Refund
+	err := r.db.Where("reference = ?", ref).First(&rf).Error
+	if err != nil {
+		return nil, err
+	}
+	return &rf, nil
+}
+
+// CreateWithinCap inserts rf while holding the payment row lock, so refunds of one payment are checked one
+// after another and cannot together exceed it. capFn gets what is already refunded (in total and to the
+// source) and returns the most rf may be for; an AmountCents of 0 takes all of it. Returns
+// ErrRefundOverCap if rf does not fit.
+func (r *RefundRepository) CreateWithinCap(rf *models.Refund, capFn func(total, toSource int64) int64) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var p models.Payment
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&p, rf.PaymentID).Error; err != nil {
+			return err
+		}
+		total, toSource, err := sumByPaymentID(tx, rf.PaymentID)
+		if err != nil {
+			return err
+		}
+		limit := capFn(total, toSource)
+		if rf.AmountCents == 0 {
+			rf.AmountCents = limit
+		}
+		if rf.AmountCents <= 0 || rf.AmountCents > limit {
+			return ErrRefundOverCap
+		}
+		return tx.Create(rf).Error
+	})
+}
+
+// SumByPaymentID returns how much of a payment is refunded or being refunded (failed refunds excluded),
+// in total and to the payment source.
+func (r *RefundRepository) SumByPaymentID(paymentID uint) (total, toSource int64, err error) {
+	return sumByPaymentID(r.db, paymentID)
+}
+
+func sumByPaymentID(db *gorm.DB, paymentID uint) (total, toSource int64, err error) {
+	var rows []models.Refund
+	err = db.Select("amount_cents", "destination").
+		Where("payment_id = ? AND status <> ?", paymentID, domain.RefundStatusFailed).Find(&rows).Error
+	for _, rf := range rows {
+		total += rf.AmountCents
+		if rf.Destination == domain.RefundDestinationSource {
+			toSource += rf.AmountCents
+		}
+	}
+	return total, toSource, err
+}
+
+// UpdateStatusIf moves a refund between statuses only if it still has the from status. Returns false if
+// another writer (callback retry, admin) got there first.
+func (r *RefundRepository) UpdateStatusIf(id uint, from, to string, fields map[string]interface{}) (bool, error) {
+	updates := map[string]interface{}{"status": to, "updated_at": time.Now()}
+	for k, v := range fields {
+		updates[k] = v
+	}
+	res := r.db.Model(&models.Refund{}).Where("id = ? AND status = ?", id, from).Updates(updates)
+	return res.RowsAffected > 0, res.Error
+}
+
+// ListByUserID returns a client's refunds, newest first.
+func (r *RefundRepository) ListByUserID(userID uint, limit, offset int) ([]models.Refund, error) {
+	var list []models.Refund
+	err := r.db.Where("user_id = ?", userID).Order("id DESC").Limit(limit).Offset(offset).Find(&list).Error
+	return list, err
+}
+
+// List returns refunds for the admin panel, newest first, optionally filtered by status.
+func (r *RefundRepository) List(status string, page, limit int) ([]models.Refund, int64, error) {
+	q := r.db.Model(&models.Refund{})
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	var total int64
+	q.Count(&total)
+	var list []models.Refund
+	err := q.Order("id DESC").Limit(limit).Offset((page - 1) * limit).Find(&list).Error
+	return list, total, err
+}
+
+// Settle moves a refund that is still in flight (PENDING or PROCESSING) to a final status. Returns false if
+// it was already final.
+func (r *RefundRepository) Settle(id uint, to string, fields map[string]interface{}) (bool, error) {
+	updates := map[string]interface{}{"status": to, "updated_at": time.Now()}
+	for k, v := range fields {
+		updates[k] = v
+	}
+	res := r.db.Model(&models.Refund{}).
+		Where("id = ? AND status IN ?", id, []string{domain.RefundStatusPending, domain.RefundStatusProcessing}).
+		Updates(updates)
+	return res.RowsAffected > 0, res.Error
+}
@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"time"
+
+	"lusty/internal/domain"
+	"lusty/internal/models"
+
+	"gorm.io/gorm"
+)
+
+type ReviewRepository struct {
+	db *gorm.DB
+}
+
+func NewReviewRepository(db *gorm.DB) *ReviewRepository {
+	return &ReviewRepository{db: db}
+}
+
+func (r *ReviewRepository) Create(rv *models.Review) error {
+	return r.db.Create(rv).Error
+}
+
+func (r *ReviewRepository) GetByID(id uint) (*models.Review, error) {
+	var rv models.Review
+	err := r.db.First(&rv, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &rv, nil
+}
+
+func (r *ReviewRepository) GetByInteractionAndReviewer(interactionID, reviewerID uint) (*models.Review, error) {
+	var rv models.Review
+	err := r.db.Where("interaction_id = ? AND reviewer_id = ?", interactionID, reviewerID).First(&rv).Error
+	if err != nil {
+		return nil, err
+	}
+	return &rv, nil
+}
+
+func (r *ReviewRepository) ListByInteractionID(interactionID uint) ([]models.Review, error) {
+	var list []models.Review
+	err := r.db.Where("interaction_id = ?", interactionID).Order("id ASC").Find(&list).Error
+	return list, err
+}
+
+// ListPublishedForCompanion returns the published client reviews of a companion profile, newest first.
+func (r *ReviewRepository) ListPublishedForCompanion(companionID uint, limit, offset int) ([]models.Review, error) {
+	var list []models.Review
+	err := r.db.Where("companion_id = ? AND reviewer_role = ? AND status = ?", companionID, domain.RoleClient, domain.ReviewStatusPublished).
+		Order("created_at DESC").Limit(limit).Offset(offset).Find(&list).Error
+	return list, err
+}
+
+// Edit changes the rating and comment if the review is still editable at now. Returns false if it was not.
+func (r *ReviewRepository) Edit(id uint, rating int, comment, status, note string, now time.Time) (bool, error) {
+	res := r.db.Model(&models.Review{}).Where("id = ? AND editable_until > ?", id, now).Updates(map[string]interface{}{
+		"rating":          rating,
+		"comment":         comment,
+		"status":          status,
+		"moderation_note": note,
+		"updated_at":      now,
+	})
+	return res.RowsAffected > 0, res.Error
+}
+
+// SetStatus records an admin's moderation decision.
+func (r *ReviewRepository) SetStatus(id uint, status string, adminID uint, note string) error {
+	return r.db.Model(&models.Review{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":          status,
+		"moderation_note": note,
+		"moderated_by":    adminID,
+		"updated_at":      time.Now(),
+	}).Error
+}
+
+// List returns reviews for the admin moderation queue, optionally filtered by status. Oldest first.
+func (r *ReviewRepository) List(status string, page, limit int) ([]models.Review, int64, error) {
+	q := r.db.Model(&models.Review{})
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	var total int64
+	q.Count(&total)
+	var list []models.Review
+	err := q.Order("id ASC").Limit(limit).Offset((page - 1) * limit).Find(&list).Error
+	return list, total, err
+}
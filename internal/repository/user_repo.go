@@ -1,6 +1,9 @@
 package repository
 
 import (
+	"math"
+
+	"lusty/internal/domain"
 	"lusty/internal/models"
 
 	"gorm.io/gorm"
@@ -66,3 +69,55 @@ func (r *UserRepository) GetByAppleID(appleID string) (*models.User, error) {
 func (r *UserRepository) Update(u *models.User) error {
 	return r.db.Save(u).Error
 }
+
+// RefreshClientReliability recomputes a client's rating from published companion reviews and their
+// reliability score: the mean of the rating scaled to 0-100 and the share of accepted sessions they did not
+// cancel. The score stays nil until the client has a review or a finished session.
+func (r *UserRepository) RefreshClientReliability(userID uint) error {
+	var agg struct {
+		Avg   float64
+		Count int
+	}
+	err := r.db.Table("reviews").
+		Select("COALESCE(AVG(rating), 0) AS avg, COUNT(*) AS count").
+		Where("reviewee_id = ? AND reviewer_role = ? AND status = ?", userID, domain.RoleCompanion, domain.ReviewStatusPublished).
+		Scan(&agg).Error
+	if err != nil {
+		return err
+	}
+	var completed, lateCancels int64
+	if err := r.db.Model(&models.InteractionRequest{}).
+		Where("client_id = ? AND status = ?", userID, domain.RequestStatusCompleted).
+		Count(&completed).Error; err != nil {
+		return err
+	}
+	if err := r.db.Table("interaction_transitions t").
+		Joins("INNER JOIN interaction_requests ir ON ir.id = t.interaction_id").
+		Where("ir.client_id = ? AND t.actor_id = ir.client_id AND t.from_status = ? AND t.to_status = ?",
+			userID, domain.RequestStatusAccepted, domain.RequestStatusCancelled).
+		Count(&lateCancels).Error; err != nil {
+		return err
+	}
+
+	var parts []float64
+	if agg.Count > 0 {
+		parts = append(parts, (agg.Avg-1)/4*100)
+	}
+	if completed+lateCancels > 0 {
+		parts = append(parts, float64(completed)/float64(completed+lateCancels)*100)
+	}
+	var score *float64
+	if len(parts) > 0 {
+		sum := 0.0
+		for _, p := range parts {
+			sum += p
+		}
+		v := math.Round(sum/float64(len(parts))*10) / 10
+		score = &v
+	}
+	return r.db.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"client_rating_avg":   math.Round(agg.Avg*100) / 100,
+		"client_rating_count": agg.Count,
+		"reliability_score":   score,
+	}).Error
+}
@@ -0,0 +1,263 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"lusty/internal/domain"
+	"lusty/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrVideoCallEnded      = errors.New("video call already ended")
+	ErrVideoCallInProgress = errors.New("a video call is already in progress for this interaction")
+	ErrVideoCallNotRinging = errors.New("video call is no longer ringing")
+)
+
+// VideoCallRepository stores metered video calls and moves their money: blocks pre-authorized from the
+// client's wallet into escrow, and the settlement that pays the companion for the time used.
+type VideoCallRepository struct {
+	db *gorm.DB
+}
+
+func NewVideoCallRepository(db *gorm.DB) *VideoCallRepository {
+	return &VideoCallRepository{db: db}
+}
+
+// CreateRinging records a new call invite. Returns ErrVideoCallInProgress if the interaction already has a
+// RINGING or ACTIVE call; both peers may call at the same moment and only one insert wins.
+func (r *VideoCallRepository) CreateRinging(call *models.VideoCall) error {
+	call.ActiveInteractionID = &call.InteractionID
+	call.Status = domain.VideoCallStatusRinging
+	res := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(call)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrVideoCallInProgress
+	}
+	return nil
+}
+
+func (r *VideoCallRepository) GetByID(id uint) (*models.VideoCall, error) {
+	var call models.VideoCall
+	if err := r.db.First(&call, id).Error; err != nil {
+		return nil, err
+	}
+	return &call, nil
+}
+
+// ListByInteractionID returns the interaction's calls, newest first.
+func (r *VideoCallRepository) ListByInteractionID(interactionID uint) ([]models.VideoCall, error) {
+	var list []models.VideoCall
+	err := r.db.Where("interaction_id = ?", interactionID).Order("id DESC").Find(&list).Error
+	return list, err
+}
+
+// ListStale returns RINGING calls invited before before, and ACTIVE calls answered before before that neither
+// side has sent a heartbeat for since.
+func (r *VideoCallRepository) ListStale(before time.Time, limit int) ([]models.VideoCall, error) {
+	var list []models.VideoCall
+	err := r.db.Where("status IN ? AND COALESCE(answered_at, started_at) < ?",
+		[]string{domain.VideoCallStatusRinging, domain.VideoCallStatusActive}, before).
+		Where("(client_heartbeat_at IS NULL OR client_heartbeat_at < ?)", before).
+		Where("(companion_heartbeat_at IS NULL OR companion_heartbeat_at < ?)", before).
+		Order("id ASC").Limit(limit).Find(&list).Error
+	return list, err
+}
+
+// GetLive returns the interaction's RINGING or ACTIVE call, or nil if there is none.
+func (r *VideoCallRepository) GetLive(interactionID uint) (*models.VideoCall, error) {
+	var call models.VideoCall
+	err := r.db.Where("active_interaction_id = ?", interactionID).Limit(1).Find(&call).Error
+	if err != nil || call.ID == 0 {
+		return nil, err
+	}
+	return &call, nil
+}
+
+// Heartbeat records that userID is still on the interaction's ACTIVE call at now; it returns nil if there is
+// no such call. Time counts as connected only while both sides have sent a heartbeat within timeout, and at
+// most timeout is counted between two heartbeats, so a call that drops is not billed for the gap.
+func (r *VideoCallRepository) Heartbeat(interactionID, userID uint, now time.Time, timeout time.Duration) (*models.VideoCall, error) {
+	var call models.VideoCall
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("active_interaction_id = ?", interactionID).
+			Limit(1).Find(&call).Error; err != nil || call.ID == 0 {
+			return err
+		}
+		if call.Status != domain.VideoCallStatusActive {
+			call = models.VideoCall{}
+			return nil
+		}
+		switch userID {
+		case call.ClientID:
+			call.ClientHeartbeatAt = &now
+		case call.CompanionUserID:
+			call.CompanionHeartbeatAt = &now
+		default:
+			return nil
+		}
+		alive := func(t *time.Time) bool { return t != nil && now.Sub(*t) <= timeout }
+		switch {
+		case !alive(call.ClientHeartbeatAt) || !alive(call.CompanionHeartbeatAt):
+			call.MeteredAt = nil
+		case call.MeteredAt == nil:
+			call.MeteredAt = &now
+		default:
+			elapsed := now.Sub(*call.MeteredAt)
+			if elapsed > timeout {
+				elapsed = timeout
+			}
+			secs := int64(elapsed / time.Second)
+			// Carry the fraction of a second over to the next heartbeat
+			meteredAt := call.MeteredAt.Add(time.Duration(secs) * time.Second)
+			if now.Sub(meteredAt) > time.Second {
+				meteredAt = now
+			}
+			call.ConnectedSeconds += secs
+			call.MeteredAt = &meteredAt
+		}
+		return tx.Save(&call).Error
+	})
+	if err != nil || call.ID == 0 {
+		return nil, err
+	}
+	return &call, nil
+}
+
+// Answer moves a RINGING call to ACTIVE, billed at ratePer5MinCents from now on. Returns
+// ErrVideoCallNotRinging if it was already answered or closed.
+func (r *VideoCallRepository) Answer(id uint, ratePer5MinCents int64) (*models.VideoCall, error) {
+	var call models.VideoCall
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&call, id).Error; err != nil {
+			return err
+		}
+		if call.Status != domain.VideoCallStatusRinging {
+			return ErrVideoCallNotRinging
+		}
+		now := time.Now()
+		call.Status = domain.VideoCallStatusActive
+		call.RatePer5MinCents = ratePer5MinCents
+		call.AnsweredAt = &now
+		return tx.Save(&call).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &call, nil
+}
+
+// CloseUnanswered moves a RINGING call to status (MISSED, DECLINED or CANCELLED). closed reports whether this
+// call closed it.
+func (r *VideoCallRepository) CloseUnanswered(id uint, status string) (*models.VideoCall, bool, error) {
+	var call models.VideoCall
+	closed := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&call, id).Error; err != nil {
+			return err
+		}
+		if call.Status != domain.VideoCallStatusRinging {
+			return nil
+		}
+		now := time.Now()
+		call.Status = status
+		call.ActiveInteractionID = nil
+		call.EndedAt = &now
+		closed = true
+		return tx.Save(&call).Error
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return &call, closed, nil
+}
+
+// Authorize reserves one more block of the call from the client's wallet into escrow. Returns
+// ErrInsufficientBalance if the wallet cannot cover it and ErrVideoCallEnded if the call is over.
+func (r *VideoCallRepository) Authorize(id uint) (*models.VideoCall, error) {
+	var call models.VideoCall
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&call, id).Error; err != nil {
+			return err
+		}
+		if call.Status != domain.VideoCallStatusActive {
+			return ErrVideoCallEnded
+		}
+		block := call.AuthorizedCents/call.RatePer5MinCents + 1
+		if _, err := postEntry(tx, domain.WalletTxTypeVideoPreauth, fmt.Sprintf("video_call_%d_%d", call.ID, block), []LedgerLeg{
+			{Account: domain.LedgerAccountClientBalance, UserID: call.ClientID, AmountCents: -call.RatePer5MinCents},
+			{Account: domain.LedgerAccountEscrow, AmountCents: call.RatePer5MinCents},
+		}, true); err != nil {
+			return err
+		}
+		call.AuthorizedCents += call.RatePer5MinCents
+		return tx.Save(&call).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &call, nil
+}
+
+// MarkLowFundsWarned records the low-funds warning. Returns false if the call was already warned.
+func (r *VideoCallRepository) MarkLowFundsWarned(id uint, at time.Time) (bool, error) {
+	res := r.db.Model(&models.VideoCall{}).Where("id = ? AND low_funds_warned_at IS NULL", id).Update("low_funds_warned_at", at)
+	return res.RowsAffected > 0, res.Error
+}
+
+// ClearLowFundsWarning lets the call be warned again once more funds were authorized.
+func (r *VideoCallRepository) ClearLowFundsWarning(id uint) error {
+	return r.db.Model(&models.VideoCall{}).Where("id = ?", id).Update("low_funds_warned_at", nil).Error
+}
+
+// Settle ends an ACTIVE call and pays for it in one ledger entry: every started block of connected time is
+// charged (never more than was authorized), the companion gets 95% of the charge and the platform the rest,
+// and whatever was authorized but not used goes back to the client's wallet. ended reports whether this
+// call ended it; settling an ended call returns it unchanged.
+func (r *VideoCallRepository) Settle(id uint, reason string) (*models.VideoCall, bool, error) {
+	var call models.VideoCall
+	ended := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&call, id).Error; err != nil {
+			return err
+		}
+		if call.Status != domain.VideoCallStatusActive {
+			return nil
+		}
+		if call.RatePer5MinCents > 0 {
+			blocks := (call.ConnectedSeconds + domain.VideoBlockSeconds - 1) / domain.VideoBlockSeconds
+			call.ChargedCents = blocks * call.RatePer5MinCents
+			if call.ChargedCents > call.AuthorizedCents {
+				call.ChargedCents = call.AuthorizedCents
+			}
+			call.CompanionCents = domain.CompanionPayout(call.ChargedCents)
+		}
+		if call.AuthorizedCents > 0 {
+			if _, err := postEntry(tx, domain.WalletTxTypeVideoCall, fmt.Sprintf("video_call_%d", call.ID), []LedgerLeg{
+				{Account: domain.LedgerAccountEscrow, AmountCents: -call.AuthorizedCents},
+				{Account: domain.LedgerAccountClientBalance, UserID: call.ClientID, AmountCents: call.AuthorizedCents - call.ChargedCents},
+				{Account: domain.LedgerAccountCompanionWithdrawable, UserID: call.CompanionUserID, AmountCents: call.CompanionCents},
+				{Account: domain.LedgerAccountPlatformRevenue, AmountCents: call.ChargedCents - call.CompanionCents},
+			}, true); err != nil {
+				return err
+			}
+		}
+		now := time.Now()
+		call.Status = domain.VideoCallStatusEnded
+		call.ActiveInteractionID = nil
+		call.EndReason = reason
+		call.EndedAt = &now
+		ended = true
+		return tx.Save(&call).Error
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return &call, ended, nil
+}
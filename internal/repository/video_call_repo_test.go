@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"lusty/internal/database/databasetest"
+	"lusty/internal/domain"
+	"lusty/internal/models"
+)
+
+// TestVideoCallMeteringAndSettle checks a call is metered only while both sides send heartbeats, is billed
+// per started block out of what was pre-authorized, and returns the unused authorization to the client.
+func TestVideoCallMeteringAndSettle(t *testing.T) {
+	db := databasetest.New(t)
+	calls := NewVideoCallRepository(db)
+	wallets := NewWalletRepository(db)
+	const client, companion, rate = uint(1), uint(100), int64(10_000)
+	if err := wallets.Credit(client, 25_000, domain.LedgerAccountProviderClearing, domain.WalletTxTypeRefund, "seed"); err != nil {
+		t.Fatalf("fund wallet: %v", err)
+	}
+	call := &models.VideoCall{InteractionID: 7, CallerID: client, ClientID: client, CompanionID: 1, CompanionUserID: companion,
+		StartedAt: time.Now()}
+	if err := calls.CreateRinging(call); err != nil {
+		t.Fatalf("invite: %v", err)
+	}
+	if err := calls.CreateRinging(&models.VideoCall{InteractionID: 7, CallerID: companion, ClientID: client, CompanionUserID: companion,
+		StartedAt: time.Now()}); !errors.Is(err, ErrVideoCallInProgress) {
+		t.Fatalf("second invite: err = %v, want ErrVideoCallInProgress", err)
+	}
+	if _, err := calls.Answer(call.ID, rate); err != nil {
+		t.Fatalf("answer: %v", err)
+	}
+	for range 2 {
+		if _, err := calls.Authorize(call.ID); err != nil {
+			t.Fatalf("authorize: %v", err)
+		}
+	}
+	if _, err := calls.Authorize(call.ID); !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("third block: err = %v, want ErrInsufficientBalance", err)
+	}
+
+	// Six minutes of heartbeats from both sides, ten seconds apart
+	t0 := time.Now()
+	beat := func(user uint, at time.Duration) *models.VideoCall {
+		t.Helper()
+		c, err := calls.Heartbeat(7, user, t0.Add(at), 30*time.Second)
+		if err != nil || c == nil {
+			t.Fatalf("heartbeat: call=%v err=%v", c, err)
+		}
+		return c
+	}
+	for s := time.Duration(0); s <= 360*time.Second; s += 10 * time.Second {
+		beat(client, s)
+		beat(companion, s)
+	}
+	// The companion drops: once her last heartbeat is older than the timeout, the client's alone add nothing
+	beat(client, 400*time.Second)
+	c := beat(client, 420*time.Second)
+	if c.ConnectedSeconds != 360 {
+		t.Fatalf("connected = %ds, want 360", c.ConnectedSeconds)
+	}
+
+	settled, ended, err := calls.Settle(call.ID, "hangup")
+	if err != nil || !ended {
+		t.Fatalf("settle: ended=%v err=%v", ended, err)
+	}
+	if settled.ChargedCents != 2*rate || settled.CompanionCents != domain.CompanionPayout(2*rate) {
+		t.Fatalf("call = %+v, want two blocks charged", settled)
+	}
+	if _, ended, err := calls.Settle(call.ID, "hangup"); err != nil || ended {
+		t.Fatalf("second settle: ended=%v err=%v, want a no-op", ended, err)
+	}
+	if got := clientBalance(t, db, client); got != 5_000 {
+		t.Fatalf("client balance = %d, want 5000", got)
+	}
+	w, _ := wallets.GetByUserID(companion)
+	if w.WithdrawableCents != settled.CompanionCents {
+		t.Fatalf("companion withdrawable = %d, want %d", w.WithdrawableCents, settled.CompanionCents)
+	}
+	if drift, unbalanced, err := wallets.CheckIntegrity(); err != nil || len(drift) > 0 || len(unbalanced) > 0 {
+		t.Fatalf("ledger out of balance: drift=%+v unbalanced=%v err=%v", drift, unbalanced, err)
+	}
+}
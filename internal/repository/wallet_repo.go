@@ -2,12 +2,29 @@ package repository
 
 import (
 	"errors"
+	"fmt"
+	"sort"
+
+	"lusty/internal/domain"
 	"lusty/internal/models"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-var ErrInsufficientBalance = errors.New("insufficient wallet balance")
+var (
+	ErrInsufficientBalance = errors.New("insufficient wallet balance")
+	ErrUnbalancedEntry     = errors.New("ledger entry does not balance")
+	ErrWalletConflict      = errors.New("wallet was modified concurrently")
+)
+
+// LedgerLeg is one side of a ledger entry. AmountCents is signed: positive increases the account.
+// UserID is the owner for per-user accounts and 0 for platform accounts.
+type LedgerLeg struct {
+	Account     string
+	UserID      uint
+	AmountCents int64
+}
 
 type WalletRepository struct {
 	db *gorm.DB
@@ -31,69 +48,352 @@ func (r *WalletRepository) GetOrCreate(userID uint) (*models.Wallet, error) {
 	if err == nil {
 		return w, nil
 	}
-	w = &models.Wallet{UserID: userID, BalanceCents: 0, WithdrawableCents: 0, Currency: "KES"}
-	if err := r.db.Create(w).Error; err != nil {
+	// A concurrent request may create the same wallet; ignore the duplicate and re-read.
+	if err := r.db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.Wallet{UserID: userID, Currency: "KES"}).Error; err != nil {
 		return nil, err
 	}
-	return w, nil
+	return r.GetByUserID(userID)
 }
 
-func (r *WalletRepository) Credit(userID uint, amountCents int64) error {
-	w, err := r.GetOrCreate(userID)
-	if err != nil {
+func (r *WalletRepository) ListTransactionsByUserID(userID uint, limit, offset int) ([]models.WalletTransaction, error) {
+	var list []models.WalletTransaction
+	err := r.db.Where("user_id = ?", userID).Order("created_at DESC").Limit(limit).Offset(offset).Find(&list).Error
+	return list, err
+}
+
+// Post writes one balanced ledger entry: all legs are applied in a single DB transaction with the
+// affected accounts locked, the owning users' Wallet rows are updated to match, and a WalletTransaction
+// is recorded for every user-owned leg. Returns ErrInsufficientBalance if a user account would go negative.
+func (r *WalletRepository) Post(entryType, reference string, legs ...LedgerLeg) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		_, err := postEntry(tx, entryType, reference, legs, true)
 		return err
+	})
+}
+
+// HasEntry reports whether a ledger entry of entryType with reference was already posted. Webhook
+// processors use it to make money movements safe to retry.
+func (r *WalletRepository) HasEntry(entryType, reference string) (bool, error) {
+	var c int64
+	err := r.db.Model(&models.LedgerEntry{}).Where("type = ? AND reference = ?", entryType, reference).Count(&c).Error
+	return c > 0, err
+}
+
+// Credit moves amountCents from a platform account into the user's spendable balance.
+func (r *WalletRepository) Credit(userID uint, amountCents int64, from, txType, reference string) error {
+	return r.Post(txType, reference,
+		LedgerLeg{Account: from, AmountCents: -amountCents},
+		LedgerLeg{Account: domain.LedgerAccountClientBalance, UserID: userID, AmountCents: amountCents},
+	)
+}
+
+// Debit moves amountCents out of the user's spendable balance into a platform account.
+func (r *WalletRepository) Debit(userID uint, amountCents int64, to, txType, reference string) error {
+	return r.Post(txType, reference,
+		LedgerLeg{Account: domain.LedgerAccountClientBalance, UserID: userID, AmountCents: -amountCents},
+		LedgerLeg{Account: to, AmountCents: amountCents},
+	)
+}
+
+// CreditWithdrawable adds to companion's withdrawable balance from a platform account.
+func (r *WalletRepository) CreditWithdrawable(userID uint, amountCents int64, from, txType, reference string) error {
+	return r.Post(txType, reference,
+		LedgerLeg{Account: from, AmountCents: -amountCents},
+		LedgerLeg{Account: domain.LedgerAccountCompanionWithdrawable, UserID: userID, AmountCents: amountCents},
+	)
+}
+
+// DebitWithdrawable deducts from withdrawable (when initiating withdrawal) into a platform account.
+func (r *WalletRepository) DebitWithdrawable(userID uint, amountCents int64, to, txType, reference string) error {
+	return r.Post(txType, reference,
+		LedgerLeg{Account: domain.LedgerAccountCompanionWithdrawable, UserID: userID, AmountCents: -amountCents},
+		LedgerLeg{Account: to, AmountCents: amountCents},
+	)
+}
+
+// postEntry applies legs inside tx. Legs on the same account are merged; the owners' Wallet rows and then
+// the ledger accounts are locked in a fixed order so concurrent entries serialize without deadlocking.
+// project=false skips the Wallet/history update (used when seeding the ledger from balances that already
+// exist on the wallet).
+func postEntry(tx *gorm.DB, entryType, reference string, legs []LedgerLeg, project bool) (uint, error) {
+	type accountKey struct {
+		account string
+		userID  uint
+	}
+	merged := make(map[accountKey]int64, len(legs))
+	var sum int64
+	for _, l := range legs {
+		if !domain.IsUserLedgerAccount(l.Account) {
+			l.UserID = 0
+		} else if l.UserID == 0 {
+			return 0, fmt.Errorf("ledger: account %s requires a user", l.Account)
+		}
+		merged[accountKey{l.Account, l.UserID}] += l.AmountCents
+		sum += l.AmountCents
+	}
+	if sum != 0 {
+		return 0, ErrUnbalancedEntry
+	}
+	keys := make([]accountKey, 0, len(merged))
+	for k, amt := range merged {
+		if amt != 0 {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].account != keys[j].account {
+			return keys[i].account < keys[j].account
+		}
+		return keys[i].userID < keys[j].userID
+	})
+
+	wallets := make(map[uint]*models.Wallet)
+	if project {
+		var userIDs []uint
+		for _, k := range keys {
+			if k.userID != 0 && wallets[k.userID] == nil {
+				wallets[k.userID] = &models.Wallet{}
+				userIDs = append(userIDs, k.userID)
+			}
+		}
+		sort.Slice(userIDs, func(i, j int) bool { return userIDs[i] < userIDs[j] })
+		for _, uid := range userIDs {
+			w, err := lockWallet(tx, uid)
+			if err != nil {
+				return 0, err
+			}
+			wallets[uid] = w
+		}
+	}
+
+	entry := &models.LedgerEntry{Type: entryType, Reference: reference}
+	if err := tx.Create(entry).Error; err != nil {
+		return 0, err
 	}
-	w.BalanceCents += amountCents
-	return r.db.Model(w).Update("balance_cents", w.BalanceCents).Error
+	for _, k := range keys {
+		amt := merged[k]
+		acct, err := lockAccount(tx, k.account, k.userID)
+		if err != nil {
+			return 0, err
+		}
+		if k.userID != 0 && acct.BalanceCents+amt < 0 {
+			return 0, ErrInsufficientBalance
+		}
+		if err := tx.Model(acct).UpdateColumn("balance_cents", gorm.Expr("balance_cents + ?", amt)).Error; err != nil {
+			return 0, err
+		}
+		if err := tx.Create(&models.LedgerPosting{EntryID: entry.ID, AccountID: acct.ID, AmountCents: amt}).Error; err != nil {
+			return 0, err
+		}
+		if !project || k.userID == 0 {
+			continue
+		}
+		if err := projectOntoWallet(tx, wallets[k.userID], k.account, amt); err != nil {
+			return 0, err
+		}
+		if err := tx.Create(&models.WalletTransaction{
+			UserID:      k.userID,
+			AmountCents: amt,
+			Type:        entryType,
+			Reference:   reference,
+			Account:     k.account,
+			EntryID:     entry.ID,
+		}).Error; err != nil {
+			return 0, err
+		}
+	}
+	return entry.ID, nil
 }
 
-func (r *WalletRepository) RecordTransaction(userID uint, amountCents int64, txType, reference string) error {
-	return r.db.Create(&models.WalletTransaction{UserID: userID, AmountCents: amountCents, Type: txType, Reference: reference}).Error
+// lockAccount returns the ledger account locked FOR UPDATE, creating it on first use.
+func lockAccount(tx *gorm.DB, accountType string, userID uint) (*models.LedgerAccount, error) {
+	var a models.LedgerAccount
+	q := func() error {
+		return tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND type = ?", userID, accountType).Limit(1).Find(&a).Error
+	}
+	if err := q(); err != nil {
+		return nil, err
+	}
+	if a.ID != 0 {
+		return &a, nil
+	}
+	// Another transaction may create the same account concurrently; ignore the duplicate and re-read.
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.LedgerAccount{UserID: userID, Type: accountType, Currency: "KES"}).Error; err != nil {
+		return nil, err
+	}
+	if err := q(); err != nil {
+		return nil, err
+	}
+	if a.ID == 0 {
+		return nil, fmt.Errorf("ledger: account %s/%d not found after create", accountType, userID)
+	}
+	return &a, nil
 }
 
-func (r *WalletRepository) ListTransactionsByUserID(userID uint, limit, offset int) ([]models.WalletTransaction, error) {
-	var list []models.WalletTransaction
-	err := r.db.Where("user_id = ?", userID).Order("created_at DESC").Limit(limit).Offset(offset).Find(&list).Error
-	return list, err
+// walletColumn maps a per-user ledger account to the Wallet column that mirrors it.
+func walletColumn(accountType string) string {
+	switch accountType {
+	case domain.LedgerAccountCompanionPending:
+		return "pending_cents"
+	case domain.LedgerAccountCompanionWithdrawable:
+		return "withdrawable_cents"
+	default:
+		return "balance_cents"
+	}
 }
 
-// DebitWithdrawable deducts from withdrawable (when initiating withdrawal).
-func (r *WalletRepository) DebitWithdrawable(userID uint, amountCents int64) error {
-	w, err := r.GetByUserID(userID)
-	if err != nil {
-		return err
+// lockWallet returns the user's Wallet locked FOR UPDATE, creating it on first use.
+func lockWallet(tx *gorm.DB, userID uint) (*models.Wallet, error) {
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.Wallet{UserID: userID, Currency: "KES"}).Error; err != nil {
+		return nil, err
 	}
-	if w.WithdrawableCents < amountCents {
-		return ErrInsufficientBalance
+	var w models.Wallet
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("user_id = ?", userID).First(&w).Error; err != nil {
+		return nil, err
 	}
-	w.WithdrawableCents -= amountCents
-	if err := r.db.Model(w).Update("withdrawable_cents", w.WithdrawableCents).Error; err != nil {
-		return err
+	return &w, nil
+}
+
+// projectOntoWallet applies amountCents to the wallet column mirroring accountType as a single conditional
+// update: it only matches while the row still has the version we locked and the column stays non-negative.
+func projectOntoWallet(tx *gorm.DB, w *models.Wallet, accountType string, amountCents int64) error {
+	col := walletColumn(accountType)
+	res := tx.Model(&models.Wallet{}).
+		Where("id = ? AND version = ? AND "+col+" + ? >= 0", w.ID, w.Version, amountCents).
+		UpdateColumns(map[string]interface{}{
+			col:       gorm.Expr(col+" + ?", amountCents),
+			"version": gorm.Expr("version + 1"),
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		var current models.Wallet
+		if err := tx.Where("id = ?", w.ID).First(&current).Error; err != nil {
+			return err
+		}
+		if current.Version == w.Version {
+			return ErrInsufficientBalance
+		}
+		return ErrWalletConflict
 	}
-	return r.RecordTransaction(userID, -amountCents, "WITHDRAWAL", "")
+	w.Version++
+	return nil
 }
 
-// CreditWithdrawable adds to companion's withdrawable balance (when service is confirmed done).
-func (r *WalletRepository) CreditWithdrawable(userID uint, amountCents int64) error {
-	w, err := r.GetOrCreate(userID)
+// SeedOpeningBalances creates ledger accounts for wallets that predate the ledger, posting their current
+// balances against OPENING_BALANCE. Wallet rows are left untouched. Safe to run on every startup.
+func (r *WalletRepository) SeedOpeningBalances() error {
+	var wallets []models.Wallet
+	err := r.db.Where("NOT EXISTS (SELECT 1 FROM ledger_accounts la WHERE la.user_id = wallets.user_id)").
+		Where("balance_cents <> 0 OR pending_cents <> 0 OR withdrawable_cents <> 0").
+		Find(&wallets).Error
 	if err != nil {
 		return err
 	}
-	w.WithdrawableCents += amountCents
-	return r.db.Model(w).Updates(map[string]interface{}{"withdrawable_cents": w.WithdrawableCents, "updated_at": w.UpdatedAt}).Error
+	for _, w := range wallets {
+		legs := []LedgerLeg{
+			{Account: domain.LedgerAccountClientBalance, UserID: w.UserID, AmountCents: w.BalanceCents},
+			{Account: domain.LedgerAccountCompanionPending, UserID: w.UserID, AmountCents: w.PendingCents},
+			{Account: domain.LedgerAccountCompanionWithdrawable, UserID: w.UserID, AmountCents: w.WithdrawableCents},
+			{Account: domain.LedgerAccountOpeningBalance, AmountCents: -(w.BalanceCents + w.PendingCents + w.WithdrawableCents)},
+		}
+		ref := fmt.Sprintf("wallet_%d", w.ID)
+		if err := r.db.Transaction(func(tx *gorm.DB) error {
+			_, err := postEntry(tx, domain.WalletTxTypeOpeningBalance, ref, legs, false)
+			return err
+		}); err != nil {
+			return fmt.Errorf("opening balance for user %d: %w", w.UserID, err)
+		}
+	}
+	return nil
 }
 
-func (r *WalletRepository) Debit(userID uint, amountCents int64) error {
-	w, err := r.GetByUserID(userID)
+// GetPlatformBalance returns the balance of a platform (unowned) ledger account.
+func (r *WalletRepository) GetPlatformBalance(accountType string) (int64, error) {
+	var a models.LedgerAccount
+	err := r.db.Where("user_id = 0 AND type = ?", accountType).Limit(1).Find(&a).Error
+	return a.BalanceCents, err
+}
+
+// LedgerDrift is a wallet or account whose stored balance disagrees with the ledger postings.
+type LedgerDrift struct {
+	UserID        uint   `json:"user_id"`
+	Account       string `json:"account"`
+	StoredCents   int64  `json:"stored_cents"`
+	ExpectedCents int64  `json:"expected_cents"`
+}
+
+// CheckIntegrity compares every ledger account against the sum of its postings and every Wallet column
+// against its ledger account. It also returns the IDs of entries whose postings do not sum to zero.
+func (r *WalletRepository) CheckIntegrity() ([]LedgerDrift, []uint, error) {
+	var drift []LedgerDrift
+	var accounts []struct {
+		UserID       uint
+		Type         string
+		BalanceCents int64
+		PostedCents  int64
+	}
+	err := r.db.Table("ledger_accounts la").
+		Select("la.user_id, la.type, la.balance_cents, COALESCE(SUM(lp.amount_cents), 0) as posted_cents").
+		Joins("LEFT JOIN ledger_postings lp ON lp.account_id = la.id").
+		Group("la.id, la.user_id, la.type, la.balance_cents").
+		Having("la.balance_cents <> COALESCE(SUM(lp.amount_cents), 0)").
+		Scan(&accounts).Error
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	if w.BalanceCents < amountCents {
-		return ErrInsufficientBalance
+	for _, a := range accounts {
+		drift = append(drift, LedgerDrift{UserID: a.UserID, Account: a.Type, StoredCents: a.BalanceCents, ExpectedCents: a.PostedCents})
 	}
-	w.BalanceCents -= amountCents
-	if err := r.db.Model(w).Update("balance_cents", w.BalanceCents).Error; err != nil {
-		return err
+
+	var wallets []struct {
+		UserID             uint
+		BalanceCents       int64
+		PendingCents       int64
+		WithdrawableCents  int64
+		LedgerBalance      int64
+		LedgerPending      int64
+		LedgerWithdrawable int64
+	}
+	err = r.db.Table("wallets w").
+		Select(`w.user_id, w.balance_cents, w.pending_cents, w.withdrawable_cents,
+			COALESCE(SUM(CASE WHEN la.type = ? THEN la.balance_cents END), 0) as ledger_balance,
+			COALESCE(SUM(CASE WHEN la.type = ? THEN la.balance_cents END), 0) as ledger_pending,
+			COALESCE(SUM(CASE WHEN la.type = ? THEN la.balance_cents END), 0) as ledger_withdrawable`,
+			domain.LedgerAccountClientBalance, domain.LedgerAccountCompanionPending, domain.LedgerAccountCompanionWithdrawable).
+		Joins("LEFT JOIN ledger_accounts la ON la.user_id = w.user_id").
+		Where("w.deleted_at IS NULL").
+		Group("w.id, w.user_id, w.balance_cents, w.pending_cents, w.withdrawable_cents").
+		Scan(&wallets).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, w := range wallets {
+		if w.BalanceCents != w.LedgerBalance {
+			drift = append(drift, LedgerDrift{UserID: w.UserID, Account: "wallet.balance_cents", StoredCents: w.BalanceCents, ExpectedCents: w.LedgerBalance})
+		}
+		if w.PendingCents != w.LedgerPending {
+			drift = append(drift, LedgerDrift{UserID: w.UserID, Account: "wallet.pending_cents", StoredCents: w.PendingCents, ExpectedCents: w.LedgerPending})
+		}
+		if w.WithdrawableCents != w.LedgerWithdrawable {
+			drift = append(drift, LedgerDrift{UserID: w.UserID, Account: "wallet.withdrawable_cents", StoredCents: w.WithdrawableCents, ExpectedCents: w.LedgerWithdrawable})
+		}
+	}
+
+	var unbalanced []uint
+	err = r.db.Model(&models.LedgerPosting{}).
+		Select("entry_id").Group("entry_id").Having("SUM(amount_cents) <> 0").
+		Pluck("entry_id", &unbalanced).Error
+	if err != nil {
+		return nil, nil, err
 	}
-	return r.RecordTransaction(userID, -amountCents, "DEBIT", "")
+	return drift, unbalanced, nil
 }
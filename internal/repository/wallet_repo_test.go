@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"lusty/internal/database/databasetest"
+	"lusty/internal/domain"
+
+	"gorm.io/gorm"
+)
+
+// TestWalletConcurrentMovements hammers one wallet with debits, credits and multi-leg entries from many
+// goroutines. Debits that would overdraw must fail with ErrInsufficientBalance, the balance must never go
+// negative, and afterwards the wallet, the ledger accounts and the postings must all agree.
+func TestWalletConcurrentMovements(t *testing.T) {
+	db := databasetest.New(t)
+	repo := NewWalletRepository(db)
+	const userID = 1
+	const opening = 10_000
+
+	if err := repo.Credit(userID, opening, domain.LedgerAccountProviderClearing, domain.WalletTxTypePayment, "opening"); err != nil {
+		t.Fatalf("opening credit: %v", err)
+	}
+
+	const workers = 16
+	const perWorker = 25
+	var debited, credited, insufficient atomic.Int64
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*perWorker)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				ref := fmt.Sprintf("w%d_%d", w, i)
+				var err error
+				switch i % 3 {
+				case 0:
+					err = repo.Credit(userID, 100, domain.LedgerAccountProviderClearing, domain.WalletTxTypeRefund, ref)
+					if err == nil {
+						credited.Add(100)
+					}
+				case 1:
+					err = repo.Debit(userID, 700, domain.LedgerAccountEscrow, domain.WalletTxTypeEscrowHold, ref)
+					if err == nil {
+						debited.Add(700)
+					}
+				default:
+					// Spend from the wallet, with part of it going to revenue, as one entry
+					err = repo.Post(domain.WalletTxTypeBoostPayment, ref,
+						LedgerLeg{Account: domain.LedgerAccountClientBalance, UserID: userID, AmountCents: -300},
+						LedgerLeg{Account: domain.LedgerAccountProviderClearing, AmountCents: 200},
+						LedgerLeg{Account: domain.LedgerAccountPlatformRevenue, AmountCents: 100},
+					)
+					if err == nil {
+						debited.Add(300)
+					}
+				}
+				if errors.Is(err, ErrInsufficientBalance) {
+					insufficient.Add(1)
+					continue
+				}
+				if err != nil {
+					errs <- fmt.Errorf("%s: %w", ref, err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if insufficient.Load() == 0 {
+		t.Fatalf("expected some debits to be refused for lack of funds; the test no longer exercises overdraft")
+	}
+
+	w, err := repo.GetByUserID(userID)
+	if err != nil {
+		t.Fatalf("get wallet: %v", err)
+	}
+	if w.BalanceCents < 0 {
+		t.Fatalf("balance went negative: %d", w.BalanceCents)
+	}
+	if want := opening + credited.Load() - debited.Load(); w.BalanceCents != want {
+		t.Fatalf("balance = %d, want %d (opening %d + credited %d - debited %d)", w.BalanceCents, want, opening, credited.Load(), debited.Load())
+	}
+
+	var history int64
+	if err := db.Raw("SELECT COALESCE(SUM(amount_cents), 0) FROM wallet_transactions WHERE user_id = ?", userID).Scan(&history).Error; err != nil {
+		t.Fatalf("sum history: %v", err)
+	}
+	if history != w.BalanceCents {
+		t.Fatalf("wallet history sums to %d, balance is %d", history, w.BalanceCents)
+	}
+
+	drift, unbalanced, err := repo.CheckIntegrity()
+	if err != nil {
+		t.Fatalf("check integrity: %v", err)
+	}
+	if len(drift) > 0 || len(unbalanced) > 0 {
+		t.Fatalf("ledger out of balance: drift=%+v unbalanced entries=%v", drift, unbalanced)
+	}
+}
+
+// TestWalletDebitOverdraft checks a single debit larger than the balance is refused and changes nothing.
+func TestWalletDebitOverdraft(t *testing.T) {
+	repo := NewWalletRepository(databasetest.New(t))
+	if err := repo.Credit(2, 500, domain.LedgerAccountProviderClearing, domain.WalletTxTypePayment, "p1"); err != nil {
+		t.Fatalf("credit: %v", err)
+	}
+	if err := repo.Debit(2, 501, domain.LedgerAccountEscrow, domain.WalletTxTypeEscrowHold, "h1"); !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("overdraft debit: err = %v, want ErrInsufficientBalance", err)
+	}
+	w, err := repo.GetByUserID(2)
+	if err != nil {
+		t.Fatalf("get wallet: %v", err)
+	}
+	if w.BalanceCents != 500 {
+		t.Fatalf("balance = %d after refused debit, want 500", w.BalanceCents)
+	}
+	if ok, _ := repo.HasEntry(domain.WalletTxTypeEscrowHold, "h1"); ok {
+		t.Fatalf("refused debit left a ledger entry")
+	}
+}
+
+// TestWalletStaleVersionRejected forces the case the row lock normally prevents: another writer bumps the
+// wallet's version between postEntry locking it and projecting onto it. The version-checked update must not
+// match, and the whole entry must be rejected with ErrWalletConflict and leave nothing behind.
+func TestWalletStaleVersionRejected(t *testing.T) {
+	db := databasetest.New(t)
+	repo := NewWalletRepository(db)
+	if err := repo.Credit(3, 1000, domain.LedgerAccountProviderClearing, domain.WalletTxTypePayment, "p1"); err != nil {
+		t.Fatalf("credit: %v", err)
+	}
+
+	var interfered atomic.Bool
+	if err := db.Callback().Update().Before("gorm:update").Register("test:stale_wallet", func(tx *gorm.DB) {
+		if tx.Statement.Table == "wallets" && interfered.CompareAndSwap(false, true) {
+			tx.Session(&gorm.Session{NewDB: true}).Exec("UPDATE wallets SET version = version + 1 WHERE user_id = ?", 3)
+		}
+	}); err != nil {
+		t.Fatalf("register callback: %v", err)
+	}
+	err := repo.Debit(3, 400, domain.LedgerAccountEscrow, domain.WalletTxTypeEscrowHold, "h1")
+	_ = db.Callback().Update().Remove("test:stale_wallet")
+	if !interfered.Load() {
+		t.Fatal("the wallet update never ran")
+	}
+	if !errors.Is(err, ErrWalletConflict) {
+		t.Fatalf("debit on a stale version: err = %v, want ErrWalletConflict", err)
+	}
+
+	w, err := repo.GetByUserID(3)
+	if err != nil {
+		t.Fatalf("get wallet: %v", err)
+	}
+	if w.BalanceCents != 1000 {
+		t.Fatalf("balance = %d after rejected debit, want 1000", w.BalanceCents)
+	}
+	if ok, _ := repo.HasEntry(domain.WalletTxTypeEscrowHold, "h1"); ok {
+		t.Fatal("rejected debit left a ledger entry")
+	}
+	if drift, unbalanced, err := repo.CheckIntegrity(); err != nil || len(drift) > 0 || len(unbalanced) > 0 {
+		t.Fatalf("ledger out of balance: drift=%+v unbalanced=%v err=%v", drift, unbalanced, err)
+	}
+	// With nobody interfering the same debit goes through
+	if err := repo.Debit(3, 400, domain.LedgerAccountEscrow, domain.WalletTxTypeEscrowHold, "h1"); err != nil {
+		t.Fatalf("retried debit: %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"time"
+
+	"lusty/internal/domain"
+	"lusty/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type WebhookEventRepository struct {
+	db *gorm.DB
+}
+
+func NewWebhookEventRepository(db *gorm.DB) *WebhookEventRepository {
+	return &WebhookEventRepository{db: db}
+}
+
+// Store inserts the event unless one with the same provider and key exists. Returns false for a duplicate.
+func (r *WebhookEventRepository) Store(ev *models.WebhookEvent) (bool, error) {
+	res := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(ev)
+	return res.RowsAffected > 0, res.Error
+}
+
+func (r *WebhookEventRepository) GetByID(id uint) (*models.WebhookEvent, error) {
+	var ev models.WebhookEvent
+	err := r.db.First(&ev, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &ev, nil
+}
+
+// ListDue returns IDs of events ready for an attempt: new or failed events whose next_attempt_at has
+// passed, and PROCESSING events whose worker lease expired (the process died mid-way).
+func (r *WebhookEventRepository) ListDue(now time.Time, limit int) ([]uint, error) {
+	var ids []uint
+	err := r.db.Model(&models.WebhookEvent{}).
+		Where("(status IN ? AND next_attempt_at <= ?) OR (status = ? AND locked_until < ?)",
+			[]string{domain.WebhookEventPending, domain.WebhookEventFailed}, now, domain.WebhookEventProcessing, now).
+		Order("id ASC").Limit(limit).Pluck("id", &ids).Error
+	return ids, err
+}
+
+// Claim leases a due event to the caller for lease and counts the attempt. Returns nil if another worker
+// holds it or it is not due.
+func (r *WebhookEventRepository) Claim(id uint, lease time.Duration) (*models.WebhookEvent, error) {
+	now := time.Now()
+	res := r.db.Model(&models.WebhookEvent{}).
+		Where("id = ? AND ((status IN ? AND next_attempt_at <= ?) OR (status = ? AND locked_until < ?))",
+			id, []string{domain.WebhookEventPending, domain.WebhookEventFailed}, now, domain.WebhookEventProcessing, now).
+		Updates(map[string]interface{}{
+			"status":       domain.WebhookEventProcessing,
+			"locked_until": now.Add(lease),
+			"attempts":     gorm.Expr("attempts + 1"),
+			"updated_at":   now,
+		})
+	if res.Error != nil || res.RowsAffected == 0 {
+		return nil, res.Error
+	}
+	return r.GetByID(id)
+}
+
+func (r *WebhookEventRepository) MarkProcessed(id uint) error {
+	now := time.Now()
+	return r.db.Model(&models.WebhookEvent{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":       domain.WebhookEventProcessed,
+		"processed_at": now,
+		"locked_until": nil,
+		"last_error":   "",
+	}).Error
+}
+
+// MarkFailed records a failed attempt. With next nil the event is DEAD, otherwise it is retried at next.
+func (r *WebhookEventRepository) MarkFailed(id uint, errMsg string, next *time.Time) error {
+	status := domain.WebhookEventFailed
+	if next == nil {
+		status = domain.WebhookEventDead
+	}
+	return r.db.Model(&models.WebhookEvent{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":          status,
+		"last_error":      errMsg,
+		"next_attempt_at": next,
+		"locked_until":    nil,
+	}).Error
+}
+
+// ResetForReplay makes an event due now regardless of its status, unless a worker currently holds it.
+// Returns false if the event is being processed.
+func (r *WebhookEventRepository) ResetForReplay(id uint) (bool, error) {
+	now := time.Now()
+	res := r.db.Model(&models.WebhookEvent{}).
+		Where("id = ? AND (status <> ? OR locked_until < ?)", id, domain.WebhookEventProcessing, now).
+		Updates(map[string]interface{}{
+			"status":          domain.WebhookEventPending,
+			"next_attempt_at": now,
+			"locked_until":    nil,
+		})
+	return res.RowsAffected > 0, res.Error
+}
+
+// List returns events for the admin inbox, newest first, with optional status and provider filters.
+func (r *WebhookEventRepository) List(statuses []string, provider string, page, limit int) ([]models.WebhookEvent, int64, error) {
+	q := r.db.Model(&models.WebhookEvent{})
+	if len(statuses) > 0 {
+		q = q.Where("status IN ?", statuses)
+	}
+	if provider != "" {
+		q = q.Where("provider = ?", provider)
+	}
+	var total int64
+	q.Count(&total)
+	var list []models.WebhookEvent
+	err := q.Order("id DESC").Limit(limit).Offset((page - 1) * limit).Find(&list).Error
+	return list, total, err
+}
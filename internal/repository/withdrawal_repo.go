@@ -1,6 +1,8 @@
 package repository
 
 import (
+	"time"
+
 	"lusty/internal/models"
 
 	"gorm.io/gorm"
@@ -30,3 +32,13 @@ func (r *WithdrawalRepository) GetByOrderID(orderID string) (*models.Withdrawal,
 func (r *WithdrawalRepository) Update(w *models.Withdrawal) error {
 	return r.db.Save(w).Error
 }
+
+// ListForReconciliation pages (by id, after afterID) through PENDING withdrawals created before
+// pendingBefore and settled ones updated since settledSince.
+func (r *WithdrawalRepository) ListForReconciliation(pendingBefore, settledSince time.Time, afterID uint, limit int) ([]models.Withdrawal, error) {
+	var list []models.Withdrawal
+	err := r.db.Where("id > ? AND ((status = ? AND created_at < ?) OR (status IN ? AND updated_at >= ?))",
+		afterID, "PENDING", pendingBefore, []string{"COMPLETED", "FAILED"}, settledSince).
+		Order("id ASC").Limit(limit).Find(&list).Error
+	return list, err
+}
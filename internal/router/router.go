@@ -4,27 +4,38 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"lusty/config"
 	"lusty/internal/domain"
 	"lusty/internal/handler"
+	"lusty/internal/jobs"
 	"lusty/internal/middleware"
 	"lusty/internal/repository"
 	"lusty/internal/service"
+	"lusty/internal/service/interaction"
+	"lusty/internal/service/videocall"
+	"lusty/internal/webhook"
 	"lusty/internal/ws"
 	"lusty/pkg/cloudinary"
+	"lusty/pkg/payment"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
-func Setup(cfg *config.Config, db *gorm.DB, cloud cloudinary.Client) *gin.Engine {
+func Setup(cfg *config.Config, db *gorm.DB, cloud cloudinary.Client, scheduler *jobs.Scheduler) *gin.Engine {
 	if cfg.Server.Env == "production" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 	r := gin.New()
+	// Only believe X-Forwarded-For from the configured proxies, otherwise anyone could pick their ClientIP
+	// (and get past the webhook IP allowlists)
+	if err := r.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatalf("[router] invalid TRUSTED_PROXIES: %v", err)
+	}
 	r.Use(gin.Recovery())
 	// CORS for dashboard
 	r.Use(func(c *gin.Context) {
@@ -57,10 +68,25 @@ func Setup(cfg *config.Config, db *gorm.DB, cloud cloudinary.Client) *gin.Engine
 	paymentRepo := repository.NewPaymentRepository(db)
 	interactionRepo := repository.NewInteractionRepository(db)
 	walletRepo := repository.NewWalletRepository(db)
+	escrowRepo := repository.NewEscrowRepository(db)
+	webhookRepo := repository.NewWebhookEventRepository(db)
 
-	mapHub := ws.NewMapHub()
-	chatHub := ws.NewChatHub()
-	videoHub := ws.NewVideoHub()
+	// Hub events are shared between instances through Redis when it is configured. A configured backplane that
+	// cannot connect stops startup: falling back to in-process hubs would silently split chat, video and map
+	// state between instances.
+	var backplane ws.Backplane = ws.NewMemoryBackplane()
+	if cfg.Redis.URL != "" {
+		rb, err := ws.NewRedisBackplane(cfg.Redis.URL, "lusty:ws:")
+		if err != nil {
+			log.Fatalf("[ws] Redis backplane: %v", err)
+		}
+		log.Printf("[ws] Redis backplane enabled")
+		backplane = rb
+	}
+	mapHub := ws.NewMapHub(backplane)
+	chatHub := ws.NewChatHub(backplane)
+	videoHub := ws.NewVideoHub(backplane)
+	userHub := ws.NewSharedHub(backplane, "user")
 
 	// Services
 	authSvc := service.NewAuthService(cfg, userRepo)
@@ -73,6 +99,7 @@ func Setup(cfg *config.Config, db *gorm.DB, cloud cloudinary.Client) *gin.Engine
 		log.Printf("[FCM] Push notifications disabled: set FIREBASE_SERVICE_ACCOUNT_PATH to enable")
 	}
 	notifSvc := service.NewNotificationService(notificationRepo, userRepo, fcmSvc)
+	notifSvc.SetLive(userHub)
 
 	referralRepo := repository.NewReferralRepository(db)
 	settingRepo := repository.NewSettingRepository(db)
@@ -85,15 +112,43 @@ func Setup(cfg *config.Config, db *gorm.DB, cloud cloudinary.Client) *gin.Engine
 		domain.SettingReferralCommissionRate: "0.05",
 		domain.SettingReferralMaxTx:          "2",
 	})
+	_ = settingRepo.SeedDefaults(interaction.DefaultCancellationSettings())
+	_ = settingRepo.SeedDefaults(map[string]string{
+		domain.SettingReviewEditWindowMinutes: "1440", // 24h
+		domain.SettingReviewBlockedTerms:      "",
+	})
+	_ = settingRepo.SeedDefaults(map[string]string{
+		domain.SettingMaxConcurrentChats: strconv.Itoa(interaction.DefaultMaxConcurrentChats),
+	})
+
+	// Seed ledger accounts for wallets that predate the ledger
+	if err := walletRepo.SeedOpeningBalances(); err != nil {
+		log.Printf("[ledger] opening balance seed failed: %v", err)
+	}
 
 	referralSvc := service.NewReferralService(referralRepo, walletRepo, settingRepo)
+	availabilityRepo := repository.NewAvailabilityRepository(db)
+	extensionRepo := repository.NewExtensionRepository(db)
+	disputeRepo := repository.NewDisputeRepository(db)
+	interactionSvc := interaction.NewService(interactionRepo, companionRepo, userRepo, walletRepo, escrowRepo, referralRepo, settingRepo, availabilityRepo, extensionRepo, disputeRepo, notifSvc)
+	interactionSvc.SetRooms(chatHub)
+
+	// Background jobs
+	sweeper := jobs.NewSweeper(paymentRepo, interactionRepo, walletRepo, interactionSvc, notifSvc, cfg.Payment.PaymentExpiry, cfg.Jobs.BookingReminderLead, cfg.Jobs.ChatPushDelay)
+	scheduler.Add("expire_requests", cfg.Jobs.SweepInterval, sweeper.ExpireRequests)
+	scheduler.Add("cancel_abandoned_payments", cfg.Jobs.SweepInterval, sweeper.CancelAbandonedPayments)
+	scheduler.Add("auto_complete_interactions", cfg.Jobs.SweepInterval, sweeper.AutoCompleteDelivered)
+	scheduler.Add("booking_reminders", cfg.Jobs.SweepInterval, sweeper.RemindUpcomingBookings)
+	scheduler.Add("push_undelivered_chat", 15*time.Second, sweeper.PushUndeliveredMessages)
+	videoCallRepo := repository.NewVideoCallRepository(db)
+	videoSvc := videocall.NewService(videoCallRepo, companionRepo, interactionRepo, userRepo, notifSvc, chatHub)
+	scheduler.Add("end_stale_video_calls", cfg.Jobs.SweepInterval, videoSvc.EndStaleCalls)
 
 	// Handlers
 	authHandler := handler.NewAuthHandler(authSvc, presenceRepo, auditRepo, companionRepo, referralSvc)
-	meHandler := handler.NewMeHandler(userRepo, companionRepo, locRepo, favRepo, paymentRepo, interactionRepo, walletRepo, notifSvc)
+	meHandler := handler.NewMeHandler(userRepo, companionRepo, locRepo, favRepo, paymentRepo, interactionRepo, walletRepo, escrowRepo, notifSvc, interactionSvc)
 	googleOAuthHandler := handler.NewGoogleOAuthHandler(cfg, authSvc, presenceRepo, auditRepo, companionRepo, referralSvc)
 	appleOAuthHandler := handler.NewAppleOAuthHandler(authSvc, presenceRepo, auditRepo, companionRepo, referralSvc)
-	adminHandler := handler.NewAdminHandler(adminRepo, settingRepo, authSvc)
 	discoveryHandler := handler.NewDiscoveryHandler(discoveryRepo)
 	companionHandler := handler.NewCompanionHandler(companionRepo, userRepo, interactionRepo, cloud)
 	locationHandler := handler.NewLocationHandler(locRepo, presenceRepo, companionRepo, cfg, mapHub)
@@ -104,20 +159,92 @@ func Setup(cfg *config.Config, db *gorm.DB, cloud cloudinary.Client) *gin.Engine
 	notificationHandler := handler.NewNotificationHandler(notificationRepo)
 	pricingHandler := handler.NewPricingHandler(companionRepo)
 	boostHandler := handler.NewBoostHandler(companionRepo)
-	interactionHandler := handler.NewInteractionHandler(interactionRepo, companionRepo, paymentRepo, walletRepo, userRepo, notifSvc, referralRepo)
-	paymentWebhookHandler := handler.NewPaymentWebhookHandler(paymentRepo, auditRepo, notifSvc, cfg)
+	interactionHandler := handler.NewInteractionHandler(interactionRepo, companionRepo, paymentRepo, userRepo, notifSvc, interactionSvc, videoHub, videoSvc, cfg.Jobs.AutoCompleteGrace)
+	iceHandler := handler.NewICEHandler(&cfg.WebRTC, interactionRepo)
 	walletHandler := handler.NewWalletHandler(walletRepo)
-	mpesaHandler := handler.NewMpesaHandler(cfg, paymentRepo, interactionRepo, companionRepo, walletRepo, userRepo, notifSvc)
-	mpesaWebhookHandler := handler.NewMpesaWebhookHandler(paymentRepo, interactionRepo, companionRepo, walletRepo, auditRepo, notifSvc, userRepo, referralRepo)
+	paymentHandler := handler.NewPaymentHandler(paymentRepo, interactionRepo)
+	// Payment providers by name; config picks the one used for each rail
+	payments := payment.NewRegistry()
+	payments.RegisterMobileMoney("liberec", payment.NewLiberecMpesaProvider(cfg.LiberecMpesa.BaseURL, cfg.LiberecMpesa.Email, cfg.LiberecMpesa.Password, cfg.LiberecMpesa.WebhookBaseURL))
+	payments.RegisterCrypto("swapuzi", payment.NewSwapuziProvider(cfg.Swapuzi.BaseURL, cfg.Swapuzi.Email, cfg.Swapuzi.Password))
+	fakeProvider := payment.NewFakeProvider(payment.FakeConfig{
+		CallbackBaseURL: cfg.Payment.Fake.CallbackBaseURL,
+		Delay:           cfg.Payment.Fake.Delay,
+		FailRate:        cfg.Payment.Fake.FailRate,
+		Secrets: map[string]string{
+			"mpesa":      cfg.Webhooks.Mpesa.Secret,
+			"crypto":     cfg.Webhooks.Crypto.Secret,
+			"withdrawal": cfg.Webhooks.Withdrawal.Secret,
+		},
+	})
+	// The fake provider settles payments nobody made, so it only exists outside production-like environments
+	if cfg.Server.Env == "development" || cfg.Server.Env == "test" {
+		payments.RegisterMobileMoney("fake", fakeProvider)
+		payments.RegisterCrypto("fake", fakeProvider)
+	} else if cfg.Payment.MobileMoneyProvider == "fake" || cfg.Payment.CryptoProvider == "fake" {
+		log.Fatalf("[payment] the fake provider is only available with ENV=development or ENV=test, not %q", cfg.Server.Env)
+	}
+	mpesaProvider, err := payments.MobileMoney(cfg.Payment.MobileMoneyProvider)
+	if err != nil {
+		log.Fatalf("[payment] %v", err)
+	}
+	cryptoProvider, err := payments.Crypto(cfg.Payment.CryptoProvider)
+	if err != nil {
+		log.Fatalf("[payment] %v", err)
+	}
+	log.Printf("[payment] mobile money provider: %s, crypto provider: %s", cfg.Payment.MobileMoneyProvider, cfg.Payment.CryptoProvider)
+	mpesaHandler := handler.NewMpesaHandler(cfg, paymentRepo, interactionRepo, companionRepo, walletRepo, interactionSvc, userRepo, notifSvc, mpesaProvider)
+	extensionHandler := handler.NewExtensionHandler(cfg, interactionRepo, extensionRepo, paymentRepo, interactionSvc, mpesaProvider)
+	// Provider clients used by webhooks to confirm a callback's status before acting on it
+	var mpesaVerifier, cryptoVerifier payment.Verifier
+	var b2cVerifier payment.MobileMoneyProvider
+	if cfg.Webhooks.Mpesa.VerifyBack {
+		mpesaVerifier = mpesaProvider
+	}
+	if cfg.Webhooks.Withdrawal.VerifyBack {
+		b2cVerifier = mpesaProvider
+	}
+	if cfg.Webhooks.Crypto.VerifyBack {
+		cryptoVerifier = cryptoProvider
+	}
+	reconRepo := repository.NewReconciliationRepository(db)
+	refundRepo := repository.NewRefundRepository(db)
+	refundMpesaCallback, refundCryptoCallback := "", ""
+	if cfg.LiberecMpesa.WebhookBaseURL != "" {
+		refundMpesaCallback = cfg.LiberecMpesa.WebhookBaseURL + "/api/v1/webhooks/refund/mpesa"
+	}
+	if cfg.Swapuzi.WebhookBaseURL != "" {
+		refundCryptoCallback = cfg.Swapuzi.WebhookBaseURL + "/api/v1/webhooks/refund/crypto"
+	}
+	refundSvc := service.NewRefundService(refundRepo, paymentRepo, interactionRepo, escrowRepo, walletRepo, reconRepo, notifSvc, mpesaProvider, cryptoProvider, refundMpesaCallback, refundCryptoCallback)
+	mpesaWebhookHandler := handler.NewMpesaWebhookHandler(paymentRepo, interactionRepo, companionRepo, walletRepo, escrowRepo, interactionSvc, auditRepo, notifSvc, userRepo, referralRepo, refundSvc, mpesaVerifier)
 	withdrawalRepo := repository.NewWithdrawalRepository(db)
-	withdrawalHandler := handler.NewWithdrawalHandler(cfg, walletRepo, withdrawalRepo, companionRepo)
-	withdrawalWebhookHandler := handler.NewWithdrawalWebhookHandler(withdrawalRepo, walletRepo)
+	withdrawalHandler := handler.NewWithdrawalHandler(cfg, walletRepo, withdrawalRepo, companionRepo, mpesaProvider)
+	withdrawalWebhookHandler := handler.NewWithdrawalWebhookHandler(withdrawalRepo, walletRepo, auditRepo, b2cVerifier)
 	chatHandler := handler.NewChatHandler(interactionRepo, companionRepo)
 	uploadHandler := handler.NewUploadHandler(cloud)
 	distanceHandler := handler.NewDistanceHandler(interactionRepo, companionRepo, locRepo, userRepo)
 	referralHandler := handler.NewReferralHandler(referralRepo)
-	cryptoHandler := handler.NewCryptoHandler(cfg, paymentRepo, companionRepo, walletRepo, userRepo, notifSvc)
-	cryptoWebhookHandler := handler.NewCryptoWebhookHandler(paymentRepo, interactionRepo, companionRepo, walletRepo, userRepo, notifSvc, referralRepo)
+	cryptoHandler := handler.NewCryptoHandler(cfg, paymentRepo, companionRepo, walletRepo, userRepo, notifSvc, interactionSvc, cryptoProvider)
+	cryptoWebhookHandler := handler.NewCryptoWebhookHandler(paymentRepo, interactionRepo, companionRepo, walletRepo, interactionSvc, userRepo, notifSvc, referralRepo, auditRepo, refundSvc, cryptoVerifier)
+
+	// Provider callbacks are stored in webhook_events, then processed (and retried) by the inbox
+	inbox := webhook.NewInbox(webhookRepo)
+	inbox.Register("mpesa", mpesaWebhookHandler)
+	inbox.Register("crypto", cryptoWebhookHandler)
+	inbox.Register("withdrawal", withdrawalWebhookHandler)
+	scheduler.Add("webhook_inbox", 15*time.Second, inbox.ProcessDue)
+	reconciler := jobs.NewReconciler(paymentRepo, withdrawalRepo, reconRepo, inbox, mpesaProvider, cryptoProvider, cfg.Jobs.ReconcileMinAge, cfg.Jobs.ReconcileLookback)
+	scheduler.Add("reconcile_payments", cfg.Jobs.ReconcileInterval, reconciler.Run)
+	refundHandler := handler.NewRefundHandler(refundSvc, refundRepo, paymentRepo)
+	inbox.Register("refund_mpesa", handler.NewRefundWebhookHandler("mpesa", refundSvc, auditRepo, mpesaProvider, cryptoProvider))
+	inbox.Register("refund_crypto", handler.NewRefundWebhookHandler("crypto", refundSvc, auditRepo, mpesaProvider, cryptoProvider))
+	disputeHandler := handler.NewDisputeHandler(disputeRepo, interactionRepo, escrowRepo, locRepo, interactionSvc)
+	availabilityHandler := handler.NewAvailabilityHandler(availabilityRepo, companionRepo, interactionSvc)
+	reviewRepo := repository.NewReviewRepository(db)
+	reviewSvc := service.NewReviewService(reviewRepo, companionRepo, userRepo, settingRepo, notifSvc, service.NewKeywordModerator(settingRepo))
+	reviewHandler := handler.NewReviewHandler(reviewSvc, reviewRepo, interactionRepo, companionRepo)
+	adminHandler := handler.NewAdminHandler(adminRepo, settingRepo, walletRepo, webhookRepo, inbox, reconRepo, authSvc)
 
 	authMw := middleware.AuthRequired(&cfg.JWT)
 	adultMw := middleware.AdultOnly(cfg, userRepo)
@@ -139,6 +266,8 @@ func Setup(cfg *config.Config, db *gorm.DB, cloud cloudinary.Client) *gin.Engine
 
 		api.GET("/discover", authMw, adultMw, discoveryHandler.Discover)
 		api.GET("/companions/:id", authMw, adultMw, companionHandler.GetProfile)
+		api.GET("/companions/:id/reviews", authMw, adultMw, reviewHandler.ListForCompanion)
+		api.GET("/companions/:id/availability", authMw, adultMw, availabilityHandler.FreeSlots)
 
 		me := api.Group("/me")
 		me.Use(authMw)
@@ -161,6 +290,8 @@ func Setup(cfg *config.Config, db *gorm.DB, cloud cloudinary.Client) *gin.Engine
 			meAdult.PUT("/notifications/:id/read", notificationHandler.MarkRead)
 			meAdult.GET("/wallet", walletHandler.GetBalance)
 			meAdult.GET("/wallet/transactions", walletHandler.GetTransactions)
+			meAdult.POST("/payments/:id/refunds", refundHandler.Request)
+			meAdult.GET("/refunds", refundHandler.List)
 			meAdult.POST("/withdraw", withdrawalHandler.Create)
 			meAdult.GET("/active-sessions", meHandler.GetActiveSessions)
 			meAdult.GET("/fans", meHandler.GetFans)
@@ -170,17 +301,30 @@ func Setup(cfg *config.Config, db *gorm.DB, cloud cloudinary.Client) *gin.Engine
 			meAdult.POST("/upload/chat", uploadHandler.UploadChatMedia)
 			meAdult.POST("/kyc-complete", meHandler.CompleteKYC)
 			meAdult.POST("/interactions/:interaction_id/video-call-request", interactionHandler.VideoCallRequest)
+			meAdult.GET("/interactions/:interaction_id/ice-servers", iceHandler.GetServers)
 			meAdult.POST("/boost/initiate", middleware.RequireRole("COMPANION"), mpesaHandler.InitiateBoost)
 			meAdult.GET("/referral-code", referralHandler.GetMyReferralCode)
 			meAdult.GET("/referrals", referralHandler.GetMyReferrals)
 		}
 		api.POST("/payments/mpesa/initiate", authMw, adultMw, mpesaHandler.Initiate)
+		api.GET("/payments/:id/status", authMw, adultMw, paymentHandler.GetStatus)
 		api.GET("/payments/crypto/rates", authMw, adultMw, cryptoHandler.GetRates)
 		api.POST("/payments/crypto/initiate", authMw, adultMw, cryptoHandler.Initiate)
 		api.POST("/interactions", authMw, adultMw, interactionHandler.Create)
 		api.POST("/interactions/:id/accept", authMw, adultMw, middleware.RequireRole("COMPANION"), interactionHandler.Accept)
 		api.POST("/interactions/:id/reject", authMw, adultMw, middleware.RequireRole("COMPANION"), interactionHandler.Reject)
 		api.POST("/interactions/:id/service-done", authMw, adultMw, middleware.RequireRole("CLIENT"), interactionHandler.ServiceDone)
+		api.POST("/interactions/:id/cancel", authMw, adultMw, middleware.RequireRole("CLIENT"), interactionHandler.Cancel)
+		api.GET("/interactions/:id/cancellation-quote", authMw, adultMw, middleware.RequireRole("CLIENT"), interactionHandler.CancellationQuote)
+		api.GET("/interactions/:id/extensions", authMw, adultMw, extensionHandler.List)
+		api.POST("/interactions/:id/extensions", authMw, adultMw, middleware.RequireRole("CLIENT"), extensionHandler.Create)
+		api.POST("/interactions/:id/delivered", authMw, adultMw, middleware.RequireRole("COMPANION"), interactionHandler.MarkDelivered)
+		api.POST("/interactions/:id/disputes", authMw, adultMw, disputeHandler.Open)
+		api.GET("/interactions/:id/dispute", authMw, adultMw, disputeHandler.Get)
+		api.POST("/interactions/:id/dispute/evidence", authMw, adultMw, disputeHandler.AddEvidence)
+		api.POST("/interactions/:id/reviews", authMw, adultMw, reviewHandler.Create)
+		api.GET("/interactions/:id/reviews", authMw, adultMw, reviewHandler.ListForInteraction)
+		api.PATCH("/reviews/:id", authMw, adultMw, reviewHandler.Update)
 		api.POST("/favorites/:companion_id", authMw, adultMw, favoriteHandler.Add)
 		api.DELETE("/favorites/:companion_id", authMw, adultMw, favoriteHandler.Remove)
 		api.POST("/block/:user_id", authMw, adultMw, blockHandler.Block)
@@ -197,16 +341,31 @@ func Setup(cfg *config.Config, db *gorm.DB, cloud cloudinary.Client) *gin.Engine
 			companions.PUT("/pricing/:id", pricingHandler.Update)
 			companions.DELETE("/pricing/:id", pricingHandler.Delete)
 			companions.POST("/boost", boostHandler.Activate)
+			companions.GET("/availability", availabilityHandler.GetMine)
+			companions.PUT("/availability", availabilityHandler.UpdateMine)
+			companions.POST("/availability/exceptions", availabilityHandler.AddException)
+			companions.DELETE("/availability/exceptions/:id", availabilityHandler.DeleteException)
+		}
+		api.POST("/webhooks/mpesa", middleware.NewWebhookGuard("mpesa", cfg.Webhooks.Mpesa, auditRepo).Handler(), inbox.Receive("mpesa"))
+		api.POST("/webhooks/crypto", middleware.NewWebhookGuard("crypto", cfg.Webhooks.Crypto, auditRepo).Handler(), inbox.Receive("crypto"))
+		api.POST("/webhooks/withdrawal", middleware.NewWebhookGuard("withdrawal", cfg.Webhooks.Withdrawal, auditRepo).Handler(), inbox.Receive("withdrawal"))
+		// A refund callback moves money, so it is only accepted signed
+		if cfg.Webhooks.Withdrawal.Secret != "" {
+			api.POST("/webhooks/refund/mpesa", middleware.NewWebhookGuard("refund_mpesa", cfg.Webhooks.Withdrawal, auditRepo).Handler(), inbox.Receive("refund_mpesa"))
+		} else {
+			log.Printf("[webhook] refund_mpesa: WITHDRAWAL_WEBHOOK_SECRET not set, refund callbacks disabled")
+		}
+		if cfg.Webhooks.Crypto.Secret != "" {
+			api.POST("/webhooks/refund/crypto", middleware.NewWebhookGuard("refund_crypto", cfg.Webhooks.Crypto, auditRepo).Handler(), inbox.Receive("refund_crypto"))
+		} else {
+			log.Printf("[webhook] refund_crypto: CRYPTO_WEBHOOK_SECRET not set, refund callbacks disabled")
 		}
-		api.POST("/webhooks/payment", paymentWebhookHandler.Handle)
-		api.POST("/webhooks/mpesa", mpesaWebhookHandler.Handle)
-		api.POST("/webhooks/crypto", cryptoWebhookHandler.Handle)
-		api.POST("/webhooks/withdrawal", withdrawalWebhookHandler.Handle)
 	}
 
+	r.GET("/ws/user", ws.UpgradeUserWS(&cfg.JWT, userHub))
 	r.GET("/ws/map", ws.UpgradeMapWS(&cfg.JWT, mapHub))
 	r.GET("/ws/chat", handler.UpgradeChatWS(&cfg.JWT, chatHub, interactionRepo, userRepo, notifSvc))
-	r.GET("/ws/video", handler.UpgradeVideoWS(&cfg.JWT, videoHub, interactionRepo))
+	r.GET("/ws/video", handler.UpgradeVideoWS(&cfg.JWT, videoHub, interactionRepo, notifSvc, videoSvc))
 
 	// Admin API routes
 	adminAPI := r.Group("/api/v1/admin")
@@ -224,7 +383,15 @@ func Setup(cfg *config.Config, db *gorm.DB, cloud cloudinary.Client) *gin.Engine
 		adminAuth.GET("/transactions", adminHandler.ListTransactions)
 		adminAuth.GET("/payments", adminHandler.ListPayments)
 		adminAuth.GET("/withdrawals", adminHandler.ListWithdrawals)
+		adminAuth.POST("/payments/:id/refunds", refundHandler.AdminIssue)
+		adminAuth.GET("/refunds", refundHandler.AdminList)
 		adminAuth.GET("/interactions", adminHandler.ListInteractions)
+		adminAuth.GET("/interactions/:id/transitions", adminHandler.InteractionTransitions)
+		adminAuth.GET("/disputes", disputeHandler.AdminList)
+		adminAuth.GET("/disputes/:id", disputeHandler.AdminGet)
+		adminAuth.POST("/disputes/:id/resolve", disputeHandler.AdminResolve)
+		adminAuth.GET("/reviews", reviewHandler.AdminList)
+		adminAuth.POST("/reviews/:id/moderate", reviewHandler.AdminModerate)
 		adminAuth.GET("/reports", adminHandler.ListReports)
 		adminAuth.PATCH("/reports/:id", adminHandler.UpdateReport)
 		adminAuth.GET("/referrals", adminHandler.ListReferrals)
@@ -232,6 +399,11 @@ func Setup(cfg *config.Config, db *gorm.DB, cloud cloudinary.Client) *gin.Engine
 		adminAuth.GET("/settings", adminHandler.GetSettings)
 		adminAuth.PUT("/settings", adminHandler.UpdateSettings)
 		adminAuth.GET("/analytics", adminHandler.Analytics)
+		adminAuth.GET("/ledger/check", adminHandler.LedgerCheck)
+		adminAuth.GET("/webhooks/events", adminHandler.ListWebhookEvents)
+		adminAuth.POST("/webhooks/events/:id/replay", adminHandler.ReplayWebhookEvent)
+		adminAuth.GET("/reconciliation/discrepancies", adminHandler.ListDiscrepancies)
+		adminAuth.POST("/reconciliation/discrepancies/:id/resolve", adminHandler.ResolveDiscrepancy)
 	}
 
 	// Serve dashboard static files (built React app)
@@ -38,6 +38,13 @@ func NewFCMService(serviceAccountPath string) *FCMService {
 
 // Send sends a push notification to the given FCM token.
 func (s *FCMService) Send(ctx context.Context, token string, title, body string, data map[string]string) error {
+	return s.SendCollapsible(ctx, token, "", title, body, data)
+}
+
+// SendCollapsible sends a push notification that replaces any earlier one with the same collapseKey still
+// pending or shown on the device, e.g. one notification per chat however many messages arrive. An empty
+// collapseKey behaves like Send.
+func (s *FCMService) SendCollapsible(ctx context.Context, token, collapseKey string, title, body string, data map[string]string) error {
 	if s == nil || token == "" {
 		return nil
 	}
@@ -65,6 +72,11 @@ func (s *FCMService) Send(ctx context.Context, token string, title, body string,
 			},
 		},
 	}
+	if collapseKey != "" {
+		msg.Android.CollapseKey = collapseKey
+		msg.Android.Notification.Tag = collapseKey
+		msg.APNS.Headers = map[string]string{"apns-collapse-id": collapseKey}
+	}
 	_, err := s.client.Send(ctx, msg)
 	if err != nil {
 		log.Printf("[FCM] Send error: %v", err)
@@ -108,6 +120,11 @@ func (s *FCMService) SendDataOnly(ctx context.Context, token string, data map[st
 // SendToUser sends a push to a user by their FCM token. Token is fetched by the caller.
 // All data values are converted to strings (FCM requires string values).
 func (s *FCMService) SendToUser(ctx context.Context, fcmToken string, notifType, title, body string, data map[string]interface{}) error {
+	return s.SendToUserCollapsible(ctx, fcmToken, "", notifType, title, body, data)
+}
+
+// SendToUserCollapsible is SendToUser with a collapse key (see SendCollapsible).
+func (s *FCMService) SendToUserCollapsible(ctx context.Context, fcmToken, collapseKey string, notifType, title, body string, data map[string]interface{}) error {
 	if s == nil || fcmToken == "" {
 		return nil
 	}
@@ -130,5 +147,5 @@ func (s *FCMService) SendToUser(ctx context.Context, fcmToken string, notifType,
 			}
 		}
 	}
-	return s.Send(ctx, fcmToken, title, body, dataStr)
+	return s.SendCollapsible(ctx, fcmToken, collapseKey, title, body, dataStr)
 }
@@ -0,0 +1,276 @@
+package interaction
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+	_ "time/tzdata" // companion timezones must load even where the host has no zoneinfo
+
+	"lusty/internal/domain"
+	"lusty/internal/models"
+	"lusty/internal/repository"
+)
+
+var (
+	ErrSlotInvalid     = errors.New("slot_start must be in the future and duration_minutes positive")
+	ErrSlotUnavailable = errors.New("companion is not available at that time")
+)
+
+const (
+	// DefaultTimezone is used for companions whose profile timezone is empty or unknown.
+	DefaultTimezone = "Africa/Nairobi"
+	// BookingResponseWindow is how long the companion has to answer a booking, cut short by the slot itself.
+	BookingResponseWindow = 12 * time.Hour
+	// MaxBookingAhead is how far ahead a slot can be booked.
+	MaxBookingAhead = 60 * 24 * time.Hour
+	// MaxBookingMinutes caps the length of one booked slot.
+	MaxBookingMinutes = 24 * 60
+)
+
+// Interval is a half-open time range [Start, End).
+type Interval struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Location returns the companion's timezone.
+func Location(comp *models.CompanionProfile) *time.Location {
+	name := comp.Timezone
+	if name == "" {
+		name = DefaultTimezone
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		loc, _ = time.LoadLocation(DefaultTimezone)
+	}
+	return loc
+}
+
+func bookingBuffer(comp *models.CompanionProfile) time.Duration {
+	if comp.BookingBufferMinutes < 0 {
+		return 0
+	}
+	return time.Duration(comp.BookingBufferMinutes) * time.Minute
+}
+
+// CheckSlot reports whether a booking of durationMinutes starting at start fits the companion's schedule
+// and does not collide with her other bookings, including the buffer around them. Open checks again
+// under a lock, so this is for answering the client early.
+func (s *Service) CheckSlot(comp *models.CompanionProfile, start time.Time, durationMinutes int) error {
+	now := time.Now()
+	if durationMinutes <= 0 || durationMinutes > MaxBookingMinutes || !start.After(now) || start.After(now.Add(MaxBookingAhead)) {
+		return ErrSlotInvalid
+	}
+	if !comp.IsActive || !comp.AcceptNewRequests {
+		return ErrSlotUnavailable
+	}
+	end := start.Add(time.Duration(durationMinutes) * time.Minute)
+	windows, err := s.scheduleWindows(comp, start.Add(-24*time.Hour), end.Add(24*time.Hour))
+	if err != nil {
+		return err
+	}
+	if !covers(windows, start, end) {
+		return ErrSlotUnavailable
+	}
+	buffer := bookingBuffer(comp)
+	booked, err := s.interactionRepo.ListBookings(comp.ID, start.Add(-buffer), end.Add(buffer))
+	if err != nil {
+		return err
+	}
+	if len(booked) > 0 {
+		return repository.ErrSlotTaken
+	}
+	return nil
+}
+
+// FreeWindows returns the times in [from, to) the companion can still be booked: her weekly schedule with
+// exceptions applied, minus her bookings and their buffers, from now on.
+func (s *Service) FreeWindows(comp *models.CompanionProfile, from, to time.Time) ([]Interval, error) {
+	if now := time.Now(); from.Before(now) {
+		from = now
+	}
+	if !from.Before(to) {
+		return []Interval{}, nil
+	}
+	windows, err := s.scheduleWindows(comp, from, to)
+	if err != nil {
+		return nil, err
+	}
+	buffer := bookingBuffer(comp)
+	booked, err := s.interactionRepo.ListBookings(comp.ID, from.Add(-buffer), to.Add(buffer))
+	if err != nil {
+		return nil, err
+	}
+	busy := make([]Interval, 0, len(booked))
+	for _, b := range booked {
+		busy = append(busy, Interval{Start: b.SlotStart.Add(-buffer), End: b.SlotEnd.Add(buffer)})
+	}
+	return subtractIntervals(windows, busy), nil
+}
+
+// scheduleWindows expands the weekly rules over [from, to) in the companion's timezone, adds exceptions that
+// open extra time and removes those that block time off.
+func (s *Service) scheduleWindows(comp *models.CompanionProfile, from, to time.Time) ([]Interval, error) {
+	rules, err := s.availabilityRepo.ListRules(comp.ID)
+	if err != nil {
+		return nil, err
+	}
+	exceptions, err := s.availabilityRepo.ListExceptions(comp.ID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	loc := Location(comp)
+	var open, blocked []Interval
+	// Start a day early for windows that run past midnight
+	first := from.In(loc).AddDate(0, 0, -1)
+	for day := time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, loc); day.Before(to); day = day.AddDate(0, 0, 1) {
+		for _, r := range rules {
+			if time.Weekday(r.Weekday) != day.Weekday() {
+				continue
+			}
+			start := day.Add(time.Duration(r.StartMinute) * time.Minute)
+			end := day.Add(time.Duration(r.EndMinute) * time.Minute)
+			if r.EndMinute <= r.StartMinute {
+				end = day.AddDate(0, 0, 1).Add(time.Duration(r.EndMinute) * time.Minute)
+			}
+			open = append(open, Interval{Start: start, End: end})
+		}
+	}
+	for _, e := range exceptions {
+		if e.Available {
+			open = append(open, Interval{Start: e.StartAt, End: e.EndAt})
+		} else {
+			blocked = append(blocked, Interval{Start: e.StartAt, End: e.EndAt})
+		}
+	}
+	windows := subtractIntervals(mergeIntervals(open), blocked)
+	return clipIntervals(windows, from, to), nil
+}
+
+// RemindBooking tells both sides an accepted booking is coming up.
+func (s *Service) RemindBooking(ir *models.InteractionRequest) {
+	if ir.SlotStart == nil {
+		return
+	}
+	comp := &ir.Companion
+	if comp.ID == 0 {
+		if comp, _ = s.companionRepo.GetByID(ir.CompanionID); comp == nil {
+			return
+		}
+	}
+	at := ir.SlotStart.In(Location(comp)).Format("Mon 2 Jan 15:04")
+	data := map[string]interface{}{"interaction_id": ir.ID, "slot_start": ir.SlotStart, "slot_end": ir.SlotEnd}
+	_ = s.notifSvc.Notify(ir.ClientID, "BOOKING_REMINDER", "Upcoming booking",
+		fmt.Sprintf("Your booking with %s starts at %s.", comp.DisplayName, at), data)
+	_ = s.notifSvc.Notify(comp.UserID, "BOOKING_REMINDER", "Upcoming booking",
+		fmt.Sprintf("You have a booking starting at %s.", at), data)
+}
+
+// openBooking validates the request's slot and creates it while holding the slot, with hold (if any) moving
+// its payment into escrow. The companion gets BookingResponseWindow to answer, or until the slot starts if
+// that is sooner.
+func (s *Service) openBooking(ir *models.InteractionRequest, t *models.InteractionTransition, hold repository.EscrowStep) error {
+	comp, err := s.companionRepo.GetByID(ir.CompanionID)
+	if err != nil {
+		return err
+	}
+	if err := s.CheckSlot(comp, *ir.SlotStart, ir.DurationMinutes); err != nil {
+		return err
+	}
+	end := ir.SlotStart.Add(time.Duration(ir.DurationMinutes) * time.Minute)
+	ir.SlotEnd = &end
+	expiresAt := time.Now().Add(BookingResponseWindow)
+	if ir.SlotStart.Before(expiresAt) {
+		expiresAt = *ir.SlotStart
+	}
+	ir.ExpiresAt = &expiresAt
+	return s.interactionRepo.CreateBookingWithTransition(ir, t, bookingBuffer(comp), hold)
+}
+
+// rejectUnavailableSlot rejects a booking whose slot was lost between CheckSlot and Open. A paid booking is
+// refunded to the client's wallet; an unpaid one is refunded by the payment webhook if the payment completes.
+func (s *Service) rejectUnavailableSlot(ir *models.InteractionRequest, slotErr error) {
+	now := time.Now()
+	reason := "booking slot unavailable: " + slotErr.Error()
+	hold, err := s.transitionSettling(ir, domain.RequestStatusRejected, nil, reason, map[string]interface{}{"rejected_at": now},
+		s.escrowRepo.RefundStep(ir, reason, nil))
+	if err != nil {
+		log.Printf("[interaction] reject unavailable booking %d: %v", ir.ID, err)
+		return
+	}
+	ir.RejectedAt = &now
+	if hold != nil {
+		_ = s.notifSvc.Notify(ir.ClientID, "BOOKING_UNAVAILABLE", "Time slot unavailable",
+			"The time you picked is no longer available. Your payment has been refunded to your wallet.",
+			map[string]interface{}{"interaction_id": ir.ID})
+	}
+}
+
+func mergeIntervals(in []Interval) []Interval {
+	if len(in) == 0 {
+		return nil
+	}
+	sorted := append([]Interval(nil), in...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start.Before(sorted[j].Start) })
+	out := []Interval{sorted[0]}
+	for _, iv := range sorted[1:] {
+		last := &out[len(out)-1]
+		if !iv.Start.After(last.End) {
+			if iv.End.After(last.End) {
+				last.End = iv.End
+			}
+			continue
+		}
+		out = append(out, iv)
+	}
+	return out
+}
+
+// subtractIntervals removes every cut from the merged, sorted base.
+func subtractIntervals(base, cuts []Interval) []Interval {
+	out := base
+	for _, c := range cuts {
+		next := make([]Interval, 0, len(out)+1)
+		for _, iv := range out {
+			if !c.Start.Before(iv.End) || !c.End.After(iv.Start) {
+				next = append(next, iv)
+				continue
+			}
+			if c.Start.After(iv.Start) {
+				next = append(next, Interval{Start: iv.Start, End: c.Start})
+			}
+			if c.End.Before(iv.End) {
+				next = append(next, Interval{Start: c.End, End: iv.End})
+			}
+		}
+		out = next
+	}
+	return out
+}
+
+func clipIntervals(in []Interval, from, to time.Time) []Interval {
+	out := make([]Interval, 0, len(in))
+	for _, iv := range in {
+		if iv.Start.Before(from) {
+			iv.Start = from
+		}
+		if iv.End.After(to) {
+			iv.End = to
+		}
+		if iv.Start.Before(iv.End) {
+			out = append(out, iv)
+		}
+	}
+	return out
+}
+
+func covers(windows []Interval, start, end time.Time) bool {
+	for _, iv := range windows {
+		if !start.Before(iv.Start) && !end.After(iv.End) {
+			return true
+		}
+	}
+	return false
+}
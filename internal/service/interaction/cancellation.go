@@ -0,0 +1,247 @@
+package interaction
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"lusty/internal/domain"
+	"lusty/internal/models"
+	"lusty/internal/repository"
+)
+
+// ErrCancelAfterDelivery is returned when the client tries to cancel a session the companion has marked
+// delivered; they have to dispute it instead.
+var ErrCancelAfterDelivery = errors.New("service already marked delivered; open a dispute instead")
+
+// ErrCancelDisputed is returned when the client tries to cancel a request under dispute; the dispute
+// resolution decides where the money goes.
+var ErrCancelDisputed = errors.New("request is under dispute; it will be settled when the dispute is resolved")
+
+// CancellationTier refunds RefundPercent of the payment when the client cancels AfterMinutes or more after
+// the companion accepted.
+type CancellationTier struct {
+	AfterMinutes  int `json:"after_minutes"`
+	RefundPercent int `json:"refund_percent"`
+}
+
+// BookingCancellationTier refunds RefundPercent of a booking's payment when the client cancels with
+// MinutesBefore or more left before the slot starts.
+type BookingCancellationTier struct {
+	MinutesBefore int `json:"minutes_before"`
+	RefundPercent int `json:"refund_percent"`
+}
+
+// CancellationPolicy decides what a client gets back when cancelling. Before accept the refund is always
+// full. After accept, an immediate request gets the latest Tiers entry reached since the accept, and a booking
+// the BookingTiers entry for the time left before its slot (the smallest one once the slot has started). The
+// fee (what is not refunded) goes CompanionSharePercent to the companion, capped at what she would have
+// earned, and the rest to the platform.
+type CancellationPolicy struct {
+	Tiers                 []CancellationTier
+	BookingTiers          []BookingCancellationTier
+	CompanionSharePercent int
+}
+
+// DefaultCancellationPolicy: free for 10 minutes after accept, then 75% back, 50% after 30 minutes. Bookings
+// are free until a day before the slot, then 75% back, 50% in the last two hours.
+var DefaultCancellationPolicy = CancellationPolicy{
+	Tiers: []CancellationTier{
+		{AfterMinutes: 0, RefundPercent: 100},
+		{AfterMinutes: 10, RefundPercent: 75},
+		{AfterMinutes: 30, RefundPercent: 50},
+	},
+	BookingTiers: []BookingCancellationTier{
+		{MinutesBefore: 0, RefundPercent: 50},
+		{MinutesBefore: 120, RefundPercent: 75},
+		{MinutesBefore: 1440, RefundPercent: 100},
+	},
+	CompanionSharePercent: 80,
+}
+
+// DefaultCancellationSettings are the system settings seeded for DefaultCancellationPolicy.
+func DefaultCancellationSettings() map[string]string {
+	tiers, _ := json.Marshal(DefaultCancellationPolicy.Tiers)
+	bookingTiers, _ := json.Marshal(DefaultCancellationPolicy.BookingTiers)
+	return map[string]string{
+		domain.SettingCancellationPolicy:         string(tiers),
+		domain.SettingBookingCancellationPolicy:  string(bookingTiers),
+		domain.SettingCancellationCompanionShare: strconv.Itoa(DefaultCancellationPolicy.CompanionSharePercent),
+	}
+}
+
+// CancellationQuote is the outcome of cancelling now.
+type CancellationQuote struct {
+	AmountCents    int64 `json:"amount_cents"` // held in escrow; 0 if the request is unpaid
+	RefundPercent  int   `json:"refund_percent"`
+	RefundCents    int64 `json:"refund_cents"`    // back to the client's wallet
+	FeeCents       int64 `json:"fee_cents"`       // kept: companion share + platform
+	CompanionCents int64 `json:"companion_cents"` // companion's share of the fee
+}
+
+// Quote applies the policy to a request and its escrow hold (nil if unpaid) at time now.
+func (p CancellationPolicy) Quote(ir *models.InteractionRequest, hold *models.EscrowHold, now time.Time) CancellationQuote {
+	q := CancellationQuote{RefundPercent: 100}
+	switch {
+	case ir.Status != domain.RequestStatusAccepted:
+	case ir.SlotStart != nil && len(p.BookingTiers) > 0:
+		left := int(ir.SlotStart.Sub(now) / time.Minute)
+		q.RefundPercent = p.BookingTiers[0].RefundPercent
+		for _, t := range p.BookingTiers {
+			if left >= t.MinutesBefore {
+				q.RefundPercent = t.RefundPercent
+			}
+		}
+	case ir.AcceptedAt != nil:
+		elapsed := int(now.Sub(*ir.AcceptedAt) / time.Minute)
+		for _, t := range p.Tiers {
+			if elapsed >= t.AfterMinutes {
+				q.RefundPercent = t.RefundPercent
+			}
+		}
+	}
+	if hold == nil {
+		return q
+	}
+	q.AmountCents = hold.AmountCents
+	q.RefundCents = hold.AmountCents * int64(q.RefundPercent) / 100
+	q.FeeCents = hold.AmountCents - q.RefundCents
+	q.CompanionCents = min(q.FeeCents*int64(p.CompanionSharePercent)/100,
+		domain.CompanionPayout(hold.CompanionBaseCents()))
+	return q
+}
+
+// CancellationPolicy reads the policy from system settings, falling back to the default for anything
+// missing or malformed.
+func (s *Service) CancellationPolicy() CancellationPolicy {
+	p := DefaultCancellationPolicy
+	if s.settingRepo == nil {
+		return p
+	}
+	if v, err := s.settingRepo.Get(domain.SettingCancellationPolicy); err == nil && v != "" {
+		var tiers []CancellationTier
+		if err := json.Unmarshal([]byte(v), &tiers); err == nil && validTiers(tiers) {
+			sort.Slice(tiers, func(i, j int) bool { return tiers[i].AfterMinutes < tiers[j].AfterMinutes })
+			p.Tiers = tiers
+		} else {
+			log.Printf("[interaction] ignoring invalid %s setting %q", domain.SettingCancellationPolicy, v)
+		}
+	}
+	if v, err := s.settingRepo.Get(domain.SettingBookingCancellationPolicy); err == nil && v != "" {
+		var tiers []BookingCancellationTier
+		if err := json.Unmarshal([]byte(v), &tiers); err == nil && validBookingTiers(tiers) {
+			sort.Slice(tiers, func(i, j int) bool { return tiers[i].MinutesBefore < tiers[j].MinutesBefore })
+			p.BookingTiers = tiers
+		} else {
+			log.Printf("[interaction] ignoring invalid %s setting %q", domain.SettingBookingCancellationPolicy, v)
+		}
+	}
+	if v, err := s.settingRepo.Get(domain.SettingCancellationCompanionShare); err == nil && v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 && n <= 100 {
+			p.CompanionSharePercent = n
+		}
+	}
+	return p
+}
+
+func validTiers(tiers []CancellationTier) bool {
+	for _, t := range tiers {
+		if t.AfterMinutes < 0 || t.RefundPercent < 0 || t.RefundPercent > 100 {
+			return false
+		}
+	}
+	return len(tiers) > 0
+}
+
+func validBookingTiers(tiers []BookingCancellationTier) bool {
+	for _, t := range tiers {
+		if t.MinutesBefore < 0 || t.RefundPercent < 0 || t.RefundPercent > 100 {
+			return false
+		}
+	}
+	return len(tiers) > 0
+}
+
+// CheckClientCancellable returns why the client cannot cancel the request now, or nil if they can.
+func CheckClientCancellable(ir *models.InteractionRequest) error {
+	switch {
+	case ir.Status == domain.RequestStatusDisputed:
+		return ErrCancelDisputed
+	case ir.Status == domain.RequestStatusAccepted && ir.DeliveredAt != nil:
+		return ErrCancelAfterDelivery
+	case !CanTransition(ir.Status, domain.RequestStatusCancelled):
+		return fmt.Errorf("%w: %s -> %s", ErrIllegalTransition, ir.Status, domain.RequestStatusCancelled)
+	}
+	return nil
+}
+
+// QuoteCancellation returns what the client would get back by cancelling now, or why they cannot.
+func (s *Service) QuoteCancellation(ir *models.InteractionRequest) (CancellationQuote, error) {
+	if err := CheckClientCancellable(ir); err != nil {
+		return CancellationQuote{}, err
+	}
+	hold, _ := s.escrowRepo.GetByInteractionID(ir.ID)
+	if hold != nil && hold.Status != domain.EscrowStatusHeld {
+		hold = nil
+	}
+	return s.CancellationPolicy().Quote(ir, hold, time.Now()), nil
+}
+
+// CancelByClient cancels a PENDING, PENDING_KYC or ACCEPTED request at the client's request under the
+// cancellation policy: the refund goes to the client's wallet, the companion's share of any fee to her
+// withdrawable balance, the rest to the platform. Both sides are notified. A disputed request cannot be
+// cancelled by the client: only the dispute resolution settles it.
+func (s *Service) CancelByClient(ir *models.InteractionRequest, actorID *uint, reason string) (CancellationQuote, error) {
+	if err := CheckClientCancellable(ir); err != nil {
+		return CancellationQuote{}, err
+	}
+	hold, err := s.escrowRepo.Hold(ir)
+	if err != nil && !errors.Is(err, repository.ErrEscrowNoPayment) {
+		return CancellationQuote{}, err
+	}
+	quote := s.CancellationPolicy().Quote(ir, hold, time.Now())
+	from := ir.Status
+	if reason == "" {
+		reason = "cancelled by client"
+	}
+	var step repository.EscrowStep
+	switch {
+	case hold == nil:
+	case quote.FeeCents == 0:
+		step = s.escrowRepo.RefundStep(ir, reason, actorID)
+	default:
+		step = s.escrowRepo.SplitStep(ir, quote.RefundCents, quote.CompanionCents, reason, actorID)
+	}
+	if _, err := s.transitionSettling(ir, domain.RequestStatusCancelled, actorID, reason, nil, step); err != nil {
+		return CancellationQuote{}, err
+	}
+	if from == domain.RequestStatusAccepted {
+		s.endSession(ir)
+		s.refreshClientReliability(ir.ClientID)
+	}
+
+	clientMsg := "Your request has been cancelled."
+	if quote.AmountCents > 0 && quote.FeeCents == 0 {
+		clientMsg += " Your payment has been refunded to your wallet."
+	} else if quote.AmountCents > 0 {
+		clientMsg += fmt.Sprintf(" KES %.2f has been refunded to your wallet under the cancellation policy.", float64(quote.RefundCents)/100)
+	}
+	_ = s.notifSvc.Notify(ir.ClientID, "REQUEST_CANCELLED", "Request cancelled", clientMsg,
+		map[string]interface{}{"interaction_id": ir.ID, "refund_cents": quote.RefundCents, "fee_cents": quote.FeeCents})
+	if from == domain.RequestStatusPendingKYC {
+		return quote, nil // never reached the companion
+	}
+	if comp, _ := s.companionRepo.GetByID(ir.CompanionID); comp != nil {
+		body := "The client cancelled their request."
+		if quote.CompanionCents > 0 {
+			body += fmt.Sprintf(" KES %.2f cancellation fee has been added to your withdrawable balance.", float64(quote.CompanionCents)/100)
+		}
+		_ = s.notifSvc.Notify(comp.UserID, "REQUEST_CANCELLED", "Request cancelled", body,
+			map[string]interface{}{"interaction_id": ir.ID, "companion_cents": quote.CompanionCents})
+	}
+	return quote, nil
+}
@@ -0,0 +1,157 @@
+package interaction
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"lusty/internal/database/databasetest"
+	"lusty/internal/domain"
+	"lusty/internal/models"
+	"lusty/internal/repository"
+	"lusty/internal/service"
+
+	"gorm.io/gorm"
+)
+
+func newTestService(db *gorm.DB) *Service {
+	userRepo := repository.NewUserRepository(db)
+	return NewService(repository.NewInteractionRepository(db), repository.NewCompanionRepository(db), userRepo,
+		repository.NewWalletRepository(db), repository.NewEscrowRepository(db), repository.NewReferralRepository(db),
+		repository.NewSettingRepository(db), repository.NewAvailabilityRepository(db), repository.NewExtensionRepository(db),
+		repository.NewDisputeRepository(db), service.NewNotificationService(repository.NewNotificationRepository(db), userRepo, nil))
+}
+
+// seedHeldInteraction creates a client, a companion and an interaction in status whose 120000-cent payment
+// is held in escrow.
+func seedHeldInteraction(t *testing.T, db *gorm.DB, status string) *models.InteractionRequest {
+	t.Helper()
+	client := models.User{Email: "client@example.com", Username: "client", Role: domain.RoleClient, KYC: true}
+	companionUser := models.User{Email: "companion@example.com", Username: "companion", Role: domain.RoleCompanion}
+	for _, u := range []*models.User{&client, &companionUser} {
+		if err := db.Create(u).Error; err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+	}
+	comp := models.CompanionProfile{UserID: companionUser.ID, DisplayName: "companion"}
+	if err := db.Create(&comp).Error; err != nil {
+		t.Fatalf("create companion: %v", err)
+	}
+	pay := models.Payment{UserID: client.ID, AmountCents: 120_000, Currency: "KES", Provider: "fake", ProviderRef: "ref_1",
+		IdempotencyKey: "key_1", Status: domain.PaymentStatusCompleted}
+	if err := db.Create(&pay).Error; err != nil {
+		t.Fatalf("create payment: %v", err)
+	}
+	accepted := time.Now().Add(-time.Hour)
+	ir := &models.InteractionRequest{ClientID: client.ID, CompanionID: comp.ID, InteractionType: "CHAT", PaymentID: &pay.ID,
+		DurationMinutes: 60, Status: status, AcceptedAt: &accepted}
+	if err := db.Create(ir).Error; err != nil {
+		t.Fatalf("create interaction: %v", err)
+	}
+	if _, err := repository.NewEscrowRepository(db).Hold(ir); err != nil {
+		t.Fatalf("hold: %v", err)
+	}
+	return ir
+}
+
+// TestCancelByClientRejectsDisputed checks a client cannot cancel their way out of a dispute: the request
+// stays DISPUTED with its money in escrow for the resolution to settle.
+func TestCancelByClientRejectsDisputed(t *testing.T) {
+	db := databasetest.New(t)
+	svc := newTestService(db)
+	ir := seedHeldInteraction(t, db, domain.RequestStatusDisputed)
+
+	if _, err := svc.QuoteCancellation(ir); !errors.Is(err, ErrCancelDisputed) {
+		t.Fatalf("quote: err = %v, want ErrCancelDisputed", err)
+	}
+	if _, err := svc.CancelByClient(ir, &ir.ClientID, ""); !errors.Is(err, ErrCancelDisputed) {
+		t.Fatalf("cancel: err = %v, want ErrCancelDisputed", err)
+	}
+	stored, _ := repository.NewInteractionRepository(db).GetByID(ir.ID)
+	if stored.Status != domain.RequestStatusDisputed {
+		t.Fatalf("status = %s, want DISPUTED", stored.Status)
+	}
+	hold, _ := repository.NewEscrowRepository(db).GetByInteractionID(ir.ID)
+	if hold == nil || hold.Status != domain.EscrowStatusHeld || hold.RefundedCents != 0 {
+		t.Fatalf("hold = %+v, want HELD with nothing refunded", hold)
+	}
+}
+
+// TestCancelByClientAppliesPolicy checks a cancellation an hour after accept refunds the last tier and splits
+// the fee between the companion and the platform.
+func TestCancelByClientAppliesPolicy(t *testing.T) {
+	db := databasetest.New(t)
+	svc := newTestService(db)
+	ir := seedHeldInteraction(t, db, domain.RequestStatusAccepted)
+
+	quote, err := svc.CancelByClient(ir, &ir.ClientID, "")
+	if err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if quote.RefundPercent != 50 || quote.RefundCents != 60_000 || quote.FeeCents != 60_000 {
+		t.Fatalf("quote = %+v, want 50%% of 120000 refunded", quote)
+	}
+	w, err := repository.NewWalletRepository(db).GetByUserID(ir.ClientID)
+	if err != nil || w.BalanceCents != 60_000 {
+		t.Fatalf("client wallet = %+v (%v), want 60000", w, err)
+	}
+	if _, err := svc.CancelByClient(ir, &ir.ClientID, ""); !errors.Is(err, ErrIllegalTransition) {
+		t.Fatalf("second cancel: err = %v, want ErrIllegalTransition", err)
+	}
+}
+
+func TestCancellationQuoteTiers(t *testing.T) {
+	accepted := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	hold := &models.EscrowHold{AmountCents: 120_000}
+	for _, tc := range []struct {
+		name    string
+		status  string
+		elapsed time.Duration
+		want    int
+	}{
+		{"before accept", domain.RequestStatusPending, 0, 100},
+		{"just accepted", domain.RequestStatusAccepted, 5 * time.Minute, 100},
+		{"second tier", domain.RequestStatusAccepted, 10 * time.Minute, 75},
+		{"last tier", domain.RequestStatusAccepted, 45 * time.Minute, 50},
+	} {
+		ir := &models.InteractionRequest{Status: tc.status}
+		if tc.status == domain.RequestStatusAccepted {
+			ir.AcceptedAt = &accepted
+		}
+		q := DefaultCancellationPolicy.Quote(ir, hold, accepted.Add(tc.elapsed))
+		if q.RefundPercent != tc.want || q.RefundCents != hold.AmountCents*int64(tc.want)/100 {
+			t.Errorf("%s: quote = %+v, want %d%% refunded", tc.name, q, tc.want)
+		}
+		if max := domain.CompanionPayout(hold.CompanionBaseCents()); q.CompanionCents > max {
+			t.Errorf("%s: companion share %d exceeds her payout %d", tc.name, q.CompanionCents, max)
+		}
+	}
+}
+
+// TestCancellationQuoteBookingTiers checks an accepted booking is quoted by the time left before its slot,
+// however long ago it was accepted.
+func TestCancellationQuoteBookingTiers(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	acceptedLongAgo := now.Add(-72 * time.Hour)
+	hold := &models.EscrowHold{AmountCents: 120_000}
+	for _, tc := range []struct {
+		name string
+		left time.Duration
+		want int
+	}{
+		{"days ahead", 48 * time.Hour, 100},
+		{"the day before", 3 * time.Hour, 75},
+		{"last two hours", 30 * time.Minute, 50},
+		{"slot started", -10 * time.Minute, 50},
+	} {
+		slot := now.Add(tc.left)
+		ir := &models.InteractionRequest{Status: domain.RequestStatusAccepted, AcceptedAt: &acceptedLongAgo, SlotStart: &slot}
+		if q := DefaultCancellationPolicy.Quote(ir, hold, now); q.RefundPercent != tc.want {
+			t.Errorf("%s: refund = %d%%, want %d%%", tc.name, q.RefundPercent, tc.want)
+		}
+	}
+	pending := &models.InteractionRequest{Status: domain.RequestStatusPending, SlotStart: &now}
+	if q := DefaultCancellationPolicy.Quote(pending, hold, now); q.RefundPercent != 100 {
+		t.Errorf("unaccepted booking: refund = %d%%, want 100%%", q.RefundPercent)
+	}
+}
@@ -0,0 +1,45 @@
+package interaction
+
+import (
+	"errors"
+	"strconv"
+
+	"lusty/internal/domain"
+	"lusty/internal/models"
+)
+
+var (
+	ErrCompanionUnavailable = errors.New("companion is not taking requests right now")
+	ErrAtCapacity           = errors.New("companion is busy right now; try again later or book a time slot")
+)
+
+// DefaultMaxConcurrentChats is the highest chat capacity a companion may choose when the setting is not set.
+const DefaultMaxConcurrentChats = 3
+
+// MaxChatCapacity is the highest max_concurrent_chats a companion may choose.
+func (s *Service) MaxChatCapacity() int {
+	if s.settingRepo != nil {
+		if v, err := s.settingRepo.Get(domain.SettingMaxConcurrentChats); err == nil && v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n >= 1 {
+				return n
+			}
+		}
+	}
+	return DefaultMaxConcurrentChats
+}
+
+// CheckAvailableNow reports whether the companion can take an immediate (unscheduled) request: she is taking
+// requests, is not in a booked slot and has chat capacity left. Bookings are checked with CheckSlot instead.
+func (s *Service) CheckAvailableNow(comp *models.CompanionProfile) error {
+	if !comp.IsActive || !comp.AcceptNewRequests || !comp.IsAvailable {
+		return ErrCompanionUnavailable
+	}
+	ok, err := s.interactionRepo.CompanionAvailableNow(comp.ID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrAtCapacity
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package interaction
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"lusty/internal/domain"
+	"lusty/internal/models"
+	"lusty/internal/repository"
+)
+
+var (
+	ErrExtensionNotAllowed = errors.New("this session can no longer be extended")
+	ErrNoChatPricing       = errors.New("companion has no CHAT_ACCESS price")
+)
+
+// ExtensionMinutes are the lengths a session can be extended by.
+var ExtensionMinutes = []int{15, 30, 60, 120}
+
+// RoomSink delivers an event to everyone connected to an interaction's live chat room.
+type RoomSink interface {
+	PublishToRoom(interactionID uint, payload interface{})
+}
+
+// SetRooms enables pushing session events into live chat rooms; nil disables it.
+func (s *Service) SetRooms(rooms RoomSink) {
+	s.rooms = rooms
+}
+
+// QuoteExtension returns what the client pays to extend ir's session by minutes: the companion's CHAT_ACCESS
+// rate pro rata (hourly, or daily for per_day and per_night pricing), rounded up to the cent. Extensions carry
+// no platform fee of their own; the platform's share is the usual cut when escrow is released.
+func (s *Service) QuoteExtension(ir *models.InteractionRequest, minutes int) (int64, error) {
+	if _, ok := s.extendableSession(ir); !ok {
+		return 0, ErrExtensionNotAllowed
+	}
+	p, _ := s.companionRepo.GetPricingByCompanionAndType(ir.CompanionID, "CHAT_ACCESS")
+	if p == nil || !p.IsActive || p.AmountCents <= 0 {
+		return 0, ErrNoChatPricing
+	}
+	period := int64(60)
+	if p.Unit == "per_day" || p.Unit == "per_night" {
+		period = 24 * 60
+	}
+	return (p.AmountCents*int64(minutes) + period - 1) / period, nil
+}
+
+// ApplyExtension settles an extension whose payment completed: the money joins the interaction's escrow and
+// the session end moves forward, which both parties see in the chat room and as a notification. If the
+// session closed while the payment was in flight, the client is refunded to their wallet instead. Safe to
+// call again for the same extension.
+func (s *Service) ApplyExtension(ext *models.SessionExtension) error {
+	if ext.Status != domain.ExtensionStatusPending {
+		return nil
+	}
+	ir, err := s.interactionRepo.GetByID(ext.InteractionID)
+	if err != nil {
+		return err
+	}
+	if _, ok := s.extendableSession(ir); ok {
+		err = s.extensionRepo.Apply(ext, ir)
+		switch {
+		case err == nil:
+			s.announceExtension(ir, ext)
+			return nil
+		case errors.Is(err, repository.ErrExtensionSettled):
+			return nil
+		case !errors.Is(err, repository.ErrEscrowSettled):
+			return err
+		}
+	}
+	if err := s.extensionRepo.Refund(ext); err != nil {
+		if errors.Is(err, repository.ErrExtensionSettled) {
+			return nil
+		}
+		return err
+	}
+	log.Printf("[interaction] extension %d of %d refunded: session closed before payment completed", ext.ID, ir.ID)
+	_ = s.notifSvc.Notify(ext.ClientID, "SESSION_EXTENSION_REFUNDED", "Extension refunded",
+		"Your session ended before the extension payment went through. It has been refunded to your wallet.",
+		map[string]interface{}{"interaction_id": ir.ID, "extension_id": ext.ID})
+	return nil
+}
+
+// ExtendFromWallet charges the client's wallet for ext and applies it at once; pay is the wallet payment to
+// record. The charge, the payment, the extension and the new session end commit together, so a failure
+// leaves the wallet untouched. Returns ErrExtensionNotAllowed if the session can no longer be extended and
+// repository.ErrInsufficientBalance if the wallet is short.
+func (s *Service) ExtendFromWallet(ir *models.InteractionRequest, ext *models.SessionExtension, pay *models.Payment) error {
+	if _, ok := s.extendableSession(ir); !ok {
+		return ErrExtensionNotAllowed
+	}
+	err := s.extensionRepo.ApplyFromWallet(ext, pay, ir)
+	if errors.Is(err, repository.ErrEscrowSettled) {
+		return ErrExtensionNotAllowed
+	}
+	if err != nil {
+		return err
+	}
+	s.announceExtension(ir, ext)
+	return nil
+}
+
+// extendableSession returns ir's chat session if more time can still be bought on it: an accepted,
+// unscheduled request that has not been marked delivered and whose session has not been ended. A session
+// that has only run past its end time can be extended.
+func (s *Service) extendableSession(ir *models.InteractionRequest) (*models.ChatSession, bool) {
+	if ir.Status != domain.RequestStatusAccepted || ir.DeliveredAt != nil || ir.SlotStart != nil {
+		return nil, false
+	}
+	session, _ := s.interactionRepo.GetChatSessionByInteractionID(ir.ID)
+	if session == nil || session.EndedAt != nil {
+		return nil, false
+	}
+	return session, true
+}
+
+func (s *Service) announceExtension(ir *models.InteractionRequest, ext *models.SessionExtension) {
+	data := map[string]interface{}{"interaction_id": ir.ID, "extension_id": ext.ID, "minutes": ext.Minutes, "ends_at": ext.EndsAt}
+	if s.rooms != nil {
+		s.rooms.PublishToRoom(ir.ID, map[string]interface{}{
+			"type":           "system",
+			"event":          "session_extended",
+			"interaction_id": ir.ID,
+			"minutes":        ext.Minutes,
+			"ends_at":        ext.EndsAt,
+		})
+	}
+	body := fmt.Sprintf("Your session was extended by %d minutes.", ext.Minutes)
+	_ = s.notifSvc.Notify(ir.ClientID, "SESSION_EXTENDED", "Session extended", body, data)
+	if ir.Companion.UserID != 0 {
+		_ = s.notifSvc.Notify(ir.Companion.UserID, "SESSION_EXTENDED", "Session extended", body, data)
+	}
+}
+
+// ExtensionPaid applies the extension bought with a payment that just completed. Returns the extension's
+// interaction, or 0 if the payment did not buy one.
+func (s *Service) ExtensionPaid(p *models.Payment) (uint, error) {
+	ext, err := s.extensionRepo.GetByPaymentID(p.ID)
+	if err != nil || ext == nil {
+		return 0, err
+	}
+	return ext.InteractionID, s.ApplyExtension(ext)
+}
+
+// ExtensionPaymentFailed marks the extension bought with a failed or abandoned payment FAILED.
+func (s *Service) ExtensionPaymentFailed(p *models.Payment) {
+	if ext, _ := s.extensionRepo.GetByPaymentID(p.ID); ext != nil {
+		if _, err := s.extensionRepo.MarkFailed(ext.ID); err != nil {
+			log.Printf("[interaction] extension %d: mark failed: %v", ext.ID, err)
+		}
+	}
+}
@@ -0,0 +1,521 @@
+// Package interaction owns the InteractionRequest lifecycle. Handlers, webhooks and jobs change a request's
+// status only through Service, which enforces the declared transitions, records each one in
+// interaction_transitions and runs the wallet, availability and notification side effects.
+package interaction
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"lusty/internal/domain"
+	"lusty/internal/models"
+	"lusty/internal/repository"
+	"lusty/internal/service"
+)
+
+// ErrIllegalTransition is returned when the requested status change is not in Transitions.
+var ErrIllegalTransition = errors.New("illegal interaction status transition")
+
+// Transitions is the interaction state machine: allowed target statuses for each status. "" is creation.
+var Transitions = map[string][]string{
+	"":                             {domain.RequestStatusPending, domain.RequestStatusPendingKYC},
+	domain.RequestStatusPendingKYC: {domain.RequestStatusPending, domain.RequestStatusRejected, domain.RequestStatusExpired, domain.RequestStatusCancelled},
+	domain.RequestStatusPending:    {domain.RequestStatusPendingKYC, domain.RequestStatusAccepted, domain.RequestStatusRejected, domain.RequestStatusExpired, domain.RequestStatusCancelled},
+	domain.RequestStatusAccepted:   {domain.RequestStatusCompleted, domain.RequestStatusDisputed, domain.RequestStatusCancelled},
+	domain.RequestStatusDisputed:   {domain.RequestStatusCompleted, domain.RequestStatusCancelled},
+}
+
+// CanTransition reports whether from → to is allowed.
+func CanTransition(from, to string) bool {
+	for _, s := range Transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+type Service struct {
+	interactionRepo  *repository.InteractionRepository
+	companionRepo    *repository.CompanionRepository
+	userRepo         *repository.UserRepository
+	walletRepo       *repository.WalletRepository
+	escrowRepo       *repository.EscrowRepository
+	referralRepo     *repository.ReferralRepository
+	settingRepo      *repository.SettingRepository
+	availabilityRepo *repository.AvailabilityRepository
+	extensionRepo    *repository.ExtensionRepository
+	disputeRepo      *repository.DisputeRepository
+	notifSvc         *service.NotificationService
+	rooms            RoomSink
+}
+
+func NewService(
+	interactionRepo *repository.InteractionRepository,
+	companionRepo *repository.CompanionRepository,
+	userRepo *repository.UserRepository,
+	walletRepo *repository.WalletRepository,
+	escrowRepo *repository.EscrowRepository,
+	referralRepo *repository.ReferralRepository,
+	settingRepo *repository.SettingRepository,
+	availabilityRepo *repository.AvailabilityRepository,
+	extensionRepo *repository.ExtensionRepository,
+	disputeRepo *repository.DisputeRepository,
+	notifSvc *service.NotificationService,
+) *Service {
+	return &Service{
+		interactionRepo:  interactionRepo,
+		companionRepo:    companionRepo,
+		userRepo:         userRepo,
+		walletRepo:       walletRepo,
+		escrowRepo:       escrowRepo,
+		referralRepo:     referralRepo,
+		settingRepo:      settingRepo,
+		availabilityRepo: availabilityRepo,
+		extensionRepo:    extensionRepo,
+		disputeRepo:      disputeRepo,
+		notifSvc:         notifSvc,
+	}
+}
+
+// transition applies one status change and records it. ir is updated in place on success.
+func (s *Service) transition(ir *models.InteractionRequest, to string, actorID *uint, reason string, fields map[string]interface{}) error {
+	_, err := s.transitionSettling(ir, to, actorID, reason, fields, nil)
+	return err
+}
+
+// transitionSettling is transition with an escrow movement in the same DB transaction, so a request never
+// ends up completed or cancelled with its money still in escrow. The hold is nil if the request was never paid.
+func (s *Service) transitionSettling(ir *models.InteractionRequest, to string, actorID *uint, reason string, fields map[string]interface{}, step repository.EscrowStep) (*models.EscrowHold, error) {
+	from := ir.Status
+	if !CanTransition(from, to) {
+		return nil, fmt.Errorf("%w: %s -> %s", ErrIllegalTransition, from, to)
+	}
+	return s.interactionRepo.TransitionSettling(ir, fields, &models.InteractionTransition{
+		FromStatus: from,
+		ToStatus:   to,
+		ActorID:    actorID,
+		Reason:     reason,
+	}, step)
+}
+
+// Open creates a request. For a paid request (payment already COMPLETED) the funds are moved into escrow and,
+// unless the client still needs KYC, the companion gets the request; otherwise it waits in PENDING_KYC.
+// An unpaid request is created PENDING and completed later by PaymentCompleted.
+//
+// A request with a SlotStart books that slot: it must fit the companion's availability and not overlap her
+// other bookings. Callers check that first with CheckSlot; if the slot is gone by the time the request is
+// created, it is created REJECTED (and refunded if paid) so its payment is still accounted for.
+func (s *Service) Open(ir *models.InteractionRequest, paid bool, actorID *uint) error {
+	ir.Status = domain.RequestStatusPending
+	if paid && !s.clientHasKYC(ir.ClientID) {
+		ir.Status = domain.RequestStatusPendingKYC
+	}
+	t := &models.InteractionTransition{
+		ToStatus: ir.Status,
+		ActorID:  actorID,
+	}
+	// A paid request is created with its payment already in escrow
+	var hold repository.EscrowStep
+	if paid {
+		hold = s.escrowRepo.HoldStep(ir)
+	}
+	var slotErr error
+	if ir.SlotStart != nil {
+		slotErr = s.openBooking(ir, t, hold)
+	}
+	if ir.SlotStart == nil || slotErr != nil {
+		if err := s.interactionRepo.CreateWithTransition(ir, t, hold); err != nil {
+			return err
+		}
+	}
+	if slotErr != nil {
+		s.rejectUnavailableSlot(ir, slotErr)
+		return nil
+	}
+	if !paid {
+		return nil
+	}
+	if ir.Status == domain.RequestStatusPending {
+		s.deliver(ir)
+	}
+	return nil
+}
+
+// PaymentCompleted is called when the payment of an already-created PENDING request completes.
+func (s *Service) PaymentCompleted(ir *models.InteractionRequest) error {
+	if ir.Status != domain.RequestStatusPending {
+		return fmt.Errorf("%w: payment completed in %s", ErrIllegalTransition, ir.Status)
+	}
+	if !s.clientHasKYC(ir.ClientID) {
+		// Client has not completed KYC: keep companion available for other clients
+		_, err := s.transitionSettling(ir, domain.RequestStatusPendingKYC, nil, "client KYC not complete", nil, s.escrowRepo.HoldStep(ir))
+		return err
+	}
+	if _, err := s.escrowRepo.Hold(ir); err != nil && !errors.Is(err, repository.ErrEscrowNoPayment) {
+		return fmt.Errorf("escrow hold for interaction %d: %w", ir.ID, err)
+	}
+	s.deliver(ir)
+	return nil
+}
+
+// ReleaseKYC sends a PENDING_KYC request to the companion after the client completes KYC. If the companion
+// stopped taking requests in the meantime, the request is rejected and the client refunded instead.
+func (s *Service) ReleaseKYC(ir *models.InteractionRequest, actorID *uint) (bool, error) {
+	comp, _ := s.companionRepo.GetByID(ir.CompanionID)
+	if comp == nil || !comp.AcceptNewRequests || !comp.IsAvailable {
+		if err := s.Reject(ir, actorID, "companion unavailable after KYC"); err != nil {
+			return false, err
+		}
+		companionName := "your companion"
+		if comp != nil {
+			companionName = comp.DisplayName
+		}
+		_ = s.notifSvc.Notify(ir.ClientID, "KYC_REFUND", "Companion unavailable",
+			companionName+" is no longer available. Your payment has been refunded to your wallet.",
+			map[string]interface{}{"interaction_id": ir.ID})
+		return false, nil
+	}
+	if err := s.transition(ir, domain.RequestStatusPending, actorID, "client KYC complete", nil); err != nil {
+		return false, err
+	}
+	s.deliver(ir)
+	return true, nil
+}
+
+// Accept starts the session. A companion runs up to her chat capacity of unscheduled sessions at once
+// (ErrAtCapacity beyond that); the one that fills it rejects and refunds her other unscheduled pending
+// requests. Accepting a booking instead confirms the slot: its session runs over the slot, and the companion's
+// other requests are left alone.
+func (s *Service) Accept(ir *models.InteractionRequest, actorID *uint) error {
+	from := ir.Status
+	if !CanTransition(from, domain.RequestStatusAccepted) {
+		return fmt.Errorf("%w: %s -> %s", ErrIllegalTransition, from, domain.RequestStatusAccepted)
+	}
+	comp, _ := s.companionRepo.GetByID(ir.CompanionID)
+	capacity := 0
+	if ir.SlotStart == nil && comp != nil {
+		capacity = comp.ChatCapacity()
+	}
+	now := time.Now()
+	session := &models.ChatSession{StartedAt: now, EndsAt: now.Add(time.Duration(ir.DurationMinutes) * time.Minute)}
+	if ir.DurationMinutes <= 0 {
+		session.EndsAt = now.Add(24 * time.Hour)
+	}
+	if ir.SlotStart != nil && ir.SlotEnd != nil {
+		session.StartedAt, session.EndsAt = *ir.SlotStart, *ir.SlotEnd
+	}
+	// Client's payment stays in escrow until service done (held at payment time; this covers older requests).
+	// The capacity check, the status change, the hold and the session commit together.
+	live, err := s.interactionRepo.AcceptWithSession(ir, map[string]interface{}{"accepted_at": now}, &models.InteractionTransition{
+		FromStatus: from,
+		ToStatus:   domain.RequestStatusAccepted,
+		ActorID:    actorID,
+	}, s.escrowRepo.HoldStep(ir), session, capacity)
+	if errors.Is(err, repository.ErrNoChatCapacity) {
+		return ErrAtCapacity
+	}
+	if err != nil {
+		return err
+	}
+	ir.AcceptedAt = &now
+	if comp == nil {
+		return nil
+	}
+	_ = s.notifSvc.NotifyAccepted(ir.ClientID, comp.DisplayName, ir.ID)
+	if capacity == 0 || live < int64(capacity) {
+		return nil
+	}
+	// At capacity: other clients waiting for an immediate session would only wait until expiry
+	others, _ := s.interactionRepo.ListPendingForCompanion(ir.CompanionID, 100)
+	for i := range others {
+		if others[i].ID == ir.ID || others[i].SlotStart != nil {
+			continue // bookings for later slots stay pending
+		}
+		if err := s.Reject(&others[i], actorID, "companion is at capacity"); err != nil {
+			log.Printf("[interaction] auto-reject of %d failed: %v", others[i].ID, err)
+		}
+	}
+	return nil
+}
+
+// Reject declines a request that has not started and refunds the client.
+func (s *Service) Reject(ir *models.InteractionRequest, actorID *uint, reason string) error {
+	from := ir.Status
+	now := time.Now()
+	if _, err := s.transitionSettling(ir, domain.RequestStatusRejected, actorID, reason, map[string]interface{}{"rejected_at": now},
+		s.escrowRepo.RefundStep(ir, reason, actorID)); err != nil {
+		return err
+	}
+	ir.RejectedAt = &now
+	// PENDING_KYC requests never reached the companion: notifications are the caller's concern
+	if from != domain.RequestStatusPending {
+		return nil
+	}
+	if comp, _ := s.companionRepo.GetByID(ir.CompanionID); comp != nil {
+		_ = s.notifSvc.NotifyRejected(ir.ClientID, comp.DisplayName)
+	}
+	return nil
+}
+
+// Expire closes a request the companion never answered, or whose payment never completed, and refunds the
+// client if it was paid. The client is always told; the companion only about a paid request, since an unpaid
+// one never reached her.
+func (s *Service) Expire(ir *models.InteractionRequest, reason string) error {
+	from := ir.Status
+	hold, err := s.transitionSettling(ir, domain.RequestStatusExpired, nil, reason, nil, s.escrowRepo.RefundStep(ir, reason, nil))
+	if err != nil {
+		return err
+	}
+	if hold == nil {
+		_ = s.notifSvc.Notify(ir.ClientID, "REQUEST_EXPIRED", "Request expired",
+			"Your request expired before its payment was completed.", map[string]interface{}{"interaction_id": ir.ID})
+		return nil
+	}
+	if from == domain.RequestStatusPending {
+		if comp, _ := s.companionRepo.GetByID(ir.CompanionID); comp != nil {
+			_ = s.notifSvc.Notify(comp.UserID, "REQUEST_EXPIRED", "Request expired",
+				"A paid request expired before you responded.", map[string]interface{}{"interaction_id": ir.ID})
+		}
+	}
+	_ = s.notifSvc.Notify(ir.ClientID, "REQUEST_EXPIRED", "Request expired",
+		"Your request was not answered in time. Your payment has been refunded to your wallet.",
+		map[string]interface{}{"interaction_id": ir.ID})
+	return nil
+}
+
+// Cancel cancels a request with a full refund (admin and system use). Clients cancel through CancelByClient,
+// which applies the cancellation policy.
+func (s *Service) Cancel(ir *models.InteractionRequest, actorID *uint, reason string) error {
+	from := ir.Status
+	if _, err := s.transitionSettling(ir, domain.RequestStatusCancelled, actorID, reason, nil, s.escrowRepo.RefundStep(ir, reason, actorID)); err != nil {
+		return err
+	}
+	if from == domain.RequestStatusAccepted || from == domain.RequestStatusDisputed {
+		s.endSession(ir)
+	}
+	if from == domain.RequestStatusPendingKYC {
+		return nil
+	}
+	if comp, _ := s.companionRepo.GetByID(ir.CompanionID); comp != nil {
+		_ = s.notifSvc.Notify(comp.UserID, "REQUEST_CANCELLED", "Request cancelled",
+			"The client cancelled their request.", map[string]interface{}{"interaction_id": ir.ID})
+	}
+	return nil
+}
+
+// Complete is the client confirming the service: ends the chat, releases escrow to the companion and pays
+// any referral commission.
+func (s *Service) Complete(ir *models.InteractionRequest, actorID *uint) error {
+	return s.complete(ir, actorID, "")
+}
+
+// MarkDelivered is the companion saying the service was delivered. The client then has until autoCompleteAt
+// to confirm or dispute; after that AutoComplete completes it.
+func (s *Service) MarkDelivered(ir *models.InteractionRequest, autoCompleteAt time.Time) (bool, error) {
+	now := time.Now()
+	ok, err := s.interactionRepo.MarkDelivered(ir.ID, now, autoCompleteAt)
+	if err != nil || !ok {
+		return ok, err
+	}
+	ir.DeliveredAt, ir.AutoCompleteAt = &now, &autoCompleteAt
+	companionName := "Your companion"
+	if comp, _ := s.companionRepo.GetByID(ir.CompanionID); comp != nil && comp.DisplayName != "" {
+		companionName = comp.DisplayName
+	}
+	_ = s.notifSvc.Notify(ir.ClientID, "SERVICE_DELIVERED", "Confirm your session",
+		fmt.Sprintf("%s marked your session as delivered. Confirm it or open a dispute before %s, otherwise it completes automatically.",
+			companionName, autoCompleteAt.Format("2 Jan 15:04")),
+		map[string]interface{}{"interaction_id": ir.ID, "auto_complete_at": autoCompleteAt})
+	return true, nil
+}
+
+// AutoComplete completes a delivered interaction the client neither confirmed nor disputed in time, with the
+// same payout as Complete.
+func (s *Service) AutoComplete(ir *models.InteractionRequest) error {
+	if err := s.complete(ir, nil, "auto-completed after grace period"); err != nil {
+		return err
+	}
+	_ = s.notifSvc.Notify(ir.ClientID, "SERVICE_AUTO_COMPLETED", "Session completed",
+		"Your session was completed automatically because it was not confirmed or disputed in time.",
+		map[string]interface{}{"interaction_id": ir.ID})
+	if comp, _ := s.companionRepo.GetByID(ir.CompanionID); comp != nil {
+		_ = s.notifSvc.Notify(comp.UserID, "SERVICE_AUTO_COMPLETED", "Session completed",
+			"Your session was completed automatically. Your earnings are now withdrawable.",
+			map[string]interface{}{"interaction_id": ir.ID})
+	}
+	return nil
+}
+
+func (s *Service) complete(ir *models.InteractionRequest, actorID *uint, reason string) error {
+	now := time.Now()
+	if _, err := s.transitionSettling(ir, domain.RequestStatusCompleted, actorID, reason, map[string]interface{}{"service_completed_at": now},
+		s.releaseStep(ir, actorID)); err != nil {
+		return err
+	}
+	ir.ServiceCompletedAt = &now
+	s.endSession(ir)
+	s.refreshClientReliability(ir.ClientID)
+	return nil
+}
+
+// Dispute freezes an accepted interaction until it is resolved: d is stored and the interaction moves to
+// DISPUTED together, the escrow stays held and the client can no longer confirm service done. The other party
+// is notified.
+func (s *Service) Dispute(ir *models.InteractionRequest, d *models.Dispute, actorID *uint) error {
+	if !CanTransition(ir.Status, domain.RequestStatusDisputed) {
+		return fmt.Errorf("%w: %s -> %s", ErrIllegalTransition, ir.Status, domain.RequestStatusDisputed)
+	}
+	if err := s.disputeRepo.Open(d, ir, &models.InteractionTransition{
+		FromStatus: ir.Status,
+		ToStatus:   domain.RequestStatusDisputed,
+		ActorID:    actorID,
+		Reason:     d.Reason,
+	}); err != nil {
+		return err
+	}
+	notifyID := ir.ClientID
+	if actorID != nil && *actorID == ir.ClientID {
+		notifyID = ir.Companion.UserID
+		if notifyID == 0 {
+			if comp, _ := s.companionRepo.GetByID(ir.CompanionID); comp != nil {
+				notifyID = comp.UserID
+			}
+		}
+	}
+	if notifyID != 0 {
+		_ = s.notifSvc.Notify(notifyID, "DISPUTE_OPENED", "Dispute opened",
+			"A dispute was opened on your session. Payment is on hold until our team reviews it. You can add evidence in the app.",
+			map[string]interface{}{"interaction_id": ir.ID})
+	}
+	return nil
+}
+
+// ResolveDispute settles the DISPUTED interaction of the open dispute d. REFUND cancels it and returns the
+// payment to the client's wallet; RELEASE completes it and pays the companion as if the client had confirmed;
+// SPLIT completes it, refunds clientCents and releases companionCents, the platform keeping the rest. The
+// dispute, the interaction and the escrow change in one transaction, so a failure leaves the dispute open for
+// the admin to retry.
+func (s *Service) ResolveDispute(d *models.Dispute, ir *models.InteractionRequest, resolution string, clientCents, companionCents int64, actorID *uint, note string) (*models.EscrowHold, error) {
+	to, fields := domain.RequestStatusCompleted, map[string]interface{}{"service_completed_at": time.Now()}
+	if resolution == domain.DisputeResolutionRefund {
+		to, fields = domain.RequestStatusCancelled, nil
+	}
+	if ir.Status != domain.RequestStatusDisputed {
+		return nil, fmt.Errorf("%w: resolve dispute in %s", ErrIllegalTransition, ir.Status)
+	}
+	reason := "dispute resolved: " + note
+	var step repository.EscrowStep
+	switch resolution {
+	case domain.DisputeResolutionRefund:
+		step = s.escrowRepo.RefundStep(ir, reason, actorID)
+	case domain.DisputeResolutionRelease:
+		step = s.releaseStep(ir, actorID)
+	default:
+		step = s.escrowRepo.SplitStep(ir, clientCents, companionCents, reason, actorID)
+	}
+	d.Resolution, d.ResolutionNote, d.ResolvedBy = resolution, note, actorID
+	hold, err := s.disputeRepo.Resolve(d, ir, fields, &models.InteractionTransition{
+		FromStatus: ir.Status,
+		ToStatus:   to,
+		ActorID:    actorID,
+		Reason:     reason,
+	}, step)
+	if err != nil {
+		return nil, err
+	}
+	s.endSession(ir)
+	comp, _ := s.companionRepo.GetByID(ir.CompanionID)
+	data := map[string]interface{}{"interaction_id": ir.ID, "resolution": resolution}
+	if hold != nil {
+		data["refunded_cents"], data["released_cents"] = hold.RefundedCents, hold.ReleasedCents
+	}
+	_ = s.notifSvc.Notify(ir.ClientID, "DISPUTE_RESOLVED", "Dispute resolved", disputeMessage(resolution, hold, true), data)
+	if comp != nil {
+		_ = s.notifSvc.Notify(comp.UserID, "DISPUTE_RESOLVED", "Dispute resolved", disputeMessage(resolution, hold, false), data)
+	}
+	return hold, nil
+}
+
+func disputeMessage(resolution string, hold *models.EscrowHold, client bool) string {
+	switch {
+	case hold == nil:
+		return "Your dispute has been resolved."
+	case client && hold.RefundedCents > 0:
+		return fmt.Sprintf("Your dispute has been resolved. KES %.2f has been refunded to your wallet.", float64(hold.RefundedCents)/100)
+	case !client && hold.ReleasedCents > 0:
+		return fmt.Sprintf("Your dispute has been resolved. KES %.2f has been added to your withdrawable balance.", float64(hold.ReleasedCents)/100)
+	case resolution == domain.DisputeResolutionRefund && !client:
+		return "Your dispute has been resolved. The payment was refunded to the client."
+	default:
+		return "Your dispute has been resolved. No payment is due to you for this session."
+	}
+}
+
+// deliver sends a paid PENDING request to the companion. A pending request does not take up her capacity;
+// only accepting it does.
+func (s *Service) deliver(ir *models.InteractionRequest) {
+	comp, _ := s.companionRepo.GetByID(ir.CompanionID)
+	if comp == nil {
+		return
+	}
+	clientName := "A client"
+	if u, _ := s.userRepo.GetByID(ir.ClientID); u != nil {
+		if u.Username != "" {
+			clientName = u.Username
+		} else {
+			clientName = u.Email
+		}
+	}
+	_ = s.notifSvc.NotifyPaidRequest(comp.UserID, ir.ID, clientName, ServiceType(ir))
+}
+
+func (s *Service) endSession(ir *models.InteractionRequest) {
+	session, _ := s.interactionRepo.GetChatSessionByInteractionID(ir.ID)
+	if session == nil || session.EndedAt != nil {
+		return
+	}
+	now := time.Now()
+	session.EndedAt = &now
+	_ = s.interactionRepo.UpdateChatSession(session)
+	_ = s.interactionRepo.DeleteMessagesBySessionID(session.ID)
+}
+
+// refreshClientReliability recomputes the client's reliability score after a session they finished or
+// cancelled.
+func (s *Service) refreshClientReliability(clientID uint) {
+	if err := s.userRepo.RefreshClientReliability(clientID); err != nil {
+		log.Printf("[interaction] refresh reliability for client %d: %v", clientID, err)
+	}
+}
+
+// releaseStep releases escrow to the companion and, in the same transaction, pays any referral commission
+// she earns her referrer.
+func (s *Service) releaseStep(ir *models.InteractionRequest, actorID *uint) repository.EscrowStep {
+	step := s.escrowRepo.ReleaseStep(ir, actorID)
+	if s.referralRepo == nil {
+		return step
+	}
+	return s.referralRepo.CommissionStep(step, ir.ID)
+}
+
+func (s *Service) clientHasKYC(clientID uint) bool {
+	u, _ := s.userRepo.GetByID(clientID)
+	return u != nil && u.KYC
+}
+
+// ServiceType returns the requested service from payment metadata, falling back to the interaction type.
+func ServiceType(ir *models.InteractionRequest) string {
+	if ir.Payment != nil && ir.Payment.Metadata != "" {
+		var meta struct {
+			ServiceType string `json:"service_type"`
+		}
+		if json.Unmarshal([]byte(ir.Payment.Metadata), &meta) == nil && meta.ServiceType != "" {
+			return meta.ServiceType
+		}
+	}
+	return ir.InteractionType
+}
@@ -0,0 +1,37 @@
+package interaction
+
+import (
+	"testing"
+
+	"lusty/internal/database/databasetest"
+	"lusty/internal/domain"
+	"lusty/internal/models"
+)
+
+// TestExpireUnpaidNotifiesClient checks a request whose payment never completed still tells the client it
+// expired, and does not bother the companion, who never saw it.
+func TestExpireUnpaidNotifiesClient(t *testing.T) {
+	db := databasetest.New(t)
+	svc := newTestService(db)
+	client := models.User{Email: "client@example.com", Username: "client", Role: domain.RoleClient, KYC: true}
+	if err := db.Create(&client).Error; err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	comp := models.CompanionProfile{UserID: 100, DisplayName: "companion"}
+	if err := db.Create(&comp).Error; err != nil {
+		t.Fatalf("create companion: %v", err)
+	}
+	ir := &models.InteractionRequest{ClientID: client.ID, CompanionID: comp.ID, InteractionType: "CHAT", DurationMinutes: 60}
+	if err := svc.Open(ir, false, &client.ID); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	if err := svc.Expire(ir, "payment failed"); err != nil {
+		t.Fatalf("expire: %v", err)
+	}
+	var notes []models.Notification
+	db.Where("type = ?", "REQUEST_EXPIRED").Find(&notes)
+	if len(notes) != 1 || notes[0].UserID != client.ID {
+		t.Fatalf("notifications = %+v, want one for the client", notes)
+	}
+}
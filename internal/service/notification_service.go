@@ -5,43 +5,63 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"strings"
 
 	"lusty/internal/models"
 	"lusty/internal/repository"
 )
 
+// LiveSink delivers an event to a user's open realtime connections (the /ws/user hub).
+type LiveSink interface {
+	BroadcastToUser(userID uint, payload interface{})
+}
+
 type NotificationService struct {
 	repo     *repository.NotificationRepository
 	userRepo *repository.UserRepository
 	fcm      *FCMService
+	live     LiveSink
 }
 
 func NewNotificationService(repo *repository.NotificationRepository, userRepo *repository.UserRepository, fcm *FCMService) *NotificationService {
 	return &NotificationService{repo: repo, userRepo: userRepo, fcm: fcm}
 }
 
+// SetLive enables realtime delivery of notifications to connected users; nil disables it.
+func (s *NotificationService) SetLive(live LiveSink) {
+	s.live = live
+}
+
 func (s *NotificationService) Notify(userID uint, notifType, title, body string, data map[string]interface{}) error {
 	var dataJSON string
 	if data != nil {
 		b, _ := json.Marshal(data)
 		dataJSON = string(b)
 	}
-	err := s.repo.Create(&models.Notification{
+	n := &models.Notification{
 		UserID: userID,
 		Type:   notifType,
 		Title:  title,
 		Body:   body,
 		Data:   dataJSON,
-	})
-	if err != nil {
+	}
+	if err := s.repo.Create(n); err != nil {
 		return err
 	}
+	// Push to open /ws/user connections
+	if s.live != nil {
+		s.live.BroadcastToUser(userID, map[string]interface{}{"type": "notification", "notification": n, "data": data})
+	}
 	// Push via FCM
 	s.sendPush(userID, notifType, title, body, data)
 	return nil
 }
 
 func (s *NotificationService) sendPush(userID uint, notifType, title, body string, data map[string]interface{}) {
+	s.sendCollapsiblePush(userID, "", notifType, title, body, data)
+}
+
+func (s *NotificationService) sendCollapsiblePush(userID uint, collapseKey, notifType, title, body string, data map[string]interface{}) {
 	if s.fcm == nil || s.userRepo == nil {
 		return
 	}
@@ -49,7 +69,7 @@ func (s *NotificationService) sendPush(userID uint, notifType, title, body strin
 	if err != nil || u == nil || u.FCMToken == "" {
 		return
 	}
-	_ = s.fcm.SendToUser(context.Background(), u.FCMToken, notifType, title, body, data)
+	_ = s.fcm.SendToUserCollapsible(context.Background(), u.FCMToken, collapseKey, notifType, title, body, data)
 }
 
 func (s *NotificationService) NotifyNewRequest(companionUserID uint, requestID uint, clientName string) error {
@@ -77,6 +97,30 @@ func (s *NotificationService) NotifyPaymentConfirmed(userID uint, amountCents in
 	return s.Notify(userID, "PAYMENT_CONFIRMED", "Payment confirmed", "Your payment was successful.", map[string]interface{}{"amount_cents": amountCents, "reference": reference})
 }
 
+// NotifyPaymentStatus tells the payer an asynchronous payment (M-Pesa STK, crypto) reached a final status.
+// Apps waiting on the payment get it over /ws/user and FCM instead of polling.
+func (s *NotificationService) NotifyPaymentStatus(p *models.Payment, interactionID uint, requiresKYC bool, message string) error {
+	notifType, title := "PAYMENT_FAILED", "Payment failed"
+	switch p.Status {
+	case "COMPLETED":
+		notifType, title = "PAYMENT_CONFIRMED", "Payment confirmed"
+	case "CANCELLED":
+		notifType, title = "PAYMENT_CANCELLED", "Payment cancelled"
+	}
+	data := map[string]interface{}{
+		"payment_id":     p.ID,
+		"order_id":       p.ProviderRef,
+		"reference":      p.ProviderRef,
+		"payment_status": p.Status,
+		"amount_cents":   p.AmountCents,
+		"requires_kyc":   requiresKYC,
+	}
+	if interactionID != 0 {
+		data["interaction_id"] = interactionID
+	}
+	return s.Notify(p.UserID, notifType, title, message, data)
+}
+
 func (s *NotificationService) NotifyFavoriteOnline(clientUserID uint, companionName string, companionID uint) error {
 	return s.Notify(clientUserID, "FAVORITE_ONLINE", "Favorite online", companionName+" is now online", map[string]interface{}{"companion_id": companionID})
 }
@@ -89,17 +133,51 @@ func (s *NotificationService) NotifySessionEnding(userID uint, minutesLeft int)
 	return s.Notify(userID, "SESSION_ENDING", "Session ending", "Your session ends in a few minutes", map[string]interface{}{"minutes_left": minutesLeft})
 }
 
-// NotifyNewChatMessage sends push when the recipient is not in the chat. Skips if recipient has no FCM token.
-func (s *NotificationService) NotifyNewChatMessage(recipientUserID uint, senderName string, interactionID uint, contentPreview string) {
+// NotifyNewChatMessage pushes m to the other side of ir when it has not reached them over the chat socket.
+// unread is how many of the sender's messages they have not received yet, m being the latest. Pushes for one
+// interaction share a collapse key, so the device shows a single notification for the chat. Skips if the
+// recipient has no FCM token.
+func (s *NotificationService) NotifyNewChatMessage(ir *models.InteractionRequest, m *models.ChatMessage, unread int) {
+	recipientUserID, senderName := ir.ClientID, ir.Companion.DisplayName
+	if m.SenderID == ir.ClientID {
+		recipientUserID, senderName = ir.Companion.UserID, ""
+		if s.userRepo != nil {
+			if u, _ := s.userRepo.GetByID(ir.ClientID); u != nil {
+				senderName = strings.TrimSpace(u.Username)
+				if senderName == "" {
+					senderName = u.Email
+				}
+			}
+		}
+	}
 	if recipientUserID == 0 {
 		return
 	}
-	body := senderName + ": " + contentPreview
+	if senderName == "" {
+		senderName = "Someone"
+	}
+	preview := strings.TrimSpace(m.Content)
+	if m.MediaURL != "" && preview == "" {
+		preview = "📷 Photo"
+	}
+	if strings.HasPrefix(preview, "LOCATION:") {
+		preview = "📍 Location"
+	}
+	if preview == "" {
+		return
+	}
+	title := "New message"
+	if unread > 1 {
+		title = fmt.Sprintf("%d new messages", unread)
+	}
+	body := senderName + ": " + preview
 	if len(body) > 100 {
 		body = body[:97] + "..."
 	}
-	s.sendPush(recipientUserID, "NEW_MESSAGE", "New message", body, map[string]interface{}{
-		"interaction_id": interactionID,
+	s.sendCollapsiblePush(recipientUserID, fmt.Sprintf("chat_%d", ir.ID), "NEW_MESSAGE", title, body, map[string]interface{}{
+		"interaction_id": ir.ID,
+		"message_id":     m.ID,
+		"unread":         unread,
 		"sender_name":    senderName,
 	})
 }
@@ -58,28 +58,19 @@ func (s *ReferralService) ProcessReferralCode(referralCode string, newUser *mode
 	referrerBonus := s.getSettingInt(domain.SettingReferralBonusReferrer, 10000) // default KES 100 = 10000 cents
 	referredBonus := s.getSettingInt(domain.SettingReferralBonusReferred, 20000) // default KES 200 = 20000 cents
 
-	// Credit referrer wallet
+	// Signup bonuses are withdrawable companion earnings funded by the platform's referral budget.
 	if referrerBonus > 0 {
-		if err := s.walletRepo.Credit(rc.UserID, int64(referrerBonus)); err != nil {
+		if err := s.walletRepo.CreditWithdrawable(rc.UserID, int64(referrerBonus), domain.LedgerAccountReferralPayable,
+			domain.WalletTxTypeReferralBonus, fmt.Sprintf("referral_bonus_for_user_%d", newUser.ID)); err != nil {
 			log.Printf("[referral] failed to credit referrer %d: %v", rc.UserID, err)
 		}
-		if err := s.walletRepo.CreditWithdrawable(rc.UserID, int64(referrerBonus)); err != nil {
-			log.Printf("[referral] failed to credit referrer withdrawable %d: %v", rc.UserID, err)
-		}
-		_ = s.walletRepo.RecordTransaction(rc.UserID, int64(referrerBonus), domain.WalletTxTypeReferralBonus,
-			fmt.Sprintf("referral_bonus_for_user_%d", newUser.ID))
 	}
 
-	// Credit new companion wallet
 	if referredBonus > 0 {
-		if err := s.walletRepo.Credit(newUser.ID, int64(referredBonus)); err != nil {
+		if err := s.walletRepo.CreditWithdrawable(newUser.ID, int64(referredBonus), domain.LedgerAccountReferralPayable,
+			domain.WalletTxTypeReferralBonus, fmt.Sprintf("referral_signup_bonus_from_user_%d", rc.UserID)); err != nil {
 			log.Printf("[referral] failed to credit referred %d: %v", newUser.ID, err)
 		}
-		if err := s.walletRepo.CreditWithdrawable(newUser.ID, int64(referredBonus)); err != nil {
-			log.Printf("[referral] failed to credit referred withdrawable %d: %v", newUser.ID, err)
-		}
-		_ = s.walletRepo.RecordTransaction(newUser.ID, int64(referredBonus), domain.WalletTxTypeReferralBonus,
-			fmt.Sprintf("referral_signup_bonus_from_user_%d", rc.UserID))
 	}
 }
 
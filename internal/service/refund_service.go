@@ -0,0 +1,383 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"lusty/internal/domain"
+	"lusty/internal/models"
+	"lusty/internal/repository"
+	"lusty/pkg/payment"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNotRefundable      = errors.New("payment is not refundable")
+	ErrRefundAmount       = errors.New("amount exceeds what can be refunded")
+	ErrRefundInWallet     = errors.New("payment was already refunded to your wallet")
+	ErrRefundHeldInEscrow = errors.New("payment is held in escrow; cancel or dispute the interaction instead")
+	ErrRefundNoSource     = errors.New("original payment source is unknown")
+	ErrRefundProvider     = errors.New("provider refused the refund")
+)
+
+// RefundService sends payments back to clients, to their wallet or to the M-Pesa number / crypto address
+// that paid. The money is reserved in the ledger before the provider is called and put back if the provider
+// fails the refund, so a refund never pays out twice or vanishes.
+type RefundService struct {
+	refundRepo      *repository.RefundRepository
+	paymentRepo     *repository.PaymentRepository
+	interactionRepo *repository.InteractionRepository
+	escrowRepo      *repository.EscrowRepository
+	walletRepo      *repository.WalletRepository
+	reconRepo       *repository.ReconciliationRepository
+	notifSvc        *NotificationService
+	mpesa           payment.MobileMoneyProvider
+	crypto          payment.CryptoProvider
+	mpesaCallback   string // refund callback URLs given to the providers
+	cryptoCallback  string
+}
+
+func NewRefundService(
+	refundRepo *repository.RefundRepository,
+	paymentRepo *repository.PaymentRepository,
+	interactionRepo *repository.InteractionRepository,
+	escrowRepo *repository.EscrowRepository,
+	walletRepo *repository.WalletRepository,
+	reconRepo *repository.ReconciliationRepository,
+	notifSvc *NotificationService,
+	mpesa payment.MobileMoneyProvider,
+	crypto payment.CryptoProvider,
+	mpesaCallback, cryptoCallback string,
+) *RefundService {
+	return &RefundService{
+		refundRepo:      refundRepo,
+		paymentRepo:     paymentRepo,
+		interactionRepo: interactionRepo,
+		escrowRepo:      escrowRepo,
+		walletRepo:      walletRepo,
+		reconRepo:       reconRepo,
+		notifSvc:        notifSvc,
+		mpesa:           mpesa,
+		crypto:          crypto,
+		mpesaCallback:   mpesaCallback,
+		cryptoCallback:  cryptoCallback,
+	}
+}
+
+// RequestByClient refunds what the client is owed for one of their payments:
+//   - a payment cancelled or failed here that the provider did charge: the charged amount, to the wallet or
+//     the source, as the client chooses;
+//   - a completed payment whose interaction was refunded to the wallet: that amount, sent on to the source.
+func (s *RefundService) RequestByClient(ctx context.Context, p *models.Payment, destination, reason string) (*models.Refund, error) {
+	verifier, _, _ := s.rail(p)
+	if verifier == nil {
+		return nil, ErrNotRefundable
+	}
+	providerCents := providerPortion(p)
+	switch p.Status {
+	case domain.PaymentStatusCancelled, domain.PaymentStatusFailed:
+		paid, err := verifier.VerifyPayment(ctx, p.ProviderRef)
+		if err != nil {
+			return nil, fmt.Errorf("verify payment with provider: %w", err)
+		}
+		if !paid {
+			return nil, ErrNotRefundable
+		}
+		return s.create(ctx, p, 0, func(total, _ int64) int64 { return providerCents - total }, ErrNotRefundable,
+			destination, domain.RefundFundedByProvider, reason, "client", &p.UserID)
+	case domain.PaymentStatusCompleted:
+		hold, err := s.hold(p)
+		if err != nil {
+			return nil, err
+		}
+		if hold == nil || hold.RefundedCents == 0 {
+			return nil, ErrNotRefundable
+		}
+		if destination == domain.RefundDestinationWallet {
+			return nil, ErrRefundInWallet
+		}
+		return s.create(ctx, p, 0, func(_, toSource int64) int64 { return min(hold.RefundedCents, providerCents) - toSource }, ErrNotRefundable,
+			destination, domain.RefundFundedByWallet, reason, "client", &p.UserID)
+	}
+	return nil, ErrNotRefundable
+}
+
+// RefundLateCharge refunds, to the wallet, a charge the provider confirmed after the payment was cancelled
+// here (the abandoned-payment job gave up on it first). Like any refund it counts against the payment, so a
+// repeated callback, or the client asking for the same charge back, finds nothing left: ErrNotRefundable.
+func (s *RefundService) RefundLateCharge(ctx context.Context, p *models.Payment) (*models.Refund, error) {
+	if p.Status != domain.PaymentStatusCancelled {
+		return nil, ErrNotRefundable
+	}
+	providerCents := providerPortion(p)
+	return s.create(ctx, p, 0, func(total, _ int64) int64 { return providerCents - total }, ErrNotRefundable,
+		domain.RefundDestinationWallet, domain.RefundFundedByProvider, "paid after the payment was cancelled", "system", nil)
+}
+
+// IssueByAdmin refunds amountCents of a payment (0 = all that is left) out of platform revenue, or out of
+// provider clearing for a charge that was never booked here. Payments still held in escrow are settled
+// through the interaction instead, and what escrow already refunded or released is not refunded again.
+func (s *RefundService) IssueByAdmin(ctx context.Context, p *models.Payment, amountCents int64, destination, reason string, adminID uint) (*models.Refund, error) {
+	verifier, _, _ := s.rail(p)
+	if verifier == nil && destination == domain.RefundDestinationSource {
+		return nil, ErrRefundNoSource
+	}
+	if amountCents < 0 {
+		return nil, ErrRefundAmount
+	}
+	providerCents := providerPortion(p)
+	fundedBy := domain.RefundFundedByPlatform
+	refundable := p.AmountCents
+	switch p.Status {
+	case domain.PaymentStatusCompleted:
+		hold, err := s.hold(p)
+		if err != nil {
+			return nil, err
+		}
+		if hold != nil && hold.Status == domain.EscrowStatusHeld {
+			return nil, ErrRefundHeldInEscrow
+		}
+		// What escrow already gave back to the client or paid the companion is not the platform's to refund
+		if hold != nil {
+			refundable = max(p.AmountCents-hold.RefundedCents-hold.ReleasedCents, 0)
+		}
+	case domain.PaymentStatusCancelled, domain.PaymentStatusFailed:
+		if verifier == nil {
+			return nil, ErrNotRefundable
+		}
+		paid, err := verifier.VerifyPayment(ctx, p.ProviderRef)
+		if err != nil {
+			return nil, fmt.Errorf("verify payment with provider: %w", err)
+		}
+		if !paid {
+			return nil, ErrNotRefundable
+		}
+		fundedBy = domain.RefundFundedByProvider
+		refundable = providerCents
+	default:
+		return nil, ErrNotRefundable
+	}
+	return s.create(ctx, p, amountCents, func(total, toSource int64) int64 {
+		remaining := refundable - total
+		if destination == domain.RefundDestinationSource {
+			remaining = min(remaining, providerCents-toSource)
+		}
+		return remaining
+	}, ErrRefundAmount, destination, fundedBy, reason, "admin", &adminID)
+}
+
+// HandleCallback applies the provider's result for a refund sent to the source. Safe to call again for
+// the same result.
+func (s *RefundService) HandleCallback(reference string, succeeded bool, detail string) error {
+	rf, err := s.refundRepo.GetByReference(reference)
+	if err != nil || rf == nil {
+		log.Printf("[refund] callback for unknown refund %s", reference)
+		return nil
+	}
+	if succeeded {
+		return s.complete(rf)
+	}
+	return s.fail(rf, detail)
+}
+
+// create records a refund of amount (0 = all that capFn allows) and sends it. capFn bounds it by what is
+// already refunded, checked under the payment's lock; overCap is returned if it does not fit.
+func (s *RefundService) create(ctx context.Context, p *models.Payment, amount int64, capFn func(total, toSource int64) int64, overCap error, destination, fundedBy, reason, initiatedBy string, actorID *uint) (*models.Refund, error) {
+	_, refunder, callbackURL := s.rail(p)
+	phone := paymentMeta(p).CustomerPhone
+	if destination == domain.RefundDestinationSource && (refunder == nil || (p.Provider == "mpesa_liberec" && phone == "")) {
+		return nil, ErrRefundNoSource
+	}
+	rf := &models.Refund{
+		PaymentID:   p.ID,
+		UserID:      p.UserID,
+		Reference:   "rf-" + uuid.New().String(),
+		AmountCents: amount,
+		Currency:    p.Currency,
+		Destination: destination,
+		FundedBy:    fundedBy,
+		Status:      domain.RefundStatusPending,
+		Reason:      reason,
+		InitiatedBy: initiatedBy,
+		ActorID:     actorID,
+		Provider:    p.Provider,
+	}
+	if err := s.refundRepo.CreateWithinCap(rf, capFn); err != nil {
+		if errors.Is(err, repository.ErrRefundOverCap) {
+			return nil, overCap
+		}
+		return nil, err
+	}
+	amount = rf.AmountCents
+	log.Printf("[refund] %s: %d cents of payment %d to %s, funded by %s (%s)", rf.Reference, amount, p.ID, destination, fundedBy, initiatedBy)
+	if fundedBy == domain.RefundFundedByProvider {
+		_ = s.reconRepo.ResolveOpen(domain.ReconcileKindPayment, p.ID, "refunded via "+rf.Reference)
+	}
+
+	if destination == domain.RefundDestinationWallet {
+		if err := s.walletRepo.Credit(p.UserID, amount, fundingAccount(fundedBy), domain.WalletTxTypeRefund, rf.Reference); err != nil {
+			_, _ = s.refundRepo.Settle(rf.ID, domain.RefundStatusFailed, map[string]interface{}{"failure_reason": err.Error()})
+			return nil, err
+		}
+		return rf, s.complete(rf)
+	}
+
+	// Reserve the money before it leaves through the provider. A charge never booked here needs no entry:
+	// it goes back out the way it came in.
+	var err error
+	switch fundedBy {
+	case domain.RefundFundedByWallet:
+		err = s.walletRepo.Debit(p.UserID, amount, domain.LedgerAccountProviderClearing, domain.WalletTxTypeRefundPayout, rf.Reference)
+	case domain.RefundFundedByPlatform:
+		err = s.walletRepo.Post(domain.WalletTxTypeRefundPayout, rf.Reference,
+			repository.LedgerLeg{Account: domain.LedgerAccountPlatformRevenue, AmountCents: -amount},
+			repository.LedgerLeg{Account: domain.LedgerAccountProviderClearing, AmountCents: amount},
+		)
+	}
+	if err != nil {
+		_, _ = s.refundRepo.Settle(rf.ID, domain.RefundStatusFailed, map[string]interface{}{"failure_reason": err.Error()})
+		return nil, err
+	}
+	resp, err := refunder.Refund(ctx, payment.RefundRequest{
+		RefundID:         rf.Reference,
+		PaymentReference: p.ProviderRef,
+		AmountCents:      amount,
+		Currency:         p.Currency,
+		PhoneNumber:      phone,
+		Reason:           reason,
+		CallbackURL:      callbackURL,
+	})
+	if err != nil {
+		log.Printf("[refund] %s: provider refund failed: %v", rf.Reference, err)
+		if ferr := s.fail(rf, err.Error()); ferr != nil {
+			log.Printf("[refund] %s: reversal failed: %v", rf.Reference, ferr)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrRefundProvider, err)
+	}
+	// The callback may already have settled it; then this is a no-op
+	_, _ = s.refundRepo.UpdateStatusIf(rf.ID, domain.RefundStatusPending, domain.RefundStatusProcessing,
+		map[string]interface{}{"provider_ref": resp.Reference})
+	return s.refundRepo.GetByReference(rf.Reference)
+}
+
+func (s *RefundService) complete(rf *models.Refund) error {
+	now := time.Now()
+	ok, err := s.refundRepo.Settle(rf.ID, domain.RefundStatusCompleted, map[string]interface{}{"completed_at": now})
+	if err != nil || !ok {
+		return err
+	}
+	rf.Status, rf.CompletedAt = domain.RefundStatusCompleted, &now
+	log.Printf("[refund] %s completed", rf.Reference)
+	if p, _ := s.paymentRepo.GetByID(rf.PaymentID); p != nil && p.Status == domain.PaymentStatusCompleted {
+		if total, _, _ := s.refundRepo.SumByPaymentID(p.ID); total >= p.AmountCents {
+			_, _ = s.paymentRepo.UpdateStatusIf(p.ID, domain.PaymentStatusCompleted, domain.PaymentStatusRefunded)
+		}
+	}
+	where := "your wallet"
+	if rf.Destination == domain.RefundDestinationSource {
+		where = "your original payment method"
+	}
+	_ = s.notifSvc.Notify(rf.UserID, "REFUND_COMPLETED", "Refund sent",
+		fmt.Sprintf("KES %.2f has been refunded to %s.", float64(rf.AmountCents)/100, where),
+		map[string]interface{}{"refund_id": rf.ID, "payment_id": rf.PaymentID, "amount_cents": rf.AmountCents})
+	return nil
+}
+
+// fail marks a refund FAILED and returns the reserved money to its funding account, once: the reversal
+// is keyed on the refund reference in the ledger.
+func (s *RefundService) fail(rf *models.Refund, detail string) error {
+	ok, err := s.refundRepo.Settle(rf.ID, domain.RefundStatusFailed, map[string]interface{}{"failure_reason": truncate(detail, 255)})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if cur, _ := s.refundRepo.GetByReference(rf.Reference); cur == nil || cur.Status != domain.RefundStatusFailed {
+			return nil // completed meanwhile
+		}
+	}
+	if rf.Destination == domain.RefundDestinationSource && rf.FundedBy != domain.RefundFundedByProvider {
+		if reversed, err := s.walletRepo.HasEntry(domain.WalletTxTypeRefundReversal, rf.Reference); err != nil || reversed {
+			return err
+		}
+		var err error
+		if rf.FundedBy == domain.RefundFundedByWallet {
+			err = s.walletRepo.Credit(rf.UserID, rf.AmountCents, domain.LedgerAccountProviderClearing, domain.WalletTxTypeRefundReversal, rf.Reference)
+		} else {
+			err = s.walletRepo.Post(domain.WalletTxTypeRefundReversal, rf.Reference,
+				repository.LedgerLeg{Account: domain.LedgerAccountProviderClearing, AmountCents: -rf.AmountCents},
+				repository.LedgerLeg{Account: domain.LedgerAccountPlatformRevenue, AmountCents: rf.AmountCents},
+			)
+		}
+		if err != nil {
+			return fmt.Errorf("reverse refund %s: %w", rf.Reference, err)
+		}
+	}
+	if !ok {
+		return nil
+	}
+	log.Printf("[refund] %s failed: %s", rf.Reference, detail)
+	body := "Your refund could not be sent. Please try again or contact support."
+	if rf.FundedBy == domain.RefundFundedByWallet {
+		body = "Your refund could not be sent. The amount is back in your wallet."
+	}
+	_ = s.notifSvc.Notify(rf.UserID, "REFUND_FAILED", "Refund failed", body,
+		map[string]interface{}{"refund_id": rf.ID, "payment_id": rf.PaymentID, "amount_cents": rf.AmountCents})
+	return nil
+}
+
+// rail returns the provider clients and refund callback URL for a payment's provider; nil for wallet payments.
+func (s *RefundService) rail(p *models.Payment) (payment.Verifier, payment.Refunder, string) {
+	switch p.Provider {
+	case "mpesa_liberec":
+		return s.mpesa, s.mpesa, s.mpesaCallback
+	case "solana":
+		return s.crypto, s.crypto, s.cryptoCallback
+	}
+	return nil, nil, ""
+}
+
+func (s *RefundService) hold(p *models.Payment) (*models.EscrowHold, error) {
+	ir, _ := s.interactionRepo.GetByPaymentID(p.ID)
+	if ir == nil {
+		return nil, nil
+	}
+	hold, _ := s.escrowRepo.GetByInteractionID(ir.ID)
+	return hold, nil
+}
+
+type refundPaymentMeta struct {
+	WalletCents   int64  `json:"wallet_cents"`
+	CustomerPhone string `json:"customer_phone"`
+}
+
+func paymentMeta(p *models.Payment) refundPaymentMeta {
+	var meta refundPaymentMeta
+	if p.Metadata != "" {
+		_ = json.Unmarshal([]byte(p.Metadata), &meta)
+	}
+	return meta
+}
+
+// providerPortion is what the client paid through the provider, as opposed to from their wallet.
+func providerPortion(p *models.Payment) int64 {
+	return p.AmountCents - paymentMeta(p).WalletCents
+}
+
+func fundingAccount(fundedBy string) string {
+	if fundedBy == domain.RefundFundedByProvider {
+		return domain.LedgerAccountProviderClearing
+	}
+	return domain.LedgerAccountPlatformRevenue
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
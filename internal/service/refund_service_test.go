@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"lusty/internal/database/databasetest"
+	"lusty/internal/domain"
+	"lusty/internal/models"
+	"lusty/internal/repository"
+)
+
+// TestRefundLateChargeOnce checks a charge confirmed after its payment was cancelled is refunded to the wallet
+// once, however many callbacks report it.
+func TestRefundLateChargeOnce(t *testing.T) {
+	db := databasetest.New(t)
+	walletRepo := repository.NewWalletRepository(db)
+	svc := NewRefundService(repository.NewRefundRepository(db), repository.NewPaymentRepository(db), repository.NewInteractionRepository(db),
+		repository.NewEscrowRepository(db), walletRepo, repository.NewReconciliationRepository(db),
+		NewNotificationService(repository.NewNotificationRepository(db), nil, nil), nil, nil, "", "")
+
+	p := &models.Payment{UserID: 7, AmountCents: 150_000, Currency: "KES", Provider: "mpesa_liberec", ProviderRef: "order-1",
+		IdempotencyKey: "order-1", Status: domain.PaymentStatusCancelled, Metadata: `{"wallet_cents":50000}`}
+	if err := db.Create(p).Error; err != nil {
+		t.Fatalf("create payment: %v", err)
+	}
+
+	rf, err := svc.RefundLateCharge(context.Background(), p)
+	if err != nil {
+		t.Fatalf("refund late charge: %v", err)
+	}
+	if rf.AmountCents != 100_000 || rf.Status != domain.RefundStatusCompleted || rf.Destination != domain.RefundDestinationWallet {
+		t.Fatalf("refund = %+v, want a completed 100000-cent wallet refund (the wallet portion was returned on cancel)", rf)
+	}
+	if _, err := svc.RefundLateCharge(context.Background(), p); !errors.Is(err, ErrNotRefundable) {
+		t.Fatalf("second late charge refund: err = %v, want ErrNotRefundable", err)
+	}
+
+	w, err := walletRepo.GetByUserID(7)
+	if err != nil || w.BalanceCents != 100_000 {
+		t.Fatalf("wallet = %+v (%v), want 100000 cents", w, err)
+	}
+	if drift, unbalanced, err := walletRepo.CheckIntegrity(); err != nil || len(drift) > 0 || len(unbalanced) > 0 {
+		t.Fatalf("ledger out of balance: drift=%+v unbalanced=%v err=%v", drift, unbalanced, err)
+	}
+}
+
+// TestIssueByAdminCountsEscrow checks an admin refund is refused while the payment is held in escrow and,
+// once escrow is settled, only covers what escrow did not already refund or pay the companion.
+func TestIssueByAdminCountsEscrow(t *testing.T) {
+	db := databasetest.New(t)
+	escrowRepo := repository.NewEscrowRepository(db)
+	svc := NewRefundService(repository.NewRefundRepository(db), repository.NewPaymentRepository(db), repository.NewInteractionRepository(db),
+		escrowRepo, repository.NewWalletRepository(db), repository.NewReconciliationRepository(db),
+		NewNotificationService(repository.NewNotificationRepository(db), nil, nil), nil, nil, "", "")
+
+	comp := models.CompanionProfile{UserID: 100, DisplayName: "companion"}
+	if err := db.Create(&comp).Error; err != nil {
+		t.Fatalf("create companion: %v", err)
+	}
+	p := &models.Payment{UserID: 7, AmountCents: 120_000, Currency: "KES", Provider: "fake", ProviderRef: "order-2",
+		IdempotencyKey: "order-2", Status: domain.PaymentStatusCompleted}
+	if err := db.Create(p).Error; err != nil {
+		t.Fatalf("create payment: %v", err)
+	}
+	ir := &models.InteractionRequest{ClientID: 7, CompanionID: comp.ID, InteractionType: "CHAT", PaymentID: &p.ID,
+		Status: domain.RequestStatusAccepted}
+	if err := db.Create(ir).Error; err != nil {
+		t.Fatalf("create interaction: %v", err)
+	}
+	if _, err := escrowRepo.Hold(ir); err != nil {
+		t.Fatalf("hold: %v", err)
+	}
+	if _, err := svc.IssueByAdmin(context.Background(), p, 0, domain.RefundDestinationWallet, "goodwill", 1); !errors.Is(err, ErrRefundHeldInEscrow) {
+		t.Fatalf("refund while held: err = %v, want ErrRefundHeldInEscrow", err)
+	}
+
+	hold, err := escrowRepo.Split(ir, 60_000, 40_000, "cancelled late", nil)
+	if err != nil {
+		t.Fatalf("split: %v", err)
+	}
+	left := p.AmountCents - hold.RefundedCents - hold.ReleasedCents
+	if _, err := svc.IssueByAdmin(context.Background(), p, left+1, domain.RefundDestinationWallet, "goodwill", 1); !errors.Is(err, ErrRefundAmount) {
+		t.Fatalf("refund beyond escrow: err = %v, want ErrRefundAmount", err)
+	}
+	rf, err := svc.IssueByAdmin(context.Background(), p, 0, domain.RefundDestinationWallet, "goodwill", 1)
+	if err != nil || rf.AmountCents != left {
+		t.Fatalf("refund the rest = %+v (%v), want %d cents", rf, err, left)
+	}
+	if _, err := svc.IssueByAdmin(context.Background(), p, 0, domain.RefundDestinationWallet, "goodwill", 1); !errors.Is(err, ErrRefundAmount) {
+		t.Fatalf("second refund: err = %v, want ErrRefundAmount", err)
+	}
+}
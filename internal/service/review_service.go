@@ -0,0 +1,198 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"lusty/internal/domain"
+	"lusty/internal/models"
+	"lusty/internal/repository"
+)
+
+var (
+	ErrReviewNotCompleted = errors.New("only completed interactions can be reviewed")
+	ErrReviewNotParty     = errors.New("not a party to this interaction")
+	ErrReviewExists       = errors.New("you have already reviewed this interaction")
+	ErrReviewRating       = errors.New("rating must be between 1 and 5")
+	ErrReviewEditClosed   = errors.New("review can no longer be edited")
+)
+
+// DefaultReviewEditWindow is how long a review stays editable when the setting is not set.
+const DefaultReviewEditWindow = 24 * time.Hour
+
+// ReviewModerator decides whether a new or edited review is published straight away. It returns
+// PUBLISHED, or FLAGGED with a note for the admin queue.
+type ReviewModerator interface {
+	Moderate(rv *models.Review) (status, note string)
+}
+
+// KeywordModerator flags reviews whose comment contains a term from the review_blocked_terms setting.
+type KeywordModerator struct {
+	settingRepo *repository.SettingRepository
+}
+
+func NewKeywordModerator(settingRepo *repository.SettingRepository) *KeywordModerator {
+	return &KeywordModerator{settingRepo: settingRepo}
+}
+
+func (m *KeywordModerator) Moderate(rv *models.Review) (string, string) {
+	if m.settingRepo == nil || rv.Comment == "" {
+		return domain.ReviewStatusPublished, ""
+	}
+	terms, _ := m.settingRepo.Get(domain.SettingReviewBlockedTerms)
+	comment := strings.ToLower(rv.Comment)
+	for _, t := range strings.Split(terms, ",") {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" && strings.Contains(comment, t) {
+			return domain.ReviewStatusFlagged, "contains blocked term \"" + t + "\""
+		}
+	}
+	return domain.ReviewStatusPublished, ""
+}
+
+// ReviewService handles reviews left after completed interactions and keeps the companion rating and
+// client reliability score in step with them.
+type ReviewService struct {
+	reviewRepo    *repository.ReviewRepository
+	companionRepo *repository.CompanionRepository
+	userRepo      *repository.UserRepository
+	settingRepo   *repository.SettingRepository
+	notifSvc      *NotificationService
+	moderator     ReviewModerator
+}
+
+func NewReviewService(
+	reviewRepo *repository.ReviewRepository,
+	companionRepo *repository.CompanionRepository,
+	userRepo *repository.UserRepository,
+	settingRepo *repository.SettingRepository,
+	notifSvc *NotificationService,
+	moderator ReviewModerator,
+) *ReviewService {
+	return &ReviewService{
+		reviewRepo:    reviewRepo,
+		companionRepo: companionRepo,
+		userRepo:      userRepo,
+		settingRepo:   settingRepo,
+		notifSvc:      notifSvc,
+		moderator:     moderator,
+	}
+}
+
+// Submit stores the caller's review of the other party to a COMPLETED interaction.
+func (s *ReviewService) Submit(ir *models.InteractionRequest, reviewerID uint, rating int, comment string) (*models.Review, error) {
+	if ir.Status != domain.RequestStatusCompleted {
+		return nil, ErrReviewNotCompleted
+	}
+	if rating < 1 || rating > 5 {
+		return nil, ErrReviewRating
+	}
+	comp, err := s.companionRepo.GetByID(ir.CompanionID)
+	if err != nil || comp == nil {
+		return nil, ErrReviewNotParty
+	}
+	rv := &models.Review{
+		InteractionID: ir.ID,
+		ReviewerID:    reviewerID,
+		CompanionID:   ir.CompanionID,
+		Rating:        rating,
+		Comment:       strings.TrimSpace(comment),
+	}
+	switch reviewerID {
+	case ir.ClientID:
+		rv.ReviewerRole, rv.RevieweeID = domain.RoleClient, comp.UserID
+	case comp.UserID:
+		rv.ReviewerRole, rv.RevieweeID = domain.RoleCompanion, ir.ClientID
+	default:
+		return nil, ErrReviewNotParty
+	}
+	if existing, _ := s.reviewRepo.GetByInteractionAndReviewer(ir.ID, reviewerID); existing != nil {
+		return nil, ErrReviewExists
+	}
+	now := time.Now()
+	rv.EditableUntil = now.Add(s.editWindow())
+	rv.Status, rv.ModerationNote = s.moderate(rv)
+	if err := s.reviewRepo.Create(rv); err != nil {
+		// Lost a race with a concurrent submit: the unique index holds
+		if existing, _ := s.reviewRepo.GetByInteractionAndReviewer(ir.ID, reviewerID); existing != nil {
+			return nil, ErrReviewExists
+		}
+		return nil, err
+	}
+	s.refresh(rv)
+	if rv.Status == domain.ReviewStatusPublished {
+		_ = s.notifSvc.Notify(rv.RevieweeID, "REVIEW_RECEIVED", "New review",
+			"You received a "+strconv.Itoa(rating)+"-star review.",
+			map[string]interface{}{"interaction_id": ir.ID, "review_id": rv.ID})
+	}
+	return rv, nil
+}
+
+// Edit changes the caller's own review while its edit window is open. Edits go through moderation again.
+func (s *ReviewService) Edit(rv *models.Review, reviewerID uint, rating int, comment string) (*models.Review, error) {
+	if rv.ReviewerID != reviewerID {
+		return nil, ErrReviewNotParty
+	}
+	if rating < 1 || rating > 5 {
+		return nil, ErrReviewRating
+	}
+	now := time.Now()
+	if rv.Status == domain.ReviewStatusHidden || !now.Before(rv.EditableUntil) {
+		return nil, ErrReviewEditClosed
+	}
+	rv.Rating, rv.Comment = rating, strings.TrimSpace(comment)
+	rv.Status, rv.ModerationNote = s.moderate(rv)
+	ok, err := s.reviewRepo.Edit(rv.ID, rv.Rating, rv.Comment, rv.Status, rv.ModerationNote, now)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrReviewEditClosed
+	}
+	rv.UpdatedAt = now
+	s.refresh(rv)
+	return rv, nil
+}
+
+// Moderate records an admin's decision to publish or hide a review.
+func (s *ReviewService) Moderate(rv *models.Review, status string, adminID uint, note string) error {
+	if err := s.reviewRepo.SetStatus(rv.ID, status, adminID, note); err != nil {
+		return err
+	}
+	rv.Status, rv.ModerationNote, rv.ModeratedBy = status, note, &adminID
+	s.refresh(rv)
+	return nil
+}
+
+func (s *ReviewService) moderate(rv *models.Review) (string, string) {
+	if s.moderator == nil {
+		return domain.ReviewStatusPublished, ""
+	}
+	return s.moderator.Moderate(rv)
+}
+
+// refresh recomputes the aggregate the review counts towards.
+func (s *ReviewService) refresh(rv *models.Review) {
+	var err error
+	if rv.ReviewerRole == domain.RoleClient {
+		err = s.companionRepo.RefreshRating(rv.CompanionID)
+	} else {
+		err = s.userRepo.RefreshClientReliability(rv.RevieweeID)
+	}
+	if err != nil {
+		log.Printf("[review] refresh aggregates for review %d: %v", rv.ID, err)
+	}
+}
+
+func (s *ReviewService) editWindow() time.Duration {
+	if s.settingRepo != nil {
+		if v, err := s.settingRepo.Get(domain.SettingReviewEditWindowMinutes); err == nil && v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+				return time.Duration(n) * time.Minute
+			}
+		}
+	}
+	return DefaultReviewEditWindow
+}
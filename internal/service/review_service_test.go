@@ -0,0 +1,181 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"lusty/internal/database/databasetest"
+	"lusty/internal/domain"
+	"lusty/internal/models"
+	"lusty/internal/repository"
+
+	"gorm.io/gorm"
+)
+
+func newTestReviewService(db *gorm.DB) *ReviewService {
+	userRepo := repository.NewUserRepository(db)
+	settingRepo := repository.NewSettingRepository(db)
+	return NewReviewService(repository.NewReviewRepository(db), repository.NewCompanionRepository(db), userRepo, settingRepo,
+		NewNotificationService(repository.NewNotificationRepository(db), userRepo, nil), NewKeywordModerator(settingRepo))
+}
+
+// seedCompletedInteractions creates a companion and one COMPLETED interaction with each of n clients.
+func seedCompletedInteractions(t *testing.T, db *gorm.DB, n int) (*models.CompanionProfile, []*models.InteractionRequest) {
+	t.Helper()
+	companionUser := models.User{Email: "companion@example.com", Username: "companion", Role: domain.RoleCompanion}
+	if err := db.Create(&companionUser).Error; err != nil {
+		t.Fatalf("create companion user: %v", err)
+	}
+	comp := &models.CompanionProfile{UserID: companionUser.ID, DisplayName: "companion"}
+	if err := db.Create(comp).Error; err != nil {
+		t.Fatalf("create companion: %v", err)
+	}
+	var list []*models.InteractionRequest
+	for i := range n {
+		client := models.User{Email: fmt.Sprintf("client%d@example.com", i), Username: fmt.Sprintf("client%d", i),
+			Role: domain.RoleClient, KYC: true}
+		if err := db.Create(&client).Error; err != nil {
+			t.Fatalf("create client: %v", err)
+		}
+		ir := &models.InteractionRequest{ClientID: client.ID, CompanionID: comp.ID, InteractionType: "CHAT", DurationMinutes: 60,
+			Status: domain.RequestStatusCompleted}
+		if err := db.Create(ir).Error; err != nil {
+			t.Fatalf("create interaction: %v", err)
+		}
+		list = append(list, ir)
+	}
+	return comp, list
+}
+
+func companionRating(t *testing.T, db *gorm.DB, id uint) (float64, int) {
+	t.Helper()
+	comp, err := repository.NewCompanionRepository(db).GetByID(id)
+	if err != nil {
+		t.Fatalf("companion: %v", err)
+	}
+	return comp.RatingAvg, comp.RatingCount
+}
+
+// TestReviewSubmitRefreshesRatings checks each party can review a completed interaction once, and that
+// client reviews feed the companion's rating and companion reviews the client's reliability.
+func TestReviewSubmitRefreshesRatings(t *testing.T) {
+	db := databasetest.New(t)
+	svc := newTestReviewService(db)
+	comp, irs := seedCompletedInteractions(t, db, 2)
+
+	for i, rating := range []int{5, 4} {
+		if _, err := svc.Submit(irs[i], irs[i].ClientID, rating, "lovely"); err != nil {
+			t.Fatalf("submit %d: %v", i, err)
+		}
+	}
+	if avg, count := companionRating(t, db, comp.ID); avg != 4.5 || count != 2 {
+		t.Fatalf("rating = %v over %d, want 4.5 over 2", avg, count)
+	}
+	if _, err := svc.Submit(irs[0], irs[0].ClientID, 1, ""); !errors.Is(err, ErrReviewExists) {
+		t.Fatalf("second review: err = %v, want ErrReviewExists", err)
+	}
+	if _, err := svc.Submit(irs[0], irs[1].ClientID, 1, ""); !errors.Is(err, ErrReviewNotParty) {
+		t.Fatalf("outsider review: err = %v, want ErrReviewNotParty", err)
+	}
+	if _, err := svc.Submit(irs[0], irs[0].ClientID, 6, ""); !errors.Is(err, ErrReviewRating) {
+		t.Fatalf("rating 6: err = %v, want ErrReviewRating", err)
+	}
+	irs[1].Status = domain.RequestStatusAccepted
+	if _, err := svc.Submit(irs[1], comp.UserID, 5, ""); !errors.Is(err, ErrReviewNotCompleted) {
+		t.Fatalf("review before completion: err = %v, want ErrReviewNotCompleted", err)
+	}
+
+	rv, err := svc.Submit(irs[0], comp.UserID, 5, "")
+	if err != nil {
+		t.Fatalf("companion review: %v", err)
+	}
+	if rv.ReviewerRole != domain.RoleCompanion || rv.RevieweeID != irs[0].ClientID {
+		t.Fatalf("review = %+v, want the companion rating client %d", rv, irs[0].ClientID)
+	}
+	client, _ := repository.NewUserRepository(db).GetByID(irs[0].ClientID)
+	if client.ClientRatingCount != 1 || client.ReliabilityScore == nil || *client.ReliabilityScore != 100 {
+		t.Fatalf("client = %+v, want one rating and a reliability of 100", client)
+	}
+	if _, count := companionRating(t, db, comp.ID); count != 2 {
+		t.Fatalf("rating count = %d after a companion review, want 2", count)
+	}
+}
+
+// TestReviewEditWindow checks a review can be edited, with the rating following, only until its window closes.
+func TestReviewEditWindow(t *testing.T) {
+	db := databasetest.New(t)
+	svc := newTestReviewService(db)
+	comp, irs := seedCompletedInteractions(t, db, 1)
+	client := irs[0].ClientID
+
+	rv, err := svc.Submit(irs[0], client, 2, "meh")
+	if err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	if _, err := svc.Edit(rv, comp.UserID, 5, ""); !errors.Is(err, ErrReviewNotParty) {
+		t.Fatalf("edit by someone else: err = %v, want ErrReviewNotParty", err)
+	}
+	if _, err := svc.Edit(rv, client, 4, "better on reflection"); err != nil {
+		t.Fatalf("edit: %v", err)
+	}
+	if avg, _ := companionRating(t, db, comp.ID); avg != 4 {
+		t.Fatalf("rating after edit = %v, want 4", avg)
+	}
+
+	// A stale copy whose window looks open is still refused once the stored window has closed
+	if err := db.Model(&models.Review{}).Where("id = ?", rv.ID).Update("editable_until", time.Now().Add(-time.Minute)).Error; err != nil {
+		t.Fatalf("close window: %v", err)
+	}
+	if _, err := svc.Edit(rv, client, 1, ""); !errors.Is(err, ErrReviewEditClosed) {
+		t.Fatalf("edit after window: err = %v, want ErrReviewEditClosed", err)
+	}
+	if avg, _ := companionRating(t, db, comp.ID); avg != 4 {
+		t.Fatalf("rating after refused edit = %v, want 4", avg)
+	}
+}
+
+// TestReviewModeration checks a review with a blocked term is held back from the rating until an admin
+// publishes it, and drops out again when hidden.
+func TestReviewModeration(t *testing.T) {
+	db := databasetest.New(t)
+	svc := newTestReviewService(db)
+	if err := repository.NewSettingRepository(db).Set(domain.SettingReviewBlockedTerms, "scam, fraud"); err != nil {
+		t.Fatalf("set blocked terms: %v", err)
+	}
+	comp, irs := seedCompletedInteractions(t, db, 1)
+	client := irs[0].ClientID
+
+	rv, err := svc.Submit(irs[0], client, 1, "Total SCAM")
+	if err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+	if rv.Status != domain.ReviewStatusFlagged || rv.ModerationNote == "" {
+		t.Fatalf("review = %+v, want FLAGGED with a note", rv)
+	}
+	if _, count := companionRating(t, db, comp.ID); count != 0 {
+		t.Fatalf("rating count = %d with only a flagged review, want 0", count)
+	}
+
+	if err := svc.Moderate(rv, domain.ReviewStatusPublished, 99, "fair criticism"); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if avg, count := companionRating(t, db, comp.ID); avg != 1 || count != 1 {
+		t.Fatalf("rating = %v over %d after publishing, want 1 over 1", avg, count)
+	}
+
+	if err := svc.Moderate(rv, domain.ReviewStatusHidden, 99, "abusive"); err != nil {
+		t.Fatalf("hide: %v", err)
+	}
+	if _, count := companionRating(t, db, comp.ID); count != 0 {
+		t.Fatalf("rating count = %d after hiding, want 0", count)
+	}
+	stored, _ := repository.NewReviewRepository(db).GetByID(rv.ID)
+	if stored.Status != domain.ReviewStatusHidden || stored.ModeratedBy == nil || *stored.ModeratedBy != 99 {
+		t.Fatalf("stored review = %+v, want HIDDEN by admin 99", stored)
+	}
+	if _, err := svc.Edit(rv, client, 5, "sorry"); !errors.Is(err, ErrReviewEditClosed) {
+		t.Fatalf("edit of hidden review: err = %v, want ErrReviewEditClosed", err)
+	}
+}
@@ -0,0 +1,328 @@
+package videocall
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"lusty/internal/domain"
+	"lusty/internal/models"
+	"lusty/internal/repository"
+	"lusty/internal/service"
+	"lusty/internal/service/interaction"
+)
+
+// ErrInsufficientFunds is returned when the client's wallet cannot cover the first block of a billed call.
+var ErrInsufficientFunds = errors.New("wallet balance does not cover a video call block")
+
+const (
+	// RingTimeout is how long an invite rings before it is missed.
+	RingTimeout = 45 * time.Second
+	// HeartbeatTimeout is how long a side may go without a heartbeat before its time stops counting.
+	// Clients should send one about every 10 seconds.
+	HeartbeatTimeout = 30 * time.Second
+	// TopUpLead is how long before the authorized time runs out the next block is reserved, and the client
+	// is warned if it cannot be.
+	TopUpLead = 60 * time.Second
+	// StaleAfter ends calls neither side has sent a heartbeat for in this long, and closes invites left
+	// ringing this long (the room's ring timer is lost if the server restarts) as missed.
+	StaleAfter = 2 * time.Minute
+
+	staleBatch = 100
+)
+
+// Ended call reasons
+const (
+	EndReasonHangup            = "hangup"
+	EndReasonDisconnected      = "disconnected"
+	EndReasonInsufficientFunds = "insufficient_funds"
+	EndReasonTimeout           = "timeout"
+)
+
+// Service records video calls from invite to hang-up, meters answered calls from the peers' heartbeats and
+// bills them at the companion's VIDEO_PER_5MIN price, pre-authorizing the client's wallet one block at a
+// time. Every call that closes leaves a record in the interaction's chat.
+type Service struct {
+	callRepo        *repository.VideoCallRepository
+	companionRepo   *repository.CompanionRepository
+	interactionRepo *repository.InteractionRepository
+	userRepo        *repository.UserRepository
+	notifSvc        *service.NotificationService
+	chatRooms       interaction.RoomSink
+}
+
+func NewService(
+	callRepo *repository.VideoCallRepository,
+	companionRepo *repository.CompanionRepository,
+	interactionRepo *repository.InteractionRepository,
+	userRepo *repository.UserRepository,
+	notifSvc *service.NotificationService,
+	chatRooms interaction.RoomSink,
+) *Service {
+	return &Service{
+		callRepo:        callRepo,
+		companionRepo:   companionRepo,
+		interactionRepo: interactionRepo,
+		userRepo:        userRepo,
+		notifSvc:        notifSvc,
+		chatRooms:       chatRooms,
+	}
+}
+
+// Meter is the state of a call after a heartbeat.
+type Meter struct {
+	Call        *models.VideoCall
+	SecondsLeft int64 // connected time the authorized funds still cover; -1 if the call is not billed
+	LowFunds    bool  // the client was just warned the next block could not be reserved
+	Ended       bool  // the call has ended (this heartbeat ended it if it ran out of funds)
+}
+
+// Live returns the interaction's RINGING or ACTIVE call, or nil.
+func (s *Service) Live(interactionID uint) (*models.VideoCall, error) {
+	return s.callRepo.GetLive(interactionID)
+}
+
+// Invite records a call from callerID on ir, ringing until it is answered or closed. An invite left ringing
+// past RingTimeout (its room was lost) is closed as missed first. Returns repository.ErrVideoCallInProgress
+// if the interaction already has a call.
+func (s *Service) Invite(ir *models.InteractionRequest, callerID uint) (*models.VideoCall, error) {
+	if live, err := s.callRepo.GetLive(ir.ID); err != nil {
+		return nil, err
+	} else if live != nil && live.Status == domain.VideoCallStatusRinging && time.Since(live.StartedAt) > RingTimeout {
+		if _, err := s.Close(live.ID, domain.VideoCallStatusMissed); err != nil {
+			return nil, err
+		}
+	}
+	call := &models.VideoCall{
+		InteractionID:   ir.ID,
+		CallerID:        callerID,
+		ClientID:        ir.ClientID,
+		CompanionID:     ir.CompanionID,
+		CompanionUserID: ir.Companion.UserID,
+		StartedAt:       time.Now(),
+	}
+	if err := s.callRepo.CreateRinging(call); err != nil {
+		return nil, err
+	}
+	return call, nil
+}
+
+// Accept answers a ringing call. It is priced from the companion's active VIDEO_PER_5MIN pricing (without it
+// the call is not billed) and its first block is reserved from the client's wallet; if the wallet cannot
+// cover it the call ends at once with ErrInsufficientFunds.
+func (s *Service) Accept(callID uint) (*models.VideoCall, error) {
+	call, err := s.callRepo.GetByID(callID)
+	if err != nil {
+		return nil, err
+	}
+	var rate int64
+	if p, _ := s.companionRepo.GetPricingByCompanionAndType(call.CompanionID, "VIDEO_PER_5MIN"); p != nil && p.IsActive && p.AmountCents > 0 {
+		rate = p.AmountCents
+	}
+	if call, err = s.callRepo.Answer(callID, rate); err != nil || rate == 0 {
+		return call, err
+	}
+	authorized, err := s.callRepo.Authorize(call.ID)
+	if err != nil {
+		if _, endErr := s.End(call.ID, EndReasonInsufficientFunds); endErr != nil {
+			log.Printf("[videocall] end call %d: %v", call.ID, endErr)
+		}
+		if errors.Is(err, repository.ErrInsufficientBalance) {
+			return nil, ErrInsufficientFunds
+		}
+		return nil, err
+	}
+	log.Printf("[videocall] call %d answered on interaction %d at %d cents per block", call.ID, call.InteractionID, rate)
+	return authorized, nil
+}
+
+// Close closes a call that was never answered: MISSED when it rang out, DECLINED by the callee or CANCELLED
+// by the caller. The callee is notified of a missed call unless she declined it.
+func (s *Service) Close(callID uint, status string) (*models.VideoCall, error) {
+	call, closed, err := s.callRepo.CloseUnanswered(callID, status)
+	if err != nil || !closed {
+		return call, err
+	}
+	log.Printf("[videocall] call %d on interaction %d %s", call.ID, call.InteractionID, strings.ToLower(status))
+	s.postHistory(call)
+	if status != domain.VideoCallStatusDeclined {
+		callee := call.ClientID
+		if call.CallerID == call.ClientID {
+			callee = call.CompanionUserID
+		}
+		name := "someone"
+		if ir, _ := s.interactionRepo.GetByID(call.InteractionID); ir != nil {
+			name = s.CallerName(ir, call.CallerID)
+		}
+		_ = s.notifSvc.Notify(callee, "MISSED_VIDEO_CALL", "Missed video call", fmt.Sprintf("You missed a video call from %s.", name),
+			map[string]interface{}{"interaction_id": call.InteractionID, "call_id": call.ID})
+	}
+	return call, nil
+}
+
+// CallerName is how the other side sees callerID on ir.
+func (s *Service) CallerName(ir *models.InteractionRequest, callerID uint) string {
+	if callerID == ir.Companion.UserID {
+		if ir.Companion.DisplayName != "" {
+			return ir.Companion.DisplayName
+		}
+		return "A companion"
+	}
+	if u, _ := s.userRepo.GetByID(callerID); u != nil {
+		if u.Username != "" {
+			return u.Username
+		}
+		return u.Email
+	}
+	return "A client"
+}
+
+// Heartbeat records that userID is still on the interaction's call and keeps it funded: once less than
+// TopUpLead of authorized time is left the next block is reserved; if the wallet cannot cover it the client
+// is warned once, and the call is ended when the authorized time runs out. Returns nil if no call is active.
+func (s *Service) Heartbeat(interactionID, userID uint) (*Meter, error) {
+	call, err := s.callRepo.Heartbeat(interactionID, userID, time.Now(), HeartbeatTimeout)
+	if err != nil || call == nil {
+		return nil, err
+	}
+	m := &Meter{Call: call, SecondsLeft: -1}
+	if call.RatePer5MinCents == 0 {
+		return m, nil
+	}
+	m.SecondsLeft = call.AuthorizedSeconds() - call.ConnectedSeconds
+	if m.SecondsLeft > int64(TopUpLead/time.Second) {
+		return m, nil
+	}
+	authorized, err := s.callRepo.Authorize(call.ID)
+	switch {
+	case err == nil:
+		m.Call = authorized
+		m.SecondsLeft = authorized.AuthorizedSeconds() - authorized.ConnectedSeconds
+		if authorized.LowFundsWarnedAt != nil {
+			_ = s.callRepo.ClearLowFundsWarning(call.ID)
+		}
+		return m, nil
+	case errors.Is(err, repository.ErrVideoCallEnded):
+		if ended, _ := s.callRepo.GetByID(call.ID); ended != nil {
+			m.Call = ended
+		}
+		m.Ended = true
+		return m, nil
+	case !errors.Is(err, repository.ErrInsufficientBalance):
+		return nil, err
+	}
+	if m.SecondsLeft <= 0 {
+		ended, err := s.End(call.ID, EndReasonInsufficientFunds)
+		if err != nil {
+			return nil, err
+		}
+		if ended != nil {
+			m.Call = ended
+		}
+		m.Ended = true
+		return m, nil
+	}
+	if warned, _ := s.callRepo.MarkLowFundsWarned(call.ID, time.Now()); warned {
+		m.LowFunds = true
+		_ = s.notifSvc.Notify(call.ClientID, "VIDEO_LOW_FUNDS", "Video call ending soon",
+			fmt.Sprintf("Your wallet does not cover the next 5 minutes. The call ends in about %d seconds unless you top up.", m.SecondsLeft),
+			map[string]interface{}{"interaction_id": interactionID, "call_id": call.ID, "seconds_left": m.SecondsLeft})
+	}
+	return m, nil
+}
+
+// End ends and settles an active call, paying the companion for the connected time. Ending a call that is
+// no longer active returns it unchanged.
+func (s *Service) End(callID uint, reason string) (*models.VideoCall, error) {
+	call, ended, err := s.callRepo.Settle(callID, reason)
+	if err != nil || !ended {
+		return call, err
+	}
+	s.postHistory(call)
+	log.Printf("[videocall] call %d ended (%s): %ds connected, %d cents charged", call.ID, reason, call.ConnectedSeconds, call.ChargedCents)
+	if call.CompanionCents > 0 {
+		_ = s.notifSvc.Notify(call.CompanionUserID, "VIDEO_CALL_EARNING", "Video call earnings",
+			fmt.Sprintf("You earned KES %.2f from a %d minute video call.", float64(call.CompanionCents)/100, (call.ConnectedSeconds+59)/60),
+			map[string]interface{}{"interaction_id": call.InteractionID, "call_id": call.ID, "amount_cents": call.CompanionCents})
+	}
+	return call, nil
+}
+
+// EndStaleCalls ends calls whose peers stopped sending heartbeats without hanging up, e.g. both apps were
+// killed, and closes invites nobody answered. Run periodically.
+func (s *Service) EndStaleCalls(ctx context.Context) error {
+	list, err := s.callRepo.ListStale(time.Now().Add(-StaleAfter), staleBatch)
+	if err != nil {
+		return err
+	}
+	for i := range list {
+		if ctx.Err() != nil {
+			return nil
+		}
+		var err error
+		if list[i].Status == domain.VideoCallStatusRinging {
+			_, err = s.Close(list[i].ID, domain.VideoCallStatusMissed)
+		} else {
+			_, err = s.End(list[i].ID, EndReasonTimeout)
+		}
+		if err != nil {
+			log.Printf("[videocall] close stale call %d: %v", list[i].ID, err)
+		}
+	}
+	return nil
+}
+
+// postHistory records the closed call in the interaction's chat, where both sides see it live and in history.
+func (s *Service) postHistory(call *models.VideoCall) {
+	session, _ := s.interactionRepo.GetChatSessionByInteractionID(call.InteractionID)
+	if session == nil || session.EndedAt != nil {
+		return
+	}
+	var content string
+	switch call.Status {
+	case domain.VideoCallStatusEnded:
+		content = fmt.Sprintf("Video call (%d:%02d)", call.ConnectedSeconds/60, call.ConnectedSeconds%60)
+	case domain.VideoCallStatusDeclined:
+		content = "Video call declined"
+	default:
+		content = "Missed video call"
+	}
+	callID := call.ID
+	msg := &models.ChatMessage{
+		SessionID: session.ID,
+		SenderID:  call.CallerID,
+		Content:   content,
+		Kind:      domain.ChatMessageKindCall,
+		CallID:    &callID,
+	}
+	if err := s.interactionRepo.CreateMessage(msg); err != nil {
+		log.Printf("[videocall] chat record for call %d: %v", call.ID, err)
+		return
+	}
+	if s.chatRooms != nil {
+		s.chatRooms.PublishToRoom(call.InteractionID, map[string]interface{}{
+			"type":        "message",
+			"id":          msg.ID,
+			"sender_id":   msg.SenderID,
+			"content":     msg.Content,
+			"kind":        msg.Kind,
+			"call_id":     call.ID,
+			"call_status": call.Status,
+			"created_at":  msg.CreatedAt,
+		})
+	}
+}
+
+// EventEnded is the payload sent to both peers when a call ends.
+func EventEnded(call *models.VideoCall) map[string]interface{} {
+	return map[string]interface{}{
+		"type":              "call_ended",
+		"call_id":           call.ID,
+		"reason":            call.EndReason,
+		"connected_seconds": call.ConnectedSeconds,
+		"charged_cents":     call.ChargedCents,
+	}
+}
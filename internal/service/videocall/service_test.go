@@ -0,0 +1,130 @@
+package videocall
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"lusty/internal/database/databasetest"
+	"lusty/internal/domain"
+	"lusty/internal/models"
+	"lusty/internal/repository"
+	"lusty/internal/service"
+)
+
+// TestCallStates walks calls through ringing, declined, missed and answered, checking each closed call is
+// recorded in the chat and the callee hears about the ones she missed.
+func TestCallStates(t *testing.T) {
+	db := databasetest.New(t)
+	userRepo := repository.NewUserRepository(db)
+	companionRepo := repository.NewCompanionRepository(db)
+	interactionRepo := repository.NewInteractionRepository(db)
+	callRepo := repository.NewVideoCallRepository(db)
+	svc := NewService(callRepo, companionRepo, interactionRepo, userRepo,
+		service.NewNotificationService(repository.NewNotificationRepository(db), userRepo, nil), nil)
+
+	client := models.User{Email: "client@example.com", Username: "client", Role: domain.RoleClient, KYC: true}
+	companionUser := models.User{Email: "companion@example.com", Username: "companion", Role: domain.RoleCompanion}
+	for _, u := range []*models.User{&client, &companionUser} {
+		if err := db.Create(u).Error; err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+	}
+	comp := models.CompanionProfile{UserID: companionUser.ID, DisplayName: "companion"}
+	if err := db.Create(&comp).Error; err != nil {
+		t.Fatalf("create companion: %v", err)
+	}
+	seeded := models.InteractionRequest{ClientID: client.ID, CompanionID: comp.ID, InteractionType: "VIDEO", DurationMinutes: 60,
+		Status: domain.RequestStatusAccepted}
+	if err := db.Create(&seeded).Error; err != nil {
+		t.Fatalf("create interaction: %v", err)
+	}
+	if err := db.Create(&models.ChatSession{InteractionID: seeded.ID, StartedAt: time.Now(), EndsAt: time.Now().Add(time.Hour)}).Error; err != nil {
+		t.Fatalf("create session: %v", err)
+	}
+	ir, _ := interactionRepo.GetByID(seeded.ID)
+
+	// The companion declines the client's call: no missed-call notification
+	call, err := svc.Invite(ir, client.ID)
+	if err != nil {
+		t.Fatalf("invite: %v", err)
+	}
+	if _, err := svc.Invite(ir, companionUser.ID); !errors.Is(err, repository.ErrVideoCallInProgress) {
+		t.Fatalf("invite while ringing: err = %v, want ErrVideoCallInProgress", err)
+	}
+	if closed, err := svc.Close(call.ID, domain.VideoCallStatusDeclined); err != nil || closed.Status != domain.VideoCallStatusDeclined {
+		t.Fatalf("decline: call=%+v err=%v", closed, err)
+	}
+	if _, err := svc.Accept(call.ID); !errors.Is(err, repository.ErrVideoCallNotRinging) {
+		t.Fatalf("accept after decline: err = %v, want ErrVideoCallNotRinging", err)
+	}
+
+	// An invite left ringing past the timeout is closed as missed when the next one is made
+	call, err = svc.Invite(ir, client.ID)
+	if err != nil {
+		t.Fatalf("invite: %v", err)
+	}
+	if err := db.Model(&models.VideoCall{}).Where("id = ?", call.ID).Update("started_at", time.Now().Add(-2*RingTimeout)).Error; err != nil {
+		t.Fatalf("age invite: %v", err)
+	}
+	next, err := svc.Invite(ir, client.ID)
+	if err != nil {
+		t.Fatalf("invite after ring timeout: %v", err)
+	}
+	if missed, _ := callRepo.GetByID(call.ID); missed.Status != domain.VideoCallStatusMissed {
+		t.Fatalf("old invite status = %s, want MISSED", missed.Status)
+	}
+	var notes []models.Notification
+	db.Where("type = ?", "MISSED_VIDEO_CALL").Find(&notes)
+	if len(notes) != 1 || notes[0].UserID != companionUser.ID {
+		t.Fatalf("missed call notifications = %+v, want one for the companion", notes)
+	}
+
+	// Without VIDEO_PER_5MIN pricing the answered call is free, and hanging up ends it
+	active, err := svc.Accept(next.ID)
+	if err != nil || active.Status != domain.VideoCallStatusActive || active.RatePer5MinCents != 0 {
+		t.Fatalf("accept: call=%+v err=%v, want ACTIVE and unbilled", active, err)
+	}
+	ended, err := svc.End(next.ID, EndReasonHangup)
+	if err != nil || ended.Status != domain.VideoCallStatusEnded || ended.EndReason != EndReasonHangup {
+		t.Fatalf("end: call=%+v err=%v", ended, err)
+	}
+	if live, _ := svc.Live(ir.ID); live != nil {
+		t.Fatalf("live call = %+v after hang-up, want none", live)
+	}
+
+	var history []models.ChatMessage
+	db.Where("kind = ?", domain.ChatMessageKindCall).Order("id ASC").Find(&history)
+	var got []string
+	for _, m := range history {
+		got = append(got, m.Content)
+	}
+	if want := "Video call declined|Missed video call|Video call (0:00)"; strings.Join(got, "|") != want {
+		t.Fatalf("chat history = %q, want %s", got, want)
+	}
+}
+
+// TestAcceptWithoutFunds checks a billed call the client's wallet cannot cover ends as soon as it is answered.
+func TestAcceptWithoutFunds(t *testing.T) {
+	db := databasetest.New(t)
+	userRepo := repository.NewUserRepository(db)
+	callRepo := repository.NewVideoCallRepository(db)
+	svc := NewService(callRepo, repository.NewCompanionRepository(db), repository.NewInteractionRepository(db), userRepo,
+		service.NewNotificationService(repository.NewNotificationRepository(db), userRepo, nil), nil)
+	if err := db.Create(&models.CompanionPricing{CompanionID: 1, Type: "VIDEO_PER_5MIN", AmountCents: 10_000, Currency: "KES", IsActive: true}).Error; err != nil {
+		t.Fatalf("create pricing: %v", err)
+	}
+	call := &models.VideoCall{InteractionID: 7, CallerID: 1, ClientID: 1, CompanionID: 1, CompanionUserID: 100, StartedAt: time.Now()}
+	if err := callRepo.CreateRinging(call); err != nil {
+		t.Fatalf("invite: %v", err)
+	}
+
+	if _, err := svc.Accept(call.ID); !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("accept: err = %v, want ErrInsufficientFunds", err)
+	}
+	stored, _ := callRepo.GetByID(call.ID)
+	if stored.Status != domain.VideoCallStatusEnded || stored.EndReason != EndReasonInsufficientFunds || stored.ChargedCents != 0 {
+		t.Fatalf("call = %+v, want ENDED for insufficient funds with nothing charged", stored)
+	}
+}
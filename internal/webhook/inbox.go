@@ -0,0 +1,203 @@
+// Package webhook is the durable inbox for provider callbacks. A callback is stored raw in webhook_events
+// and acknowledged; a Processor then applies it, with retries and backoff, outside the provider's request.
+// The same Processor is used for first delivery, retries and admin replay, so processing must be idempotent.
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"lusty/internal/domain"
+	"lusty/internal/models"
+	"lusty/internal/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxAttempts = 8
+	baseBackoff = 30 * time.Second
+	maxBackoff  = time.Hour
+	lease       = 2 * time.Minute // how long a worker owns an event before another may take it over
+	batchSize   = 50
+)
+
+// ErrBusy is returned by Replay when a worker is processing the event right now.
+var ErrBusy = errors.New("webhook event is being processed")
+
+// Processor applies one provider's callbacks.
+type Processor interface {
+	// EventKey returns the provider's identity for the callback; a second callback with the same key is
+	// treated as a redelivery and dropped. An error rejects the callback as malformed.
+	EventKey(body []byte) (string, error)
+	// Process applies the event. Returning an error schedules a retry; returning nil marks it done (also
+	// for callbacks that are deliberately ignored). Must be safe to run again on an already-applied event.
+	Process(ctx context.Context, ev *models.WebhookEvent) error
+}
+
+type Inbox struct {
+	repo       *repository.WebhookEventRepository
+	processors map[string]Processor
+}
+
+func NewInbox(repo *repository.WebhookEventRepository) *Inbox {
+	return &Inbox{repo: repo, processors: make(map[string]Processor)}
+}
+
+// Register sets the processor for provider. Call during setup.
+func (i *Inbox) Register(provider string, p Processor) {
+	i.processors[provider] = p
+}
+
+// Receive is the HTTP endpoint for provider callbacks: store, acknowledge, then process in the background.
+// Authentication (middleware.WebhookGuard) runs before it.
+func (i *Inbox) Receive(provider string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p := i.processors[provider]
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil || p == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid body"})
+			return
+		}
+		key, err := p.EventKey(body)
+		if err != nil {
+			log.Printf("[webhook] %s: rejecting malformed callback: %v", provider, err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+			return
+		}
+		ev, created, err := i.store(provider, key, body, c.ClientIP(), c.Request.UserAgent())
+		if err != nil {
+			log.Printf("[webhook] %s: store failed for key=%s: %v", provider, key, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "store failed"})
+			return
+		}
+		if !created {
+			log.Printf("[webhook] %s: duplicate delivery key=%s", provider, key)
+			c.JSON(http.StatusOK, gin.H{"received": true, "duplicate": true})
+			return
+		}
+		go i.run(context.Background(), ev.ID)
+		c.JSON(http.StatusOK, gin.H{"received": true})
+	}
+}
+
+// Enqueue stores an event that did not come over HTTP (e.g. a status the reconciler read from the provider's
+// API) and processes it now, exactly as if the provider had called back. Returns the event as it ended up;
+// created is false if an event with the same key already existed, in which case it is left alone.
+func (i *Inbox) Enqueue(ctx context.Context, provider, key string, body []byte, source string) (*models.WebhookEvent, bool, error) {
+	if i.processors[provider] == nil {
+		return nil, false, fmt.Errorf("no processor for provider %q", provider)
+	}
+	ev, created, err := i.store(provider, key, body, "", source)
+	if err != nil || !created {
+		return ev, created, err
+	}
+	i.run(ctx, ev.ID)
+	ev, err = i.repo.GetByID(ev.ID)
+	return ev, true, err
+}
+
+func (i *Inbox) store(provider, key string, body []byte, ip, userAgent string) (*models.WebhookEvent, bool, error) {
+	now := time.Now()
+	ev := &models.WebhookEvent{
+		Provider:      provider,
+		EventKey:      key,
+		Payload:       string(body),
+		SourceIP:      ip,
+		UserAgent:     userAgent,
+		Status:        domain.WebhookEventPending,
+		NextAttemptAt: &now,
+	}
+	created, err := i.repo.Store(ev)
+	return ev, created, err
+}
+
+// ProcessDue runs every event that is due for an attempt. Registered as a scheduled job.
+func (i *Inbox) ProcessDue(ctx context.Context) error {
+	ids, err := i.repo.ListDue(time.Now(), batchSize)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return nil
+		}
+		i.run(ctx, id)
+	}
+	return nil
+}
+
+// Replay processes an event again now through its provider's processor, whatever its status, and returns
+// the event as it ended up.
+func (i *Inbox) Replay(ctx context.Context, id uint) (*models.WebhookEvent, error) {
+	ok, err := i.repo.ResetForReplay(id)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if _, err := i.repo.GetByID(id); err != nil {
+			return nil, err
+		}
+		return nil, ErrBusy
+	}
+	i.run(ctx, id)
+	return i.repo.GetByID(id)
+}
+
+// run claims the event and applies it. Losing the claim to another worker is not an error.
+func (i *Inbox) run(ctx context.Context, id uint) {
+	ev, err := i.repo.Claim(id, lease)
+	if err != nil {
+		log.Printf("[webhook] claim event %d: %v", id, err)
+		return
+	}
+	if ev == nil {
+		return
+	}
+	err = i.process(ctx, ev)
+	if err == nil {
+		if err := i.repo.MarkProcessed(ev.ID); err != nil {
+			log.Printf("[webhook] event %d: mark processed: %v", ev.ID, err)
+		}
+		return
+	}
+	var next *time.Time
+	if ev.Attempts < maxAttempts {
+		t := time.Now().Add(backoff(ev.Attempts))
+		next = &t
+	}
+	log.Printf("[webhook] %s event %d attempt %d failed: %v (retry at %v)", ev.Provider, ev.ID, ev.Attempts, err, next)
+	if err := i.repo.MarkFailed(ev.ID, err.Error(), next); err != nil {
+		log.Printf("[webhook] event %d: mark failed: %v", ev.ID, err)
+	}
+}
+
+func (i *Inbox) process(ctx context.Context, ev *models.WebhookEvent) (err error) {
+	p := i.processors[ev.Provider]
+	if p == nil {
+		return fmt.Errorf("no processor for provider %q", ev.Provider)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return p.Process(ctx, ev)
+}
+
+// backoff is 30s doubled per attempt, capped at an hour.
+func backoff(attempts int) time.Duration {
+	d := baseBackoff
+	for n := 1; n < attempts && d < maxBackoff; n++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
@@ -0,0 +1,166 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"lusty/internal/database/databasetest"
+	"lusty/internal/domain"
+	"lusty/internal/models"
+	"lusty/internal/repository"
+
+	"gorm.io/gorm"
+)
+
+// countingProcessor keys events by their raw body and fails while fail is set.
+type countingProcessor struct {
+	calls int
+	fail  bool
+}
+
+func (p *countingProcessor) EventKey(body []byte) (string, error) {
+	if len(body) == 0 {
+		return "", errors.New("empty body")
+	}
+	return string(body), nil
+}
+
+func (p *countingProcessor) Process(context.Context, *models.WebhookEvent) error {
+	p.calls++
+	if p.fail {
+		return errors.New("provider said no")
+	}
+	return nil
+}
+
+func newTestInbox(t *testing.T) (*Inbox, *repository.WebhookEventRepository, *countingProcessor, *gorm.DB) {
+	t.Helper()
+	db := databasetest.New(t)
+	repo := repository.NewWebhookEventRepository(db)
+	inbox := NewInbox(repo)
+	p := &countingProcessor{}
+	inbox.Register("test", p)
+	return inbox, repo, p, db
+}
+
+func TestBackoff(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		3:  2 * time.Minute,
+		7:  32 * time.Minute,
+		8:  time.Hour,
+		20: time.Hour,
+	} {
+		if got := backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+// TestEnqueueRetriesUntilDead checks a failing event is retried on its backoff schedule, not before, and is
+// given up on as DEAD after maxAttempts.
+func TestEnqueueRetriesUntilDead(t *testing.T) {
+	inbox, repo, p, db := newTestInbox(t)
+	p.fail = true
+	ctx := context.Background()
+
+	ev, created, err := inbox.Enqueue(ctx, "test", "ev-1", []byte("ev-1"), "test")
+	if err != nil || !created {
+		t.Fatalf("enqueue = %v, %v; want created", created, err)
+	}
+	if ev.Status != domain.WebhookEventFailed || ev.Attempts != 1 || ev.NextAttemptAt == nil ||
+		ev.NextAttemptAt.Before(time.Now().Add(25*time.Second)) {
+		t.Fatalf("after first attempt: %+v, want FAILED with a retry about 30s out", ev)
+	}
+	if err := inbox.ProcessDue(ctx); err != nil {
+		t.Fatalf("process due: %v", err)
+	}
+	if p.calls != 1 {
+		t.Fatalf("processed %d times before the retry was due, want 1", p.calls)
+	}
+
+	past := time.Now().Add(-time.Second)
+	for attempt := 2; attempt <= maxAttempts; attempt++ {
+		db.Model(&models.WebhookEvent{}).Where("id = ?", ev.ID).Update("next_attempt_at", past)
+		if err := inbox.ProcessDue(ctx); err != nil {
+			t.Fatalf("process due: %v", err)
+		}
+	}
+	ev, _ = repo.GetByID(ev.ID)
+	if ev.Status != domain.WebhookEventDead || ev.Attempts != maxAttempts || ev.NextAttemptAt != nil {
+		t.Fatalf("after %d attempts: %+v, want DEAD with no retry", maxAttempts, ev)
+	}
+	if ev.LastError != "provider said no" {
+		t.Fatalf("last error = %q", ev.LastError)
+	}
+}
+
+// TestEnqueueDuplicate checks a second event with the same key is dropped without being processed.
+func TestEnqueueDuplicate(t *testing.T) {
+	inbox, _, p, _ := newTestInbox(t)
+	ctx := context.Background()
+	if _, created, err := inbox.Enqueue(ctx, "test", "ev-1", []byte("ev-1"), "test"); err != nil || !created {
+		t.Fatalf("enqueue = %v, %v; want created", created, err)
+	}
+	if _, created, err := inbox.Enqueue(ctx, "test", "ev-1", []byte("ev-1"), "test"); err != nil || created {
+		t.Fatalf("second enqueue = %v, %v; want a duplicate", created, err)
+	}
+	if p.calls != 1 {
+		t.Fatalf("processed %d times, want 1", p.calls)
+	}
+}
+
+// TestClaimLease checks only one worker holds an event at a time, and that a lease left by a worker that
+// died can be taken over once it runs out.
+func TestClaimLease(t *testing.T) {
+	_, repo, _, db := newTestInbox(t)
+	now := time.Now()
+	ev := &models.WebhookEvent{Provider: "test", EventKey: "ev-1", Payload: "ev-1", Status: domain.WebhookEventPending, NextAttemptAt: &now}
+	if _, err := repo.Store(ev); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+
+	first, err := repo.Claim(ev.ID, time.Minute)
+	if err != nil || first == nil || first.Status != domain.WebhookEventProcessing || first.Attempts != 1 {
+		t.Fatalf("first claim = %+v, %v; want PROCESSING on attempt 1", first, err)
+	}
+	if second, err := repo.Claim(ev.ID, time.Minute); err != nil || second != nil {
+		t.Fatalf("second claim = %+v, %v; want nothing while leased", second, err)
+	}
+	db.Model(&models.WebhookEvent{}).Where("id = ?", ev.ID).Update("locked_until", now.Add(-time.Second))
+	if ids, _ := repo.ListDue(time.Now(), 10); len(ids) != 1 {
+		t.Fatalf("due after lease expiry = %v, want the event", ids)
+	}
+	if takeover, err := repo.Claim(ev.ID, time.Minute); err != nil || takeover == nil || takeover.Attempts != 2 {
+		t.Fatalf("takeover claim = %+v, %v; want attempt 2", takeover, err)
+	}
+}
+
+// TestReplay checks an admin replay processes an event again whatever its status, but not while a worker
+// holds it.
+func TestReplay(t *testing.T) {
+	inbox, repo, p, _ := newTestInbox(t)
+	ctx := context.Background()
+	ev, _, err := inbox.Enqueue(ctx, "test", "ev-1", []byte("ev-1"), "test")
+	if err != nil || ev.Status != domain.WebhookEventProcessed {
+		t.Fatalf("enqueue = %+v, %v; want PROCESSED", ev, err)
+	}
+
+	ev, err = inbox.Replay(ctx, ev.ID)
+	if err != nil || ev.Status != domain.WebhookEventProcessed || p.calls != 2 {
+		t.Fatalf("replay = %+v, %v after %d calls; want PROCESSED after 2", ev, err, p.calls)
+	}
+
+	if _, err := repo.ResetForReplay(ev.ID); err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+	if _, err := repo.Claim(ev.ID, time.Minute); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if _, err := inbox.Replay(ctx, ev.ID); !errors.Is(err, ErrBusy) {
+		t.Fatalf("replay while claimed: err = %v, want ErrBusy", err)
+	}
+}
@@ -0,0 +1,127 @@
+package ws
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Backplane carries hub events between server instances, so users connected to different nodes share chat
+// rooms, video signaling, map markers and /ws/user events. Every subscriber of a channel, on every node,
+// gets each message published on it, in order.
+type Backplane interface {
+	Publish(channel string, data []byte) error
+	// Subscribe calls handle for every message published on channel from now on.
+	Subscribe(channel string, handle func(data []byte)) error
+}
+
+// backplaneQueue is how many events a node buffers for the backplane before dropping them.
+const backplaneQueue = 1024
+
+// MemoryBackplane is a Backplane inside one process: enough for a single instance, and lets several hubs in
+// one process stand in for separate nodes.
+type MemoryBackplane struct {
+	mu   sync.RWMutex
+	subs map[string][]chan []byte
+}
+
+func NewMemoryBackplane() *MemoryBackplane {
+	return &MemoryBackplane{subs: make(map[string][]chan []byte)}
+}
+
+func (b *MemoryBackplane) Publish(channel string, data []byte) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, ch := range b.subs[channel] {
+		select {
+		case ch <- data:
+		default:
+			log.Printf("[ws] backplane subscriber on %s is full, dropping event", channel)
+		}
+	}
+	return nil
+}
+
+func (b *MemoryBackplane) Subscribe(channel string, handle func(data []byte)) error {
+	ch := make(chan []byte, backplaneQueue)
+	b.mu.Lock()
+	b.subs[channel] = append(b.subs[channel], ch)
+	b.mu.Unlock()
+	go func() {
+		for data := range ch {
+			handle(data)
+		}
+	}()
+	return nil
+}
+
+// Backplane operations.
+const (
+	opUser   = "user"   // deliver to one user's connections
+	opAll    = "all"    // deliver to every connection
+	opRoom   = "room"   // deliver to everyone in a room
+	opOther  = "other"  // deliver to everyone in a room but UserID
+	opJoin   = "join"   // UserID connected to a room
+	opLeave  = "leave"  // UserID left a room
+	opState  = "state"  // a video room's call state changed
+	opMarker = "marker" // a companion's map marker moved
+)
+
+// envelope is one hub event on the backplane.
+type envelope struct {
+	Node   string          `json:"node"`
+	Op     string          `json:"op"`
+	Room   uint            `json:"room,omitempty"`
+	UserID uint            `json:"user_id,omitempty"`
+	State  *callState      `json:"state,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+// bus connects one hub to its backplane channel. Events are published from a queue so hubs can publish while
+// holding their locks; events the hub published itself are dropped when they come back. A nil bus (no
+// backplane) does nothing.
+type bus struct {
+	node    string
+	channel string
+	queue   chan envelope
+}
+
+func newBus(bp Backplane, channel string, receive func(e envelope)) *bus {
+	if bp == nil {
+		return nil
+	}
+	b := &bus{node: uuid.NewString(), channel: channel, queue: make(chan envelope, backplaneQueue)}
+	err := bp.Subscribe(channel, func(data []byte) {
+		var e envelope
+		if err := json.Unmarshal(data, &e); err != nil || e.Node == b.node {
+			return
+		}
+		receive(e)
+	})
+	if err != nil {
+		log.Printf("[ws] subscribe to backplane channel %s: %v", channel, err)
+	}
+	go func() {
+		for e := range b.queue {
+			data, _ := json.Marshal(e)
+			if err := bp.Publish(channel, data); err != nil {
+				log.Printf("[ws] publish to backplane channel %s: %v", channel, err)
+			}
+		}
+	}()
+	return b
+}
+
+func (b *bus) publish(e envelope) {
+	if b == nil {
+		return
+	}
+	e.Node = b.node
+	select {
+	case b.queue <- e:
+	default:
+		log.Printf("[ws] backplane queue for %s is full, dropping event", b.channel)
+	}
+}
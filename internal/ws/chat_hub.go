@@ -5,12 +5,15 @@ import (
 	"sync"
 )
 
-// ChatRoom is one room per interaction (client + companion).
+// ChatRoom is one room per interaction (client + companion). With a backplane the room spans nodes: what is
+// broadcast reaches the room's connections on every node, and the room knows who is connected elsewhere.
 type ChatRoom struct {
 	InteractionID uint
 	ClientID      uint
 	CompanionID   uint
 	clients       map[*Client]struct{}
+	remote        map[uint]int // userID -> connections on other nodes
+	bus           *bus
 	mu            sync.RWMutex
 }
 
@@ -20,6 +23,7 @@ func NewChatRoom(interactionID, clientID, companionID uint) *ChatRoom {
 		ClientID:      clientID,
 		CompanionID:   companionID,
 		clients:       make(map[*Client]struct{}),
+		remote:        make(map[uint]int),
 	}
 }
 
@@ -27,12 +31,17 @@ func (r *ChatRoom) Join(c *Client) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.clients[c] = struct{}{}
+	r.bus.publish(envelope{Op: opJoin, Room: r.InteractionID, UserID: c.UserID})
 }
 
 func (r *ChatRoom) Leave(c *Client) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	if _, ok := r.clients[c]; !ok {
+		return
+	}
 	delete(r.clients, c)
+	r.bus.publish(envelope{Op: opLeave, Room: r.InteractionID, UserID: c.UserID})
 }
 
 func (r *ChatRoom) ClientCount() int {
@@ -41,8 +50,31 @@ func (r *ChatRoom) ClientCount() int {
 	return len(r.clients)
 }
 
+// HasUser reports whether userID has a connection in the room, on this node or another.
+func (r *ChatRoom) HasUser(userID uint) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.remote[userID] > 0 {
+		return true
+	}
+	for c := range r.clients {
+		if c.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// Broadcast sends payload to every connection but from. A connection whose Send buffer is full is a slow
+// consumer: it is removed from the room and evicted rather than silently missing the payload, so it
+// reconnects and catches up from the stored messages.
 func (r *ChatRoom) Broadcast(from *Client, payload interface{}) {
 	data, _ := json.Marshal(payload)
+	r.sendAll(from, data)
+	r.bus.publish(envelope{Op: opRoom, Room: r.InteractionID, Data: data})
+}
+
+func (r *ChatRoom) sendAll(from *Client, data []byte) {
 	r.mu.RLock()
 	clients := make([]*Client, 0, len(r.clients))
 	for c := range r.clients {
@@ -52,10 +84,46 @@ func (r *ChatRoom) Broadcast(from *Client, payload interface{}) {
 	}
 	r.mu.RUnlock()
 	for _, c := range clients {
-		select {
-		case c.Send <- data:
-		default:
+		r.deliver(c, data)
+	}
+}
+
+// SendTo delivers payload to one connection only, e.g. an ack for the message it sent.
+func (r *ChatRoom) SendTo(c *Client, payload interface{}) {
+	data, _ := json.Marshal(payload)
+	r.deliver(c, data)
+}
+
+func (r *ChatRoom) deliver(c *Client, data []byte) {
+	if !c.send(data) {
+		r.Leave(c)
+		if c.Evict != nil {
+			c.Evict()
+		}
+	}
+}
+
+// BroadcastTyping tells the other connections that userID started or stopped typing. Typing state is never
+// stored.
+func (r *ChatRoom) BroadcastTyping(from *Client, userID uint, typing bool) {
+	r.Broadcast(from, map[string]interface{}{"type": "typing", "user_id": userID, "typing": typing})
+}
+
+// receive applies an event from another node.
+func (r *ChatRoom) receive(e envelope) {
+	switch e.Op {
+	case opRoom:
+		r.sendAll(nil, e.Data)
+	case opJoin:
+		r.mu.Lock()
+		r.remote[e.UserID]++
+		r.mu.Unlock()
+	case opLeave:
+		r.mu.Lock()
+		if r.remote[e.UserID]--; r.remote[e.UserID] <= 0 {
+			delete(r.remote, e.UserID)
 		}
+		r.mu.Unlock()
 	}
 }
 
@@ -63,19 +131,39 @@ func (r *ChatRoom) Broadcast(from *Client, payload interface{}) {
 type ChatHub struct {
 	mu    sync.RWMutex
 	rooms map[uint]*ChatRoom
+	bus   *bus
 }
 
-func NewChatHub() *ChatHub {
-	return &ChatHub{rooms: make(map[uint]*ChatRoom)}
+// NewChatHub creates the chat hub; bp may be nil for a single node.
+func NewChatHub(bp Backplane) *ChatHub {
+	h := &ChatHub{rooms: make(map[uint]*ChatRoom)}
+	h.bus = newBus(bp, "chat", h.receive)
+	return h
+}
+
+func (h *ChatHub) receive(e envelope) {
+	if e.Op == opRoom {
+		// Nobody here to deliver to unless the room exists
+		if r := h.GetRoom(e.Room); r != nil {
+			r.receive(e)
+		}
+		return
+	}
+	h.GetOrCreateRoom(e.Room, 0, 0).receive(e)
 }
 
 func (h *ChatHub) GetOrCreateRoom(interactionID, clientID, companionID uint) *ChatRoom {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 	if r, ok := h.rooms[interactionID]; ok {
+		if r.ClientID == 0 {
+			// Created for another node's connection, before anyone joined here
+			r.ClientID, r.CompanionID = clientID, companionID
+		}
 		return r
 	}
 	r := NewChatRoom(interactionID, clientID, companionID)
+	r.bus = h.bus
 	h.rooms[interactionID] = r
 	return r
 }
@@ -86,6 +174,13 @@ func (h *ChatHub) GetRoom(interactionID uint) *ChatRoom {
 	return h.rooms[interactionID]
 }
 
+// PublishToRoom sends payload to everyone in the interaction's room, if anyone is connected.
+func (h *ChatHub) PublishToRoom(interactionID uint, payload interface{}) {
+	if r := h.GetRoom(interactionID); r != nil {
+		r.Broadcast(nil, payload)
+	}
+}
+
 func (h *ChatHub) RemoveRoom(interactionID uint) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
@@ -12,6 +12,7 @@ type Client struct {
 	Send     chan []byte
 	conn     interface{ SendMessage([]byte) error }
 	Hub      *Hub // set so Close() can unregister; may be nil for chat/video rooms
+	Evict    func() // set by chat connections to be disconnected when Send is full; may be called more than once
 	mu       sync.Mutex
 	closed   bool
 }
@@ -29,12 +30,30 @@ func (c *Client) Close() {
 	}
 }
 
+// send queues data without blocking. It reports false when Send is full or the client is closed: hubs and
+// backplane subscribers can still hold a client that its connection has just closed, and sending on the
+// closed channel would panic.
+func (c *Client) send(data []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false
+	}
+	select {
+	case c.Send <- data:
+		return true
+	default:
+		return false
+	}
+}
+
 // Hub maintains the set of active clients and broadcasts to them.
 type Hub struct {
 	mu      sync.RWMutex
 	clients map[*Client]struct{}
 	// userID -> clients (one user can have multiple connections)
 	byUser map[uint]map[*Client]struct{}
+	bus    *bus
 }
 
 func NewHub() *Hub {
@@ -44,6 +63,23 @@ func NewHub() *Hub {
 	}
 }
 
+// NewSharedHub returns a Hub whose broadcasts also reach the clients of the hubs on other nodes subscribed to
+// channel on bp.
+func NewSharedHub(bp Backplane, channel string) *Hub {
+	h := NewHub()
+	h.bus = newBus(bp, channel, h.receive)
+	return h
+}
+
+func (h *Hub) receive(e envelope) {
+	switch e.Op {
+	case opUser:
+		h.sendToUser(e.UserID, e.Data)
+	case opAll:
+		h.sendAll(e.Data)
+	}
+}
+
 func (h *Hub) Register(c *Client) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
@@ -69,6 +105,11 @@ func (h *Hub) unregister(c *Client) {
 
 func (h *Hub) BroadcastToUser(userID uint, payload interface{}) {
 	data, _ := json.Marshal(payload)
+	h.sendToUser(userID, data)
+	h.bus.publish(envelope{Op: opUser, UserID: userID, Data: data})
+}
+
+func (h *Hub) sendToUser(userID uint, data []byte) {
 	h.mu.RLock()
 	m := h.byUser[userID]
 	if m == nil {
@@ -81,15 +122,17 @@ func (h *Hub) BroadcastToUser(userID uint, payload interface{}) {
 	}
 	h.mu.RUnlock()
 	for _, c := range clients {
-		select {
-		case c.Send <- data:
-		default:
-		}
+		c.send(data)
 	}
 }
 
 func (h *Hub) BroadcastAll(payload interface{}) {
 	data, _ := json.Marshal(payload)
+	h.sendAll(data)
+	h.bus.publish(envelope{Op: opAll, Data: data})
+}
+
+func (h *Hub) sendAll(data []byte) {
 	h.mu.RLock()
 	clients := make([]*Client, 0, len(h.clients))
 	for c := range h.clients {
@@ -97,10 +140,7 @@ func (h *Hub) BroadcastAll(payload interface{}) {
 	}
 	h.mu.RUnlock()
 	for _, c := range clients {
-		select {
-		case c.Send <- data:
-		default:
-		}
+		c.send(data)
 	}
 }
 
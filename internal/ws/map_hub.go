@@ -15,19 +15,32 @@ type MapMarker struct {
 	UpdatedAt   int64   `json:"updated_at"`
 }
 
-// MapHub streams fuzzed companion locations to clients; companions push their location.
+// MapHub streams fuzzed companion locations to clients; companions push their location. Location updates
+// are shared with the other nodes over the backplane, so every node keeps the same markers.
 type MapHub struct {
 	*Hub
 	// companionID -> last fuzzed location (so we can broadcast to map viewers)
 	mu      sync.RWMutex
 	markers map[uint]MapMarker
+	bus     *bus
 }
 
-func NewMapHub() *MapHub {
-	return &MapHub{
+// NewMapHub creates the map hub; bp may be nil for a single node.
+func NewMapHub(bp Backplane) *MapHub {
+	m := &MapHub{
 		Hub:     NewHub(),
 		markers: make(map[uint]MapMarker),
 	}
+	m.bus = newBus(bp, "map", m.receive)
+	return m
+}
+
+func (m *MapHub) receive(e envelope) {
+	var marker MapMarker
+	if e.Op != opMarker || json.Unmarshal(e.Data, &marker) != nil {
+		return
+	}
+	m.setMarker(marker)
 }
 
 // UpdateLocation is called when a companion's location updates (with fuzzed coords).
@@ -39,8 +52,14 @@ func (m *MapHub) UpdateLocation(companionID uint, lat, lng float64, isOnline boo
 		IsOnline:    isOnline,
 		UpdatedAt:   time.Now().Unix(),
 	}
+	m.setMarker(marker)
+	data, _ := json.Marshal(marker)
+	m.bus.publish(envelope{Op: opMarker, Data: data})
+}
+
+func (m *MapHub) setMarker(marker MapMarker) {
 	m.mu.Lock()
-	m.markers[companionID] = marker
+	m.markers[marker.CompanionID] = marker
 	m.mu.Unlock()
 	m.BroadcastAll(marker)
 }
@@ -66,8 +85,5 @@ type MapClient struct {
 
 func (c *MapClient) SendMarkers(markers []MapMarker) {
 	data, _ := json.Marshal(map[string]interface{}{"type": "markers", "markers": markers})
-	select {
-	case c.Send <- data:
-	default:
-	}
+	c.send(data)
 }
@@ -0,0 +1,61 @@
+package ws
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisBackplane is a Backplane over Redis pub/sub, for running several instances behind a load balancer.
+// Redis does not keep pub/sub messages, so a node that is disconnected from Redis misses what is published
+// meanwhile; chat clients catch up from the stored messages when they reconnect.
+type RedisBackplane struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisBackplane connects to the Redis at url (redis://[:password@]host:port/db). Channel names are
+// prefixed with prefix.
+func NewRedisBackplane(url, prefix string) (*RedisBackplane, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &RedisBackplane{client: client, prefix: prefix}, nil
+}
+
+func (b *RedisBackplane) Publish(channel string, data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return b.client.Publish(ctx, b.prefix+channel, data).Err()
+}
+
+// Subscribe waits until Redis confirms the subscription, then hands messages to handle in the background. The
+// client resubscribes by itself after a dropped connection.
+func (b *RedisBackplane) Subscribe(channel string, handle func(data []byte)) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ps := b.client.Subscribe(context.Background(), b.prefix+channel)
+	if _, err := ps.Receive(ctx); err != nil {
+		ps.Close()
+		return err
+	}
+	go func() {
+		for msg := range ps.Channel() {
+			handle([]byte(msg.Payload))
+		}
+	}()
+	return nil
+}
+
+func (b *RedisBackplane) Close() error {
+	return b.client.Close()
+}
@@ -0,0 +1,143 @@
+package ws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newTestRedisBackplane(t *testing.T, addr string) *RedisBackplane {
+	t.Helper()
+	bp, err := NewRedisBackplane("redis://"+addr+"/0", "test:")
+	if err != nil {
+		t.Fatalf("connect backplane: %v", err)
+	}
+	t.Cleanup(func() { bp.Close() })
+	return bp
+}
+
+func newTestClient(userID uint) *Client {
+	return &Client{UserID: userID, Send: make(chan []byte, 8)}
+}
+
+func expectMessage(t *testing.T, c *Client) []byte {
+	t.Helper()
+	select {
+	case data := <-c.Send:
+		return data
+	case <-time.After(2 * time.Second):
+		t.Fatalf("user %d got nothing", c.UserID)
+		return nil
+	}
+}
+
+func expectSilence(t *testing.T, c *Client) {
+	t.Helper()
+	select {
+	case data := <-c.Send:
+		t.Fatalf("user %d got unexpected %s", c.UserID, data)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestNewRedisBackplaneUnreachable(t *testing.T) {
+	mr := miniredis.RunT(t)
+	addr := mr.Addr()
+	mr.Close()
+	if _, err := NewRedisBackplane("redis://"+addr+"/0", "test:"); err == nil {
+		t.Fatal("expected an error for an unreachable Redis")
+	}
+}
+
+func TestRedisBackplanePublishSubscribe(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a := newTestRedisBackplane(t, mr.Addr())
+	b := newTestRedisBackplane(t, mr.Addr())
+
+	got := make(chan string, 1)
+	if err := b.Subscribe("events", func(data []byte) { got <- string(data) }); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	if err := a.Publish("events", []byte("hello")); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	select {
+	case msg := <-got:
+		if msg != "hello" {
+			t.Fatalf("got %q, want hello", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("subscriber got nothing")
+	}
+}
+
+// Two hubs on separate backplane connections stand in for two nodes.
+func TestSharedHubAcrossRedisNodes(t *testing.T) {
+	mr := miniredis.RunT(t)
+	node1 := NewSharedHub(newTestRedisBackplane(t, mr.Addr()), "user")
+	node2 := NewSharedHub(newTestRedisBackplane(t, mr.Addr()), "user")
+
+	alice, bob := newTestClient(1), newTestClient(2)
+	node1.Register(alice)
+	node2.Register(bob)
+
+	node1.BroadcastToUser(2, map[string]string{"type": "ping"})
+	if got := string(expectMessage(t, bob)); got != `{"type":"ping"}` {
+		t.Fatalf("bob got %s", got)
+	}
+	expectSilence(t, alice)
+
+	node2.BroadcastAll(map[string]string{"type": "all"})
+	expectMessage(t, alice)
+	expectMessage(t, bob)
+	// The sending node must not deliver its own event a second time when it comes back from Redis.
+	expectSilence(t, bob)
+}
+
+func TestChatRoomAcrossRedisNodes(t *testing.T) {
+	mr := miniredis.RunT(t)
+	hub1 := NewChatHub(newTestRedisBackplane(t, mr.Addr()))
+	hub2 := NewChatHub(newTestRedisBackplane(t, mr.Addr()))
+	room1 := hub1.GetOrCreateRoom(7, 1, 2)
+	room2 := hub2.GetOrCreateRoom(7, 1, 2)
+
+	client, companion := newTestClient(1), newTestClient(2)
+	room1.Join(client)
+	room2.Join(companion)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !room1.HasUser(2) {
+		if time.Now().After(deadline) {
+			t.Fatal("node 1 never saw the companion join on node 2")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	room1.Broadcast(client, map[string]string{"type": "message"})
+	expectMessage(t, companion)
+	expectSilence(t, client)
+}
+
+// A remote event for a client whose connection has just closed must be dropped, not panic on the closed
+// Send channel.
+func TestRemoteEventAfterClientClosed(t *testing.T) {
+	mr := miniredis.RunT(t)
+	node1 := NewSharedHub(newTestRedisBackplane(t, mr.Addr()), "user")
+	node2 := NewSharedHub(newTestRedisBackplane(t, mr.Addr()), "user")
+
+	bob := newTestClient(2)
+	node2.Register(bob)
+	// Closed without unregistering, as when the subscriber already holds the client.
+	bob.mu.Lock()
+	bob.closed = true
+	close(bob.Send)
+	bob.mu.Unlock()
+
+	node1.BroadcastToUser(2, map[string]string{"type": "late"})
+	node1.BroadcastAll(map[string]string{"type": "late"})
+	time.Sleep(200 * time.Millisecond)
+	if bob.send([]byte("x")) {
+		t.Fatal("send on a closed client reported success")
+	}
+}
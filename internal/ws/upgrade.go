@@ -51,7 +51,39 @@ func UpgradeMapWS(cfg *config.JWTConfig, mapHub *MapHub) gin.HandlerFunc {
 		// Send initial markers
 		markers := mapHub.GetMarkers()
 		data, _ := json.Marshal(map[string]interface{}{"type": "markers", "markers": markers})
-		client.Send <- data
+		client.send(data)
+		go writePump(client, conn)
+		readPump(conn)
+	}
+}
+
+// UpgradeUserWS upgrades the per-user event channel. The server pushes the user's notifications (payment
+// results, request updates) as they happen; the client only reads.
+func UpgradeUserWS(cfg *config.JWTConfig, userHub *Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		token := c.Query("token")
+		if token == "" {
+			conn.WriteMessage(websocket.TextMessage, []byte(`{"error":"token required"}`))
+			return
+		}
+		claims, err := auth.ParseAccessToken(cfg, token)
+		if err != nil {
+			conn.WriteMessage(websocket.TextMessage, []byte(`{"error":"invalid token"}`))
+			return
+		}
+		client := &Client{
+			UserID: claims.UserID,
+			Role:   claims.Role,
+			Send:   make(chan []byte, 64),
+		}
+		client.conn = &wsConn{conn: conn}
+		userHub.Register(client)
+		defer client.Close()
 		go writePump(client, conn)
 		readPump(conn)
 	}
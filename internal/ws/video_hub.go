@@ -2,54 +2,334 @@ package ws
 
 import (
 	"encoding/json"
+	"errors"
 	"sync"
+	"time"
 )
 
-// VideoRoom has exactly two peers (client and companion) for WebRTC signaling.
+// Call states of a VideoRoom: idle -> ringing on invite; ringing -> active on accept, or back to idle on
+// decline, cancel or ring timeout; active -> idle on hangup or when the call is ended server-side.
+const (
+	CallIdle    = "idle"
+	CallRinging = "ringing"
+	CallActive  = "active"
+)
+
+// ErrCallState is returned for a call action the room's state, or the user's side of the call, does not allow.
+var ErrCallState = errors.New("call action not allowed now")
+
+// VideoRoom has exactly two peers (client and companion) for WebRTC signaling, and the state of the
+// interaction's current call. With a backplane the peers may be on different nodes: signaling is relayed
+// between them, and every call state change is copied to the room on the other nodes.
 type VideoRoom struct {
 	InteractionID uint
 	peers        map[uint]*Client // userID -> client
+	remote       map[uint]int     // userID -> connections on other nodes
+	bus          *bus
 	mu           sync.RWMutex
+	state        string
+	callID       uint
+	callerID     uint
+	ringUntil    time.Time
+	ringTimer    *time.Timer
+}
+
+// callState is a VideoRoom's call state as shared with the other nodes.
+type callState struct {
+	State     string    `json:"state"`
+	CallID    uint      `json:"call_id"`
+	CallerID  uint      `json:"caller_id"`
+	RingUntil time.Time `json:"ring_until"`
 }
 
 func NewVideoRoom(interactionID uint) *VideoRoom {
-	return &VideoRoom{InteractionID: interactionID, peers: make(map[uint]*Client)}
+	return &VideoRoom{InteractionID: interactionID, peers: make(map[uint]*Client), remote: make(map[uint]int), state: CallIdle}
 }
 
 func (r *VideoRoom) Join(c *Client) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.peers[c.UserID] = c
+	r.bus.publish(envelope{Op: opJoin, Room: r.InteractionID, UserID: c.UserID})
 }
 
 func (r *VideoRoom) Leave(userID uint) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	if _, ok := r.peers[userID]; !ok {
+		return
+	}
 	delete(r.peers, userID)
+	r.bus.publish(envelope{Op: opLeave, Room: r.InteractionID, UserID: userID})
 }
 
 func (r *VideoRoom) SendToOther(senderUserID uint, payload interface{}) {
 	data, _ := json.Marshal(payload)
+	r.sendToOther(senderUserID, data)
+	r.bus.publish(envelope{Op: opOther, Room: r.InteractionID, UserID: senderUserID, Data: data})
+}
+
+func (r *VideoRoom) sendToOther(senderUserID uint, data []byte) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 	for uid, c := range r.peers {
 		if uid != senderUserID {
-			select {
-			case c.Send <- data:
-			default:
-			}
+			c.send(data)
 			break
 		}
 	}
 }
 
+// SendTo delivers a server event to one peer.
+func (r *VideoRoom) SendTo(userID uint, payload interface{}) {
+	data, _ := json.Marshal(payload)
+	if !r.sendTo(userID, data) {
+		r.bus.publish(envelope{Op: opUser, Room: r.InteractionID, UserID: userID, Data: data})
+	}
+}
+
+func (r *VideoRoom) sendTo(userID uint, data []byte) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c, ok := r.peers[userID]
+	if ok {
+		c.send(data)
+	}
+	return ok
+}
+
+// Broadcast delivers a server event to both peers.
+func (r *VideoRoom) Broadcast(payload interface{}) {
+	data, _ := json.Marshal(payload)
+	r.sendAll(data)
+	r.bus.publish(envelope{Op: opRoom, Room: r.InteractionID, Data: data})
+}
+
+func (r *VideoRoom) sendAll(data []byte) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, c := range r.peers {
+		c.send(data)
+	}
+}
+
+// HasPeer reports whether userID is connected to the room, on this node or another.
+func (r *VideoRoom) HasPeer(userID uint) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.peers[userID]
+	return ok || r.remote[userID] > 0
+}
+
+// PeerCount returns how many users are connected to the room across all nodes.
+func (r *VideoRoom) PeerCount() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	n := len(r.peers)
+	for uid := range r.remote {
+		if _, ok := r.peers[uid]; !ok {
+			n++
+		}
+	}
+	return n
+}
+
+// CallState returns the room's call state, the current call and who placed it.
+func (r *VideoRoom) CallState() (state string, callID, callerID uint) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.state, r.callID, r.callerID
+}
+
+// Invite starts ringing callID placed by callerID. If nobody answers within ringTimeout the room goes back to
+// idle and onTimeout runs.
+func (r *VideoRoom) Invite(callID, callerID uint, ringTimeout time.Duration, onTimeout func()) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.state != CallIdle {
+		return ErrCallState
+	}
+	r.state, r.callID, r.callerID = CallRinging, callID, callerID
+	r.ringUntil = time.Now().Add(ringTimeout)
+	r.ringTimer = time.AfterFunc(ringTimeout, func() {
+		r.mu.Lock()
+		if r.state != CallRinging || r.callID != callID {
+			r.mu.Unlock()
+			return
+		}
+		r.reset()
+		r.shareState()
+		r.mu.Unlock()
+		onTimeout()
+	})
+	r.shareState()
+	return nil
+}
+
+// Accept answers the ringing call; only the callee can.
+func (r *VideoRoom) Accept(userID uint) (uint, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.state != CallRinging || userID == r.callerID {
+		return 0, ErrCallState
+	}
+	r.stopRinging()
+	r.state = CallActive
+	r.shareState()
+	return r.callID, nil
+}
+
+// Decline rejects the ringing call; only the callee can.
+func (r *VideoRoom) Decline(userID uint) (uint, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.state != CallRinging || userID == r.callerID {
+		return 0, ErrCallState
+	}
+	callID := r.callID
+	r.reset()
+	r.shareState()
+	return callID, nil
+}
+
+// Cancel withdraws the ringing call; only the caller can.
+func (r *VideoRoom) Cancel(userID uint) (uint, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.state != CallRinging || userID != r.callerID {
+		return 0, ErrCallState
+	}
+	callID := r.callID
+	r.reset()
+	r.shareState()
+	return callID, nil
+}
+
+// Hangup ends the active call; either side can.
+func (r *VideoRoom) Hangup() (uint, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.state != CallActive {
+		return 0, ErrCallState
+	}
+	callID := r.callID
+	r.reset()
+	r.shareState()
+	return callID, nil
+}
+
+// Restore marks callID as active, for a call that was answered before this room was created (e.g. the
+// server restarted mid-call). Does nothing unless the room is idle.
+func (r *VideoRoom) Restore(callID, callerID uint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.state == CallIdle {
+		r.state, r.callID, r.callerID = CallActive, callID, callerID
+		r.shareState()
+	}
+}
+
+// End returns the room to idle if callID is still its current call, for calls ended server-side.
+func (r *VideoRoom) End(callID uint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.callID == callID {
+		r.reset()
+		r.shareState()
+	}
+}
+
+func (r *VideoRoom) stopRinging() {
+	if r.ringTimer != nil {
+		r.ringTimer.Stop()
+		r.ringTimer = nil
+	}
+}
+
+func (r *VideoRoom) reset() {
+	r.stopRinging()
+	r.state, r.callID, r.callerID, r.ringUntil = CallIdle, 0, 0, time.Time{}
+}
+
+// shareState copies the call state to the room on the other nodes. Must hold r.mu.
+func (r *VideoRoom) shareState() {
+	r.bus.publish(envelope{Op: opState, Room: r.InteractionID, State: &callState{
+		State:     r.state,
+		CallID:    r.callID,
+		CallerID:  r.callerID,
+		RingUntil: r.ringUntil,
+	}})
+}
+
+// applyState takes over a call state change from another node. The ring timeout belongs to the node the
+// call was placed on; a ringing copy only falls back to idle by itself in case that node went away.
+func (r *VideoRoom) applyState(s *callState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stopRinging()
+	r.state, r.callID, r.callerID, r.ringUntil = s.State, s.CallID, s.CallerID, s.RingUntil
+	if s.State != CallRinging {
+		return
+	}
+	callID := s.CallID
+	r.ringTimer = time.AfterFunc(time.Until(s.RingUntil)+5*time.Second, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.state == CallRinging && r.callID == callID {
+			r.reset()
+		}
+	})
+}
+
+// receive applies an event from another node.
+func (r *VideoRoom) receive(e envelope) {
+	switch e.Op {
+	case opRoom:
+		r.sendAll(e.Data)
+	case opOther:
+		r.sendToOther(e.UserID, e.Data)
+	case opUser:
+		r.sendTo(e.UserID, e.Data)
+	case opJoin:
+		r.mu.Lock()
+		r.remote[e.UserID]++
+		r.mu.Unlock()
+	case opLeave:
+		r.mu.Lock()
+		if r.remote[e.UserID]--; r.remote[e.UserID] <= 0 {
+			delete(r.remote, e.UserID)
+		}
+		r.mu.Unlock()
+	case opState:
+		if e.State != nil {
+			r.applyState(e.State)
+		}
+	}
+}
+
 type VideoHub struct {
 	mu    sync.RWMutex
 	rooms map[uint]*VideoRoom
+	bus   *bus
+}
+
+// NewVideoHub creates the video hub; bp may be nil for a single node.
+func NewVideoHub(bp Backplane) *VideoHub {
+	h := &VideoHub{rooms: make(map[uint]*VideoRoom)}
+	h.bus = newBus(bp, "video", h.receive)
+	return h
 }
 
-func NewVideoHub() *VideoHub {
-	return &VideoHub{rooms: make(map[uint]*VideoRoom)}
+func (h *VideoHub) receive(e envelope) {
+	switch e.Op {
+	case opRoom, opOther, opUser:
+		// Nobody here to deliver to unless the room exists
+		if r := h.GetRoom(e.Room); r != nil {
+			r.receive(e)
+		}
+	default:
+		h.GetOrCreateRoom(e.Room).receive(e)
+	}
 }
 
 func (h *VideoHub) GetOrCreateRoom(interactionID uint) *VideoRoom {
@@ -59,6 +339,7 @@ func (h *VideoHub) GetOrCreateRoom(interactionID uint) *VideoRoom {
 		return r
 	}
 	r := NewVideoRoom(interactionID)
+	r.bus = h.bus
 	h.rooms[interactionID] = r
 	return r
 }
@@ -0,0 +1,344 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FakeConfig controls the in-process fake provider.
+type FakeConfig struct {
+	CallbackBaseURL string            // e.g. http://localhost:8099; replaces scheme and host of the callback URLs requests carry
+	Delay           time.Duration     // from initiation to callback
+	FailRate        float64           // share (0..1) of transactions that fail when the phone number does not force an outcome
+	Secrets         map[string]string // webhook route (mpesa, withdrawal, crypto) -> signing secret; unsigned when empty
+	KESPerUSDT      float64           // rate returned by GetRates
+}
+
+// FakeProvider stands in for TheLiberec (STK push, B2C payouts) and Swapuzi (USDT deposits) without any
+// network calls to them. Each transaction settles after Delay and the result is POSTed to our webhook route
+// in that provider's payload shape, signed like a real callback, so the whole payment flow can run locally.
+//
+// Phone numbers force an outcome: ending in 1111 the transaction fails (prompt cancelled, payout rejected),
+// ending in 2222 it never settles and no callback is sent (left to the abandoned-payment job and the
+// reconciler). Other transactions fail at FailRate. Deposits have no phone number and only use FailRate.
+type FakeProvider struct {
+	cfg    FakeConfig
+	client *http.Client
+
+	mu  sync.Mutex
+	seq int
+	txs map[string]*fakeTx // by our order ID
+}
+
+type fakeTx struct {
+	kind       string // stk, b2c, deposit
+	id         int    // provider-side ID
+	status     string // in the provider's own vocabulary
+	amount     float64
+	phone      string
+	callback   string
+	providerID string
+	paymentRef string // refunds: our order ID of the refunded payment
+}
+
+const (
+	fakeSTK     = "stk"
+	fakeB2C     = "b2c"
+	fakeDeposit = "deposit"
+	fakeRefund  = "refund" // USDT refund of a deposit; M-Pesa refunds are B2C payouts
+)
+
+func NewFakeProvider(cfg FakeConfig) *FakeProvider {
+	if cfg.Delay <= 0 {
+		cfg.Delay = 5 * time.Second
+	}
+	if cfg.KESPerUSDT <= 0 {
+		cfg.KESPerUSDT = 129.5
+	}
+	return &FakeProvider{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+		txs:    make(map[string]*fakeTx),
+	}
+}
+
+// InitiatePayment starts a simulated STK push.
+func (f *FakeProvider) InitiatePayment(ctx context.Context, req PaymentRequest) (*PaymentResponse, error) {
+	orderID := req.OrderID
+	if orderID == "" {
+		orderID = uuid.New().String()
+	}
+	tx := f.start(orderID, &fakeTx{
+		kind:     fakeSTK,
+		status:   "PENDING",
+		amount:   float64(req.AmountCents) / 100,
+		phone:    req.CustomerPhone,
+		callback: f.callbackURL(req.CallbackURL, "/api/v1/webhooks/mpesa"),
+	})
+	log.Printf("[Fake provider] STK push order_id=%s amount_kes=%.0f phone=%s", orderID, tx.amount, tx.phone)
+	return &PaymentResponse{
+		Reference:         orderID,
+		Status:            "PENDING",
+		ExpiresAt:         time.Now().Add(10 * time.Minute),
+		CheckoutRequestID: "ws_CO_fake_" + tx.providerID,
+	}, nil
+}
+
+// VerifyPayment reports whether the STK payment or deposit with our order ID reference completed.
+func (f *FakeProvider) VerifyPayment(ctx context.Context, reference string) (bool, error) {
+	status, _ := f.TransactionStatus(ctx, reference)
+	return strings.EqualFold(status, "COMPLETED"), nil
+}
+
+// TransactionStatus returns a transaction's status, "" if there is none with that order ID.
+func (f *FakeProvider) TransactionStatus(ctx context.Context, orderID string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if tx := f.txs[orderID]; tx != nil {
+		return tx.status, nil
+	}
+	return "", nil
+}
+
+// InitiateB2C starts a simulated payout.
+func (f *FakeProvider) InitiateB2C(ctx context.Context, req B2CRequest) (*B2CResponse, error) {
+	orderID := req.OrderID
+	if orderID == "" {
+		orderID = fmt.Sprintf("wd-%s", uuid.New().String())
+	}
+	tx := f.start(orderID, &fakeTx{
+		kind:     fakeB2C,
+		status:   "PENDING",
+		amount:   float64(req.Amount),
+		phone:    req.PhoneNumber,
+		callback: f.callbackURL(req.CallbackURL, "/api/v1/webhooks/withdrawal"),
+	})
+	log.Printf("[Fake provider] B2C order_id=%s amount_kes=%d phone=%s", orderID, req.Amount, req.PhoneNumber)
+	return &B2CResponse{
+		UUID:                     tx.providerID,
+		OrderID:                  orderID,
+		OriginatorConversationID: "fake-oc-" + tx.providerID,
+		ConversationID:           "fake-c-" + tx.providerID,
+		Amount:                   int(req.Amount),
+		PhoneNumber:              req.PhoneNumber,
+		Status:                   "PENDING",
+		ResponseCode:             "0",
+		ResponseDescription:      "Accept the service request successfully.",
+		CreatedAt:                time.Now().Format(time.RFC3339),
+	}, nil
+}
+
+func (f *FakeProvider) GetRates(ctx context.Context) (*SwapuziRates, error) {
+	return &SwapuziRates{UsdtBuyingRate: f.cfg.KESPerUSDT, UsdtSellingRate: f.cfg.KESPerUSDT * 0.98}, nil
+}
+
+// InitiateDeposit starts a simulated USDT deposit. The page URL does not resolve; the deposit settles on
+// its own after Delay.
+func (f *FakeProvider) InitiateDeposit(ctx context.Context, depositID, webhookURL, notes string, expectedAmountUSDT float64) (*SwapuziDepositResp, error) {
+	tx := f.start(depositID, &fakeTx{
+		kind:     fakeDeposit,
+		status:   "pending",
+		amount:   expectedAmountUSDT,
+		callback: f.callbackURL(webhookURL, "/api/v1/webhooks/crypto"),
+	})
+	log.Printf("[Fake provider] deposit merchant_deposit_id=%s amount_usdt=%.4f", depositID, expectedAmountUSDT)
+	now := time.Now()
+	return &SwapuziDepositResp{
+		DepositID:         tx.id,
+		MerchantDepositID: depositID,
+		Status:            "pending",
+		Message:           "Deposit created",
+		PageURL:           "https://fake-provider.invalid/deposit/" + url.PathEscape(depositID),
+		ExpectedAmount:    expectedAmountUSDT,
+		ExpiresAt:         now.Add(30 * time.Minute).Format(time.RFC3339),
+		CreatedAt:         now.Format(time.RFC3339),
+	}, nil
+}
+
+func (f *FakeProvider) DepositStatus(ctx context.Context, depositID string) (string, error) {
+	return f.TransactionStatus(ctx, depositID)
+}
+
+func (f *FakeProvider) RefundStatus(ctx context.Context, refundID string) (string, error) {
+	return f.TransactionStatus(ctx, refundID)
+}
+
+// Refund simulates refunding an earlier fake payment: a B2C payout for an STK push, a USDT return for a
+// deposit, each calling back in the matching provider's shape.
+func (f *FakeProvider) Refund(ctx context.Context, req RefundRequest) (*RefundResponse, error) {
+	f.mu.Lock()
+	orig := f.txs[req.PaymentReference]
+	f.mu.Unlock()
+	if orig == nil {
+		return nil, fmt.Errorf("fake refund %s: unknown payment %s", req.RefundID, req.PaymentReference)
+	}
+	tx := &fakeTx{
+		kind:       fakeB2C,
+		status:     "PENDING",
+		amount:     float64(req.AmountCents) / 100,
+		phone:      req.PhoneNumber,
+		paymentRef: req.PaymentReference,
+		callback:   f.callbackURL(req.CallbackURL, "/api/v1/webhooks/refund/mpesa"),
+	}
+	if orig.kind == fakeDeposit {
+		tx.kind, tx.status, tx.amount = fakeRefund, "pending", float64(req.AmountCents)/100/f.cfg.KESPerUSDT // USDT
+		tx.callback = f.callbackURL(req.CallbackURL, "/api/v1/webhooks/refund/crypto")
+	}
+	tx = f.start(req.RefundID, tx)
+	log.Printf("[Fake provider] refund %s of %s amount_kes=%.2f", req.RefundID, req.PaymentReference, float64(req.AmountCents)/100)
+	return &RefundResponse{Reference: tx.providerID, Status: tx.status}, nil
+}
+
+func (f *FakeProvider) start(orderID string, tx *fakeTx) *fakeTx {
+	f.mu.Lock()
+	f.seq++
+	tx.id = f.seq
+	tx.providerID = uuid.New().String()
+	f.txs[orderID] = tx
+	f.mu.Unlock()
+	time.AfterFunc(f.cfg.Delay, func() { f.settle(orderID) })
+	return tx
+}
+
+// settle decides the outcome of a pending transaction and sends the callback.
+func (f *FakeProvider) settle(orderID string) {
+	f.mu.Lock()
+	tx := f.txs[orderID]
+	if tx == nil || !strings.EqualFold(tx.status, "PENDING") || strings.HasSuffix(tx.phone, "2222") {
+		f.mu.Unlock()
+		return
+	}
+	ok := !strings.HasSuffix(tx.phone, "1111") && rand.Float64() >= f.cfg.FailRate
+	var route string
+	var body map[string]interface{}
+	switch tx.kind {
+	case fakeRefund:
+		route = "crypto"
+		tx.status = "completed"
+		if !ok {
+			tx.status = "failed"
+		}
+		body = map[string]interface{}{
+			"event":               "refund." + tx.status,
+			"refund_id":           orderID,
+			"merchant_deposit_id": tx.paymentRef,
+			"amount":              tx.amount,
+			"status":              tx.status,
+			"tx_hash":             "fake" + strings.ReplaceAll(tx.providerID, "-", ""),
+			"timestamp":           time.Now().Unix(),
+		}
+	case fakeDeposit:
+		route = "crypto"
+		tx.status = "completed"
+		received := tx.amount
+		if !ok {
+			tx.status, received = "expired", 0
+		}
+		body = map[string]interface{}{
+			"event":               "deposit." + tx.status,
+			"deposit_id":          tx.id,
+			"merchant_deposit_id": orderID,
+			"solana_address":      "FakeSo1anaDepositAddress" + strconv.Itoa(tx.id),
+			"received_amount":     received,
+			"expected_amount":     tx.amount,
+			"status":              tx.status,
+			"timestamp":           time.Now().Unix(),
+		}
+	default:
+		route, tx.status = "mpesa", "COMPLETED"
+		code, desc, receipt := "0", "The service request is processed successfully.", strings.ToUpper(strings.ReplaceAll(tx.providerID, "-", "")[:10])
+		if !ok {
+			tx.status, code, desc, receipt = "FAILED", "1032", "Request cancelled by user", ""
+		}
+		txType := "STK_PUSH"
+		if tx.kind == fakeB2C {
+			route, txType = "withdrawal", "B2C"
+			if !ok {
+				code, desc = "2001", "The initiator information is invalid."
+			}
+		}
+		body = map[string]interface{}{
+			"amount":              strconv.FormatFloat(tx.amount, 'f', 0, 64),
+			"checkout_request_id": "ws_CO_fake_" + tx.providerID,
+			"currency":            "KES",
+			"customer_phone":      tx.phone,
+			"merchant_order_id":   orderID,
+			"order_id":            tx.providerID,
+			"payment_method":      "MPESA",
+			"receipt_number":      receipt,
+			"status":              tx.status,
+			"status_code":         code,
+			"status_description":  desc,
+			"transaction_date":    time.Now().Format("20060102150405"),
+			"transaction_type":    txType,
+			"transaction_uuid":    tx.providerID,
+			"updated_at":          time.Now().Format(time.RFC3339),
+		}
+	}
+	callback := tx.callback
+	f.mu.Unlock()
+
+	payload, _ := json.Marshal(body)
+	log.Printf("[Fake provider] %s %s settled %s, calling back %s", tx.kind, orderID, body["status"], callback)
+	f.deliver(callback, route, payload)
+}
+
+// deliver POSTs a callback, retrying a few times on failure as the real providers do.
+func (f *FakeProvider) deliver(callback, route string, payload []byte) {
+	for attempt := 1; attempt <= 3; attempt++ {
+		req, err := http.NewRequest(http.MethodPost, callback, bytes.NewReader(payload))
+		if err != nil {
+			log.Printf("[Fake provider] callback %s: %v", callback, err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if secret := f.cfg.Secrets[route]; secret != "" {
+			// Same scheme middleware.WebhookGuard checks
+			ts := strconv.FormatInt(time.Now().Unix(), 10)
+			mac := hmac.New(sha256.New, []byte(secret))
+			mac.Write([]byte(ts + "."))
+			mac.Write(payload)
+			req.Header.Set("X-Webhook-Timestamp", ts)
+			req.Header.Set("X-Webhook-Signature", hex.EncodeToString(mac.Sum(nil)))
+		}
+		resp, err := f.client.Do(req)
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				return
+			}
+			err = fmt.Errorf("status %d", resp.StatusCode)
+		}
+		log.Printf("[Fake provider] callback %s attempt %d failed: %v", callback, attempt, err)
+		time.Sleep(time.Duration(attempt) * 2 * time.Second)
+	}
+}
+
+// callbackURL points a callback at CallbackBaseURL, keeping the route path from the URL the request carried.
+func (f *FakeProvider) callbackURL(given, defaultPath string) string {
+	if f.cfg.CallbackBaseURL == "" && given != "" {
+		return given
+	}
+	path := defaultPath
+	if u, err := url.Parse(given); err == nil && u.Path != "" {
+		path = u.Path
+	}
+	return strings.TrimSuffix(f.cfg.CallbackBaseURL, "/") + path
+}
@@ -93,3 +93,24 @@ func (p *LiberecMpesaProvider) InitiateB2C(ctx context.Context, req B2CRequest)
 	}
 	return &out, nil
 }
+
+// Refund sends a payment back to the number that paid it. TheLiberec has no STK reversal, so the refund is a
+// B2C payout carrying the refund ID as its order ID; its B2C callback goes to req.CallbackURL.
+func (p *LiberecMpesaProvider) Refund(ctx context.Context, req RefundRequest) (*RefundResponse, error) {
+	if req.PhoneNumber == "" {
+		return nil, fmt.Errorf("mpesa refund %s: no phone number for payment %s", req.RefundID, req.PaymentReference)
+	}
+	description := "Refund for " + req.PaymentReference
+	out, err := p.InitiateB2C(ctx, B2CRequest{
+		Amount:      req.AmountCents / 100,
+		PhoneNumber: req.PhoneNumber,
+		Description: description,
+		Remarks:     "Refund",
+		OrderID:     req.RefundID,
+		CallbackURL: req.CallbackURL,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &RefundResponse{Reference: out.UUID, Status: out.Status}, nil
+}
@@ -8,6 +8,7 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 )
@@ -159,6 +160,44 @@ func (p *LiberecMpesaProvider) InitiatePayment(ctx context.Context, req PaymentR
 	}, nil
 }
 
+// VerifyPayment reports whether the STK payment with merchant order ID reference completed.
 func (p *LiberecMpesaProvider) VerifyPayment(ctx context.Context, reference string) (bool, error) {
-	return false, nil
+	status, err := p.TransactionStatus(ctx, reference)
+	if err != nil {
+		return false, err
+	}
+	return status == "COMPLETED", nil
+}
+
+// TransactionStatus looks up a transaction (STK or B2C) by our order ID and returns its current status.
+func (p *LiberecMpesaProvider) TransactionStatus(ctx context.Context, orderID string) (string, error) {
+	token, err := p.getToken(ctx)
+	if err != nil {
+		return "", fmt.Errorf("mpesa login: %w", err)
+	}
+	apiReq, err := http.NewRequestWithContext(ctx, http.MethodGet, p.BaseURL+"/api/v1/transactions/"+url.PathEscape(orderID), nil)
+	if err != nil {
+		return "", err
+	}
+	apiReq.Header.Set("Authorization", "Bearer "+token)
+	resp, err := p.client.Do(apiReq)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("mpesa transaction lookup: %d", resp.StatusCode)
+	}
+	var out struct {
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(respBody, &out); err != nil {
+		return "", err
+	}
+	log.Printf("[MPESA Liberec] transaction %s status=%s", orderID, out.Status)
+	return out.Status, nil
 }
@@ -33,4 +33,32 @@ type PaymentResponse struct {
 type Provider interface {
 	InitiatePayment(ctx context.Context, req PaymentRequest) (*PaymentResponse, error)
 	VerifyPayment(ctx context.Context, reference string) (bool, error)
+	Refunder
+}
+
+// RefundRequest sends (part of) a payment back to where it came from.
+type RefundRequest struct {
+	RefundID         string // our unique refund reference; the provider's callback carries it back
+	PaymentReference string // our order ID of the original payment
+	AmountCents      int64
+	Currency         string
+	PhoneNumber      string // M-Pesa: the number that paid
+	Reason           string
+	CallbackURL      string
+}
+
+type RefundResponse struct {
+	Reference string // provider-side ID of the refund
+	Status    string
+}
+
+// Refunder returns money to the original payment source. The result arrives asynchronously via CallbackURL.
+type Refunder interface {
+	Refund(ctx context.Context, req RefundRequest) (*RefundResponse, error)
+}
+
+// Verifier confirms with the provider's API that a payment really completed. Webhook handlers call it before
+// acting on a callback, so a forged or stale callback cannot change state on its own.
+type Verifier interface {
+	VerifyPayment(ctx context.Context, reference string) (bool, error)
 }
@@ -0,0 +1,71 @@
+package payment
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// MobileMoneyProvider is an M-Pesa gateway: STK push collections, B2C payouts and transaction lookups.
+type MobileMoneyProvider interface {
+	Provider
+	InitiateB2C(ctx context.Context, req B2CRequest) (*B2CResponse, error)
+	// TransactionStatus returns the status of a collection or payout by our order ID, or "" if unknown.
+	TransactionStatus(ctx context.Context, orderID string) (string, error)
+}
+
+// CryptoProvider takes USDT deposits.
+type CryptoProvider interface {
+	Verifier
+	Refunder
+	GetRates(ctx context.Context) (*SwapuziRates, error)
+	InitiateDeposit(ctx context.Context, depositID, webhookURL, notes string, expectedAmountUSDT float64) (*SwapuziDepositResp, error)
+	// DepositStatus returns the status of a deposit by our order ID, or "" if unknown.
+	DepositStatus(ctx context.Context, depositID string) (string, error)
+	// RefundStatus returns the status of a deposit refund by our refund ID, or "" if unknown.
+	RefundStatus(ctx context.Context, refundID string) (string, error)
+}
+
+// Registry holds the available providers by name; config picks which one the app uses for each rail.
+type Registry struct {
+	mobileMoney map[string]MobileMoneyProvider
+	crypto      map[string]CryptoProvider
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		mobileMoney: make(map[string]MobileMoneyProvider),
+		crypto:      make(map[string]CryptoProvider),
+	}
+}
+
+func (r *Registry) RegisterMobileMoney(name string, p MobileMoneyProvider) {
+	r.mobileMoney[name] = p
+}
+
+func (r *Registry) RegisterCrypto(name string, p CryptoProvider) {
+	r.crypto[name] = p
+}
+
+func (r *Registry) MobileMoney(name string) (MobileMoneyProvider, error) {
+	if p, ok := r.mobileMoney[name]; ok {
+		return p, nil
+	}
+	return nil, fmt.Errorf("unknown mobile money provider %q (have %v)", name, keys(r.mobileMoney))
+}
+
+func (r *Registry) Crypto(name string) (CryptoProvider, error) {
+	if p, ok := r.crypto[name]; ok {
+		return p, nil
+	}
+	return nil, fmt.Errorf("unknown crypto provider %q (have %v)", name, keys(r.crypto))
+}
+
+func keys[T any](m map[string]T) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -8,6 +8,7 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"time"
 )
 
@@ -155,3 +156,130 @@ func (p *SwapuziProvider) InitiateDeposit(ctx context.Context, depositID, webhoo
 	}
 	return &out, nil
 }
+
+// DepositStatus looks up a deposit by our order ID (merchant_deposit_id) and returns its current status.
+func (p *SwapuziProvider) DepositStatus(ctx context.Context, depositID string) (string, error) {
+	token, err := p.getToken(ctx)
+	if err != nil {
+		return "", fmt.Errorf("swapuzi deposit status auth: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.BaseURL+"/merchants/solana/deposit/"+url.PathEscape(depositID), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("swapuzi deposit status: %d %s", resp.StatusCode, string(respBody))
+	}
+	var out struct {
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(respBody, &out); err != nil {
+		return "", err
+	}
+	return out.Status, nil
+}
+
+// RefundStatus looks up a deposit refund by our refund ID and returns its current status.
+func (p *SwapuziProvider) RefundStatus(ctx context.Context, refundID string) (string, error) {
+	token, err := p.getToken(ctx)
+	if err != nil {
+		return "", fmt.Errorf("swapuzi refund status auth: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.BaseURL+"/merchants/solana/refunds/"+url.PathEscape(refundID), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("swapuzi refund status: %d %s", resp.StatusCode, string(respBody))
+	}
+	var out struct {
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(respBody, &out); err != nil {
+		return "", err
+	}
+	return out.Status, nil
+}
+
+// VerifyPayment reports whether the deposit with merchant_deposit_id reference completed.
+func (p *SwapuziProvider) VerifyPayment(ctx context.Context, reference string) (bool, error) {
+	status, err := p.DepositStatus(ctx, reference)
+	if err != nil {
+		return false, err
+	}
+	return status == "completed", nil
+}
+
+type swapuziRefundReq struct {
+	RefundID   string  `json:"refund_id"`
+	Amount     float64 `json:"amount"`
+	WebhookURL string  `json:"webhook_url"`
+	Reason     string  `json:"reason"`
+}
+
+// Refund returns USDT to the address a deposit was paid from. The KES amount is converted at the current
+// buying rate, the same rate deposits are priced at. Swapuzi reports the outcome to req.CallbackURL.
+func (p *SwapuziProvider) Refund(ctx context.Context, req RefundRequest) (*RefundResponse, error) {
+	rates, err := p.GetRates(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("swapuzi refund rates: %w", err)
+	}
+	if rates.UsdtBuyingRate <= 0 {
+		return nil, fmt.Errorf("swapuzi refund: invalid exchange rate")
+	}
+	token, err := p.getToken(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("swapuzi refund auth: %w", err)
+	}
+	body, _ := json.Marshal(swapuziRefundReq{
+		RefundID:   req.RefundID,
+		Amount:     float64(int64(float64(req.AmountCents)/100/rates.UsdtBuyingRate*10000)) / 10000,
+		WebhookURL: req.CallbackURL,
+		Reason:     req.Reason,
+	})
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		p.BaseURL+"/merchants/solana/deposit/"+url.PathEscape(req.PaymentReference)+"/refund", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	log.Printf("[Swapuzi] refund %s for deposit %s status=%d body=%s", req.RefundID, req.PaymentReference, resp.StatusCode, string(respBody))
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("swapuzi refund: %d %s", resp.StatusCode, string(respBody))
+	}
+	var out struct {
+		ID     json.Number `json:"id"`
+		Status string      `json:"status"`
+	}
+	if err := json.Unmarshal(respBody, &out); err != nil {
+		return nil, err
+	}
+	return &RefundResponse{Reference: out.ID.String(), Status: out.Status}, nil
+}
@@ -0,0 +1,20 @@
+// Package turn issues time-limited TURN credentials for coturn's REST API scheme (use-auth-secret): the
+// username is "<unix expiry>:<user>" and the password is base64(HMAC-SHA1(secret, username)). coturn
+// checks the signature with the same shared secret and refuses the credentials once the expiry has passed.
+package turn
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"time"
+)
+
+// Credentials returns a username and password for user valid until expiresAt.
+func Credentials(secret, user string, expiresAt time.Time) (username, password string) {
+	username = fmt.Sprintf("%d:%s", expiresAt.Unix(), user)
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	return username, base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}